@@ -0,0 +1,306 @@
+package blockchainparser
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Address prefixes of a network
+type AddressParams struct {
+	PubKeyHashAddrId byte   // base58 version of P2PKH addresses
+	ScriptHashAddrId byte   // base58 version of P2SH addresses
+	Bech32HRP        string // human readable part of segwit addresses, empty without segwit
+}
+
+var (
+	MainNetAddressParams = &AddressParams{0x00, 0x05, "bc"}
+	TestNetAddressParams = &AddressParams{0x6f, 0xc4, "tb"}
+	RegTestAddressParams = &AddressParams{0x6f, 0xc4, "bcrt"}
+)
+
+var ErrInvalidAddress = errors.New("Invalid address")
+
+const (
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	bech32Charset  = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+	//! Checksum constants of BIP173 and BIP350
+	BECH32_CONST  = 1
+	BECH32M_CONST = 0x2bc830a3
+)
+
+func Base58Encode(b []byte) string {
+	x := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	encoded := make([]byte, 0, len(b)*138/100+1)
+	for x.Sign() > 0 {
+		x.DivMod(x, radix, mod)
+		encoded = append(encoded, base58Alphabet[mod.Int64()])
+	}
+	// Leading zero bytes are encoded as leading '1's
+	for _, v := range b {
+		if v != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+
+	return string(encoded)
+}
+
+func Base58Decode(s string) ([]byte, error) {
+	x := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		i := strings.IndexRune(base58Alphabet, c)
+		if i < 0 {
+			return nil, errors.New("Invalid base58 character")
+		}
+		x.Mul(x, radix)
+		x.Add(x, big.NewInt(int64(i)))
+	}
+
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+
+	return append(make([]byte, zeros), x.Bytes()...), nil
+}
+
+// Base58 with a 4 bytes double SHA256 checksum
+func Base58CheckEncode(b []byte) string {
+	checksum := DoubleSha256(b)[:4]
+	return Base58Encode(append(append([]byte{}, b...), checksum...))
+}
+
+func Base58CheckDecode(s string) ([]byte, error) {
+	b, err := Base58Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 4 {
+		return nil, errors.New("Invalid base58 checksum")
+	}
+	payload := b[:len(b)-4]
+	if !bytes.Equal(DoubleSha256(payload)[:4], b[len(b)-4:]) {
+		return nil, errors.New("Invalid base58 checksum")
+	}
+
+	return payload, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := uint(0); i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+// Encode 5 bits values with a bech32 (BECH32_CONST) or bech32m (BECH32M_CONST) checksum
+func Bech32Encode(hrp string, data []byte, checksumConst uint32) string {
+	values := append(bech32HrpExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ checksumConst
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range data {
+		sb.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+
+	return sb.String()
+}
+
+// Decode a bech32 or bech32m string into its hrp, 5 bits values and checksum constant
+func Bech32Decode(s string) (string, []byte, uint32, error) {
+	if len(s) > 90 {
+		return "", nil, 0, errors.New("Bech32 string too long")
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, 0, errors.New("Mixed case bech32 string")
+	}
+	s = strings.ToLower(s)
+
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, 0, errors.New("Invalid bech32 separator position")
+	}
+	hrp := s[:pos]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, 0, errors.New("Invalid bech32 human readable part")
+		}
+	}
+
+	data := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, 0, errors.New("Invalid bech32 character")
+		}
+		data = append(data, byte(v))
+	}
+
+	checksumConst := bech32Polymod(append(bech32HrpExpand(hrp), data...))
+	if checksumConst != BECH32_CONST && checksumConst != BECH32M_CONST {
+		return "", nil, 0, errors.New("Invalid bech32 checksum")
+	}
+
+	return hrp, data[:len(data)-6], checksumConst, nil
+}
+
+// Regroup bits, e.g. from 8 bits bytes to 5 bits bech32 values
+func convertBits(data []byte, fromBits uint, toBits uint, pad bool) ([]byte, bool) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<toBits - 1
+	result := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, false
+		}
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte((acc>>bits)&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			result = append(result, byte((acc<<(toBits-bits))&maxv))
+		}
+	} else if bits >= fromBits || (acc<<(toBits-bits))&maxv != 0 {
+		return nil, false
+	}
+
+	return result, true
+}
+
+// Encode a segwit address (BIP173 for version 0, BIP350 for later versions)
+func EncodeSegwitAddress(hrp string, version int, program []byte) (string, error) {
+	if version < 0 || version > 16 || len(program) < 2 || len(program) > 40 {
+		return "", ErrInvalidAddress
+	}
+	data, _ := convertBits(program, 8, 5, true)
+	checksumConst := uint32(BECH32M_CONST)
+	if version == 0 {
+		checksumConst = BECH32_CONST
+	}
+
+	return Bech32Encode(hrp, append([]byte{byte(version)}, data...), checksumConst), nil
+}
+
+// Decode a segwit address for hrp into its witness version and program
+func DecodeSegwitAddress(hrp string, address string) (int, []byte, error) {
+	decodedHrp, data, checksumConst, err := Bech32Decode(address)
+	if err != nil {
+		return 0, nil, err
+	}
+	if decodedHrp != hrp || len(data) < 1 {
+		return 0, nil, ErrInvalidAddress
+	}
+
+	version := int(data[0])
+	program, ok := convertBits(data[1:], 5, 8, false)
+	if !ok || version > 16 || len(program) < 2 || len(program) > 40 {
+		return 0, nil, ErrInvalidAddress
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return 0, nil, ErrInvalidAddress
+	}
+	if (version == 0) != (checksumConst == BECH32_CONST) {
+		return 0, nil, ErrInvalidAddress
+	}
+
+	return version, program, nil
+}
+
+// Returns the address paid by the script, if it has one. Bare public keys,
+// multisig and OP_RETURN outputs have no address, nor witness programs on
+// chains without segwit.
+func (script Script) Address(params *AddressParams) (string, bool) {
+	scriptType, solutions := script.Type()
+
+	switch scriptType {
+	case TX_PUBKEYHASH:
+		return Base58CheckEncode(append([]byte{params.PubKeyHashAddrId}, solutions[0]...)), true
+	case TX_SCRIPTHASH:
+		return Base58CheckEncode(append([]byte{params.ScriptHashAddrId}, solutions[0]...)), true
+	case TX_WITNESS_V0_KEYHASH, TX_WITNESS_V0_SCRIPTHASH, TX_WITNESS_V1_TAPROOT, TX_WITNESS_UNKNOWN:
+		if params.Bech32HRP == "" {
+			return "", false
+		}
+		version, program, _ := script.WitnessProgram()
+		address, err := EncodeSegwitAddress(params.Bech32HRP, version, program)
+		return address, err == nil
+	}
+
+	return "", false
+}
+
+// Returns the output script paying to address
+func DecodeAddress(address string, params *AddressParams) (Script, error) {
+	if params.Bech32HRP != "" && strings.HasPrefix(strings.ToLower(address), params.Bech32HRP+"1") {
+		version, program, err := DecodeSegwitAddress(params.Bech32HRP, address)
+		if err != nil {
+			return nil, err
+		}
+		opcode := byte(OP_0)
+		if version > 0 {
+			opcode = byte(OP_1 + version - 1)
+		}
+		return append([]byte{opcode, byte(len(program))}, program...), nil
+	}
+
+	payload, err := Base58CheckDecode(address)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) != 21 {
+		return nil, ErrInvalidAddress
+	}
+
+	switch payload[0] {
+	case params.PubKeyHashAddrId:
+		script := append([]byte{OP_DUP, OP_HASH160, 20}, payload[1:]...)
+		return append(script, OP_EQUALVERIFY, OP_CHECKSIG), nil
+	case params.ScriptHashAddrId:
+		script := append([]byte{OP_HASH160, 20}, payload[1:]...)
+		return append(script, OP_EQUAL), nil
+	}
+
+	return nil, ErrInvalidAddress
+}
@@ -0,0 +1,51 @@
+package blockchainparser
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDecodeAddressWithoutBech32(t *testing.T) {
+	// Legacy addresses starting with "1" must not be taken for bech32 ones
+	for _, address := range []string{"1BoatSLRHtKNngkdXEeobR76b53LETtpyT", "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy"} {
+		want, err := DecodeAddress(address, MainNetAddressParams)
+		if err != nil {
+			t.Fatalf("%s: %s", address, err)
+		}
+		script, err := DecodeAddress(address, BitcoinCashParams.Address)
+		if err != nil {
+			t.Errorf("%s: %s", address, err)
+			continue
+		}
+		if script.String() != want.String() {
+			t.Errorf("%s: got %s, want %s", address, script, want)
+		}
+	}
+
+	hash, _ := hex.DecodeString("751e76e8199196d454941c45d1b3a323f1433bd6")
+	address := Base58CheckEncode(append([]byte{DogecoinParams.Address.PubKeyHashAddrId}, hash...))
+	script, err := DecodeAddress(address, DogecoinParams.Address)
+	if err != nil {
+		t.Fatalf("%s: %s", address, err)
+	}
+	if script.String() != "76a914751e76e8199196d454941c45d1b3a323f1433bd688ac" {
+		t.Errorf("%s: got %s", address, script)
+	}
+
+	if _, err := DecodeAddress("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", BitcoinCashParams.Address); err == nil {
+		t.Error("Segwit address decoded without a bech32 HRP")
+	}
+}
+
+func TestScriptAddressWithoutBech32(t *testing.T) {
+	script, _ := hex.DecodeString("0014751e76e8199196d454941c45d1b3a323f1433bd6")
+
+	if address, ok := Script(script).Address(MainNetAddressParams); !ok || address != "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4" {
+		t.Errorf("Mainnet address: got %q, %v", address, ok)
+	}
+	for _, params := range []*AddressParams{BitcoinCashParams.Address, DogecoinParams.Address} {
+		if address, ok := Script(script).Address(params); ok {
+			t.Errorf("Witness program has address %q without a bech32 HRP", address)
+		}
+	}
+}
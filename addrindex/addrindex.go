@@ -0,0 +1,389 @@
+// Package addrindex builds an address index from the blk*.dat files, which
+// bitcoind doesn't provide: the outputs paying to every address and the
+// transactions spending them, stored in a local LevelDB.
+package addrindex
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ruqqq/blockchainparser"
+	"github.com/ruqqq/blockchainparser/scanner"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	//! Output records: 'a' + address length + address + height + txid + vout
+	ADDR_INDEX_RECORD_PREFIX = 'a'
+
+	//! Outpoints of the indexed outputs: 'o' + txid + vout, to the key of their record
+	ADDR_INDEX_OUTPOINT_PREFIX = 'o'
+
+	//! Hash and height of the last connected block
+	ADDR_INDEX_BEST_BLOCK_KEY = "B"
+)
+
+// Output paying to an address
+type Record struct {
+	Address     string
+	Txid        blockchainparser.Hash256
+	Vout        uint32
+	Height      int32
+	Value       blockchainparser.Amount
+	SpentBy     blockchainparser.Hash256 // nil while unspent
+	SpentHeight int32
+}
+
+type Balance struct {
+	Received blockchainparser.Amount
+	Sent     blockchainparser.Amount
+	Unspent  blockchainparser.Amount
+}
+
+type AddrIndex struct {
+	*leveldb.DB
+	params *blockchainparser.ChainParams
+}
+
+// Opens the index at path, creating it if needed
+func Open(path string, params *blockchainparser.ChainParams) (*AddrIndex, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AddrIndex{DB: db, params: params}, nil
+}
+
+// Hash and height of the last connected block, nil and -1 for an empty index
+func (addrIndex *AddrIndex) BestBlock() (blockchainparser.Hash256, int32, error) {
+	value, err := addrIndex.Get([]byte(ADDR_INDEX_BEST_BLOCK_KEY), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, -1, nil
+	}
+	if err != nil {
+		return nil, -1, err
+	}
+	if len(value) != 36 {
+		return nil, -1, errors.New("Invalid best block record")
+	}
+
+	return blockchainparser.Hash256(value[:32]), int32(binary.LittleEndian.Uint32(value[32:])), nil
+}
+
+// Outputs paying to address, by height
+func (addrIndex *AddrIndex) History(address string) ([]Record, error) {
+	var records []Record
+	iter := addrIndex.NewIterator(util.BytesPrefix(addressPrefix(address)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		record, err := decodeRecord(iter.Key(), iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+
+	return records, iter.Error()
+}
+
+func (addrIndex *AddrIndex) Balance(address string) (Balance, error) {
+	balance := Balance{}
+	records, err := addrIndex.History(address)
+	if err != nil {
+		return balance, err
+	}
+
+	for _, record := range records {
+		balance.Received += record.Value
+		if record.SpentBy != nil {
+			balance.Sent += record.Value
+		} else {
+			balance.Unspent += record.Value
+		}
+	}
+
+	return balance, nil
+}
+
+// Indexes the outputs of a block extending the best block and marks the
+// outputs its inputs spend
+func (addrIndex *AddrIndex) ConnectBlock(block *blockchainparser.Block, height int32) error {
+	best, _, err := addrIndex.BestBlock()
+	if err != nil {
+		return err
+	}
+	if best != nil && !bytes.Equal(block.HashPrev, best) {
+		return fmt.Errorf("Block %s does not extend the indexed block %s", block.Hash(), best)
+	}
+
+	batch := newBlockBatch(addrIndex)
+	for _, tx := range block.Transactions {
+		txid := tx.Txid()
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Vin {
+				record, key, err := batch.spentRecord(in)
+				if err != nil {
+					return err
+				}
+				if record == nil {
+					continue
+				}
+				record.SpentBy = txid
+				record.SpentHeight = height
+				batch.putRecord(key, record)
+			}
+		}
+
+		for vout, out := range tx.Vout {
+			address, ok := out.Script.Address(addrIndex.params.Address)
+			if !ok {
+				continue
+			}
+			record := &Record{Address: address, Txid: txid, Vout: uint32(vout), Height: height, Value: out.Value}
+			key := recordKey(record)
+			batch.putRecord(key, record)
+			batch.putOutpoint(outpointKey(txid, uint32(vout)), key)
+		}
+	}
+
+	return batch.write(block.Hash(), height)
+}
+
+// Reverts ConnectBlock for the best block
+func (addrIndex *AddrIndex) DisconnectBlock(block *blockchainparser.Block, height int32) error {
+	best, _, err := addrIndex.BestBlock()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(block.Hash(), best) {
+		return fmt.Errorf("Block %s is not the indexed block %s", block.Hash(), best)
+	}
+
+	batch := newBlockBatch(addrIndex)
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		txid := tx.Txid()
+		for vout := range tx.Vout {
+			outpoint := outpointKey(txid, uint32(vout))
+			key, err := batch.recordKey(outpoint)
+			if err != nil {
+				return err
+			}
+			if key != nil {
+				batch.deleteRecord(key)
+				batch.deleteOutpoint(outpoint)
+			}
+		}
+
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Vin {
+				record, key, err := batch.spentRecord(in)
+				if err != nil {
+					return err
+				}
+				if record == nil {
+					continue
+				}
+				record.SpentBy = nil
+				record.SpentHeight = 0
+				batch.putRecord(key, record)
+			}
+		}
+	}
+
+	return batch.write(block.HashPrev, height-1)
+}
+
+// Applies an event of scanner.ChainEvents or scanner.Follower
+func (addrIndex *AddrIndex) Apply(event scanner.Event) error {
+	if event.Type == scanner.EVENT_BLOCK_DISCONNECTED {
+		return addrIndex.DisconnectBlock(event.Block.Block, event.Block.Height)
+	}
+
+	return addrIndex.ConnectBlock(event.Block.Block, event.Block.Height)
+}
+
+// Brings the index to the tip of the active chain of chainIndex. Blocks are
+// read with an ordered scan, whose Workers and OnProgress are taken from
+// opts. An index left on a stale block is first moved to the new branch.
+func (addrIndex *AddrIndex) Update(ctx context.Context, chainIndex *scanner.ChainIndex, dataDir string, opts scanner.Options) error {
+	best, height, err := addrIndex.BestBlock()
+	if err != nil {
+		return err
+	}
+	if best != nil && !chainIndex.Contains(best) {
+		return scanner.ChainEvents(chainIndex, addrIndex.params, dataDir, best, addrIndex.Apply)
+	}
+	if height >= chainIndex.Height() {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	opts.Ordered = true
+	opts.Index = chainIndex
+	opts.StartHeight = height + 1
+	blockScanner := scanner.New(addrIndex.params, dataDir, opts)
+	for block := range blockScanner.Scan(ctx) {
+		if err := addrIndex.ConnectBlock(block.Block, block.Height); err != nil {
+			return err
+		}
+	}
+
+	return blockScanner.Err()
+}
+
+// Changes of one block, written atomically with the new best block. Reads
+// see the changes not written yet.
+type blockBatch struct {
+	addrIndex *AddrIndex
+	batch     *leveldb.Batch
+	records   map[string]*Record // nil when deleted
+	outpoints map[string][]byte  // nil when deleted
+}
+
+func newBlockBatch(addrIndex *AddrIndex) *blockBatch {
+	return &blockBatch{
+		addrIndex: addrIndex,
+		batch:     new(leveldb.Batch),
+		records:   make(map[string]*Record),
+		outpoints: make(map[string][]byte),
+	}
+}
+
+// Key of the record of an outpoint, nil when it isn't indexed
+func (batch *blockBatch) recordKey(outpoint []byte) ([]byte, error) {
+	if key, ok := batch.outpoints[string(outpoint)]; ok {
+		return key, nil
+	}
+	key, err := batch.addrIndex.Get(outpoint, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+
+	return key, err
+}
+
+// Record of the output spent by in, nil when it isn't indexed
+func (batch *blockBatch) spentRecord(in blockchainparser.TxInput) (*Record, []byte, error) {
+	key, err := batch.recordKey(outpointKey(in.Hash, in.Index))
+	if err != nil || key == nil {
+		return nil, nil, err
+	}
+	if record, ok := batch.records[string(key)]; ok {
+		if record == nil {
+			return nil, nil, nil
+		}
+		return record, key, nil
+	}
+
+	value, err := batch.addrIndex.Get(key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	record, err := decodeRecord(key, value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return record, key, nil
+}
+
+func (batch *blockBatch) putRecord(key []byte, record *Record) {
+	batch.records[string(key)] = record
+	batch.batch.Put(key, recordValue(record))
+}
+
+func (batch *blockBatch) deleteRecord(key []byte) {
+	batch.records[string(key)] = nil
+	batch.batch.Delete(key)
+}
+
+func (batch *blockBatch) putOutpoint(outpoint []byte, key []byte) {
+	batch.outpoints[string(outpoint)] = key
+	batch.batch.Put(outpoint, key)
+}
+
+func (batch *blockBatch) deleteOutpoint(outpoint []byte) {
+	batch.outpoints[string(outpoint)] = nil
+	batch.batch.Delete(outpoint)
+}
+
+func (batch *blockBatch) write(best blockchainparser.Hash256, height int32) error {
+	if height < 0 {
+		batch.batch.Delete([]byte(ADDR_INDEX_BEST_BLOCK_KEY))
+	} else {
+		value := make([]byte, 36)
+		copy(value, best)
+		binary.LittleEndian.PutUint32(value[32:], uint32(height))
+		batch.batch.Put([]byte(ADDR_INDEX_BEST_BLOCK_KEY), value)
+	}
+
+	return batch.addrIndex.Write(batch.batch, nil)
+}
+
+func addressPrefix(address string) []byte {
+	return append([]byte{ADDR_INDEX_RECORD_PREFIX, byte(len(address))}, address...)
+}
+
+// Heights are big endian so that records are sorted by height
+func recordKey(record *Record) []byte {
+	prefix := addressPrefix(record.Address)
+	key := make([]byte, len(prefix)+40)
+	copy(key, prefix)
+	binary.BigEndian.PutUint32(key[len(prefix):], uint32(record.Height))
+	copy(key[len(prefix)+4:], record.Txid)
+	binary.BigEndian.PutUint32(key[len(prefix)+36:], record.Vout)
+
+	return key
+}
+
+func outpointKey(txid blockchainparser.Hash256, vout uint32) []byte {
+	key := make([]byte, 37)
+	key[0] = ADDR_INDEX_OUTPOINT_PREFIX
+	copy(key[1:], txid)
+	binary.BigEndian.PutUint32(key[33:], vout)
+
+	return key
+}
+
+// Value, then the spending txid and height once spent
+func recordValue(record *Record) []byte {
+	if record.SpentBy == nil {
+		value := make([]byte, 8)
+		binary.LittleEndian.PutUint64(value, uint64(record.Value))
+		return value
+	}
+
+	value := make([]byte, 44)
+	binary.LittleEndian.PutUint64(value, uint64(record.Value))
+	copy(value[8:], record.SpentBy)
+	binary.LittleEndian.PutUint32(value[40:], uint32(record.SpentHeight))
+
+	return value
+}
+
+func decodeRecord(key []byte, value []byte) (*Record, error) {
+	if len(key) < 2 || len(key) != 2+int(key[1])+40 || (len(value) != 8 && len(value) != 44) {
+		return nil, errors.New("Invalid address index record")
+	}
+
+	key = key[2:]
+	record := &Record{Address: string(key[:len(key)-40])}
+	key = key[len(record.Address):]
+	record.Height = int32(binary.BigEndian.Uint32(key))
+	record.Txid = blockchainparser.Hash256(append([]byte{}, key[4:36]...))
+	record.Vout = binary.BigEndian.Uint32(key[36:])
+	record.Value = blockchainparser.Amount(binary.LittleEndian.Uint64(value))
+	if len(value) == 44 {
+		record.SpentBy = blockchainparser.Hash256(append([]byte{}, value[8:40]...))
+		record.SpentHeight = int32(binary.LittleEndian.Uint32(value[40:]))
+	}
+
+	return record, nil
+}
@@ -0,0 +1,141 @@
+package addrindex
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+func p2wpkhForTest(name string) blockchainparser.Script {
+	return append(blockchainparser.Script{blockchainparser.OP_0, 20}, blockchainparser.DoubleSha256([]byte(name))[:20]...)
+}
+
+func addressForTest(t *testing.T, script blockchainparser.Script) string {
+	address, ok := script.Address(blockchainparser.RegTestParams.Address)
+	if !ok {
+		t.Fatalf("No address for %s", script)
+	}
+
+	return address
+}
+
+func blockForTest(hashPrev blockchainparser.Hash256, tag byte, coinbaseScript blockchainparser.Script, txs ...blockchainparser.Transaction) *blockchainparser.Block {
+	coinbase := blockchainparser.Transaction{
+		Version: 1,
+		Vin:     []blockchainparser.TxInput{{Hash: make(blockchainparser.Hash256, 32), Index: 0xffffffff, Script: blockchainparser.Script{1, tag}}},
+		Vout:    []blockchainparser.TxOutput{{Value: 5000, Script: coinbaseScript}},
+	}
+	block := &blockchainparser.Block{Transactions: append([]blockchainparser.Transaction{coinbase}, txs...)}
+	block.Version = 4
+	block.HashPrev = hashPrev
+	block.Timestamp = time.Unix(1600000000+int64(tag), 0)
+	block.TargetDifficulty = 0x207fffff
+	block.HashMerkle = block.MerkleRoot()
+
+	return block
+}
+
+func spendForTest(txid blockchainparser.Hash256, vout uint32, outs ...blockchainparser.TxOutput) blockchainparser.Transaction {
+	return blockchainparser.Transaction{
+		Version: 2,
+		Vin:     []blockchainparser.TxInput{{Hash: txid, Index: vout, Sequence: 0xffffffff}},
+		Vout:    outs,
+	}
+}
+
+func openForTest(t *testing.T) *AddrIndex {
+	addrIndex, err := Open(t.TempDir(), blockchainparser.RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { addrIndex.Close() })
+
+	return addrIndex
+}
+
+func historyForTest(t *testing.T, addrIndex *AddrIndex, address string) []Record {
+	records, err := addrIndex.History(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return records
+}
+
+func TestConnectDisconnectBlock(t *testing.T) {
+	addrIndex := openForTest(t)
+	a, b := p2wpkhForTest("a"), p2wpkhForTest("b")
+	addressA, addressB := addressForTest(t, a), addressForTest(t, b)
+
+	genesis := blockForTest(make(blockchainparser.Hash256, 32), 0, a)
+	if err := addrIndex.ConnectBlock(genesis, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// tx2 spends an output of tx1 in the same block
+	tx1 := spendForTest(genesis.Transactions[0].Txid(), 0, blockchainparser.TxOutput{Value: 3000, Script: a}, blockchainparser.TxOutput{Value: 2000, Script: b})
+	tx2 := spendForTest(tx1.Txid(), 0, blockchainparser.TxOutput{Value: 3000, Script: b})
+	block := blockForTest(genesis.Hash(), 1, blockchainparser.Script{blockchainparser.OP_TRUE}, tx1, tx2)
+	if err := addrIndex.ConnectBlock(block, 1); err != nil {
+		t.Fatal(err)
+	}
+	if best, height, err := addrIndex.BestBlock(); err != nil || !bytes.Equal(best, block.Hash()) || height != 1 {
+		t.Errorf("Got best block %s at %d, %v", best, height, err)
+	}
+
+	history := historyForTest(t, addrIndex, addressA)
+	if len(history) != 2 {
+		t.Fatalf("Got history %+v", history)
+	}
+	if record := history[0]; !bytes.Equal(record.Txid, genesis.Transactions[0].Txid()) || record.Height != 0 || record.Value != 5000 ||
+		!bytes.Equal(record.SpentBy, tx1.Txid()) || record.SpentHeight != 1 {
+		t.Errorf("Got coinbase record %+v", record)
+	}
+	if record := history[1]; !bytes.Equal(record.Txid, tx1.Txid()) || record.Vout != 0 || record.Height != 1 || record.Value != 3000 ||
+		!bytes.Equal(record.SpentBy, tx2.Txid()) || record.SpentHeight != 1 {
+		t.Errorf("Got record spent in the same block %+v", record)
+	}
+	if balance, err := addrIndex.Balance(addressA); err != nil || balance != (Balance{Received: 8000, Sent: 8000}) {
+		t.Errorf("Got balance %+v, %v", balance, err)
+	}
+	if balance, err := addrIndex.Balance(addressB); err != nil || balance != (Balance{Received: 5000, Unspent: 5000}) {
+		t.Errorf("Got balance %+v, %v", balance, err)
+	}
+
+	// Only the best block can be disconnected, and blocks must extend it
+	if err := addrIndex.DisconnectBlock(genesis, 0); err == nil {
+		t.Error("Disconnected a block below the best block")
+	}
+	if err := addrIndex.ConnectBlock(blockForTest(genesis.Hash(), 2, a), 1); err == nil {
+		t.Error("Connected a block not extending the best block")
+	}
+
+	if err := addrIndex.DisconnectBlock(block, 1); err != nil {
+		t.Fatal(err)
+	}
+	history = historyForTest(t, addrIndex, addressA)
+	if len(history) != 1 || history[0].SpentBy != nil || history[0].SpentHeight != 0 {
+		t.Errorf("Got history %+v after disconnect", history)
+	}
+	if history := historyForTest(t, addrIndex, addressB); len(history) != 0 {
+		t.Errorf("Got history %+v after disconnect", history)
+	}
+	if best, height, err := addrIndex.BestBlock(); err != nil || !bytes.Equal(best, genesis.Hash()) || height != 0 {
+		t.Errorf("Got best block %s at %d, %v", best, height, err)
+	}
+
+	// Nothing left once empty
+	if err := addrIndex.DisconnectBlock(genesis, 0); err != nil {
+		t.Fatal(err)
+	}
+	iter := addrIndex.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		t.Errorf("Key %x left", iter.Key())
+	}
+	if best, height, err := addrIndex.BestBlock(); err != nil || best != nil || height != -1 {
+		t.Errorf("Got best block %s at %d, %v", best, height, err)
+	}
+}
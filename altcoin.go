@@ -0,0 +1,404 @@
+package blockchainparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	//! Network names of the supported altcoins
+	CHAIN_LITECOIN         = "litecoin"
+	CHAIN_LITECOIN_TESTNET = "litecoin-test"
+	CHAIN_DOGECOIN         = "dogecoin"
+	CHAIN_DOGECOIN_TESTNET = "dogecoin-test"
+	CHAIN_BITCOIN_CASH     = "bitcoincash"
+
+	//! Block version bit set when an AuxPoW follows the header
+	BLOCK_VERSION_AUXPOW = 1 << 8
+
+	//! Merged mining chain id of Dogecoin, stored in the high 16 bits of the block version
+	AUXPOW_CHAIN_ID_DOGECOIN = 0x0062
+
+	//! Maximum depth of the merged mining chain merkle tree
+	AUXPOW_MAX_CHAIN_MERKLE_BRANCH = 30
+
+	//! Flag of Litecoin transactions carrying an (optional) MWEB transaction
+	SERIALIZE_FLAG_MWEB = 0x08
+)
+
+// Marks the chain merkle root in the coinbase of a merge mined parent block
+var mergedMiningHeader = []byte{0xfa, 0xbe, 'm', 'm'}
+
+var (
+	LitecoinAddressParams        = &AddressParams{0x30, 0x32, "ltc"}
+	LitecoinTestNetAddressParams = &AddressParams{0x6f, 0x3a, "tltc"}
+	DogecoinAddressParams        = &AddressParams{0x1e, 0x16, ""}
+	DogecoinTestNetAddressParams = &AddressParams{0x71, 0xc4, ""}
+)
+
+var (
+	LitecoinParams = &ChainParams{
+		Name:                   CHAIN_LITECOIN,
+		MagicId:                BLOCK_MAGIC_ID_LITECOIN,
+		RpcPort:                "9332",
+		GenesisHash:            mustHash("12a765e31ffd4059bada1e25190f6e98c99d9714d334efa41a195a7e7e04bfe2"),
+		Address:                LitecoinAddressParams,
+		ParseBlock:             ParseMwebBlockFromFile,
+		PowHash:                ScryptHash,
+		SubsidyHalvingInterval: 840000,
+		BIP34Height:            710000,
+		BIP65Height:            918684,
+		BIP66Height:            811879,
+		CSVHeight:              1201536,
+		SegwitHeight:           1201536,
+		TaprootHeight:          -1,
+	}
+
+	LitecoinTestNetParams = &ChainParams{
+		Name:                   CHAIN_LITECOIN_TESTNET,
+		MagicId:                BLOCK_MAGIC_ID_LITECOIN_TESTNET,
+		DataDirSubDir:          "testnet4",
+		RpcPort:                "19332",
+		GenesisHash:            mustHash("4966625a4b2851d9fdee139e56211a0d88575f59ed816ff5e6a63deb4e3e29a0"),
+		Address:                LitecoinTestNetAddressParams,
+		ParseBlock:             ParseMwebBlockFromFile,
+		PowHash:                ScryptHash,
+		SubsidyHalvingInterval: 840000,
+		BIP34Height:            76,
+		BIP65Height:            76,
+		BIP66Height:            76,
+		CSVHeight:              6048,
+		SegwitHeight:           6048,
+		TaprootHeight:          -1,
+	}
+
+	// Dogecoin has random then fixed block rewards, SubsidyHalvingInterval
+	// only reflects the first reward change. Segwit, CSV and taproot are not
+	// deployed.
+	DogecoinParams = &ChainParams{
+		Name:                   CHAIN_DOGECOIN,
+		MagicId:                BLOCK_MAGIC_ID_DOGECOIN,
+		RpcPort:                "22555",
+		GenesisHash:            mustHash("1a91e3dace36e2be3bf030a65679fe821aa1d6ef92e7c9902eb318182c355691"),
+		Address:                DogecoinAddressParams,
+		ParseBlock:             ParseAuxPowBlockFromFile,
+		PowHash:                ScryptHash,
+		AuxPowChainId:          AUXPOW_CHAIN_ID_DOGECOIN,
+		SubsidyHalvingInterval: 100000,
+		BIP34Height:            1034383,
+		BIP65Height:            3464751,
+		BIP66Height:            1034383,
+		CSVHeight:              NEVER_ACTIVE_HEIGHT,
+		SegwitHeight:           NEVER_ACTIVE_HEIGHT,
+		TaprootHeight:          NEVER_ACTIVE_HEIGHT,
+	}
+
+	DogecoinTestNetParams = &ChainParams{
+		Name:                   CHAIN_DOGECOIN_TESTNET,
+		MagicId:                BLOCK_MAGIC_ID_DOGECOIN_TESTNET,
+		DataDirSubDir:          "testnet3",
+		RpcPort:                "44555",
+		GenesisHash:            mustHash("bb0a78264637406b6360aad926284d544d7049f45189db5664f3c4d07350559e"),
+		Address:                DogecoinTestNetAddressParams,
+		ParseBlock:             ParseAuxPowBlockFromFile,
+		PowHash:                ScryptHash,
+		AuxPowChainId:          AUXPOW_CHAIN_ID_DOGECOIN,
+		SubsidyHalvingInterval: 100000,
+		BIP34Height:            708658,
+		BIP65Height:            1854705,
+		BIP66Height:            708658,
+		CSVHeight:              NEVER_ACTIVE_HEIGHT,
+		SegwitHeight:           NEVER_ACTIVE_HEIGHT,
+		TaprootHeight:          NEVER_ACTIVE_HEIGHT,
+	}
+
+	// Bitcoin Cash keeps bitcoin's block file magic and layout. Its cashaddr
+	// addresses are not supported, only legacy base58 ones.
+	BitcoinCashParams = &ChainParams{
+		Name:                   CHAIN_BITCOIN_CASH,
+		MagicId:                BLOCK_MAGIC_ID_BITCOIN,
+		RpcPort:                "8332",
+		GenesisHash:            mustHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"),
+		Address:                &AddressParams{0x00, 0x05, ""},
+		SubsidyHalvingInterval: SUBSIDY_HALVING_INTERVAL_BITCOIN,
+		BIP34Height:            227931,
+		BIP65Height:            388381,
+		BIP66Height:            363725,
+		CSVHeight:              419328,
+		SegwitHeight:           NEVER_ACTIVE_HEIGHT,
+		TaprootHeight:          NEVER_ACTIVE_HEIGHT,
+	}
+)
+
+// Scrypt(1024, 1, 1) of a block header, the proof of work hash of Litecoin
+// and Dogecoin
+func ScryptHash(header []byte) Hash256 {
+	hash, err := scrypt.Key(header, header, 1024, 1, 1, 32)
+	if err != nil {
+		panic(err)
+	}
+
+	return hash
+}
+
+// Merged mining proof of a Dogecoin (or Namecoin style) block: the parent
+// chain coinbase committing to the block hash, and the parent block header
+// whose proof of work is reused
+type AuxPow struct {
+	CoinbaseTx      Transaction
+	ParentBlockHash Hash256 // unused, kept for serialization
+	CoinbaseBranch  []Hash256
+	CoinbaseIndex   int32
+	ChainBranch     []Hash256
+	ChainIndex      int32
+	ParentBlock     BlockHeader
+}
+
+func (blockHeader *BlockHeader) IsAuxPow() bool {
+	return blockHeader.Version&BLOCK_VERSION_AUXPOW != 0
+}
+
+// Merged mining chain id, from the high 16 bits of the version
+func (blockHeader *BlockHeader) ChainId() int32 {
+	return blockHeader.Version >> 16
+}
+
+func hashesBinary(hashes []Hash256) []byte {
+	bin := Varint(uint64(len(hashes)))
+	for _, hash := range hashes {
+		bin = append(bin, hash...)
+	}
+
+	return bin
+}
+
+func (auxPow *AuxPow) Binary() []byte {
+	bin := auxPow.CoinbaseTx.Binary()
+	bin = append(bin, auxPow.ParentBlockHash...)
+	bin = append(bin, hashesBinary(auxPow.CoinbaseBranch)...)
+	index := make([]byte, 4)
+	binary.LittleEndian.PutUint32(index, uint32(auxPow.CoinbaseIndex))
+	bin = append(bin, index...)
+	bin = append(bin, hashesBinary(auxPow.ChainBranch)...)
+	binary.LittleEndian.PutUint32(index, uint32(auxPow.ChainIndex))
+	bin = append(bin, index...)
+
+	return append(bin, auxPow.ParentBlock.Binary()...)
+}
+
+func parseHashesFromFile(blockFile *BlockFile) []Hash256 {
+	count := blockFile.ReadVarint()
+	var hashes []Hash256
+	for i := uint64(0); i < count; i++ {
+		hashes = append(hashes, blockFile.ReadBytes(32))
+	}
+
+	return hashes
+}
+
+func ParseAuxPowFromFile(blockFile *BlockFile) (*AuxPow, error) {
+	coinbaseTx, err := ParseBlockTransactionFromFile(blockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	auxPow := &AuxPow{CoinbaseTx: *coinbaseTx}
+	auxPow.ParentBlockHash = blockFile.ReadBytes(32)
+	auxPow.CoinbaseBranch = parseHashesFromFile(blockFile)
+	auxPow.CoinbaseIndex = blockFile.ReadInt32()
+	auxPow.ChainBranch = parseHashesFromFile(blockFile)
+	auxPow.ChainIndex = blockFile.ReadInt32()
+
+	parent := &auxPow.ParentBlock
+	parent.Version = blockFile.ReadInt32()
+	parent.HashPrev = blockFile.ReadBytes(32)
+	parent.HashMerkle = blockFile.ReadBytes(32)
+	parent.Timestamp = time.Unix(int64(blockFile.ReadUint32()), 0)
+	parent.TargetDifficulty = blockFile.ReadUint32()
+	parent.Nonce = blockFile.ReadUint32()
+
+	return auxPow, nil
+}
+
+// Port of CAuxPow::CheckMerkleBranch
+func checkMerkleBranch(hash Hash256, branch []Hash256, index int32) Hash256 {
+	if index == -1 {
+		return make(Hash256, 32)
+	}
+	for _, node := range branch {
+		if index&1 == 1 {
+			hash = DoubleSha256(append(append([]byte{}, node...), hash...))
+		} else {
+			hash = DoubleSha256(append(append([]byte{}, hash...), node...))
+		}
+		index >>= 1
+	}
+
+	return hash
+}
+
+// Port of CAuxPow::getExpectedIndex: the slot of chainId in the merged
+// mining merkle tree of the given height
+func auxPowExpectedIndex(nonce uint32, chainId int32, height uint) int32 {
+	rand := nonce*1103515245 + 12345
+	rand += uint32(chainId)
+	rand = rand*1103515245 + 12345
+
+	return int32(rand % (1 << height))
+}
+
+// Checks that the parent coinbase commits to hashAuxBlock for chainId, as
+// CAuxPow::check in Dogecoin (with strict chain ids)
+func (auxPow *AuxPow) Check(hashAuxBlock Hash256, chainId int32) error {
+	if auxPow.CoinbaseIndex != 0 {
+		return errors.New("AuxPoW is not a generate")
+	}
+	if auxPow.ParentBlock.ChainId() == chainId {
+		return errors.New("AuxPoW parent has our chain ID")
+	}
+	if len(auxPow.ChainBranch) > AUXPOW_MAX_CHAIN_MERKLE_BRANCH {
+		return errors.New("AuxPoW chain merkle branch too long")
+	}
+
+	// The chain merkle root is in the coinbase, big endian
+	rootHash := ReverseHex(checkMerkleBranch(hashAuxBlock, auxPow.ChainBranch, auxPow.ChainIndex))
+
+	if !bytes.Equal(checkMerkleBranch(auxPow.CoinbaseTx.Txid(), auxPow.CoinbaseBranch, auxPow.CoinbaseIndex), auxPow.ParentBlock.HashMerkle) {
+		return errors.New("AuxPoW merkle root incorrect")
+	}
+	if len(auxPow.CoinbaseTx.Vin) == 0 {
+		return errors.New("AuxPoW coinbase has no input")
+	}
+
+	script := auxPow.CoinbaseTx.Vin[0].Script
+	head := bytes.Index(script, mergedMiningHeader)
+	pc := bytes.Index(script, rootHash)
+	if pc < 0 {
+		return errors.New("AuxPoW missing chain merkle root in parent coinbase")
+	}
+	if head >= 0 {
+		// Only one chain merkle root, just after the merged mining header
+		if bytes.Contains(script[head+1:], mergedMiningHeader) {
+			return errors.New("Multiple merged mining headers in coinbase")
+		}
+		if head+len(mergedMiningHeader) != pc {
+			return errors.New("Merged mining header is not just before chain merkle root")
+		}
+	} else if pc > 20 {
+		// Legacy coinbases without header must have the root early
+		return errors.New("AuxPoW chain merkle root must start in the first 20 bytes of the parent coinbase")
+	}
+
+	pc += len(rootHash)
+	if len(script)-pc < 8 {
+		return errors.New("AuxPoW missing chain merkle tree size and nonce in parent coinbase")
+	}
+	height := uint(len(auxPow.ChainBranch))
+	if binary.LittleEndian.Uint32(script[pc:]) != 1<<height {
+		return errors.New("AuxPoW merkle branch size does not match parent coinbase")
+	}
+	nonce := binary.LittleEndian.Uint32(script[pc+4:])
+	if auxPow.ChainIndex != auxPowExpectedIndex(nonce, chainId, height) {
+		return errors.New("AuxPoW wrong index")
+	}
+
+	return nil
+}
+
+// Hash compared to the target for the chain's proof of work
+func (params *ChainParams) BlockPowHash(blockHeader *BlockHeader) Hash256 {
+	if params.PowHash == nil {
+		return blockHeader.Hash()
+	}
+
+	return params.PowHash(blockHeader.Binary())
+}
+
+// Checks the proof of work of the block, as CheckAuxPowProofOfWork for merge
+// mined chains: AuxPoW blocks reuse the work of their parent block.
+func (block *Block) CheckProofOfWork(params *ChainParams) error {
+	if params.AuxPowChainId != 0 && block.IsAuxPow() {
+		if block.ChainId() != params.AuxPowChainId {
+			return fmt.Errorf("Block does not have chain ID %d", params.AuxPowChainId)
+		}
+		if block.AuxPow == nil {
+			return errors.New("No AuxPoW with AuxPoW version")
+		}
+		if err := block.AuxPow.Check(block.Hash(), params.AuxPowChainId); err != nil {
+			return err
+		}
+		if !CheckProofOfWork(params.BlockPowHash(&block.AuxPow.ParentBlock), block.TargetDifficulty) {
+			return errors.New("AuxPoW parent block has insufficient proof of work")
+		}
+		return nil
+	}
+
+	if !CheckProofOfWork(params.BlockPowHash(&block.BlockHeader), block.TargetDifficulty) {
+		return errors.New("Block has insufficient proof of work")
+	}
+
+	return nil
+}
+
+// Reads a Dogecoin block, whose header is followed by an AuxPoW when merge mined
+func ParseAuxPowBlockFromFile(blockFile *BlockFile, block *Block) error {
+	err := ParseBlockHeaderFromFile(blockFile, block)
+	if err != nil {
+		return err
+	}
+
+	if block.IsAuxPow() {
+		block.AuxPow, err = ParseAuxPowFromFile(blockFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ParseBlockTransactionsFromFile(blockFile, block)
+}
+
+// Reads a Litecoin block. After MWEB activation the last transaction is the
+// HogEx (integrating transaction) and is followed by the MWEB extension
+// block, which is kept serialized in Block.Mweb.
+func ParseMwebBlockFromFile(blockFile *BlockFile, block *Block) error {
+	start, err := blockFile.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+
+	err = ParseBlockHeaderFromFile(blockFile, block)
+	if err != nil {
+		return err
+	}
+	err = ParseBlockTransactionsFromFile(blockFile, block)
+	if err != nil {
+		return err
+	}
+
+	count := len(block.Transactions)
+	if count < 2 || !block.Transactions[count-1].HogEx {
+		return nil
+	}
+
+	// Optional pointer: a presence byte, then the extension block up to the
+	// end of the block
+	if blockFile.ReadByte() == 0 {
+		return nil
+	}
+	pos, err := blockFile.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+	end := start + 4 + int64(block.Length)
+	if end < pos {
+		return errors.New("Invalid block length")
+	}
+	block.Mweb = blockFile.ReadBytes(uint64(end - pos))
+
+	return nil
+}
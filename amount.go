@@ -0,0 +1,102 @@
+package blockchainparser
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	//! No amount larger than this (in satoshi) is valid
+	MAX_MONEY = 21000000 * COIN
+)
+
+var ErrInvalidAmount = errors.New("Invalid amount")
+
+// Amount in satoshis. In JSON it is a decimal number of BTC with 8 decimals,
+// as bitcoind uses in its RPC.
+type Amount int64
+
+// Converts a BTC value to an Amount, rounding to the nearest satoshi
+func NewAmount(btc float64) (Amount, error) {
+	if math.IsNaN(btc) || math.IsInf(btc, 0) {
+		return 0, ErrInvalidAmount
+	}
+	satoshis := math.Round(btc * COIN)
+	if math.Abs(satoshis) > MAX_MONEY {
+		return 0, ErrInvalidAmount
+	}
+
+	return Amount(satoshis), nil
+}
+
+// Parse a decimal BTC string exactly, e.g. "0.1", "21000000" or "1e-8"
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	// big.Rat also accepts fractions and base prefixes, which JSON numbers can't have
+	if strings.ContainsAny(s, "/xXbBoO_") {
+		return 0, ErrInvalidAmount
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, ErrInvalidAmount
+	}
+	r.Mul(r, big.NewRat(COIN, 1))
+	if !r.IsInt() {
+		// More than 8 decimals
+		return 0, ErrInvalidAmount
+	}
+	n := r.Num()
+	if !n.IsInt64() || n.Int64() > MAX_MONEY || n.Int64() < -MAX_MONEY {
+		return 0, ErrInvalidAmount
+	}
+
+	return Amount(n.Int64()), nil
+}
+
+func (amount Amount) BTC() float64 {
+	return float64(amount) / COIN
+}
+
+// Returns true if amount is within the valid range of money
+func (amount Amount) MoneyRange() bool {
+	return amount >= 0 && amount <= MAX_MONEY
+}
+
+// Exact decimal BTC representation with 8 decimals
+func (amount Amount) String() string {
+	sign := ""
+	abs := uint64(amount)
+	if amount < 0 {
+		sign = "-"
+		abs = uint64(-amount)
+	}
+	fraction := strconv.FormatUint(abs%COIN, 10)
+
+	return sign + strconv.FormatUint(abs/COIN, 10) + "." + strings.Repeat("0", 8-len(fraction)) + fraction
+}
+
+func (amount Amount) MarshalJSON() ([]byte, error) {
+	return []byte(amount.String()), nil
+}
+
+// Accepts a JSON number or a string holding a number
+func (amount *Amount) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+
+	parsed, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*amount = parsed
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package blockchainparser
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Amount
+	}{
+		{"0", 0},
+		{"1", COIN},
+		{"0.1", 10000000},
+		{"0.00000001", 1},
+		{"1e-8", 1},
+		{"1.5E2", 150 * COIN},
+		{"-0.5", -COIN / 2},
+		{" 12.34500000 ", 1234500000},
+		{"21000000", MAX_MONEY},
+		{"-21000000", -MAX_MONEY},
+	}
+	for _, test := range tests {
+		if got, err := ParseAmount(test.s); err != nil || got != test.want {
+			t.Errorf("%q: got %d, %v, want %d", test.s, got, err, test.want)
+		}
+	}
+
+	for _, s := range []string{"", "abc", "0.000000001", "1e-9", "0.123456789", "21000000.00000001", "-21000001", "1e100", "1/2", "0x10", "1_000"} {
+		if got, err := ParseAmount(s); err != ErrInvalidAmount {
+			t.Errorf("%q: got %d, %v", s, got, err)
+		}
+	}
+}
+
+func TestNewAmount(t *testing.T) {
+	// 0.1 + 0.2 is 0.30000000000000004 in floating point
+	if got, err := NewAmount(0.1 + 0.2); err != nil || got != 30000000 {
+		t.Errorf("Got %d, %v", got, err)
+	}
+	if got, err := NewAmount(0.000000005); err != nil || got != 1 {
+		t.Errorf("Half a satoshi: got %d, %v", got, err)
+	}
+	if got, err := NewAmount(21000000.1); err != ErrInvalidAmount {
+		t.Errorf("More than MAX_MONEY: got %d, %v", got, err)
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		want   string
+	}{
+		{0, "0.00000000"},
+		{1, "0.00000001"},
+		{COIN, "1.00000000"},
+		{1234500000, "12.34500000"},
+		{-COIN / 2, "-0.50000000"},
+		{MAX_MONEY, "21000000.00000000"},
+	}
+	for _, test := range tests {
+		if got := test.amount.String(); got != test.want {
+			t.Errorf("%d: got %s, want %s", int64(test.amount), got, test.want)
+		}
+		if parsed, err := ParseAmount(test.want); err != nil || parsed != test.amount {
+			t.Errorf("%s: parsed back to %d, %v", test.want, parsed, err)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	type output struct {
+		Value Amount  `json:"value"`
+		Fee   *Amount `json:"fee"`
+	}
+
+	b, err := json.Marshal(output{Value: 2099999999999999})
+	if err != nil || string(b) != `{"value":20999999.99999999,"fee":null}` {
+		t.Errorf("Got %s, %v", b, err)
+	}
+
+	// bitcoind's amounts survive the round trip exactly, where a float64
+	// would lose the last satoshi
+	var decoded output
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded.Value != 2099999999999999 || decoded.Fee != nil {
+		t.Errorf("Got %+v, %v", decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`{"value":"0.00012","fee":0.0000141}`), &decoded); err != nil || decoded.Value != 12000 || decoded.Fee == nil || *decoded.Fee != 1410 {
+		t.Errorf("Got %+v, %v", decoded, err)
+	}
+
+	for _, s := range []string{`{"value":0.000000001}`, `{"value":true}`, `{"value":"1/2"}`} {
+		if err := json.Unmarshal([]byte(s), &decoded); err == nil {
+			t.Errorf("%s accepted", s)
+		}
+	}
+}
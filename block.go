@@ -14,12 +14,36 @@ func (hash Hash256) String() string {
 	return hex.EncodeToString(ReverseHex(hash))
 }
 
+// Parse a hash from the big endian hex used by bitcoind's RPC
+func NewHash256FromString(s string) (Hash256, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 32 {
+		return nil, errors.New("Invalid hash length")
+	}
+
+	return ReverseHex(b), nil
+}
+
 type MagicId uint32
 
 func (magicId MagicId) String() string {
 	return fmt.Sprintf("%x", uint32(magicId))
 }
 
+const (
+	//! Size of a serialized block header
+	BLOCK_HEADER_SIZE = 80
+
+	//! BIP141 scale factor between non-witness and witness bytes
+	WITNESS_SCALE_FACTOR = 4
+
+	//! The maximum allowed weight for a block, see BIP 141
+	MAX_BLOCK_WEIGHT = 4000000
+)
+
 type BlockHeader struct {
 	hash             Hash256 // not actuallyin blockchain data; for caching
 	Version          int32
@@ -36,15 +60,17 @@ type Block struct {
 	Length           uint32
 	TransactionCount uint64 // txn_count
 	Transactions     []Transaction
-	StartPos         uint64 // not actually in blockchain data
+	AuxPow           *AuxPow // merged mining proof, for AuxPoW chains
+	Mweb             []byte  // serialized Litecoin MWEB extension block, not parsed
+	StartPos         uint64  // not actually in blockchain data
 }
 
-func (blockHeader *BlockHeader) Hash() Hash256 {
-	if blockHeader.hash != nil {
-		return blockHeader.hash
-	}
+// Reads a block from its Length field, after the MagicId
+type BlockParserFunc func(blockFile *BlockFile, block *Block) error
 
-	bin := make([]byte, 0)
+// Serialize the 80 bytes block header
+func (blockHeader *BlockHeader) Binary() []byte {
+	bin := make([]byte, 0, BLOCK_HEADER_SIZE)
 
 	version := make([]byte, 4)
 	binary.LittleEndian.PutUint32(version, uint32(blockHeader.Version))
@@ -65,10 +91,153 @@ func (blockHeader *BlockHeader) Hash() Hash256 {
 	binary.LittleEndian.PutUint32(nonce, blockHeader.Nonce)
 	bin = append(bin, nonce...)
 
-	blockHeader.hash = DoubleSha256(bin)
+	return bin
+}
+
+func (blockHeader *BlockHeader) Hash() Hash256 {
+	if blockHeader.hash != nil {
+		return blockHeader.hash
+	}
+
+	blockHeader.hash = DoubleSha256(blockHeader.Binary())
 	return blockHeader.hash
 }
 
+// Serialize the block with its AuxPoW and transactions, without MagicId,
+// Length and MWEB extension block
+func (block *Block) Binary() []byte {
+	bin := block.BlockHeader.Binary()
+	if block.AuxPow != nil {
+		bin = append(bin, block.AuxPow.Binary()...)
+	}
+	bin = append(bin, Varint(uint64(len(block.Transactions)))...)
+	for _, tx := range block.Transactions {
+		bin = append(bin, tx.Binary()...)
+	}
+
+	return bin
+}
+
+// Size of the header with its AuxPoW
+func (block *Block) headerSize() int {
+	if block.AuxPow != nil {
+		return BLOCK_HEADER_SIZE + len(block.AuxPow.Binary())
+	}
+
+	return BLOCK_HEADER_SIZE
+}
+
+// Size of the block serialized without witness data
+func (block *Block) StrippedSize() int {
+	size := block.headerSize() + len(Varint(uint64(len(block.Transactions))))
+	for _, tx := range block.Transactions {
+		size += tx.StrippedSize()
+	}
+
+	return size
+}
+
+// Size of the block serialized with witness data. For blocks read from disk
+// this matches Length.
+func (block *Block) TotalSize() int {
+	size := block.headerSize() + len(Varint(uint64(len(block.Transactions))))
+	for _, tx := range block.Transactions {
+		size += tx.TotalSize()
+	}
+
+	return size
+}
+
+// Weight as defined in BIP141: stripped size * 3 + total size
+func (block *Block) Weight() int {
+	return block.StrippedSize()*(WITNESS_SCALE_FACTOR-1) + block.TotalSize()
+}
+
+// Virtual size: weight / 4, rounded up
+func (block *Block) VSize() int {
+	return (block.Weight() + WITNESS_SCALE_FACTOR - 1) / WITNESS_SCALE_FACTOR
+}
+
+func (block *Block) CheckWeight() error {
+	if weight := block.Weight(); weight > MAX_BLOCK_WEIGHT {
+		return fmt.Errorf("Block weight %d exceeds limit of %d", weight, MAX_BLOCK_WEIGHT)
+	}
+
+	return nil
+}
+
+// Merkle root of hashes, duplicating the last hash of odd levels as
+// bitcoind's ComputeMerkleRoot does
+func ComputeMerkleRoot(hashes []Hash256) Hash256 {
+	if len(hashes) == 0 {
+		return make(Hash256, 32)
+	}
+
+	level := append([]Hash256{}, hashes...)
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([]Hash256, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, DoubleSha256(append(append([]byte{}, level[i]...), level[i+1]...)))
+		}
+		level = next
+	}
+
+	return level[0]
+}
+
+// Merkle root of the txids of the block's transactions
+func (block *Block) MerkleRoot() Hash256 {
+	hashes := make([]Hash256, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		hashes[i] = tx.Txid()
+	}
+
+	return ComputeMerkleRoot(hashes)
+}
+
+type WitnessStats struct {
+	TxCount          int
+	SegwitTxCount    int // transactions serialized with witness data
+	InputCount       int
+	SegwitInputs     int // inputs with a non-empty witness
+	WitnessBytes     int // marker, flag and witness stacks
+	NonWitnessBytes  int // everything else, including the header
+	WitnessWeight    int
+	NonWitnessWeight int
+}
+
+// Breakdown of the witness versus non-witness bytes of the block
+func (block *Block) WitnessStats() WitnessStats {
+	stats := WitnessStats{TxCount: len(block.Transactions)}
+	stats.NonWitnessBytes = block.headerSize() + len(Varint(uint64(len(block.Transactions))))
+
+	for _, tx := range block.Transactions {
+		stripped := tx.StrippedSize()
+		total := tx.TotalSize()
+
+		stats.NonWitnessBytes += stripped
+		stats.WitnessBytes += total - stripped
+		if tx.HasWitness() {
+			stats.SegwitTxCount++
+		}
+
+		for _, in := range tx.Vin {
+			stats.InputCount++
+			if len(in.ScriptWitness) > 0 {
+				stats.SegwitInputs++
+			}
+		}
+	}
+
+	stats.NonWitnessWeight = stats.NonWitnessBytes * WITNESS_SCALE_FACTOR
+	stats.WitnessWeight = stats.WitnessBytes
+
+	return stats
+}
+
 // Parse the header fields except the MagicId
 // TODO: Currently won't return any error
 func ParseBlockHeaderFromFile(blockFile *BlockFile, block *Block) error {
@@ -136,7 +305,7 @@ func ParseBlockTransactionFromFile(blockFile *BlockFile) (*Transaction, error) {
 	txOutputLength := blockFile.ReadVarint()
 	for i := uint64(0); i < txOutputLength; i++ {
 		output := TxOutput{}
-		output.Value = int64(blockFile.ReadUint64())
+		output.Value = Amount(blockFile.ReadUint64())
 		scriptLength := blockFile.ReadVarint()
 		output.Script = blockFile.ReadBytes(scriptLength)
 		tx.Vout = append(tx.Vout, output)
@@ -154,12 +323,53 @@ func ParseBlockTransactionFromFile(blockFile *BlockFile) (*Transaction, error) {
 		}
 	}
 
+	if txFlag&SERIALIZE_FLAG_MWEB != 0 {
+		// Litecoin: an optional MWEB transaction, only ever empty in blocks
+		// where it marks the HogEx
+		if blockFile.ReadByte() != 0 {
+			return nil, errors.New("MWEB transactions are not supported")
+		}
+		tx.HogEx = true
+	}
+
 	tx.Locktime = blockFile.ReadUint32()
 
 	return tx, nil
 }
 
+// Reads the block at the current position of blockFile. The block layout is
+// chosen from the chain whose magic is magicHeader, and defaults to bitcoin's.
 func ParseBlockFromFile(blockFile *BlockFile, magicHeader MagicId) (*Block, error) {
+	parse := parseBitcoinBlockFromFile
+	if params, err := GetChainParamsByMagicId(magicHeader); err == nil && params.ParseBlock != nil {
+		parse = params.ParseBlock
+	}
+
+	return parseBlockFromFile(blockFile, magicHeader, parse)
+}
+
+// Same as ParseBlockFromFile with the layout of the chain described by params
+func (params *ChainParams) ParseBlockFromFile(blockFile *BlockFile) (*Block, error) {
+	parse := params.ParseBlock
+	if parse == nil {
+		parse = parseBitcoinBlockFromFile
+	}
+
+	return parseBlockFromFile(blockFile, params.MagicId, parse)
+}
+
+func parseBitcoinBlockFromFile(blockFile *BlockFile, block *Block) error {
+	// Read header fields
+	err := ParseBlockHeaderFromFile(blockFile, block)
+	if err != nil {
+		return err
+	}
+
+	// Parse transactions
+	return ParseBlockTransactionsFromFile(blockFile, block)
+}
+
+func parseBlockFromFile(blockFile *BlockFile, magicHeader MagicId, parse BlockParserFunc) (*Block, error) {
 	block := &Block{}
 
 	curPos, err := blockFile.Seek(0, 1)
@@ -174,15 +384,7 @@ func ParseBlockFromFile(blockFile *BlockFile, magicHeader MagicId) (*Block, erro
 		return nil, errors.New("Invalid block header: Can't find Magic ID")
 	}
 
-	// Read header fields
-	err = ParseBlockHeaderFromFile(blockFile, block)
-	if err != nil {
-		blockFile.Seek(curPos, 0) // Seek back to original pos before we encounter the error
-		return nil, err
-	}
-
-	// Parse transactions
-	err = ParseBlockTransactionsFromFile(blockFile, block)
+	err = parse(blockFile, block)
 	if err != nil {
 		blockFile.Seek(curPos, 0) // Seek back to original pos before we encounter the error
 		return nil, err
@@ -9,8 +9,16 @@ import (
 
 const (
 	//! Magic numbers to identify start of block
-	BLOCK_MAGIC_ID_BITCOIN MagicId = 0xd9b4bef9
-	BLOCK_MAGIC_ID_TESTNET MagicId = 0x0709110b
+	BLOCK_MAGIC_ID_BITCOIN  MagicId = 0xd9b4bef9
+	BLOCK_MAGIC_ID_TESTNET  MagicId = 0x0709110b
+	BLOCK_MAGIC_ID_TESTNET4 MagicId = 0x283f161c
+	BLOCK_MAGIC_ID_SIGNET   MagicId = 0x40cf030a
+	BLOCK_MAGIC_ID_REGTEST  MagicId = 0xdab5bffa
+
+	BLOCK_MAGIC_ID_LITECOIN         MagicId = 0xdbb6c0fb
+	BLOCK_MAGIC_ID_LITECOIN_TESTNET MagicId = 0xf1c8d2fd
+	BLOCK_MAGIC_ID_DOGECOIN         MagicId = 0xc0c0c0c0
+	BLOCK_MAGIC_ID_DOGECOIN_TESTNET MagicId = 0xdcb7c1fc
 )
 
 type BlockFile struct {
@@ -18,8 +26,13 @@ type BlockFile struct {
 	FileNum uint32
 }
 
+// Path of the blk*.dat file number fileNum
+func BlockFilePath(blockchainDataDir string, fileNum uint32) string {
+	return fmt.Sprintf(blockchainDataDir+"/blocks/blk%05d.dat", fileNum)
+}
+
 func NewBlockFile(blockchainDataDir string, fileNum uint32) (*BlockFile, error) {
-	filepath := fmt.Sprintf(blockchainDataDir+"/blocks/blk%05d.dat", fileNum)
+	filepath := BlockFilePath(blockchainDataDir, fileNum)
 	//fmt.Printf("Opening file %s...\n", filepath)
 
 	file, err := os.OpenFile(filepath, os.O_RDONLY, 0666)
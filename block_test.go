@@ -0,0 +1,34 @@
+package blockchainparser
+
+import (
+	"testing"
+)
+
+func TestWitnessStatsAuxPow(t *testing.T) {
+	coinbase := Transaction{
+		Version: 1,
+		Vin:     []TxInput{{Hash: make(Hash256, 32), Index: 0xffffffff, Script: Script{1, 1}, Sequence: 0xffffffff}},
+		Vout:    []TxOutput{{Value: 10000, Script: Script{OP_1}}},
+	}
+	block := &Block{Transactions: []Transaction{coinbase}}
+	block.Version = BLOCK_VERSION_AUXPOW | AUXPOW_CHAIN_ID_DOGECOIN<<16
+	block.HashPrev = make(Hash256, 32)
+	block.HashMerkle = block.MerkleRoot()
+	block.AuxPow = &AuxPow{
+		CoinbaseTx:      coinbase,
+		ParentBlockHash: make(Hash256, 32),
+		CoinbaseBranch:  []Hash256{make(Hash256, 32)},
+		ParentBlock:     BlockHeader{HashPrev: make(Hash256, 32), HashMerkle: make(Hash256, 32)},
+	}
+
+	stats := block.WitnessStats()
+	if stats.NonWitnessBytes != block.StrippedSize() {
+		t.Errorf("Non-witness bytes %d, stripped size %d", stats.NonWitnessBytes, block.StrippedSize())
+	}
+	if stats.NonWitnessBytes+stats.WitnessBytes != block.TotalSize() {
+		t.Errorf("Witness stats count %d bytes, total size %d", stats.NonWitnessBytes+stats.WitnessBytes, block.TotalSize())
+	}
+	if block.TotalSize() != len(block.Binary()) {
+		t.Errorf("Total size %d, serialized %d bytes", block.TotalSize(), len(block.Binary()))
+	}
+}
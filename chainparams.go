@@ -0,0 +1,215 @@
+package blockchainparser
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"path/filepath"
+)
+
+const (
+	//! Network names, as in bitcoind's -chain option
+	CHAIN_MAIN     = "main"
+	CHAIN_TESTNET  = "test"
+	CHAIN_TESTNET4 = "testnet4"
+	CHAIN_SIGNET   = "signet"
+	CHAIN_REGTEST  = "regtest"
+
+	//! Activation height of soft forks a chain never deployed
+	NEVER_ACTIVE_HEIGHT = math.MaxInt32
+)
+
+// Network and consensus parameters of a chain, see bitcoind's chainparams.cpp
+type ChainParams struct {
+	Name          string // one of CHAIN_*
+	MagicId       MagicId
+	DataDirSubDir string // under the bitcoind data directory, empty for mainnet
+	RpcPort       string
+	GenesisHash   Hash256
+	Address       *AddressParams
+
+	// Script that signet block solutions must satisfy, nil for other chains
+	SignetChallenge Script
+
+	// Coin specific block layout, nil for bitcoin's
+	ParseBlock BlockParserFunc
+	// Proof of work hash of a serialized header, nil for double SHA256
+	PowHash func(header []byte) Hash256
+	// Merged mining chain id, 0 for chains without AuxPoW
+	AuxPowChainId int32
+
+	SubsidyHalvingInterval int32
+
+	// Heights from which soft forks are enforced. Taproot is -1 where it is
+	// not buried and was activated by signalling.
+	BIP34Height   int32
+	BIP65Height   int32
+	BIP66Height   int32
+	CSVHeight     int32
+	SegwitHeight  int32
+	TaprootHeight int32
+}
+
+func mustHash(s string) Hash256 {
+	hash, err := NewHash256FromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+var (
+	MainNetParams = &ChainParams{
+		Name:                   CHAIN_MAIN,
+		MagicId:                BLOCK_MAGIC_ID_BITCOIN,
+		RpcPort:                "8332",
+		GenesisHash:            mustHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"),
+		Address:                MainNetAddressParams,
+		SubsidyHalvingInterval: SUBSIDY_HALVING_INTERVAL_BITCOIN,
+		BIP34Height:            227931,
+		BIP65Height:            388381,
+		BIP66Height:            363725,
+		CSVHeight:              419328,
+		SegwitHeight:           481824,
+		TaprootHeight:          709632,
+	}
+
+	TestNetParams = &ChainParams{
+		Name:                   CHAIN_TESTNET,
+		MagicId:                BLOCK_MAGIC_ID_TESTNET,
+		DataDirSubDir:          "testnet3",
+		RpcPort:                "18332",
+		GenesisHash:            mustHash("000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943"),
+		Address:                TestNetAddressParams,
+		SubsidyHalvingInterval: SUBSIDY_HALVING_INTERVAL_BITCOIN,
+		BIP34Height:            21111,
+		BIP65Height:            581885,
+		BIP66Height:            330776,
+		CSVHeight:              770112,
+		SegwitHeight:           834624,
+		TaprootHeight:          -1,
+	}
+
+	TestNet4Params = &ChainParams{
+		Name:                   CHAIN_TESTNET4,
+		MagicId:                BLOCK_MAGIC_ID_TESTNET4,
+		DataDirSubDir:          "testnet4",
+		RpcPort:                "48332",
+		GenesisHash:            mustHash("00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043"),
+		Address:                TestNetAddressParams,
+		SubsidyHalvingInterval: SUBSIDY_HALVING_INTERVAL_BITCOIN,
+		BIP34Height:            1,
+		BIP65Height:            1,
+		BIP66Height:            1,
+		CSVHeight:              1,
+		SegwitHeight:           1,
+		TaprootHeight:          0,
+	}
+
+	// Default signet
+	SigNetParams = &ChainParams{
+		Name:                   CHAIN_SIGNET,
+		MagicId:                BLOCK_MAGIC_ID_SIGNET,
+		DataDirSubDir:          "signet",
+		RpcPort:                "38332",
+		GenesisHash:            mustHash("00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6"),
+		Address:                TestNetAddressParams,
+		SignetChallenge:        mustHex(SIGNET_DEFAULT_CHALLENGE),
+		SubsidyHalvingInterval: SUBSIDY_HALVING_INTERVAL_BITCOIN,
+		BIP34Height:            1,
+		BIP65Height:            1,
+		BIP66Height:            1,
+		CSVHeight:              1,
+		SegwitHeight:           1,
+		TaprootHeight:          0,
+	}
+
+	RegTestParams = &ChainParams{
+		Name:                   CHAIN_REGTEST,
+		MagicId:                BLOCK_MAGIC_ID_REGTEST,
+		DataDirSubDir:          "regtest",
+		RpcPort:                "18443",
+		GenesisHash:            mustHash("0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"),
+		Address:                RegTestAddressParams,
+		SubsidyHalvingInterval: SUBSIDY_HALVING_INTERVAL_REGTEST,
+		BIP34Height:            1,
+		BIP65Height:            1,
+		BIP66Height:            1,
+		CSVHeight:              1,
+		SegwitHeight:           0,
+		TaprootHeight:          0,
+	}
+)
+
+// Bitcoin Cash comes after mainnet, which has the same block file magic
+var chainParams = []*ChainParams{
+	MainNetParams, TestNetParams, TestNet4Params, SigNetParams, RegTestParams,
+	LitecoinParams, LitecoinTestNetParams, DogecoinParams, DogecoinTestNetParams, BitcoinCashParams,
+}
+
+// Returns the parameters of the network named as in bitcoind's -chain option
+func GetChainParams(name string) (*ChainParams, error) {
+	for _, params := range chainParams {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+
+	return nil, errors.New("Unknown chain: " + name)
+}
+
+// Returns the parameters of the network whose blocks start with magicId
+func GetChainParamsByMagicId(magicId MagicId) (*ChainParams, error) {
+	for _, params := range chainParams {
+		if params.MagicId == magicId {
+			return params, nil
+		}
+	}
+
+	return nil, errors.New("Unknown magic id: " + magicId.String())
+}
+
+// Network specific data directory under the bitcoind data directory
+func (params *ChainParams) DataDir(datadir string) string {
+	if params.DataDirSubDir == "" {
+		return datadir
+	}
+
+	return filepath.Join(datadir, params.DataDirSubDir)
+}
+
+// Script verification flags enforced for a block at height, as in
+// bitcoind's GetBlockScriptFlags. P2SH rules are applied to every block
+// (ignoring bitcoind's exception blocks), segwit and taproot rules from
+// their activation, so never on chains that didn't adopt them.
+func (params *ChainParams) ScriptFlags(height int32) ScriptFlags {
+	flags := SCRIPT_VERIFY_P2SH
+	if height >= params.SegwitHeight {
+		flags |= SCRIPT_VERIFY_WITNESS
+	}
+	if height >= params.TaprootHeight {
+		flags |= SCRIPT_VERIFY_TAPROOT
+	}
+	if height >= params.BIP66Height {
+		flags |= SCRIPT_VERIFY_DERSIG
+	}
+	if height >= params.BIP65Height {
+		flags |= SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY
+	}
+	if height >= params.CSVHeight {
+		flags |= SCRIPT_VERIFY_CHECKSEQUENCEVERIFY
+	}
+	if height >= params.SegwitHeight {
+		flags |= SCRIPT_VERIFY_NULLDUMMY
+	}
+
+	return flags
+}
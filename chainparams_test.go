@@ -0,0 +1,126 @@
+package blockchainparser
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGetChainParams(t *testing.T) {
+	for _, params := range []*ChainParams{MainNetParams, TestNetParams, TestNet4Params, SigNetParams, RegTestParams,
+		LitecoinParams, LitecoinTestNetParams, DogecoinParams, DogecoinTestNetParams} {
+		byName, err := GetChainParams(params.Name)
+		if err != nil || byName != params {
+			t.Errorf("%s by name: got %v, %v", params.Name, byName, err)
+		}
+		byMagic, err := GetChainParamsByMagicId(params.MagicId)
+		if err != nil || byMagic != params {
+			t.Errorf("%s by magic id %s: got %v, %v", params.Name, params.MagicId, byMagic, err)
+		}
+	}
+
+	// Bitcoin Cash shares its block file magic with mainnet, so it can only
+	// be selected by name
+	if params, err := GetChainParams(BitcoinCashParams.Name); err != nil || params != BitcoinCashParams {
+		t.Errorf("Bitcoin Cash by name: got %v, %v", params, err)
+	}
+	if params, err := GetChainParamsByMagicId(BitcoinCashParams.MagicId); err != nil || params != MainNetParams {
+		t.Errorf("Bitcoin Cash by magic id: got %v, %v", params, err)
+	}
+
+	if _, err := GetChainParams("testnet3"); err == nil {
+		t.Error("Data directory name accepted as chain name")
+	}
+	if _, err := GetChainParamsByMagicId(0x01020304); err == nil {
+		t.Error("Unknown magic id accepted")
+	}
+}
+
+func TestChainParamsDataDir(t *testing.T) {
+	if got := MainNetParams.DataDir("/btc"); got != "/btc" {
+		t.Errorf("Mainnet: %s", got)
+	}
+	if got := TestNetParams.DataDir("/btc"); got != filepath.Join("/btc", "testnet3") {
+		t.Errorf("Testnet: %s", got)
+	}
+	if got := RegTestParams.DataDir("/btc"); got != filepath.Join("/btc", "regtest") {
+		t.Errorf("Regtest: %s", got)
+	}
+}
+
+func TestChainParamsScriptFlags(t *testing.T) {
+	segwit := SCRIPT_VERIFY_P2SH | SCRIPT_VERIFY_DERSIG | SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY | SCRIPT_VERIFY_CHECKSEQUENCEVERIFY |
+		SCRIPT_VERIFY_WITNESS | SCRIPT_VERIFY_NULLDUMMY
+	tests := []struct {
+		height int32
+		flags  ScriptFlags
+	}{
+		{0, SCRIPT_VERIFY_P2SH},
+		{363724, SCRIPT_VERIFY_P2SH},
+		{363725, SCRIPT_VERIFY_P2SH | SCRIPT_VERIFY_DERSIG},
+		{388381, SCRIPT_VERIFY_P2SH | SCRIPT_VERIFY_DERSIG | SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY},
+		{419328, SCRIPT_VERIFY_P2SH | SCRIPT_VERIFY_DERSIG | SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY | SCRIPT_VERIFY_CHECKSEQUENCEVERIFY},
+		{481824, segwit},
+		{709632, segwit | SCRIPT_VERIFY_TAPROOT},
+	}
+	for _, test := range tests {
+		if got := MainNetParams.ScriptFlags(test.height); got != test.flags {
+			t.Errorf("Height %d: got %x, want %x", test.height, got, test.flags)
+		}
+	}
+
+	if got := RegTestParams.ScriptFlags(0); got != SCRIPT_VERIFY_P2SH|SCRIPT_VERIFY_WITNESS|SCRIPT_VERIFY_NULLDUMMY|SCRIPT_VERIFY_TAPROOT {
+		t.Errorf("Regtest genesis: got %x", got)
+	}
+	if got := RegTestParams.ScriptFlags(1); got != segwit|SCRIPT_VERIFY_TAPROOT {
+		t.Errorf("Regtest: got %x", got)
+	}
+}
+
+func TestScriptFlags(t *testing.T) {
+	tests := []struct {
+		params  *ChainParams
+		height  int32
+		witness bool
+		taproot bool
+	}{
+		{MainNetParams, 481823, false, false},
+		{MainNetParams, 481824, true, false},
+		{MainNetParams, 709632, true, true},
+		{LitecoinParams, 1201536, true, true}, // taproot activated by signalling
+		{DogecoinParams, 5000000, false, false},
+		{BitcoinCashParams, 800000, false, false},
+	}
+
+	for _, test := range tests {
+		flags := test.params.ScriptFlags(test.height)
+		if flags&SCRIPT_VERIFY_P2SH == 0 {
+			t.Errorf("%s at %d: P2SH not enforced", test.params.Name, test.height)
+		}
+		if (flags&SCRIPT_VERIFY_WITNESS != 0) != test.witness {
+			t.Errorf("%s at %d: witness enforced = %v", test.params.Name, test.height, !test.witness)
+		}
+		if (flags&SCRIPT_VERIFY_TAPROOT != 0) != test.taproot {
+			t.Errorf("%s at %d: taproot enforced = %v", test.params.Name, test.height, !test.taproot)
+		}
+	}
+}
+
+// Bitcoin Cash recovers coins sent to P2SH-wrapped segwit addresses by
+// spending them without a witness, which segwit rules forbid
+func TestScriptFlagsSegwitRecovery(t *testing.T) {
+	redeemScript := append(Script{OP_0, 20}, Hash160([]byte("key"))...)
+	scriptPubKey := append(append(Script{OP_HASH160, 20}, Hash160(redeemScript)...), OP_EQUAL)
+	tx := &Transaction{
+		Version: 1,
+		Vin:     []TxInput{{Hash: make(Hash256, 32), Script: PushData(redeemScript), Sequence: 0xffffffff}},
+		Vout:    []TxOutput{{Value: 1000, Script: Script{OP_RETURN}}},
+	}
+	prevouts := []TxOutput{{Value: 2000, Script: scriptPubKey}}
+
+	if result := VerifyInputScript(tx, 0, prevouts, BitcoinCashParams.ScriptFlags(800000), false); !result.Valid {
+		t.Errorf("Bitcoin Cash: %s", result.Err)
+	}
+	if result := VerifyInputScript(tx, 0, prevouts, MainNetParams.ScriptFlags(800000), false); result.Valid {
+		t.Error("Bitcoin: witness program spent without a witness")
+	}
+}
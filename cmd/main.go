@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/ruqqq/blockchainparser"
+	"github.com/ruqqq/blockchainparser/addrindex"
 	"github.com/ruqqq/blockchainparser/db"
+	"github.com/ruqqq/blockchainparser/scanner"
+	"github.com/ruqqq/blockchainparser/txindex"
+	"github.com/syndtr/goleveldb/leveldb"
 	"log"
 	"os"
 	"strconv"
@@ -15,21 +20,41 @@ import (
 func main() {
 	var help bool
 	var testnet bool
+	var chain string
 	var datadir string
-	flag.BoolVar(&testnet, "testnet", testnet, "Use testnet")
+	var signetChallenge string
+	var addrIndexPath string
+	var txIndexPath string
+	flag.BoolVar(&testnet, "testnet", testnet, "Use testnet (same as -chain=test)")
+	flag.StringVar(&chain, "chain", blockchainparser.CHAIN_MAIN, "Network: main, test, testnet4, signet, regtest, litecoin, litecoin-test, dogecoin, dogecoin-test or bitcoincash")
+	flag.StringVar(&signetChallenge, "signetchallenge", "", "Hex challenge of a custom signet (implies -chain=signet)")
 	flag.StringVar(&datadir, "datadir", blockchainparser.BitcoinDir(), "Bitcoin data path")
+	flag.StringVar(&addrIndexPath, "addrindex", "addrindex", "Path of the address index built by BuildAddrIndex")
+	flag.StringVar(&txIndexPath, "txindex", "txindex", "Path of the txindex built by BuildTxIndex, used when bitcoind runs without -txindex")
 	flag.BoolVar(&help, "help", help, "Show help")
 	flag.Parse()
 
-	fmt.Printf("testnet: %v\n", testnet)
+	if testnet {
+		chain = blockchainparser.CHAIN_TESTNET
+	}
+	params, err := blockchainparser.GetChainParams(chain)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if signetChallenge != "" {
+		challenge, err := hex.DecodeString(signetChallenge)
+		if err != nil {
+			log.Fatal(err)
+		}
+		params = blockchainparser.NewSigNetParams(challenge)
+	}
+
+	fmt.Printf("chain: %s\n", params.Name)
 	fmt.Printf("datapath: %s\n", datadir)
 	args := flag.Args()
 
-	magicId := blockchainparser.BLOCK_MAGIC_ID_BITCOIN
-	if testnet {
-		datadir += "/testnet3"
-		magicId = blockchainparser.BLOCK_MAGIC_ID_TESTNET
-	}
+	datadir = params.DataDir(datadir)
+	magicId := params.MagicId
 
 	showHelp := func() {
 		fmt.Fprint(os.Stderr, "blockchainparser\n(c)2017 Faruq Rasid\n\n"+
@@ -39,11 +64,16 @@ func main() {
 			"  GetBlockFromFile <fileNum> <blockStartPos>\n"+
 			"  GetTx <hash>\n"+
 			"  GetTxIndexRecord <hash>\n"+
+			"  GetTxIndexBestBlock\n"+
 			"  GetTxFromFile <fileNum> <blockStartPos> <txPos>\n"+
 			"  GetFileInfoRecord <fileNum>\n"+
 			"  GetLastBlockFileNumberUsed\n"+
 			"  GetFlag <name>\n"+
 			"  GetReindexing\n"+
+			"  BuildTxIndex\n"+
+			"  BuildAddrIndex\n"+
+			"  GetAddressHistory <address>\n"+
+			"  GetAddressBalance <address>\n"+
 			"\n"+
 			"Options:\n")
 		flag.PrintDefaults()
@@ -101,11 +131,7 @@ func main() {
 		fmt.Printf("%+v\n", block)
 	} else if len(args) == 2 && args[0] == "GetTx" {
 		failIfReindexing(indexDb)
-		f, _ := db.GetFlag(indexDb, []byte("txindex"))
-		if !f {
-			log.Fatal(errors.New("txindex is not enabled for your bitcoind"))
-		}
-		result, err := db.GetTxIndexRecordByBigEndianHex(indexDb, args[1])
+		result, err := getTxIndexRecord(indexDb, datadir, txIndexPath, params, args[1])
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -119,15 +145,26 @@ func main() {
 		fmt.Printf("%+v\n", tx)
 	} else if len(args) == 2 && args[0] == "GetTxIndexRecord" {
 		failIfReindexing(indexDb)
-		f, _ := db.GetFlag(indexDb, []byte("txindex"))
-		if !f {
-			log.Fatal(errors.New("txindex is not enabled for your bitcoind"))
-		}
-		result, err := db.GetTxIndexRecordByBigEndianHex(indexDb, args[1])
+		result, err := getTxIndexRecord(indexDb, datadir, txIndexPath, params, args[1])
 		if err != nil {
 			log.Fatal(err)
 		}
 		fmt.Printf("%+v\n", result)
+	} else if args[0] == "GetTxIndexBestBlock" {
+		txIndexDb, err := db.OpenTxIndexDb(datadir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer txIndexDb.Close()
+
+		locator, err := db.GetTxIndexBestBlock(txIndexDb)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(locator.Have) > 0 {
+			fmt.Printf("best block: %s\n", locator.Have[0])
+		}
+		fmt.Printf("%+v\n", locator)
 	} else if len(args) == 4 && args[0] == "GetTxFromFile" {
 		num, err := strconv.ParseUint(args[1], 10, 32)
 		if err != nil {
@@ -174,12 +211,124 @@ func main() {
 			log.Fatal(err)
 		}
 		fmt.Printf("%+v\n", result)
+	} else if args[0] == "BuildTxIndex" {
+		failIfReindexing(indexDb)
+		chainIndex, err := scanner.LoadDataDirChainIndex(indexDb, datadir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		txIndex, err := txindex.Open(txIndexPath, params)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer txIndex.Close()
+
+		err = txIndex.Update(context.Background(), chainIndex, datadir, scanner.Options{OnProgress: printProgress})
+		if err != nil {
+			log.Fatal(err)
+		}
+		best, height, err := txIndex.BestBlock()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Indexed up to %s at height %d\n", best, height)
+	} else if args[0] == "BuildAddrIndex" {
+		failIfReindexing(indexDb)
+		chainIndex, err := scanner.LoadDataDirChainIndex(indexDb, datadir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		addrIndex := openAddrIndex(addrIndexPath, params)
+		defer addrIndex.Close()
+
+		err = addrIndex.Update(context.Background(), chainIndex, datadir, scanner.Options{OnProgress: printProgress})
+		if err != nil {
+			log.Fatal(err)
+		}
+		best, height, err := addrIndex.BestBlock()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Indexed up to %s at height %d\n", best, height)
+	} else if len(args) == 2 && args[0] == "GetAddressHistory" {
+		addrIndex := openAddrIndex(addrIndexPath, params)
+		defer addrIndex.Close()
+
+		records, err := addrIndex.History(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, record := range records {
+			fmt.Printf("%+v\n", record)
+		}
+	} else if len(args) == 2 && args[0] == "GetAddressBalance" {
+		addrIndex := openAddrIndex(addrIndexPath, params)
+		defer addrIndex.Close()
+
+		balance, err := addrIndex.Balance(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%+v\n", balance)
 	} else {
 		showHelp()
 		return
 	}
 }
 
+// Looks a transaction up in the txindex of bitcoind, in its own database
+// since 0.17 or in the block index before, then in the one built by
+// BuildTxIndex, which also covers bitcoind running without -txindex
+func getTxIndexRecord(indexDb *db.IndexDb, datadir string, txIndexPath string, params *blockchainparser.ChainParams, txHash string) (*db.TxIndexRecord, error) {
+	enabled := false
+	if db.HasTxIndexDb(datadir) {
+		txIndexDb, err := db.OpenTxIndexDb(datadir)
+		if err != nil {
+			return nil, err
+		}
+		defer txIndexDb.Close()
+
+		enabled = true
+		record, err := db.GetTxIndexDbRecordByBigEndianHex(txIndexDb, txHash)
+		if err != leveldb.ErrNotFound {
+			return record, err
+		}
+	} else if enabled, _ = db.GetFlag(indexDb, []byte("txindex")); enabled {
+		record, err := db.GetTxIndexRecordByBigEndianHex(indexDb, txHash)
+		if err != leveldb.ErrNotFound {
+			return record, err
+		}
+	}
+
+	txid, err := blockchainparser.NewHash256FromString(txHash)
+	if err != nil {
+		return nil, err
+	}
+	txIndex, err := txindex.OpenReadOnly(txIndexPath, params)
+	if err != nil {
+		if enabled {
+			return nil, leveldb.ErrNotFound
+		}
+		return nil, errors.New("txindex is not enabled for your bitcoind, build one with BuildTxIndex")
+	}
+	defer txIndex.Close()
+
+	return txIndex.GetTxIndexRecord(txid)
+}
+
+func printProgress(progress scanner.Progress) {
+	fmt.Printf("files %d/%d, %d blocks, %.0f blocks/s\n", progress.FilesDone, progress.FilesTotal, progress.Blocks, progress.BlocksPerSecond())
+}
+
+func openAddrIndex(path string, params *blockchainparser.ChainParams) *addrindex.AddrIndex {
+	addrIndex, err := addrindex.Open(path, params)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return addrIndex
+}
+
 func failIfReindexing(indexDb *db.IndexDb) {
 	result, err := db.GetReindexing(indexDb)
 	if err != nil {
@@ -0,0 +1,221 @@
+package blockchainparser
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var ErrUnexpectedEnd = errors.New("Unexpected end of data")
+
+// Bounds checked reader over serialized data. The first overrun is kept in
+// err and every read after it returns zero values.
+type byteReader struct {
+	b   []byte
+	pos uint64
+	err error
+}
+
+func (r *byteReader) readBytes(length uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if length > uint64(len(r.b))-r.pos {
+		r.err = ErrUnexpectedEnd
+		return nil
+	}
+	val := make([]byte, length)
+	copy(val, r.b[r.pos:r.pos+length])
+	r.pos += length
+	return val
+}
+
+func (r *byteReader) peekByte() (byte, bool) {
+	if r.err != nil || r.pos >= uint64(len(r.b)) {
+		return 0, false
+	}
+	return r.b[r.pos], true
+}
+
+func (r *byteReader) readByte() byte {
+	val := r.readBytes(1)
+	if val == nil {
+		return 0
+	}
+	return val[0]
+}
+
+func (r *byteReader) readUint16() uint16 {
+	val := r.readBytes(2)
+	if val == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(val)
+}
+
+func (r *byteReader) readUint32() uint32 {
+	val := r.readBytes(4)
+	if val == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(val)
+}
+
+func (r *byteReader) readUint64() uint64 {
+	val := r.readBytes(8)
+	if val == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(val)
+}
+
+func (r *byteReader) readVarint() uint64 {
+	intType := r.readByte()
+	if intType == 0xFF {
+		return r.readUint64()
+	} else if intType == 0xFE {
+		return uint64(r.readUint32())
+	} else if intType == 0xFD {
+		return uint64(r.readUint16())
+	}
+
+	return uint64(intType)
+}
+
+// Reads a length prefix, failing early when it can't fit in the remaining
+// data so that garbage lengths don't allocate huge buffers
+func (r *byteReader) readCount(minItemSize uint64) uint64 {
+	count := r.readVarint()
+	if r.err == nil && count > (uint64(len(r.b))-r.pos)/minItemSize {
+		r.err = ErrUnexpectedEnd
+		return 0
+	}
+	return count
+}
+
+func (r *byteReader) readHeader(blockHeader *BlockHeader) {
+	blockHeader.Version = int32(r.readUint32())
+	blockHeader.HashPrev = r.readBytes(32)
+	blockHeader.HashMerkle = r.readBytes(32)
+	blockHeader.Timestamp = time.Unix(int64(r.readUint32()), 0)
+	blockHeader.TargetDifficulty = r.readUint32()
+	blockHeader.Nonce = r.readUint32()
+}
+
+// Same as ParseBlockTransactionFromFile. Without allowWitness the legacy
+// format is assumed, so that transactions without inputs can be read.
+func (r *byteReader) readTransaction(allowWitness bool) *Transaction {
+	tx := &Transaction{}
+	tx.StartPos = r.pos
+	tx.Version = int32(r.readUint32())
+
+	// Check for extended transaction serialization format
+	var txFlag byte
+	txInputLength := r.readCount(41)
+	if txInputLength == 0 && allowWitness {
+		if p, ok := r.peekByte(); ok && p != 0 {
+			txFlag = r.readByte()
+			txInputLength = r.readCount(41)
+		}
+	}
+
+	for i := uint64(0); i < txInputLength && r.err == nil; i++ {
+		input := TxInput{}
+		input.Hash = r.readBytes(32)
+		input.Index = r.readUint32()
+		input.Script = r.readBytes(r.readVarint())
+		input.Sequence = r.readUint32()
+		tx.Vin = append(tx.Vin, input)
+	}
+
+	txOutputLength := r.readCount(9)
+	for i := uint64(0); i < txOutputLength && r.err == nil; i++ {
+		output := TxOutput{}
+		output.Value = Amount(r.readUint64())
+		output.Script = r.readBytes(r.readVarint())
+		tx.Vout = append(tx.Vout, output)
+	}
+
+	if txFlag&1 == 1 {
+		txFlag ^= 1
+		for i := range tx.Vin {
+			witnessCount := r.readCount(1)
+			tx.Vin[i].ScriptWitness = make([][]byte, witnessCount)
+			for j := uint64(0); j < witnessCount; j++ {
+				tx.Vin[i].ScriptWitness[j] = r.readBytes(r.readVarint())
+			}
+		}
+		if r.err == nil && !tx.HasWitness() {
+			// Superfluous witness record
+			r.err = errors.New("Witness flag set but all witnesses are empty")
+		}
+	}
+	if r.err == nil && txFlag != 0 {
+		r.err = errors.New("Unknown transaction optional data")
+	}
+
+	tx.Locktime = r.readUint32()
+
+	return tx
+}
+
+// Parse a serialized transaction (with or without witness), as returned by
+// getrawtransaction
+func ParseTransactionFromBytes(b []byte) (*Transaction, error) {
+	return parseTransactionFromBytes(b, true)
+}
+
+// Parse a transaction serialized without witness, such as the unsigned
+// transaction of a PSBT
+func ParseTransactionFromBytesNoWitness(b []byte) (*Transaction, error) {
+	return parseTransactionFromBytes(b, false)
+}
+
+func parseTransactionFromBytes(b []byte, allowWitness bool) (*Transaction, error) {
+	r := &byteReader{b: b}
+	tx := r.readTransaction(allowWitness)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.pos != uint64(len(b)) {
+		return nil, errors.New("Unexpected data after transaction")
+	}
+
+	return tx, nil
+}
+
+// Parse a serialized 80 bytes block header, as returned by getblockheader
+func ParseBlockHeaderFromBytes(b []byte) (*BlockHeader, error) {
+	if len(b) != BLOCK_HEADER_SIZE {
+		return nil, errors.New("Invalid block header size")
+	}
+
+	r := &byteReader{b: b}
+	blockHeader := &BlockHeader{}
+	r.readHeader(blockHeader)
+
+	return blockHeader, r.err
+}
+
+// Parse a serialized block, as returned by getblock. The block has no
+// MagicId and its Length is the size of b. Transaction StartPos are offsets
+// in b.
+func ParseBlockFromBytes(b []byte) (*Block, error) {
+	r := &byteReader{b: b}
+	block := &Block{Length: uint32(len(b))}
+	r.readHeader(&block.BlockHeader)
+
+	block.TransactionCount = r.readCount(60)
+	for t := uint64(0); t < block.TransactionCount && r.err == nil; t++ {
+		tx := r.readTransaction(true)
+		block.Transactions = append(block.Transactions, *tx)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.pos != uint64(len(b)) {
+		return nil, errors.New("Unexpected data after block")
+	}
+
+	return block, nil
+}
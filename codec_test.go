@@ -0,0 +1,88 @@
+package blockchainparser
+
+import (
+	"bytes"
+	"testing"
+)
+
+// A block of legacyTxForTest and segwitTxForTest under a zero header
+func blockBytesForTest(t *testing.T) []byte {
+	b := make([]byte, BLOCK_HEADER_SIZE)
+	b[0] = 1
+	b = append(b, 2)
+	b = append(b, hexForTest(t, legacyTxForTest)...)
+	return append(b, hexForTest(t, segwitTxForTest)...)
+}
+
+func TestParseBlockFromBytes(t *testing.T) {
+	b := blockBytesForTest(t)
+	block, err := ParseBlockFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Version != 1 || block.Length != uint32(len(b)) || block.TransactionCount != 2 || len(block.Transactions) != 2 {
+		t.Fatalf("Got %+v", block)
+	}
+
+	legacy, segwit := hexForTest(t, legacyTxForTest), hexForTest(t, segwitTxForTest)
+	if block.Transactions[0].StartPos != 81 || block.Transactions[1].StartPos != 81+uint64(len(legacy)) {
+		t.Errorf("Transactions start at %d and %d", block.Transactions[0].StartPos, block.Transactions[1].StartPos)
+	}
+	if !bytes.Equal(block.Transactions[0].Binary(), legacy) || !bytes.Equal(block.Transactions[1].Binary(), segwit) {
+		t.Error("Transactions don't serialize back to their bytes")
+	}
+}
+
+// Every truncation of a block fails instead of returning a partial block
+func TestParseBlockFromBytesTruncated(t *testing.T) {
+	b := blockBytesForTest(t)
+	for length := 0; length < len(b); length++ {
+		if block, err := ParseBlockFromBytes(b[:length]); err != ErrUnexpectedEnd {
+			t.Fatalf("Block truncated to %d bytes: got %v, %v", length, block, err)
+		}
+	}
+
+	if _, err := ParseBlockFromBytes(append(b, 0)); err == nil {
+		t.Error("Trailing data accepted")
+	}
+
+	// A transaction count that can't fit in the data fails before allocating
+	huge := append(make([]byte, BLOCK_HEADER_SIZE), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x0f)
+	if _, err := ParseBlockFromBytes(huge); err != ErrUnexpectedEnd {
+		t.Errorf("Huge transaction count: got %v", err)
+	}
+}
+
+func TestParseTransactionFromBytes(t *testing.T) {
+	for _, s := range []string{legacyTxForTest, segwitTxForTest} {
+		b := hexForTest(t, s)
+		tx, err := ParseTransactionFromBytes(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(tx.Binary(), b) {
+			t.Errorf("%s doesn't serialize back to its bytes", tx.Txid())
+		}
+
+		for length := 0; length < len(b); length++ {
+			if _, err := ParseTransactionFromBytes(b[:length]); err != ErrUnexpectedEnd {
+				t.Fatalf("%s truncated to %d bytes: got %v", tx.Txid(), length, err)
+			}
+		}
+		if _, err := ParseTransactionFromBytes(append(b, 0)); err == nil {
+			t.Errorf("%s with trailing data accepted", tx.Txid())
+		}
+	}
+}
+
+func TestParseBlockHeaderFromBytes(t *testing.T) {
+	b := blockBytesForTest(t)
+	if header, err := ParseBlockHeaderFromBytes(b[:BLOCK_HEADER_SIZE]); err != nil || header.Version != 1 {
+		t.Errorf("Got %+v, %v", header, err)
+	}
+	for _, length := range []int{0, BLOCK_HEADER_SIZE - 1, BLOCK_HEADER_SIZE + 1} {
+		if _, err := ParseBlockHeaderFromBytes(b[:length]); err == nil {
+			t.Errorf("Header of %d bytes accepted", length)
+		}
+	}
+}
@@ -3,10 +3,13 @@ package db
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/ruqqq/blockchainparser"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"os"
 	"time"
 )
 
@@ -89,6 +92,17 @@ type ChainstateDb struct {
 	*leveldb.DB
 }
 
+// txindex of bitcoind 0.17 and later, in its own database
+type TxIndexDb struct {
+	*leveldb.DB
+}
+
+// Blocks of the best chain known to an index, most recent first
+type BlockLocator struct {
+	Version int32
+	Have    []blockchainparser.Hash256
+}
+
 func OpenIndexDb(blockchainDataDir string) (*IndexDb, error) {
 	db, err := leveldb.OpenFile(blockchainDataDir+"/blocks/index/", &opt.Options{
 		ReadOnly: true,
@@ -111,6 +125,29 @@ func OpenChainstateDb(blockchainDataDir string) (*ChainstateDb, error) {
 	return &ChainstateDb{db}, nil
 }
 
+func OpenTxIndexDb(blockchainDataDir string) (*TxIndexDb, error) {
+	db, err := leveldb.OpenFile(TxIndexDbPath(blockchainDataDir), &opt.Options{
+		ReadOnly:       true,
+		ErrorIfMissing: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxIndexDb{db}, nil
+}
+
+func TxIndexDbPath(blockchainDataDir string) string {
+	return blockchainDataDir + "/indexes/txindex/"
+}
+
+// Whether the data directory has the txindex database of bitcoind 0.17 and
+// later. Older versions keep the txindex in the block index, see GetFlag.
+func HasTxIndexDb(blockchainDataDir string) bool {
+	_, err := os.Stat(TxIndexDbPath(blockchainDataDir))
+	return err == nil
+}
+
 func GetBlockIndexRecordByBigEndianHex(indexDb *IndexDb, blockHash string) (*BlockIndexRecord, error) {
 	blockHashInBytes, err := hex.DecodeString(blockHash)
 	if err != nil {
@@ -146,6 +183,26 @@ func GetBlockIndexRecord(indexDb *IndexDb, blockHash []byte) (*BlockIndexRecord,
 	return blockIndexRecord, nil
 }
 
+// Calls fn with the hash and record of every block of the index, stopping at
+// the first error
+func ForEachBlockIndexRecord(indexDb *IndexDb, fn func(blockHash blockchainparser.Hash256, record *BlockIndexRecord) error) error {
+	iter := indexDb.NewIterator(util.BytesPrefix([]byte("b")), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != 33 {
+			continue
+		}
+		blockHash := append(blockchainparser.Hash256{}, key[1:]...)
+		if err := fn(blockHash, parseBlockIndexRecord(iter.Value())); err != nil {
+			return err
+		}
+	}
+
+	return iter.Error()
+}
+
 func GetFileInfoRecord(indexDb *IndexDb, number uint32) (*FileInfoRecord, error) {
 	fileNumber := make([]byte, 4)
 	// the key is stored in LittleEndian in LevelDB
@@ -199,6 +256,36 @@ func GetTxIndexRecord(indexDb *IndexDb, txHash []byte) (*TxIndexRecord, error) {
 	return txRecord, nil
 }
 
+func GetTxIndexDbRecordByBigEndianHex(txIndexDb *TxIndexDb, txHash string) (*TxIndexRecord, error) {
+	txHashInBytes, err := hex.DecodeString(txHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetTxIndexDbRecord(txIndexDb, blockchainparser.ReverseHex(txHashInBytes))
+}
+
+// Records keep the format of the legacy txindex: 't' + txid to the position
+// of the block and the offset of the transaction after its header
+func GetTxIndexDbRecord(txIndexDb *TxIndexDb, txHash []byte) (*TxIndexRecord, error) {
+	data, err := txIndexDb.Get(append([]byte("t"), txHash...), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTxIndexRecord(data), nil
+}
+
+// Best block locator of the txindex, the tip it was synced to
+func GetTxIndexBestBlock(txIndexDb *TxIndexDb) (*BlockLocator, error) {
+	data, err := txIndexDb.Get([]byte("B"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBlockLocatorFromBytes(data)
+}
+
 func GetLastBlockFileNumberUsed(indexDb *IndexDb) (uint32, error) {
 	data, err := indexDb.Get([]byte("l"), nil)
 	if err != nil {
@@ -239,16 +326,62 @@ func GetFlag(indexDb *IndexDb, name []byte) (bool, error) {
 //	return coinRecord, nil
 //}
 
+// Key XORed with the values of the chainstate, empty when it isn't obfuscated
+func GetObfuscateKey(chainstateDb *ChainstateDb) ([]byte, error) {
+	data, err := chainstateDb.Get(append([]byte{14, 0}, "obfuscate_key"...), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || int(data[0]) != len(data)-1 {
+		return nil, errors.New("Invalid obfuscate key")
+	}
+
+	return data[1:], nil
+}
+
+// Hash of the block the chainstate is synced to, the tip of bitcoind's
+// active chain. Returns leveldb.ErrNotFound while bitcoind is in the middle
+// of a flush.
 func GetBestBlock(chainstateDb *ChainstateDb) ([]byte, error) {
-	return chainstateDb.Get([]byte("B"), nil)
+	data, err := chainstateDb.Get([]byte("B"), nil)
+	if err != nil {
+		return nil, err
+	}
+	key, err := GetObfuscateKey(chainstateDb)
+	if err != nil {
+		return nil, err
+	}
+
+	return deobfuscate(data, key), nil
+}
+
+func deobfuscate(data []byte, key []byte) []byte {
+	if len(key) == 0 {
+		return data
+	}
+
+	result := make([]byte, len(data))
+	for i := range data {
+		result[i] = data[i] ^ key[i%len(key)]
+	}
+
+	return result
 }
 
 func NewBlockIndexRecordFromBytes(b []byte) *BlockIndexRecord {
-	dataBuf := NewDataBuf(b)
 	fmt.Printf("rawData: %v\n", b)
 	dataHex := hex.EncodeToString(b)
 	fmt.Printf("rawData: %v\n", dataHex)
 
+	return parseBlockIndexRecord(b)
+}
+
+func parseBlockIndexRecord(b []byte) *BlockIndexRecord {
+	dataBuf := NewDataBuf(b)
+
 	// Discard first varint
 	// FIXME: Not exactly sure why need to, but if we don't do this we won't get correct values
 	dataBuf.ShiftVarint()
@@ -295,11 +428,16 @@ func NewFileInfoRecordFromBytes(b []byte) *FileInfoRecord {
 }
 
 func NewTxIndexRecordFromBytes(b []byte) *TxIndexRecord {
-	dataBuf := NewDataBuf(b)
 	fmt.Printf("rawData: %v\n", b)
 	dataHex := hex.EncodeToString(b)
 	fmt.Printf("rawData: %v\n", dataHex)
 
+	return parseTxIndexRecord(b)
+}
+
+func parseTxIndexRecord(b []byte) *TxIndexRecord {
+	dataBuf := NewDataBuf(b)
+
 	record := &TxIndexRecord{}
 	record.NFile = int32(dataBuf.ShiftVarint())
 	record.NDataPos = uint32(dataBuf.ShiftVarint())
@@ -307,3 +445,37 @@ func NewTxIndexRecordFromBytes(b []byte) *TxIndexRecord {
 
 	return record
 }
+
+// Parse a CBlockLocator: version, then a CompactSize count of hashes
+func NewBlockLocatorFromBytes(b []byte) (*BlockLocator, error) {
+	if len(b) < 5 {
+		return nil, errors.New("Invalid block locator")
+	}
+
+	locator := &BlockLocator{Version: int32(binary.LittleEndian.Uint32(b))}
+	count := uint64(b[4])
+	b = b[5:]
+	switch count {
+	case 0xfd:
+		if len(b) < 2 {
+			return nil, errors.New("Invalid block locator")
+		}
+		count, b = uint64(binary.LittleEndian.Uint16(b)), b[2:]
+	case 0xfe:
+		if len(b) < 4 {
+			return nil, errors.New("Invalid block locator")
+		}
+		count, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+	case 0xff:
+		return nil, errors.New("Invalid block locator")
+	}
+	if uint64(len(b)) != count*32 {
+		return nil, errors.New("Invalid block locator")
+	}
+
+	for i := uint64(0); i < count; i++ {
+		locator.Have = append(locator.Have, blockchainparser.Hash256(b[i*32:(i+1)*32]))
+	}
+
+	return locator, nil
+}
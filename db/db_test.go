@@ -0,0 +1,63 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestGetBestBlock(t *testing.T) {
+	dataDir := t.TempDir()
+	hash := bytes.Repeat([]byte{0x11, 0x22, 0x33}, 11)[:32]
+	key := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x80}
+
+	chainstate, err := leveldb.OpenFile(dataDir+"/chainstate/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obfuscated := make([]byte, len(hash))
+	for i := range hash {
+		obfuscated[i] = hash[i] ^ key[i%len(key)]
+	}
+	chainstate.Put(append([]byte{14, 0}, "obfuscate_key"...), append([]byte{byte(len(key))}, key...), nil)
+	chainstate.Put([]byte("B"), obfuscated, nil)
+	chainstate.Close()
+
+	chainstateDb, err := OpenChainstateDb(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chainstateDb.Close()
+
+	best, err := GetBestBlock(chainstateDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(best, hash) {
+		t.Errorf("Got best block %x, want %x", best, hash)
+	}
+}
+
+// Chainstates created before 0.15 aren't obfuscated
+func TestGetBestBlockWithoutObfuscation(t *testing.T) {
+	dataDir := t.TempDir()
+	hash := bytes.Repeat([]byte{0xab}, 32)
+
+	chainstate, err := leveldb.OpenFile(dataDir+"/chainstate/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainstate.Put([]byte("B"), hash, nil)
+	chainstate.Close()
+
+	chainstateDb, err := OpenChainstateDb(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chainstateDb.Close()
+
+	if best, err := GetBestBlock(chainstateDb); err != nil || !bytes.Equal(best, hash) {
+		t.Errorf("Got best block %x, %v", best, err)
+	}
+}
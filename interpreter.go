@@ -0,0 +1,1359 @@
+package blockchainparser
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Script verification flags, same bit values as bitcoind's script/interpreter.h
+type ScriptFlags uint32
+
+const (
+	SCRIPT_VERIFY_NONE ScriptFlags = 0
+
+	//! Evaluate P2SH subscripts (BIP16)
+	SCRIPT_VERIFY_P2SH ScriptFlags = 1 << 0
+
+	//! Enforce strict DER signatures (BIP66)
+	SCRIPT_VERIFY_DERSIG ScriptFlags = 1 << 2
+
+	//! Verify dummy stack item consumed by CHECKMULTISIG is of zero-length (BIP147)
+	SCRIPT_VERIFY_NULLDUMMY ScriptFlags = 1 << 4
+
+	//! Verify CHECKLOCKTIMEVERIFY (BIP65)
+	SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY ScriptFlags = 1 << 9
+
+	//! Support CHECKSEQUENCEVERIFY opcode (BIP112)
+	SCRIPT_VERIFY_CHECKSEQUENCEVERIFY ScriptFlags = 1 << 10
+
+	//! Support segregated witness (BIP141)
+	SCRIPT_VERIFY_WITNESS ScriptFlags = 1 << 11
+
+	//! Taproot/Tapscript validation (BIPs 341 & 342)
+	SCRIPT_VERIFY_TAPROOT ScriptFlags = 1 << 17
+
+	//! All the consensus rules above
+	SCRIPT_VERIFY_CONSENSUS = SCRIPT_VERIFY_P2SH | SCRIPT_VERIFY_DERSIG | SCRIPT_VERIFY_NULLDUMMY |
+		SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY | SCRIPT_VERIFY_CHECKSEQUENCEVERIFY | SCRIPT_VERIFY_WITNESS |
+		SCRIPT_VERIFY_TAPROOT
+)
+
+const (
+	MAX_SCRIPT_ELEMENT_SIZE  = 520   // bytes
+	MAX_OPS_PER_SCRIPT       = 201   // non-push operations
+	MAX_PUBKEYS_PER_MULTISIG = 20    // keys
+	MAX_SCRIPT_SIZE          = 10000 // bytes
+	MAX_STACK_SIZE           = 1000  // stack + altstack items
+
+	//! Threshold for nLockTime: below this value it is interpreted as block number, otherwise as UNIX timestamp
+	LOCKTIME_THRESHOLD = 500000000
+
+	//! Sequence number semantics (BIP68)
+	SEQUENCE_FINAL                 = 0xffffffff
+	SEQUENCE_LOCKTIME_DISABLE_FLAG = 1 << 31
+	SEQUENCE_LOCKTIME_TYPE_FLAG    = 1 << 22
+	SEQUENCE_LOCKTIME_MASK         = 0x0000ffff
+
+	//! Taproot
+	TAPROOT_LEAF_MASK                  = 0xfe
+	TAPROOT_CONTROL_BASE_SIZE          = 33
+	TAPROOT_CONTROL_NODE_SIZE          = 32
+	TAPROOT_CONTROL_MAX_NODE_COUNT     = 128
+	TAPROOT_CONTROL_MAX_SIZE           = TAPROOT_CONTROL_BASE_SIZE + TAPROOT_CONTROL_NODE_SIZE*TAPROOT_CONTROL_MAX_NODE_COUNT
+	VALIDATION_WEIGHT_PER_SIGOP_PASSED = 50
+	VALIDATION_WEIGHT_OFFSET           = 50
+)
+
+// Script errors, messages as in bitcoind's ScriptErrorString
+var (
+	ErrScriptEvalFalse                  = errors.New("Script evaluated without error but finished with a false/empty top stack element")
+	ErrScriptOpReturn                   = errors.New("OP_RETURN was encountered")
+	ErrScriptSize                       = errors.New("Script is too big")
+	ErrScriptPushSize                   = errors.New("Push value size limit exceeded")
+	ErrScriptOpCount                    = errors.New("Operation limit exceeded")
+	ErrScriptStackSize                  = errors.New("Stack size limit exceeded")
+	ErrScriptSigCount                   = errors.New("Signature count negative or greater than pubkey count")
+	ErrScriptPubKeyCount                = errors.New("Pubkey count negative or limit exceeded")
+	ErrScriptVerify                     = errors.New("Script failed an OP_VERIFY operation")
+	ErrScriptEqualVerify                = errors.New("Script failed an OP_EQUALVERIFY operation")
+	ErrScriptCheckMultisigVerify        = errors.New("Script failed an OP_CHECKMULTISIGVERIFY operation")
+	ErrScriptCheckSigVerify             = errors.New("Script failed an OP_CHECKSIGVERIFY operation")
+	ErrScriptNumEqualVerify             = errors.New("Script failed an OP_NUMEQUALVERIFY operation")
+	ErrScriptBadOpcode                  = errors.New("Opcode missing or not understood")
+	ErrScriptDisabledOpcode             = errors.New("Attempted to use a disabled opcode")
+	ErrScriptInvalidStackOperation      = errors.New("Operation not valid with the current stack size")
+	ErrScriptInvalidAltstackOperation   = errors.New("Operation not valid with the current altstack size")
+	ErrScriptUnbalancedConditional      = errors.New("Invalid OP_IF construction")
+	ErrScriptNegativeLocktime           = errors.New("Negative locktime")
+	ErrScriptUnsatisfiedLocktime        = errors.New("Locktime requirement not satisfied")
+	ErrScriptSigDer                     = errors.New("Non-canonical DER signature")
+	ErrScriptSigPushOnly                = errors.New("Only push operators allowed in signatures")
+	ErrScriptSigNullDummy               = errors.New("Dummy CHECKMULTISIG argument must be zero")
+	ErrScriptPubKeyType                 = errors.New("Public key is neither compressed or uncompressed")
+	ErrScriptNumOverflow                = errors.New("Script number overflow")
+	ErrScriptWitnessProgramWrongLength  = errors.New("Witness program has incorrect length")
+	ErrScriptWitnessProgramWitnessEmpty = errors.New("Witness program was passed an empty witness")
+	ErrScriptWitnessProgramMismatch     = errors.New("Witness program hash mismatch")
+	ErrScriptWitnessMalleated           = errors.New("Witness requires empty scriptSig")
+	ErrScriptWitnessMalleatedP2SH       = errors.New("Witness requires only-redeemscript scriptSig")
+	ErrScriptWitnessUnexpected          = errors.New("Witness provided for non-witness script")
+	ErrScriptCleanStack                 = errors.New("Stack size must be exactly one after execution")
+	ErrScriptSchnorrSigSize             = errors.New("Invalid Schnorr signature size")
+	ErrScriptSchnorrSigHashType         = errors.New("Invalid Schnorr signature hash type")
+	ErrScriptSchnorrSig                 = errors.New("Invalid Schnorr signature")
+	ErrScriptTaprootWrongControlSize    = errors.New("Invalid Taproot control block size")
+	ErrScriptTapscriptValidationWeight  = errors.New("Too much signature validation relative to witness weight")
+	ErrScriptTapscriptCheckMultisig     = errors.New("OP_CHECKMULTISIG(VERIFY) is not available in tapscript")
+	ErrScriptTapscriptMinimalIf         = errors.New("OP_IF/NOTIF argument must be minimal in tapscript")
+)
+
+type sigVersion int
+
+const (
+	sigVersionBase sigVersion = iota
+	sigVersionWitnessV0
+	sigVersionTaproot
+	sigVersionTapscript
+)
+
+// One executed (or skipped) opcode
+type ScriptTraceStep struct {
+	Script   string // scriptSig, scriptPubKey, redeemScript, witnessScript or tapscript
+	Pos      int    // offset of the opcode in the script
+	Opcode   byte
+	Data     []byte // push data
+	Executed bool   // false inside a non-taken OP_IF branch
+	Stack    [][]byte
+	AltStack [][]byte
+}
+
+func (step ScriptTraceStep) String() string {
+	op := OpcodeName(step.Opcode)
+	if step.Opcode > OP_0 && step.Opcode <= OP_PUSHDATA4 {
+		op = "PUSH " + hex.EncodeToString(step.Data)
+	}
+	if !step.Executed {
+		op += " (skipped)"
+	}
+
+	stack := make([]string, len(step.Stack))
+	for i, item := range step.Stack {
+		stack[i] = hex.EncodeToString(item)
+	}
+
+	return fmt.Sprintf("%s@%d: %s [%s]", step.Script, step.Pos, op, strings.Join(stack, " "))
+}
+
+type ScriptResult struct {
+	Valid bool
+	Err   error
+	Trace []ScriptTraceStep // only when tracing was requested
+}
+
+// Execution state of a script being verified
+type scriptEngine struct {
+	tx       *Transaction
+	nIn      int
+	prevouts []TxOutput
+	flags    ScriptFlags
+	cache    *SigHashCache
+
+	tracing bool
+	trace   []ScriptTraceStep
+
+	// Taproot execution data
+	annex                []byte
+	tapLeafHash          []byte
+	codeSeparatorPos     uint32
+	validationWeightLeft int64
+}
+
+// Executes the scriptSig, scriptPubKey and witness of input nIn of tx, as
+// bitcoind's VerifyScript does. prevouts are the outputs spent by every input
+// of tx, in input order. When trace is set every opcode and the resulting
+// stack is recorded in the result.
+func VerifyInputScript(tx *Transaction, nIn int, prevouts []TxOutput, flags ScriptFlags, trace bool) *ScriptResult {
+	if nIn < 0 || nIn >= len(tx.Vin) {
+		return &ScriptResult{Err: fmt.Errorf("Input index %d out of range", nIn)}
+	}
+	if len(prevouts) != len(tx.Vin) {
+		return &ScriptResult{Err: ErrMissingPrevouts}
+	}
+
+	return verifyInputScript(tx, nIn, prevouts, flags, trace, NewSigHashCache(tx, prevouts))
+}
+
+// Runs VerifyInputScript for every input of tx, sharing the sighash cache
+func VerifyTransactionScripts(tx *Transaction, prevouts []TxOutput, flags ScriptFlags) ([]*ScriptResult, error) {
+	if len(prevouts) != len(tx.Vin) {
+		return nil, ErrMissingPrevouts
+	}
+
+	cache := NewSigHashCache(tx, prevouts)
+	results := make([]*ScriptResult, len(tx.Vin))
+	for i := range tx.Vin {
+		results[i] = verifyInputScript(tx, i, prevouts, flags, false, cache)
+	}
+
+	return results, nil
+}
+
+func verifyInputScript(tx *Transaction, nIn int, prevouts []TxOutput, flags ScriptFlags, trace bool, cache *SigHashCache) *ScriptResult {
+	engine := &scriptEngine{
+		tx:       tx,
+		nIn:      nIn,
+		prevouts: prevouts,
+		flags:    flags,
+		cache:    cache,
+		tracing:  trace,
+	}
+
+	err := engine.verifyScript(tx.Vin[nIn].Script, prevouts[nIn].Script, tx.Vin[nIn].ScriptWitness)
+	return &ScriptResult{Valid: err == nil, Err: err, Trace: engine.trace}
+}
+
+func (engine *scriptEngine) verifyScript(scriptSig Script, scriptPubKey Script, witness [][]byte) error {
+	flags := engine.flags
+	hadWitness := false
+
+	// scriptSig and scriptPubKey must be evaluated sequentially on the same stack
+	stack := make([][]byte, 0)
+	stack, err := engine.evalScript(stack, scriptSig, sigVersionBase, "scriptSig")
+	if err != nil {
+		return err
+	}
+	stackCopy := copyStack(stack)
+
+	stack, err = engine.evalScript(stack, scriptPubKey, sigVersionBase, "scriptPubKey")
+	if err != nil {
+		return err
+	}
+	if len(stack) == 0 || !castToBool(stack[len(stack)-1]) {
+		return ErrScriptEvalFalse
+	}
+
+	// Bare witness programs
+	if flags&SCRIPT_VERIFY_WITNESS != 0 {
+		if version, program, ok := scriptPubKey.WitnessProgram(); ok {
+			hadWitness = true
+			if len(scriptSig) != 0 {
+				return ErrScriptWitnessMalleated
+			}
+			if err := engine.verifyWitnessProgram(witness, version, program, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Additional validation for spend-to-script-hash transactions
+	if flags&SCRIPT_VERIFY_P2SH != 0 && scriptPubKey.IsPayToScriptHash() {
+		// scriptSig must be literals-only or validation fails
+		if !scriptSig.IsPushOnly() {
+			return ErrScriptSigPushOnly
+		}
+
+		// Restore stack; it can't be empty here, otherwise the scriptPubKey
+		// would have failed
+		stack = stackCopy
+		redeemScript := Script(stack[len(stack)-1])
+		stack = stack[:len(stack)-1]
+
+		stack, err = engine.evalScript(stack, redeemScript, sigVersionBase, "redeemScript")
+		if err != nil {
+			return err
+		}
+		if len(stack) == 0 || !castToBool(stack[len(stack)-1]) {
+			return ErrScriptEvalFalse
+		}
+
+		// P2SH witness program
+		if flags&SCRIPT_VERIFY_WITNESS != 0 {
+			if version, program, ok := redeemScript.WitnessProgram(); ok {
+				hadWitness = true
+				// The scriptSig must be exactly a single push of the redeemScript,
+				// otherwise malleability is reintroduced
+				if !bytes.Equal(scriptSig, PushData(redeemScript)) {
+					return ErrScriptWitnessMalleatedP2SH
+				}
+				if err := engine.verifyWitnessProgram(witness, version, program, true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if flags&SCRIPT_VERIFY_WITNESS != 0 && !hadWitness && len(witness) > 0 {
+		return ErrScriptWitnessUnexpected
+	}
+
+	return nil
+}
+
+func (engine *scriptEngine) verifyWitnessProgram(witness [][]byte, version int, program []byte, isP2SH bool) error {
+	stack := copyStack(witness)
+
+	if version == 0 {
+		if len(program) == 32 {
+			// P2WSH: the last witness item is the script, committed to by its SHA256
+			if len(stack) == 0 {
+				return ErrScriptWitnessProgramWitnessEmpty
+			}
+			witnessScript := Script(stack[len(stack)-1])
+			stack = stack[:len(stack)-1]
+			if !bytes.Equal(Sha256(witnessScript), program) {
+				return ErrScriptWitnessProgramMismatch
+			}
+			return engine.executeWitnessScript(stack, witnessScript, sigVersionWitnessV0, "witnessScript")
+		} else if len(program) == 20 {
+			// P2WPKH: executed as the equivalent P2PKH script
+			if len(stack) != 2 {
+				return ErrScriptWitnessProgramMismatch
+			}
+			script := append([]byte{OP_DUP, OP_HASH160, 0x14}, program...)
+			script = append(script, OP_EQUALVERIFY, OP_CHECKSIG)
+			return engine.executeWitnessScript(stack, script, sigVersionWitnessV0, "witnessScript")
+		}
+		return ErrScriptWitnessProgramWrongLength
+	}
+
+	if version == 1 && len(program) == 32 && !isP2SH {
+		if engine.flags&SCRIPT_VERIFY_TAPROOT == 0 {
+			return nil
+		}
+		if len(stack) == 0 {
+			return ErrScriptWitnessProgramWitnessEmpty
+		}
+		if len(stack) >= 2 && len(stack[len(stack)-1]) > 0 && stack[len(stack)-1][0] == ANNEX_TAG {
+			engine.annex = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		}
+
+		if len(stack) == 1 {
+			// Key path spending
+			return engine.checkSchnorrSignature(stack[0], program, sigVersionTaproot)
+		}
+
+		// Script path spending
+		control := stack[len(stack)-1]
+		script := Script(stack[len(stack)-2])
+		stack = stack[:len(stack)-2]
+		if len(control) < TAPROOT_CONTROL_BASE_SIZE || len(control) > TAPROOT_CONTROL_MAX_SIZE ||
+			(len(control)-TAPROOT_CONTROL_BASE_SIZE)%TAPROOT_CONTROL_NODE_SIZE != 0 {
+			return ErrScriptTaprootWrongControlSize
+		}
+		engine.tapLeafHash = TapLeafHash(control[0]&TAPROOT_LEAF_MASK, script)
+		if !VerifyTaprootCommitment(control, program, engine.tapLeafHash) {
+			return ErrScriptWitnessProgramMismatch
+		}
+		if control[0]&TAPROOT_LEAF_MASK == TAPROOT_LEAF_TAPSCRIPT {
+			witnessSize := len(TxInput{ScriptWitness: witness}.ScriptWitnessBinary())
+			engine.validationWeightLeft = int64(witnessSize) + VALIDATION_WEIGHT_OFFSET
+			return engine.executeWitnessScript(stack, script, sigVersionTapscript, "tapscript")
+		}
+		// Unknown leaf versions are left for future soft forks
+		return nil
+	}
+
+	// Other version/size/p2sh combinations succeed for future soft fork compatibility
+	return nil
+}
+
+// Returns true for the opcodes that make a tapscript succeed unconditionally
+func isOpSuccess(opcode byte) bool {
+	return opcode == 80 || opcode == 98 || (opcode >= 126 && opcode <= 129) ||
+		(opcode >= 131 && opcode <= 134) || (opcode >= 137 && opcode <= 138) ||
+		(opcode >= 141 && opcode <= 142) || (opcode >= 149 && opcode <= 153) ||
+		(opcode >= 187 && opcode <= 254)
+}
+
+func (engine *scriptEngine) executeWitnessScript(stack [][]byte, script Script, sigVersion sigVersion, name string) error {
+	if sigVersion == sigVersionTapscript {
+		// OP_SUCCESSx processing overrides everything, including stack element size limits
+		pc := 0
+		for pc < len(script) {
+			opcode, _, next, ok := GetScriptOp(script, pc)
+			if !ok {
+				return ErrScriptBadOpcode
+			}
+			if isOpSuccess(opcode) {
+				return nil
+			}
+			pc = next
+		}
+
+		// Tapscript enforces initial stack size limits (altstack is empty here)
+		if len(stack) > MAX_STACK_SIZE {
+			return ErrScriptStackSize
+		}
+	}
+
+	// Disallow stack item size > MAX_SCRIPT_ELEMENT_SIZE in witness stack
+	for _, item := range stack {
+		if len(item) > MAX_SCRIPT_ELEMENT_SIZE {
+			return ErrScriptPushSize
+		}
+	}
+
+	stack, err := engine.evalScript(stack, script, sigVersion, name)
+	if err != nil {
+		return err
+	}
+
+	// Scripts inside witness implicitly require cleanstack behaviour
+	if len(stack) != 1 {
+		return ErrScriptCleanStack
+	}
+	if !castToBool(stack[0]) {
+		return ErrScriptEvalFalse
+	}
+
+	return nil
+}
+
+// Checks that the output key program commits to the leaf through the merkle
+// path and internal key in the control block
+func VerifyTaprootCommitment(control []byte, program []byte, tapLeafHash []byte) bool {
+	internalKey, err := ParseXOnlyPubKey(control[1:TAPROOT_CONTROL_BASE_SIZE])
+	if err != nil {
+		return false
+	}
+
+	// Compute the merkle root from the leaf and the provided path
+	k := tapLeafHash
+	for pos := TAPROOT_CONTROL_BASE_SIZE; pos < len(control); pos += TAPROOT_CONTROL_NODE_SIZE {
+		node := control[pos : pos+TAPROOT_CONTROL_NODE_SIZE]
+		if bytes.Compare(k, node) < 0 {
+			k = TaggedHash("TapBranch", k, node)
+		} else {
+			k = TaggedHash("TapBranch", node, k)
+		}
+	}
+
+	outputKey, ok := TaprootTweakPubKey(internalKey, k)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(outputKey.SerializeXOnly(), program) && outputKey.Y.Bit(0) == uint(control[0]&1)
+}
+
+func copyStack(stack [][]byte) [][]byte {
+	stackCopy := make([][]byte, len(stack))
+	copy(stackCopy, stack)
+	return stackCopy
+}
+
+func castToBool(b []byte) bool {
+	for i, v := range b {
+		if v != 0 {
+			// Can be negative zero
+			if i == len(b)-1 && v == 0x80 {
+				return false
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// Decode a CScriptNum, without the minimal encoding requirement
+func scriptNum(b []byte, maxLen int) (int64, error) {
+	if len(b) > maxLen {
+		return 0, ErrScriptNumOverflow
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	var result int64
+	for i, v := range b {
+		result |= int64(v) << uint(8*i)
+	}
+
+	// If the input's most significant byte is 0x80, remove it from the
+	// result's msb and return a negative
+	if b[len(b)-1]&0x80 != 0 {
+		return -(result & ^(int64(0x80) << uint(8*(len(b)-1)))), nil
+	}
+
+	return result, nil
+}
+
+func scriptNumBytes(n int64) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+		abs = uint64(-n)
+	}
+
+	result := make([]byte, 0, 9)
+	for abs > 0 {
+		result = append(result, byte(abs&0xff))
+		abs >>= 8
+	}
+
+	// If the most significant byte is >= 0x80 and the value is positive, push a
+	// new zero byte to make the significant byte < 0x80 again. If negative, push
+	// 0x80 instead, otherwise set the sign bit of the most significant byte.
+	if result[len(result)-1]&0x80 != 0 {
+		if negative {
+			result = append(result, 0x80)
+		} else {
+			result = append(result, 0)
+		}
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+func boolBytes(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{}
+}
+
+func isDisabledOpcode(opcode byte) bool {
+	switch opcode {
+	case OP_CAT, OP_SUBSTR, OP_LEFT, OP_RIGHT, OP_INVERT, OP_AND, OP_OR, OP_XOR,
+		OP_2MUL, OP_2DIV, OP_MUL, OP_DIV, OP_MOD, OP_LSHIFT, OP_RSHIFT:
+		return true
+	}
+	return false
+}
+
+// Port of bitcoind's EvalScript
+func (engine *scriptEngine) evalScript(stack [][]byte, script Script, sigVersion sigVersion, name string) ([][]byte, error) {
+	flags := engine.flags
+	isTapscript := sigVersion == sigVersionTapscript
+
+	if !isTapscript && len(script) > MAX_SCRIPT_SIZE {
+		return stack, ErrScriptSize
+	}
+
+	altStack := make([][]byte, 0)
+	execStack := make([]bool, 0)
+	opCount := 0
+	beginCodeHash := 0
+	engine.codeSeparatorPos = 0xFFFFFFFF
+	opcodePos := uint32(0)
+
+	// Stack accessors; depth 1 is the top of the stack
+	top := func(depth int) []byte {
+		return stack[len(stack)-depth]
+	}
+	pop := func() []byte {
+		item := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return item
+	}
+	push := func(item []byte) {
+		stack = append(stack, item)
+	}
+
+	pc := 0
+	for pc < len(script) {
+		opPos := pc
+		exec := true
+		for _, e := range execStack {
+			if !e {
+				exec = false
+				break
+			}
+		}
+
+		// Read instruction
+		opcode, data, next, ok := GetScriptOp(script, pc)
+		if !ok {
+			return stack, ErrScriptBadOpcode
+		}
+		pc = next
+		if len(data) > MAX_SCRIPT_ELEMENT_SIZE {
+			return stack, ErrScriptPushSize
+		}
+
+		if !isTapscript {
+			// Note how OP_RESERVED does not count towards the opcode limit
+			if opcode > OP_16 {
+				opCount++
+				if opCount > MAX_OPS_PER_SCRIPT {
+					return stack, ErrScriptOpCount
+				}
+			}
+		}
+
+		// Disabled opcodes fail even in an unexecuted branch
+		if isDisabledOpcode(opcode) {
+			return stack, ErrScriptDisabledOpcode
+		}
+
+		if exec && opcode <= OP_PUSHDATA4 {
+			item := make([]byte, len(data))
+			copy(item, data)
+			push(item)
+		} else if exec || (opcode >= OP_IF && opcode <= OP_ENDIF) {
+			switch opcode {
+			// Push value
+			case OP_1NEGATE, OP_1, OP_2, OP_3, OP_4, OP_5, OP_6, OP_7, OP_8,
+				OP_9, OP_10, OP_11, OP_12, OP_13, OP_14, OP_15, OP_16:
+				push(scriptNumBytes(int64(opcode) - (OP_1 - 1)))
+
+			// Control
+			case OP_NOP, OP_NOP1, OP_NOP4, OP_NOP5, OP_NOP6, OP_NOP7, OP_NOP8, OP_NOP9, OP_NOP10:
+
+			case OP_CHECKLOCKTIMEVERIFY:
+				if flags&SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY == 0 {
+					break
+				}
+				if len(stack) < 1 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				// Note that elsewhere numeric opcodes are limited to operands in
+				// the range -2**31+1 to 2**31-1, however it is legal for opcodes
+				// to produce results exceeding that range. Thus 5 bytes here.
+				lockTime, err := scriptNum(top(1), 5)
+				if err != nil {
+					return stack, err
+				}
+				if lockTime < 0 {
+					return stack, ErrScriptNegativeLocktime
+				}
+				if !engine.checkLockTime(lockTime) {
+					return stack, ErrScriptUnsatisfiedLocktime
+				}
+
+			case OP_CHECKSEQUENCEVERIFY:
+				if flags&SCRIPT_VERIFY_CHECKSEQUENCEVERIFY == 0 {
+					break
+				}
+				if len(stack) < 1 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				sequence, err := scriptNum(top(1), 5)
+				if err != nil {
+					return stack, err
+				}
+				if sequence < 0 {
+					return stack, ErrScriptNegativeLocktime
+				}
+				// To provide for future soft-fork extensibility, if the operand
+				// has the disabled lock-time flag set, CSV behaves as a NOP
+				if sequence&SEQUENCE_LOCKTIME_DISABLE_FLAG != 0 {
+					break
+				}
+				if !engine.checkSequence(sequence) {
+					return stack, ErrScriptUnsatisfiedLocktime
+				}
+
+			case OP_IF, OP_NOTIF:
+				value := false
+				if exec {
+					if len(stack) < 1 {
+						return stack, ErrScriptUnbalancedConditional
+					}
+					item := top(1)
+					if isTapscript && (len(item) > 1 || (len(item) == 1 && item[0] != 1)) {
+						return stack, ErrScriptTapscriptMinimalIf
+					}
+					value = castToBool(item)
+					if opcode == OP_NOTIF {
+						value = !value
+					}
+					pop()
+				}
+				execStack = append(execStack, value)
+
+			case OP_ELSE:
+				if len(execStack) == 0 {
+					return stack, ErrScriptUnbalancedConditional
+				}
+				execStack[len(execStack)-1] = !execStack[len(execStack)-1]
+
+			case OP_ENDIF:
+				if len(execStack) == 0 {
+					return stack, ErrScriptUnbalancedConditional
+				}
+				execStack = execStack[:len(execStack)-1]
+
+			case OP_VERIFY:
+				if len(stack) < 1 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				if !castToBool(top(1)) {
+					return stack, ErrScriptVerify
+				}
+				pop()
+
+			case OP_RETURN:
+				return stack, ErrScriptOpReturn
+
+			// Stack ops
+			case OP_TOALTSTACK:
+				if len(stack) < 1 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				altStack = append(altStack, pop())
+
+			case OP_FROMALTSTACK:
+				if len(altStack) < 1 {
+					return stack, ErrScriptInvalidAltstackOperation
+				}
+				push(altStack[len(altStack)-1])
+				altStack = altStack[:len(altStack)-1]
+
+			case OP_2DROP:
+				// (x1 x2 -- )
+				if len(stack) < 2 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				pop()
+				pop()
+
+			case OP_2DUP:
+				// (x1 x2 -- x1 x2 x1 x2)
+				if len(stack) < 2 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				x1, x2 := top(2), top(1)
+				push(x1)
+				push(x2)
+
+			case OP_3DUP:
+				// (x1 x2 x3 -- x1 x2 x3 x1 x2 x3)
+				if len(stack) < 3 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				x1, x2, x3 := top(3), top(2), top(1)
+				push(x1)
+				push(x2)
+				push(x3)
+
+			case OP_2OVER:
+				// (x1 x2 x3 x4 -- x1 x2 x3 x4 x1 x2)
+				if len(stack) < 4 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				x1, x2 := top(4), top(3)
+				push(x1)
+				push(x2)
+
+			case OP_2ROT:
+				// (x1 x2 x3 x4 x5 x6 -- x3 x4 x5 x6 x1 x2)
+				if len(stack) < 6 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				x1, x2 := top(6), top(5)
+				i := len(stack) - 6
+				stack = append(stack[:i], stack[i+2:]...)
+				push(x1)
+				push(x2)
+
+			case OP_2SWAP:
+				// (x1 x2 x3 x4 -- x3 x4 x1 x2)
+				if len(stack) < 4 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				n := len(stack)
+				stack[n-4], stack[n-2] = stack[n-2], stack[n-4]
+				stack[n-3], stack[n-1] = stack[n-1], stack[n-3]
+
+			case OP_IFDUP:
+				// (x - 0 | x x)
+				if len(stack) < 1 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				if castToBool(top(1)) {
+					push(top(1))
+				}
+
+			case OP_DEPTH:
+				// -- stacksize
+				push(scriptNumBytes(int64(len(stack))))
+
+			case OP_DROP:
+				// (x -- )
+				if len(stack) < 1 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				pop()
+
+			case OP_DUP:
+				// (x -- x x)
+				if len(stack) < 1 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				push(top(1))
+
+			case OP_NIP:
+				// (x1 x2 -- x2)
+				if len(stack) < 2 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				x2 := pop()
+				stack[len(stack)-1] = x2
+
+			case OP_OVER:
+				// (x1 x2 -- x1 x2 x1)
+				if len(stack) < 2 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				push(top(2))
+
+			case OP_PICK, OP_ROLL:
+				// (xn ... x2 x1 x0 n - xn ... x2 x1 x0 xn)
+				// (xn ... x2 x1 x0 n - ... x2 x1 x0 xn)
+				if len(stack) < 2 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				n, err := scriptNum(top(1), 4)
+				if err != nil {
+					return stack, err
+				}
+				pop()
+				if n < 0 || n >= int64(len(stack)) {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				i := len(stack) - 1 - int(n)
+				item := stack[i]
+				if opcode == OP_ROLL {
+					stack = append(stack[:i], stack[i+1:]...)
+				}
+				push(item)
+
+			case OP_ROT:
+				// (x1 x2 x3 -- x2 x3 x1)
+				if len(stack) < 3 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				n := len(stack)
+				stack[n-3], stack[n-2], stack[n-1] = stack[n-2], stack[n-1], stack[n-3]
+
+			case OP_SWAP:
+				// (x1 x2 -- x2 x1)
+				if len(stack) < 2 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				n := len(stack)
+				stack[n-2], stack[n-1] = stack[n-1], stack[n-2]
+
+			case OP_TUCK:
+				// (x1 x2 -- x2 x1 x2)
+				if len(stack) < 2 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				x1, x2 := top(2), top(1)
+				stack = append(stack[:len(stack)-2], x2, x1, x2)
+
+			case OP_SIZE:
+				// (in -- in size)
+				if len(stack) < 1 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				push(scriptNumBytes(int64(len(top(1)))))
+
+			// Bitwise logic
+			case OP_EQUAL, OP_EQUALVERIFY:
+				// (x1 x2 - bool)
+				if len(stack) < 2 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				equal := bytes.Equal(top(2), top(1))
+				pop()
+				pop()
+				push(boolBytes(equal))
+				if opcode == OP_EQUALVERIFY {
+					if !equal {
+						return stack, ErrScriptEqualVerify
+					}
+					pop()
+				}
+
+			// Numeric
+			case OP_1ADD, OP_1SUB, OP_NEGATE, OP_ABS, OP_NOT, OP_0NOTEQUAL:
+				// (in -- out)
+				if len(stack) < 1 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				n, err := scriptNum(top(1), 4)
+				if err != nil {
+					return stack, err
+				}
+				switch opcode {
+				case OP_1ADD:
+					n++
+				case OP_1SUB:
+					n--
+				case OP_NEGATE:
+					n = -n
+				case OP_ABS:
+					if n < 0 {
+						n = -n
+					}
+				case OP_NOT:
+					if n == 0 {
+						n = 1
+					} else {
+						n = 0
+					}
+				case OP_0NOTEQUAL:
+					if n != 0 {
+						n = 1
+					}
+				}
+				pop()
+				push(scriptNumBytes(n))
+
+			case OP_ADD, OP_SUB, OP_BOOLAND, OP_BOOLOR, OP_NUMEQUAL, OP_NUMEQUALVERIFY, OP_NUMNOTEQUAL,
+				OP_LESSTHAN, OP_GREATERTHAN, OP_LESSTHANOREQUAL, OP_GREATERTHANOREQUAL, OP_MIN, OP_MAX:
+				// (x1 x2 -- out)
+				if len(stack) < 2 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				n1, err := scriptNum(top(2), 4)
+				if err != nil {
+					return stack, err
+				}
+				n2, err := scriptNum(top(1), 4)
+				if err != nil {
+					return stack, err
+				}
+
+				var n int64
+				boolNum := func(b bool) int64 {
+					if b {
+						return 1
+					}
+					return 0
+				}
+				switch opcode {
+				case OP_ADD:
+					n = n1 + n2
+				case OP_SUB:
+					n = n1 - n2
+				case OP_BOOLAND:
+					n = boolNum(n1 != 0 && n2 != 0)
+				case OP_BOOLOR:
+					n = boolNum(n1 != 0 || n2 != 0)
+				case OP_NUMEQUAL, OP_NUMEQUALVERIFY:
+					n = boolNum(n1 == n2)
+				case OP_NUMNOTEQUAL:
+					n = boolNum(n1 != n2)
+				case OP_LESSTHAN:
+					n = boolNum(n1 < n2)
+				case OP_GREATERTHAN:
+					n = boolNum(n1 > n2)
+				case OP_LESSTHANOREQUAL:
+					n = boolNum(n1 <= n2)
+				case OP_GREATERTHANOREQUAL:
+					n = boolNum(n1 >= n2)
+				case OP_MIN:
+					n = n1
+					if n2 < n1 {
+						n = n2
+					}
+				case OP_MAX:
+					n = n1
+					if n2 > n1 {
+						n = n2
+					}
+				}
+				pop()
+				pop()
+				push(scriptNumBytes(n))
+
+				if opcode == OP_NUMEQUALVERIFY {
+					if !castToBool(top(1)) {
+						return stack, ErrScriptNumEqualVerify
+					}
+					pop()
+				}
+
+			case OP_WITHIN:
+				// (x min max -- out)
+				if len(stack) < 3 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				x, err := scriptNum(top(3), 4)
+				if err != nil {
+					return stack, err
+				}
+				min, err := scriptNum(top(2), 4)
+				if err != nil {
+					return stack, err
+				}
+				max, err := scriptNum(top(1), 4)
+				if err != nil {
+					return stack, err
+				}
+				pop()
+				pop()
+				pop()
+				push(boolBytes(min <= x && x < max))
+
+			// Crypto
+			case OP_RIPEMD160, OP_SHA1, OP_SHA256, OP_HASH160, OP_HASH256:
+				// (in -- hash)
+				if len(stack) < 1 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				item := pop()
+				var hash []byte
+				switch opcode {
+				case OP_RIPEMD160:
+					h := ripemd160.New()
+					h.Write(item)
+					hash = h.Sum(nil)
+				case OP_SHA1:
+					h := sha1.Sum(item)
+					hash = h[:]
+				case OP_SHA256:
+					h := sha256.Sum256(item)
+					hash = h[:]
+				case OP_HASH160:
+					hash = Hash160(item)
+				case OP_HASH256:
+					hash = DoubleSha256(item)
+				}
+				push(hash)
+
+			case OP_CODESEPARATOR:
+				// Hash starts after the code separator
+				beginCodeHash = pc
+				engine.codeSeparatorPos = opcodePos
+
+			case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+				// (sig pubkey -- bool)
+				if len(stack) < 2 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				sig, pubKey := top(2), top(1)
+				success, err := engine.evalCheckSig(sig, pubKey, script[beginCodeHash:], sigVersion)
+				if err != nil {
+					return stack, err
+				}
+				pop()
+				pop()
+				push(boolBytes(success))
+				if opcode == OP_CHECKSIGVERIFY {
+					if !success {
+						return stack, ErrScriptCheckSigVerify
+					}
+					pop()
+				}
+
+			case OP_CHECKSIGADD:
+				// OP_CHECKSIGADD is only available in Tapscript
+				if !isTapscript {
+					return stack, ErrScriptBadOpcode
+				}
+				// (sig num pubkey -- num)
+				if len(stack) < 3 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				sig, pubKey := top(3), top(1)
+				n, err := scriptNum(top(2), 4)
+				if err != nil {
+					return stack, err
+				}
+				success, err := engine.evalCheckSig(sig, pubKey, script[beginCodeHash:], sigVersion)
+				if err != nil {
+					return stack, err
+				}
+				pop()
+				pop()
+				pop()
+				if success {
+					n++
+				}
+				push(scriptNumBytes(n))
+
+			case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+				if isTapscript {
+					return stack, ErrScriptTapscriptCheckMultisig
+				}
+
+				// ([sig ...] num_of_signatures [pubkey ...] num_of_pubkeys -- bool)
+				i := 1
+				if len(stack) < i {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				keysCount, err := scriptNum(top(i), 4)
+				if err != nil {
+					return stack, err
+				}
+				if keysCount < 0 || keysCount > MAX_PUBKEYS_PER_MULTISIG {
+					return stack, ErrScriptPubKeyCount
+				}
+				opCount += int(keysCount)
+				if opCount > MAX_OPS_PER_SCRIPT {
+					return stack, ErrScriptOpCount
+				}
+				i++
+				iKey := i
+				i += int(keysCount)
+				if len(stack) < i {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				sigsCount, err := scriptNum(top(i), 4)
+				if err != nil {
+					return stack, err
+				}
+				if sigsCount < 0 || sigsCount > keysCount {
+					return stack, ErrScriptSigCount
+				}
+				i++
+				iSig := i
+				i += int(sigsCount)
+				if len(stack) < i {
+					return stack, ErrScriptInvalidStackOperation
+				}
+
+				// Subset of script starting at the most recent codeseparator
+				scriptCode := Script(script[beginCodeHash:])
+
+				// Drop the signatures in pre-segwit scripts but not segwit scripts
+				if sigVersion == sigVersionBase {
+					for k := 0; k < int(sigsCount); k++ {
+						scriptCode, _ = FindAndDelete(scriptCode, PushData(top(iSig+k)))
+					}
+				}
+
+				success := true
+				for success && sigsCount > 0 {
+					sig, pubKey := top(iSig), top(iKey)
+					if err := engine.checkSignatureEncoding(sig); err != nil {
+						return stack, err
+					}
+					if engine.checkECDSASignature(sig, pubKey, scriptCode, sigVersion) {
+						iSig++
+						sigsCount--
+					}
+					iKey++
+					keysCount--
+
+					// If there are more signatures left than keys left, then too
+					// many signatures have failed
+					if sigsCount > keysCount {
+						success = false
+					}
+				}
+
+				// Clean up stack of actual arguments
+				stack = stack[:len(stack)-(i-1)]
+
+				// A bug causes CHECKMULTISIG to consume one extra argument whose
+				// contents were not checked in any way
+				if len(stack) < 1 {
+					return stack, ErrScriptInvalidStackOperation
+				}
+				if flags&SCRIPT_VERIFY_NULLDUMMY != 0 && len(top(1)) != 0 {
+					return stack, ErrScriptSigNullDummy
+				}
+				pop()
+
+				push(boolBytes(success))
+				if opcode == OP_CHECKMULTISIGVERIFY {
+					if !success {
+						return stack, ErrScriptCheckMultisigVerify
+					}
+					pop()
+				}
+
+			default:
+				return stack, ErrScriptBadOpcode
+			}
+		}
+
+		// Size limits
+		if len(stack)+len(altStack) > MAX_STACK_SIZE {
+			return stack, ErrScriptStackSize
+		}
+
+		if engine.tracing {
+			engine.trace = append(engine.trace, ScriptTraceStep{
+				Script:   name,
+				Pos:      opPos,
+				Opcode:   opcode,
+				Data:     data,
+				Executed: exec,
+				Stack:    copyStack(stack),
+				AltStack: copyStack(altStack),
+			})
+		}
+
+		opcodePos++
+	}
+
+	if len(execStack) != 0 {
+		return stack, ErrScriptUnbalancedConditional
+	}
+
+	return stack, nil
+}
+
+func (engine *scriptEngine) checkLockTime(lockTime int64) bool {
+	txLockTime := int64(engine.tx.Locktime)
+
+	// There are two kinds of nLockTime: lock-by-blockheight and
+	// lock-by-blocktime. We want to compare apples to apples.
+	if !((txLockTime < LOCKTIME_THRESHOLD && lockTime < LOCKTIME_THRESHOLD) ||
+		(txLockTime >= LOCKTIME_THRESHOLD && lockTime >= LOCKTIME_THRESHOLD)) {
+		return false
+	}
+	if lockTime > txLockTime {
+		return false
+	}
+
+	// Finally the nLockTime feature can be disabled in IsFinalTx() and thus
+	// CHECKLOCKTIMEVERIFY bypassed if every txin has been finalized
+	return engine.tx.Vin[engine.nIn].Sequence != SEQUENCE_FINAL
+}
+
+func (engine *scriptEngine) checkSequence(sequence int64) bool {
+	txSequence := int64(engine.tx.Vin[engine.nIn].Sequence)
+
+	// Fail if the transaction's version number is not set high enough to
+	// trigger BIP 68 rules
+	if uint32(engine.tx.Version) < 2 {
+		return false
+	}
+
+	// Sequence numbers with their most significant bit set are not consensus
+	// constrained
+	if txSequence&SEQUENCE_LOCKTIME_DISABLE_FLAG != 0 {
+		return false
+	}
+
+	// Mask off any bits that do not have consensus-enforced meaning before
+	// doing the integer comparisons
+	mask := int64(SEQUENCE_LOCKTIME_TYPE_FLAG | SEQUENCE_LOCKTIME_MASK)
+	txSequenceMasked := txSequence & mask
+	sequenceMasked := sequence & mask
+
+	if !((txSequenceMasked < SEQUENCE_LOCKTIME_TYPE_FLAG && sequenceMasked < SEQUENCE_LOCKTIME_TYPE_FLAG) ||
+		(txSequenceMasked >= SEQUENCE_LOCKTIME_TYPE_FLAG && sequenceMasked >= SEQUENCE_LOCKTIME_TYPE_FLAG)) {
+		return false
+	}
+
+	return sequenceMasked <= txSequenceMasked
+}
+
+func (engine *scriptEngine) checkSignatureEncoding(sig []byte) error {
+	// Empty signature. Not strictly DER encoded, but allowed to provide a
+	// compact way to provide an invalid signature for use with CHECK(MULTI)SIG
+	if len(sig) == 0 {
+		return nil
+	}
+	if engine.flags&SCRIPT_VERIFY_DERSIG != 0 && !IsValidSignatureEncoding(sig) {
+		return ErrScriptSigDer
+	}
+
+	return nil
+}
+
+func (engine *scriptEngine) evalCheckSig(sig []byte, pubKey []byte, scriptCode Script, sigVersion sigVersion) (bool, error) {
+	if sigVersion == sigVersionTapscript {
+		success := len(sig) > 0
+		if success {
+			// Implement the sigops/witnesssize ratio test
+			engine.validationWeightLeft -= VALIDATION_WEIGHT_PER_SIGOP_PASSED
+			if engine.validationWeightLeft < 0 {
+				return false, ErrScriptTapscriptValidationWeight
+			}
+		}
+		if len(pubKey) == 0 {
+			return false, ErrScriptPubKeyType
+		} else if len(pubKey) == XONLY_PUBKEY_SIZE {
+			if success {
+				if err := engine.checkSchnorrSignature(sig, pubKey, sigVersion); err != nil {
+					return false, err
+				}
+			}
+		}
+		// Unknown public key types are left for future soft forks
+		return success, nil
+	}
+
+	// Drop the signature in pre-segwit scripts but not segwit scripts
+	if sigVersion == sigVersionBase {
+		scriptCode, _ = FindAndDelete(scriptCode, PushData(sig))
+	}
+	if err := engine.checkSignatureEncoding(sig); err != nil {
+		return false, err
+	}
+
+	return engine.checkECDSASignature(sig, pubKey, scriptCode, sigVersion), nil
+}
+
+func (engine *scriptEngine) checkECDSASignature(sig []byte, pubKey []byte, scriptCode Script, sigVersion sigVersion) bool {
+	key, err := ParsePubKey(pubKey)
+	if err != nil || len(sig) == 0 {
+		return false
+	}
+
+	hashType := int32(sig[len(sig)-1])
+	signature, err := ParseDERSignatureLax(sig[:len(sig)-1])
+	if err != nil {
+		return false
+	}
+
+	var hash Hash256
+	if sigVersion == sigVersionWitnessV0 {
+		hash, err = SignatureHashWitnessV0(scriptCode, engine.tx, engine.nIn, hashType, engine.prevouts[engine.nIn].Value, engine.cache)
+		if err != nil {
+			return false
+		}
+	} else {
+		hash = SignatureHashLegacy(scriptCode, engine.tx, engine.nIn, hashType)
+	}
+
+	return VerifyECDSA(key, hash, signature)
+}
+
+func (engine *scriptEngine) checkSchnorrSignature(sig []byte, pubKey []byte, sigVersion sigVersion) error {
+	if len(sig) != SCHNORR_SIGNATURE_SIZE && len(sig) != SCHNORR_SIGNATURE_SIZE+1 {
+		return ErrScriptSchnorrSigSize
+	}
+
+	hashType := byte(SIGHASH_DEFAULT)
+	if len(sig) == SCHNORR_SIGNATURE_SIZE+1 {
+		hashType = sig[SCHNORR_SIGNATURE_SIZE]
+		if hashType == SIGHASH_DEFAULT {
+			return ErrScriptSchnorrSigHashType
+		}
+		sig = sig[:SCHNORR_SIGNATURE_SIZE]
+	}
+
+	var ext *TapscriptSigHashExt
+	if sigVersion == sigVersionTapscript {
+		ext = &TapscriptSigHashExt{
+			LeafHash:         engine.tapLeafHash,
+			KeyVersion:       0,
+			CodeSeparatorPos: engine.codeSeparatorPos,
+		}
+	}
+
+	hash, err := SignatureHashTaproot(engine.tx, engine.nIn, hashType, engine.prevouts, engine.annex, ext, engine.cache)
+	if err != nil {
+		return ErrScriptSchnorrSigHashType
+	}
+	if !VerifySchnorr(pubKey, hash, sig) {
+		return ErrScriptSchnorrSig
+	}
+
+	return nil
+}
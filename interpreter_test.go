@@ -0,0 +1,601 @@
+package blockchainparser
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Port of ParseScript from bitcoind's core_read.cpp: the script format of
+// the test vectors. Numbers are pushed as script numbers, 0x prefixed hex is
+// inserted as is, quoted strings are pushed and opcodes may omit OP_.
+func parseScriptForTest(s string) (Script, error) {
+	names := make(map[string]byte)
+	for opcode, name := range opcodeNames {
+		if opcode < OP_NOP && opcode != OP_RESERVED {
+			continue
+		}
+		names[name] = opcode
+		names[strings.TrimPrefix(name, "OP_")] = opcode
+	}
+
+	script := Script{}
+	for _, word := range strings.Fields(s) {
+		if n, err := strconv.ParseInt(word, 10, 64); err == nil {
+			switch {
+			case n == 0:
+				script = append(script, OP_0)
+			case n == -1 || n >= 1 && n <= 16:
+				script = append(script, byte(OP_1+n-1))
+			default:
+				script = append(script, PushData(scriptNumBytes(n))...)
+			}
+		} else if strings.HasPrefix(word, "0x") && len(word) > 2 {
+			b, err := hex.DecodeString(word[2:])
+			if err != nil {
+				return nil, err
+			}
+			script = append(script, b...)
+		} else if len(word) >= 2 && word[0] == '\'' && word[len(word)-1] == '\'' {
+			script = append(script, PushData([]byte(word[1:len(word)-1]))...)
+		} else if opcode, ok := names[word]; ok {
+			script = append(script, opcode)
+		} else {
+			return nil, fmt.Errorf("Unknown opcode %s", word)
+		}
+	}
+
+	return script, nil
+}
+
+var scriptFlagsForTest = map[string]ScriptFlags{
+	"NONE":                SCRIPT_VERIFY_NONE,
+	"":                    SCRIPT_VERIFY_NONE,
+	"P2SH":                SCRIPT_VERIFY_P2SH,
+	"DERSIG":              SCRIPT_VERIFY_DERSIG,
+	"NULLDUMMY":           SCRIPT_VERIFY_NULLDUMMY,
+	"CHECKLOCKTIMEVERIFY": SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY,
+	"CHECKSEQUENCEVERIFY": SCRIPT_VERIFY_CHECKSEQUENCEVERIFY,
+	"WITNESS":             SCRIPT_VERIFY_WITNESS,
+	"TAPROOT":             SCRIPT_VERIFY_TAPROOT,
+}
+
+// Policy flags of bitcoind, which the interpreter doesn't implement, and the
+// errors they raise. Without them a script fails the same way unless it
+// failed with one of these errors.
+var policyFlagsForTest = map[string][]string{
+	"STRICTENC":                             {"SIG_DER", "SIG_HASHTYPE", "PUBKEYTYPE"},
+	"LOW_S":                                 {"SIG_DER", "SIG_HIGH_S"},
+	"MINIMALDATA":                           {"MINIMALDATA", "UNKNOWN_ERROR"},
+	"SIGPUSHONLY":                           {"SIG_PUSHONLY"},
+	"CLEANSTACK":                            {"CLEANSTACK"},
+	"DISCOURAGE_UPGRADABLE_NOPS":            {"DISCOURAGE_UPGRADABLE_NOPS"},
+	"DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM": {"DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM"},
+	"MINIMALIF":                             {"MINIMALIF"},
+	"NULLFAIL":                              {"NULLFAIL"},
+	"WITNESS_PUBKEYTYPE":                    {"WITNESS_PUBKEYTYPE"},
+}
+
+var scriptErrorsForTest = map[string]error{
+	"OK":                            nil,
+	"EVAL_FALSE":                    ErrScriptEvalFalse,
+	"OP_RETURN":                     ErrScriptOpReturn,
+	"SCRIPT_SIZE":                   ErrScriptSize,
+	"PUSH_SIZE":                     ErrScriptPushSize,
+	"OP_COUNT":                      ErrScriptOpCount,
+	"STACK_SIZE":                    ErrScriptStackSize,
+	"SIG_COUNT":                     ErrScriptSigCount,
+	"PUBKEY_COUNT":                  ErrScriptPubKeyCount,
+	"VERIFY":                        ErrScriptVerify,
+	"EQUALVERIFY":                   ErrScriptEqualVerify,
+	"CHECKMULTISIGVERIFY":           ErrScriptCheckMultisigVerify,
+	"CHECKSIGVERIFY":                ErrScriptCheckSigVerify,
+	"NUMEQUALVERIFY":                ErrScriptNumEqualVerify,
+	"BAD_OPCODE":                    ErrScriptBadOpcode,
+	"DISABLED_OPCODE":               ErrScriptDisabledOpcode,
+	"INVALID_STACK_OPERATION":       ErrScriptInvalidStackOperation,
+	"INVALID_ALTSTACK_OPERATION":    ErrScriptInvalidAltstackOperation,
+	"UNBALANCED_CONDITIONAL":        ErrScriptUnbalancedConditional,
+	"NEGATIVE_LOCKTIME":             ErrScriptNegativeLocktime,
+	"UNSATISFIED_LOCKTIME":          ErrScriptUnsatisfiedLocktime,
+	"SIG_DER":                       ErrScriptSigDer,
+	"SIG_PUSHONLY":                  ErrScriptSigPushOnly,
+	"SIG_NULLDUMMY":                 ErrScriptSigNullDummy,
+	"PUBKEYTYPE":                    ErrScriptPubKeyType,
+	"UNKNOWN_ERROR":                 ErrScriptNumOverflow,
+	"CLEANSTACK":                    ErrScriptCleanStack,
+	"WITNESS_PROGRAM_WRONG_LENGTH":  ErrScriptWitnessProgramWrongLength,
+	"WITNESS_PROGRAM_WITNESS_EMPTY": ErrScriptWitnessProgramWitnessEmpty,
+	"WITNESS_PROGRAM_MISMATCH":      ErrScriptWitnessProgramMismatch,
+	"WITNESS_MALLEATED":             ErrScriptWitnessMalleated,
+	"WITNESS_MALLEATED_P2SH":        ErrScriptWitnessMalleatedP2SH,
+	"WITNESS_UNEXPECTED":            ErrScriptWitnessUnexpected,
+}
+
+// Parses a comma separated flags string of the test vectors, returning the
+// policy flags apart
+func parseScriptFlagsForTest(s string) (flags ScriptFlags, policy []string, err error) {
+	for _, name := range strings.Split(s, ",") {
+		if flag, ok := scriptFlagsForTest[name]; ok {
+			flags |= flag
+		} else if _, ok := policyFlagsForTest[name]; ok {
+			policy = append(policy, name)
+		} else {
+			return 0, nil, fmt.Errorf("Unknown flag %s", name)
+		}
+	}
+	return flags, policy, nil
+}
+
+// Returns true if the expected error could be raised by a policy flag
+func policyErrorForTest(policy []string, expected string) bool {
+	for _, name := range policy {
+		for _, err := range policyFlagsForTest[name] {
+			if err == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func loadVectorsForTest(t *testing.T, name string) [][]interface{} {
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests [][]interface{}
+	if err := json.Unmarshal(data, &tests); err != nil {
+		t.Fatal(err)
+	}
+	return tests
+}
+
+// Bitcoin Core's src/test/data/script_tests.json. Each script spends the
+// output of a crediting transaction, as in bitcoind's script_tests.cpp.
+func TestScriptVectors(t *testing.T) {
+	count := 0
+	for i, test := range loadVectorsForTest(t, "script_tests.json") {
+		if len(test) == 1 {
+			continue // comment
+		}
+
+		var witness [][]byte
+		var amount Amount
+		if items, ok := test[0].([]interface{}); ok {
+			for _, item := range items[:len(items)-1] {
+				witness = append(witness, hexForTest(t, item.(string)))
+			}
+			var err error
+			if amount, err = NewAmount(items[len(items)-1].(float64)); err != nil {
+				t.Fatal(err)
+			}
+			test = test[1:]
+		}
+		if len(test) < 4 {
+			t.Fatalf("Test %d: malformed %v", i, test)
+		}
+		scriptSig, err := parseScriptForTest(test[0].(string))
+		if err != nil {
+			t.Fatalf("Test %d: %s", i, err)
+		}
+		scriptPubKey, err := parseScriptForTest(test[1].(string))
+		if err != nil {
+			t.Fatalf("Test %d: %s", i, err)
+		}
+		flags, policy, err := parseScriptFlagsForTest(test[2].(string))
+		if err != nil {
+			t.Fatalf("Test %d: %s", i, err)
+		}
+		expected := test[3].(string)
+		want, ok := scriptErrorsForTest[expected]
+		if !ok && !policyErrorForTest(policy, expected) {
+			t.Fatalf("Test %d: unknown error %s", i, expected)
+		}
+		if !ok || policyErrorForTest(policy, expected) {
+			continue
+		}
+
+		credit := &Transaction{
+			Version: 1,
+			Vin:     []TxInput{{Hash: make(Hash256, 32), Index: 0xffffffff, Script: Script{OP_0, OP_0}, Sequence: SEQUENCE_FINAL}},
+			Vout:    []TxOutput{{Value: amount, Script: scriptPubKey}},
+		}
+		spend := &Transaction{
+			Version: 1,
+			Vin:     []TxInput{{Hash: credit.Txid(), Index: 0, Script: scriptSig, ScriptWitness: witness, Sequence: SEQUENCE_FINAL}},
+			Vout:    []TxOutput{{Value: amount, Script: Script{}}},
+		}
+
+		result := VerifyInputScript(spend, 0, credit.Vout, flags, false)
+		// These vectors predate taproot, before which bitcoind reported a
+		// witness script not leaving exactly one element as EVAL_FALSE
+		if want == ErrScriptEvalFalse && witness != nil && result.Err == ErrScriptCleanStack {
+			want = ErrScriptCleanStack
+		}
+		if !errors.Is(result.Err, want) || result.Valid != (want == nil) {
+			t.Errorf("Test %d %v: got %v, want %s", i, test, result.Err, expected)
+		}
+		count++
+	}
+	if count < 1000 {
+		t.Errorf("Only %d vectors run", count)
+	}
+}
+
+// Runs a test of Bitcoin Core's tx_valid.json or tx_invalid.json: prevouts,
+// serialized transaction and flags. Returns the result of each input and the
+// policy flags, which are not applied.
+func verifyTxVectorForTest(t *testing.T, i int, test []interface{}) ([]*ScriptResult, *Transaction, []string) {
+	tx := parseTxForTest(t, test[1].(string))
+	flags, policy, err := parseScriptFlagsForTest(test[2].(string))
+	if err != nil {
+		t.Fatalf("Test %d: %s", i, err)
+	}
+
+	outputs := make(map[string]TxOutput)
+	for _, item := range test[0].([]interface{}) {
+		input := item.([]interface{})
+		hash := Hash256(ReverseHex(hexForTest(t, input[0].(string))))
+		script, err := parseScriptForTest(input[2].(string))
+		if err != nil {
+			t.Fatalf("Test %d: %s", i, err)
+		}
+		output := TxOutput{Script: script}
+		if len(input) > 3 {
+			output.Value = Amount(input[3].(float64))
+		}
+		outputs[fmt.Sprintf("%s:%d", hash, uint32(int64(input[1].(float64))))] = output
+	}
+	var prevouts []TxOutput
+	for _, in := range tx.Vin {
+		output, ok := outputs[fmt.Sprintf("%s:%d", in.Hash, in.Index)]
+		if !ok {
+			t.Fatalf("Test %d: missing prevout %s:%d", i, in.Hash, in.Index)
+		}
+		prevouts = append(prevouts, output)
+	}
+
+	results, err := VerifyTransactionScripts(tx, prevouts, flags)
+	if err != nil {
+		t.Fatalf("Test %d: %s", i, err)
+	}
+	return results, tx, policy
+}
+
+func TestTxValidVectors(t *testing.T) {
+	count := 0
+	for i, test := range loadVectorsForTest(t, "tx_valid.json") {
+		if _, ok := test[0].([]interface{}); !ok {
+			continue // comment
+		}
+		// Policy flags only invalidate, so the transaction stays valid
+		results, _, _ := verifyTxVectorForTest(t, i, test)
+		for j, result := range results {
+			if !result.Valid {
+				t.Errorf("Test %d input %d: %v", i, j, result.Err)
+			}
+		}
+		count++
+	}
+	if count < 100 {
+		t.Errorf("Only %d vectors run", count)
+	}
+}
+
+func TestTxInvalidVectors(t *testing.T) {
+	count := 0
+	for i, test := range loadVectorsForTest(t, "tx_invalid.json") {
+		if _, ok := test[0].([]interface{}); !ok {
+			continue // comment
+		}
+		// The transaction could be invalid only because of a policy flag
+		results, tx, policy := verifyTxVectorForTest(t, i, test)
+		if len(policy) > 0 {
+			continue
+		}
+
+		valid := true
+		for _, result := range results {
+			valid = valid && result.Valid
+		}
+		if valid {
+			t.Errorf("Test %d: %x accepted", i, tx.Binary())
+		}
+		count++
+	}
+	if count < 50 {
+		t.Errorf("Only %d vectors run", count)
+	}
+}
+
+// The scriptPubKey vectors of BIP341's wallet-test-vectors.json that commit to
+// a single leaf, and one without a script tree
+func TestTaprootCommitmentVectors(t *testing.T) {
+	tests := []struct {
+		internalKey, script, leafHash, outputKey string
+		control                                  string
+	}{
+		{"d6889cb081036e0faefa3a35157ad71086b123b2b144b649798b494c300a961d", "", "", "53a1f6e454df1aa2776a2814a721372d6258050de330b3c6d10ee8f4e0dda343", ""},
+		{"187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27", "20d85a959b0290bf19bb89ed43c916be835475d013da4b362117393e25a48229b8ac", "5b75adecf53548f3ec6ad7d78383bf84cc57b55a3127c72b9a2481752dd88b21", "147c9c57132f6e7ecddba9800bb0c4449251c92a1e60371ee77557b6620f3ea3", "c1187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27"},
+		{"93478e9488f956df2396be2ce6c5cced75f900dfa18e7dabd2428aae78451820", "20b617298552a72ade070667e86ca63b8f5789a9fe8731ef91202a91c9f3459007ac", "c525714a7f49c28aedbbba78c005931a81c234b2f6c99a73e4d06082adc8bf2b", "e4d810fd50586274face62b8a807eb9719cef49c04177cc6b76a9a4251d5450e", "c093478e9488f956df2396be2ce6c5cced75f900dfa18e7dabd2428aae78451820"},
+	}
+
+	for _, test := range tests {
+		internalKey, err := ParseXOnlyPubKey(hexForTest(t, test.internalKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var merkleRoot []byte
+		if test.script != "" {
+			merkleRoot = TapLeafHash(TAPROOT_LEAF_TAPSCRIPT, hexForTest(t, test.script))
+			if hex.EncodeToString(merkleRoot) != test.leafHash {
+				t.Errorf("%s: got leaf hash %x", test.internalKey, merkleRoot)
+			}
+		}
+		outputKey, ok := TaprootTweakPubKey(internalKey, merkleRoot)
+		if !ok || hex.EncodeToString(outputKey.SerializeXOnly()) != test.outputKey {
+			t.Errorf("%s: got output key %x", test.internalKey, outputKey.SerializeXOnly())
+		}
+
+		if test.control == "" {
+			continue
+		}
+		control := hexForTest(t, test.control)
+		if !VerifyTaprootCommitment(control, hexForTest(t, test.outputKey), merkleRoot) {
+			t.Errorf("%s: control block rejected", test.internalKey)
+		}
+		control[0] ^= 1
+		if VerifyTaprootCommitment(control, hexForTest(t, test.outputKey), merkleRoot) {
+			t.Errorf("%s: control block with the wrong parity accepted", test.internalKey)
+		}
+	}
+}
+
+// Script path spends of the BIP371 test vectors, signed by Bitcoin Core's
+// wallet: three `<key> OP_CHECKSIG` leaves at different depths of one tree
+func TestTaprootScriptPathSpends(t *testing.T) {
+	tx := parseTxForTest(t, "02000000019bd48765230bf9a72e662001f972556e54f0c6f97feb56bcb5600d817f6995260100000000ffffffff0148e6052a0100000022512083698e458c6664e1595d75da2597de1e22ee97d798e706c4c0a4b5a9823cd74300000000")
+	prevouts := []TxOutput{{Value: 5000000000, Script: hexForTest(t, "5120c2247efbfd92ac47f6f40b8d42d169175a19fa9fa10e4a25d7f35eb4dd85b692")}}
+	leaves := []struct{ pubKey, sig, control string }{
+		{"2cb13ac68248de806aa6a3659cf3c03eb6821d09c8114a4e868febde865bb6d2", "bf818d9757d6ffeb538ba057fb4c1fc4e0f5ef186e765beb564791e02af5fd3d5e2551d4e34e33d86f276b82c99c79aed3f0395a081efcd2cc2c65dd7e693d79", "c150929b74c1a04954b78b4b6035e97a5e078a5a0f28ec96d547bfee9ace803ac06f7d62059e9497a1a4a267569d9876da60101aff38e3529b9b939ce7f91ae970115f2e490af7cc45c4f78511f36057ce5c5a5c56325a29fb44dfc203f356e1f8"},
+		{"4320b0bf16f011b53ea7be615924aa7f27e5d29ad20ea1155d848676c3bad1b2", "e1f1ab6fabfa26b236f21833719dc1d428ab768d80f91f9988d8abef47bfb863bb1f2a529f768c15f00ce34ec283cdc07e88f8428be28f6ef64043c32911811a", "c150929b74c1a04954b78b4b6035e97a5e078a5a0f28ec96d547bfee9ace803ac097c6e6fea5ff714ff5724499990810e406e98aa10f5bf7e5f6784bc1d0a9a6ce"},
+		{"fa0f7a3cef3b1d0c0a6ce7d26e17ada0b2e5c92d19efad48b41859cb8a451ca9", "ec1f0379206461c83342285423326708ab031f0da4a253ee45aafa5b8c92034d8b605490f8cd13e00f989989b97e215faa36f12dee3693d2daccf3781c1757f6", "c150929b74c1a04954b78b4b6035e97a5e078a5a0f28ec96d547bfee9ace803ac0cd970e15f53fc0c82f950fd560ffa919b76172be017368a89913af074f400b09115f2e490af7cc45c4f78511f36057ce5c5a5c56325a29fb44dfc203f356e1f8"},
+	}
+
+	verify := func(sig []byte, script Script, control []byte, flags ScriptFlags) error {
+		tx.Vin[0].ScriptWitness = [][]byte{sig, script, control}
+		return VerifyInputScript(tx, 0, prevouts, flags, false).Err
+	}
+	for i, leaf := range leaves {
+		script := append(append(Script{32}, hexForTest(t, leaf.pubKey)...), OP_CHECKSIG)
+		sig := hexForTest(t, leaf.sig)
+		control := hexForTest(t, leaf.control)
+
+		if err := verify(sig, script, control, SCRIPT_VERIFY_CONSENSUS); err != nil {
+			t.Errorf("Leaf %s: %v", leaf.pubKey, err)
+		}
+		// The merkle path of another leaf
+		other := hexForTest(t, leaves[(i+1)%len(leaves)].control)
+		if err := verify(sig, script, other, SCRIPT_VERIFY_CONSENSUS); err != ErrScriptWitnessProgramMismatch {
+			t.Errorf("Leaf %s with the control block of another leaf: %v", leaf.pubKey, err)
+		}
+		if err := verify(sig, script, control[:len(control)-1], SCRIPT_VERIFY_CONSENSUS); err != ErrScriptTaprootWrongControlSize {
+			t.Errorf("Leaf %s with a truncated control block: %v", leaf.pubKey, err)
+		}
+
+		badSig := append([]byte{}, sig...)
+		badSig[0] ^= 1
+		if err := verify(badSig, script, control, SCRIPT_VERIFY_CONSENSUS); err != ErrScriptSchnorrSig {
+			t.Errorf("Leaf %s with a bad signature: %v", leaf.pubKey, err)
+		}
+		// An explicit SIGHASH_DEFAULT byte is not allowed
+		if err := verify(append(sig, SIGHASH_DEFAULT), script, control, SCRIPT_VERIFY_CONSENSUS); err != ErrScriptSchnorrSigHashType {
+			t.Errorf("Leaf %s with an explicit SIGHASH_DEFAULT: %v", leaf.pubKey, err)
+		}
+		// Witness v1 programs are anyone-can-spend before taproot activation
+		if err := verify(badSig, script, control, SCRIPT_VERIFY_CONSENSUS&^SCRIPT_VERIFY_TAPROOT); err != nil {
+			t.Errorf("Leaf %s without the taproot flag: %v", leaf.pubKey, err)
+		}
+	}
+}
+
+// A one input transaction spending a taproot output with a single leaf, the
+// tapscript sighash of that input and the control block of the leaf
+type tapscriptSpendForTest struct {
+	tx       *Transaction
+	prevouts []TxOutput
+	script   Script
+	control  []byte
+	sigHash  Hash256
+}
+
+func newTapscriptSpendForTest(t *testing.T, script Script) *tapscriptSpendForTest {
+	internalKey, err := ParseXOnlyPubKey(pubKeyForTest(big.NewInt(1000)).SerializeXOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafHash := TapLeafHash(TAPROOT_LEAF_TAPSCRIPT, script)
+	outputKey, ok := TaprootTweakPubKey(internalKey, leafHash)
+	if !ok {
+		t.Fatal("Tweak failed")
+	}
+
+	spend := &tapscriptSpendForTest{
+		tx: &Transaction{
+			Version: 2,
+			Vin:     []TxInput{{Hash: make(Hash256, 32), Index: 1, Sequence: 0xffffffff}},
+			Vout:    []TxOutput{{Value: 90000, Script: Script{OP_1}}},
+		},
+		prevouts: []TxOutput{{Value: 100000, Script: append(Script{OP_1, 32}, outputKey.SerializeXOnly()...)}},
+		script:   script,
+		control:  append([]byte{TAPROOT_LEAF_TAPSCRIPT | byte(outputKey.Y.Bit(0))}, internalKey.SerializeXOnly()...),
+	}
+	ext := &TapscriptSigHashExt{LeafHash: leafHash, CodeSeparatorPos: 0xffffffff}
+	spend.sigHash, err = SignatureHashTaproot(spend.tx, 0, SIGHASH_DEFAULT, spend.prevouts, nil, ext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return spend
+}
+
+// Verifies the spend with stack as the initial tapscript stack, bottom first
+func (spend *tapscriptSpendForTest) verify(stack ...[]byte) error {
+	spend.tx.Vin[0].ScriptWitness = append(append([][]byte{}, stack...), spend.script, spend.control)
+	return VerifyInputScript(spend.tx, 0, spend.prevouts, SCRIPT_VERIFY_CONSENSUS, false).Err
+}
+
+func TestTapscriptCheckSigAdd(t *testing.T) {
+	keys := []*big.Int{big.NewInt(11), big.NewInt(12), big.NewInt(13)}
+	// <key1> CHECKSIG <key2> CHECKSIGADD <key3> CHECKSIGADD 2 NUMEQUAL
+	script := Script{}
+	for i, key := range keys {
+		script = append(script, PushData(pubKeyForTest(key).SerializeXOnly())...)
+		if i == 0 {
+			script = append(script, OP_CHECKSIG)
+		} else {
+			script = append(script, OP_CHECKSIGADD)
+		}
+	}
+	script = append(script, OP_2, OP_NUMEQUAL)
+
+	spend := newTapscriptSpendForTest(t, script)
+	var sigs [][]byte
+	for _, key := range keys {
+		sigs = append(sigs, signSchnorrForTest(key, spend.sigHash))
+	}
+	badSig := append([]byte{}, sigs[1]...)
+	badSig[63] ^= 1
+
+	// The signature for the first key is on top of the stack
+	tests := []struct {
+		stack [][]byte
+		err   error
+	}{
+		{[][]byte{sigs[2], sigs[1], sigs[0]}, ErrScriptEvalFalse},
+		{[][]byte{sigs[2], {}, sigs[0]}, nil},
+		{[][]byte{{}, sigs[1], sigs[0]}, nil},
+		{[][]byte{sigs[2], sigs[1], {}}, nil},
+		{[][]byte{{}, {}, sigs[0]}, ErrScriptEvalFalse},
+		// Only empty signatures may fail
+		{[][]byte{sigs[2], badSig, sigs[0]}, ErrScriptSchnorrSig},
+		{[][]byte{sigs[2], sigs[1][:63], sigs[0]}, ErrScriptSchnorrSigSize},
+		{[][]byte{sigs[0], {}, sigs[2]}, ErrScriptSchnorrSig},
+	}
+	for i, test := range tests {
+		if err := spend.verify(test.stack...); err != test.err {
+			t.Errorf("Test %d: got %v, want %v", i, err, test.err)
+		}
+	}
+}
+
+func TestTapscriptOpcodes(t *testing.T) {
+	tests := []struct {
+		name   string
+		script Script
+		stack  [][]byte
+		err    error
+	}{
+		// OP_SUCCESS80 is found before the truncated OP_PUSHDATA1
+		{"OP_SUCCESS", Script{OP_RETURN, 0x50, OP_PUSHDATA1}, nil, nil},
+		{"OP_SUCCESS after a bad push", Script{OP_PUSHDATA1, 0x50}, nil, ErrScriptBadOpcode},
+		{"OP_CHECKMULTISIG", Script{OP_0, OP_0, OP_CHECKMULTISIG}, nil, ErrScriptTapscriptCheckMultisig},
+		{"OP_CHECKMULTISIGVERIFY", Script{OP_0, OP_0, OP_CHECKMULTISIGVERIFY, OP_1}, nil, ErrScriptTapscriptCheckMultisig},
+		{"minimal OP_IF", Script{OP_IF, OP_1, OP_ENDIF}, [][]byte{{1}}, nil},
+		{"non-minimal OP_IF", Script{OP_IF, OP_1, OP_ENDIF}, [][]byte{{2}}, ErrScriptTapscriptMinimalIf},
+		{"empty public key", Script{OP_0, OP_CHECKSIG}, [][]byte{{}}, ErrScriptPubKeyType},
+		// Unknown public key types succeed for any non-empty signature
+		{"unknown public key type", Script{OP_1, OP_CHECKSIG}, [][]byte{{1}}, nil},
+		{"stack size", Script{OP_1}, make([][]byte, MAX_STACK_SIZE+1), ErrScriptStackSize},
+	}
+
+	for _, test := range tests {
+		if err := newTapscriptSpendForTest(t, test.script).verify(test.stack...); err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+// Each signature checked costs 50 units of the budget of the witness size
+// plus 50, so a script can't check more signatures than its witness pays for
+func TestTapscriptValidationWeight(t *testing.T) {
+	key := big.NewInt(21)
+	pubKey := PushData(pubKeyForTest(key).SerializeXOnly())
+
+	for _, test := range []struct {
+		checks int
+		err    error
+	}{
+		{1, nil},
+		{5, nil},
+		{10, nil},
+		{11, ErrScriptTapscriptValidationWeight},
+		{20, ErrScriptTapscriptValidationWeight},
+	} {
+		// (checks - 1) * <DUP key CHECKSIGVERIFY> followed by <key CHECKSIG>:
+		// each check adds 35 bytes of witness but costs 50
+		script := Script{}
+		for i := 1; i < test.checks; i++ {
+			script = append(append(append(script, OP_DUP), pubKey...), OP_CHECKSIGVERIFY)
+		}
+		script = append(append(script, pubKey...), OP_CHECKSIG)
+
+		spend := newTapscriptSpendForTest(t, script)
+		if err := spend.verify(signSchnorrForTest(key, spend.sigHash)); err != test.err {
+			t.Errorf("%d signatures: got %v, want %v", test.checks, err, test.err)
+		}
+	}
+}
+
+func TestVerifyInputScriptTrace(t *testing.T) {
+	tx := &Transaction{
+		Version: 1,
+		Vin:     []TxInput{{Hash: make(Hash256, 32), Script: Script{2, 0xab, 0xcd}, Sequence: 0xffffffff}},
+		Vout:    []TxOutput{{Script: Script{OP_1}}},
+	}
+	prevouts := []TxOutput{{Script: Script{OP_1, OP_IF, OP_TOALTSTACK, OP_2, OP_ELSE, OP_3, OP_ENDIF}}}
+
+	result := VerifyInputScript(tx, 0, prevouts, SCRIPT_VERIFY_NONE, true)
+	if !result.Valid {
+		t.Fatal(result.Err)
+	}
+	want := []string{
+		"scriptSig@0: PUSH abcd [abcd]",
+		"scriptPubKey@0: 1 [abcd 01]",
+		"scriptPubKey@1: OP_IF [abcd]",
+		"scriptPubKey@2: OP_TOALTSTACK []",
+		"scriptPubKey@3: 2 [02]",
+		"scriptPubKey@4: OP_ELSE [02]",
+		"scriptPubKey@5: 3 (skipped) [02]",
+		// Still inside the branch that is not taken
+		"scriptPubKey@6: OP_ENDIF (skipped) [02]",
+	}
+	if len(result.Trace) != len(want) {
+		t.Fatalf("Got %d steps: %v", len(result.Trace), result.Trace)
+	}
+	for i, step := range result.Trace {
+		if step.String() != want[i] {
+			t.Errorf("Step %d: got %q, want %q", i, step.String(), want[i])
+		}
+	}
+
+	step := result.Trace[3]
+	if step.Opcode != OP_TOALTSTACK || !step.Executed || len(step.AltStack) != 1 || hex.EncodeToString(step.AltStack[0]) != "abcd" {
+		t.Errorf("Got %+v", step)
+	}
+	if step := result.Trace[6]; step.Executed || step.Opcode != OP_3 {
+		t.Errorf("Got %+v", step)
+	}
+
+	// Without tracing nothing is recorded
+	if result := VerifyInputScript(tx, 0, prevouts, SCRIPT_VERIFY_NONE, false); !result.Valid || result.Trace != nil {
+		t.Errorf("Got %+v", result)
+	}
+}
@@ -0,0 +1,68 @@
+package blockchainparser
+
+import (
+	"math/big"
+)
+
+// Decode the compact "bits" representation of a target, as in bitcoind's
+// arith_uint256::SetCompact. Negative or overflowing targets return nil.
+func CompactToBig(bits uint32) *big.Int {
+	size := bits >> 24
+	word := bits & 0x007fffff
+
+	target := new(big.Int)
+	if size <= 3 {
+		target.SetUint64(uint64(word >> (8 * (3 - size))))
+	} else {
+		target.SetUint64(uint64(word))
+		target.Lsh(target, uint(8*(size-3)))
+	}
+
+	negative := word != 0 && bits&0x00800000 != 0
+	overflow := word != 0 && (size > 34 || (word > 0xff && size > 33) || (word > 0xffff && size > 32))
+	if negative || overflow {
+		return nil
+	}
+
+	return target
+}
+
+// Expected number of hashes to find a block with this target, as in
+// bitcoind's GetBlockProof: 2**256 / (target+1)
+func GetBlockWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target == nil || target.Sign() == 0 {
+		return new(big.Int)
+	}
+
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// Difficulty relative to the minimum difficulty, as in bitcoind's GetDifficulty
+func GetDifficulty(bits uint32) float64 {
+	shift := (bits >> 24) & 0xff
+	difficulty := float64(0x0000ffff) / float64(bits&0x00ffffff)
+
+	for shift < 29 {
+		difficulty *= 256.0
+		shift++
+	}
+	for shift > 29 {
+		difficulty /= 256.0
+		shift--
+	}
+
+	return difficulty
+}
+
+// Checks that hash, as a little endian number, doesn't exceed the target
+// encoded in bits, as in bitcoind's CheckProofOfWork (without the pow limit)
+func CheckProofOfWork(hash Hash256, bits uint32) bool {
+	target := CompactToBig(bits)
+	if target == nil || target.Sign() == 0 {
+		return false
+	}
+
+	return new(big.Int).SetBytes(ReverseHex(hash)).Cmp(target) <= 0
+}
@@ -0,0 +1,415 @@
+package psbt
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+// Merges the items of src into dst, skipping items whose key is already in dst
+func mergeByKey[T any](dst []T, src []T, key func(T) string) []T {
+	dst = append([]T{}, dst...)
+	seen := map[string]bool{}
+	for _, item := range dst {
+		seen[key(item)] = true
+	}
+	for _, item := range src {
+		if !seen[key(item)] {
+			seen[key(item)] = true
+			dst = append(dst, item)
+		}
+	}
+	return dst
+}
+
+func derivationKey(d Bip32Derivation) string       { return string(d.PubKey) }
+func tapDerivationKey(d TapBip32Derivation) string { return string(d.XOnlyPubKey) }
+func preimageKey(p Preimage) string                { return string(p.Hash) }
+func unknownKey(u Unknown) string                  { return string(u.Key) }
+
+// Returns true if both PSBTs are for the same unsigned transaction
+func (psbt *Psbt) sameTransaction(other *Psbt) bool {
+	if psbt.Version != other.Version || len(psbt.Inputs) != len(other.Inputs) || len(psbt.Outputs) != len(other.Outputs) {
+		return false
+	}
+	if psbt.Version == 0 {
+		return bytes.Equal(psbt.UnsignedTx.Txid(), other.UnsignedTx.Txid())
+	}
+
+	for i := range psbt.Inputs {
+		hash, index := psbt.Outpoint(i)
+		otherHash, otherIndex := other.Outpoint(i)
+		if !bytes.Equal(hash, otherHash) || index != otherIndex {
+			return false
+		}
+	}
+	for i, output := range psbt.Outputs {
+		if *output.Amount != *other.Outputs[i].Amount || !bytes.Equal(output.Script, other.Outputs[i].Script) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Combines PSBTs for the same transaction into a new one holding the union
+// of their data, as the BIP174 Combiner role. For fields with a single value
+// the first PSBT having it wins.
+func Combine(psbts ...*Psbt) (*Psbt, error) {
+	if len(psbts) == 0 {
+		return nil, fmt.Errorf("No PSBT to combine")
+	}
+
+	first := psbts[0]
+	result := *first
+	result.Xpubs = append([]Bip32Derivation{}, first.Xpubs...)
+	result.Unknowns = append([]Unknown{}, first.Unknowns...)
+	result.Inputs = append([]Input{}, first.Inputs...)
+	result.Outputs = append([]Output{}, first.Outputs...)
+
+	for _, other := range psbts[1:] {
+		if !first.sameTransaction(other) {
+			return nil, ErrTxMismatch
+		}
+
+		result.Xpubs = mergeByKey(result.Xpubs, other.Xpubs, derivationKey)
+		result.Unknowns = mergeByKey(result.Unknowns, other.Unknowns, unknownKey)
+		if result.FallbackLocktime == nil {
+			result.FallbackLocktime = other.FallbackLocktime
+		}
+		if result.TxModifiable == nil {
+			result.TxModifiable = other.TxModifiable
+		}
+		for i := range result.Inputs {
+			result.Inputs[i].merge(&other.Inputs[i])
+		}
+		for i := range result.Outputs {
+			result.Outputs[i].merge(&other.Outputs[i])
+		}
+	}
+
+	return &result, nil
+}
+
+func (input *Input) merge(other *Input) {
+	if input.NonWitnessUtxo == nil {
+		input.NonWitnessUtxo = other.NonWitnessUtxo
+	}
+	if input.WitnessUtxo == nil {
+		input.WitnessUtxo = other.WitnessUtxo
+	}
+	input.PartialSigs = mergeByKey(input.PartialSigs, other.PartialSigs, func(s PartialSig) string { return string(s.PubKey) })
+	if input.SighashType == nil {
+		input.SighashType = other.SighashType
+	}
+	if input.RedeemScript == nil {
+		input.RedeemScript = other.RedeemScript
+	}
+	if input.WitnessScript == nil {
+		input.WitnessScript = other.WitnessScript
+	}
+	input.Bip32Derivations = mergeByKey(input.Bip32Derivations, other.Bip32Derivations, derivationKey)
+	if input.FinalScriptSig == nil {
+		input.FinalScriptSig = other.FinalScriptSig
+	}
+	if input.FinalScriptWitness == nil {
+		input.FinalScriptWitness = other.FinalScriptWitness
+	}
+	input.Ripemd160Preimages = mergeByKey(input.Ripemd160Preimages, other.Ripemd160Preimages, preimageKey)
+	input.Sha256Preimages = mergeByKey(input.Sha256Preimages, other.Sha256Preimages, preimageKey)
+	input.Hash160Preimages = mergeByKey(input.Hash160Preimages, other.Hash160Preimages, preimageKey)
+	input.Hash256Preimages = mergeByKey(input.Hash256Preimages, other.Hash256Preimages, preimageKey)
+
+	if input.Sequence == nil {
+		input.Sequence = other.Sequence
+	}
+	if input.RequiredTimeLocktime == nil {
+		input.RequiredTimeLocktime = other.RequiredTimeLocktime
+	}
+	if input.RequiredHeightLocktime == nil {
+		input.RequiredHeightLocktime = other.RequiredHeightLocktime
+	}
+
+	if input.TapKeySig == nil {
+		input.TapKeySig = other.TapKeySig
+	}
+	input.TapScriptSigs = mergeByKey(input.TapScriptSigs, other.TapScriptSigs, func(s TapScriptSig) string {
+		return string(s.XOnlyPubKey) + string(s.LeafHash)
+	})
+	input.TapLeafScripts = mergeByKey(input.TapLeafScripts, other.TapLeafScripts, func(l TapLeafScript) string {
+		return string(l.ControlBlock)
+	})
+	input.TapBip32Derivations = mergeByKey(input.TapBip32Derivations, other.TapBip32Derivations, tapDerivationKey)
+	if input.TapInternalKey == nil {
+		input.TapInternalKey = other.TapInternalKey
+	}
+	if input.TapMerkleRoot == nil {
+		input.TapMerkleRoot = other.TapMerkleRoot
+	}
+	input.Unknowns = mergeByKey(input.Unknowns, other.Unknowns, unknownKey)
+}
+
+func (output *Output) merge(other *Output) {
+	if output.RedeemScript == nil {
+		output.RedeemScript = other.RedeemScript
+	}
+	if output.WitnessScript == nil {
+		output.WitnessScript = other.WitnessScript
+	}
+	output.Bip32Derivations = mergeByKey(output.Bip32Derivations, other.Bip32Derivations, derivationKey)
+	if output.TapInternalKey == nil {
+		output.TapInternalKey = other.TapInternalKey
+	}
+	if output.TapTree == nil {
+		output.TapTree = other.TapTree
+	}
+	output.TapBip32Derivations = mergeByKey(output.TapBip32Derivations, other.TapBip32Derivations, tapDerivationKey)
+	output.Unknowns = mergeByKey(output.Unknowns, other.Unknowns, unknownKey)
+}
+
+func (input *Input) IsFinalized() bool {
+	return input.FinalScriptSig != nil || input.FinalScriptWitness != nil
+}
+
+// Returns true if every input is finalized
+func (psbt *Psbt) IsComplete() bool {
+	for i := range psbt.Inputs {
+		if !psbt.Inputs[i].IsFinalized() {
+			return false
+		}
+	}
+	return true
+}
+
+func (input *Input) partialSig(pubKey []byte) []byte {
+	for _, partialSig := range input.PartialSigs {
+		if bytes.Equal(partialSig.PubKey, pubKey) {
+			return partialSig.Signature
+		}
+	}
+	return nil
+}
+
+func (input *Input) tapScriptSig(xOnlyPubKey []byte, leafHash []byte) []byte {
+	for _, sig := range input.TapScriptSigs {
+		if bytes.Equal(sig.XOnlyPubKey, xOnlyPubKey) && bytes.Equal(sig.LeafHash, leafHash) {
+			return sig.Signature
+		}
+	}
+	return nil
+}
+
+// Builds the stack satisfying a P2PK, P2PKH or bare multisig script from the
+// partial signatures
+func (input *Input) solve(script blockchainparser.Script) ([][]byte, error) {
+	scriptType, solutions := script.Type()
+	switch scriptType {
+	case blockchainparser.TX_PUBKEY:
+		if sig := input.partialSig(solutions[0]); sig != nil {
+			return [][]byte{sig}, nil
+		}
+	case blockchainparser.TX_PUBKEYHASH:
+		for _, partialSig := range input.PartialSigs {
+			if bytes.Equal(blockchainparser.Hash160(partialSig.PubKey), solutions[0]) {
+				return [][]byte{partialSig.Signature, partialSig.PubKey}, nil
+			}
+		}
+	case blockchainparser.TX_MULTISIG:
+		required, pubKeys, _ := script.MultisigKeys()
+		// Dummy element consumed by OP_CHECKMULTISIG
+		stack := [][]byte{{}}
+		for _, pubKey := range pubKeys {
+			if sig := input.partialSig(pubKey); sig != nil && len(stack) <= required {
+				stack = append(stack, sig)
+			}
+		}
+		if len(stack) == required+1 {
+			return stack, nil
+		}
+	}
+
+	return nil, ErrCannotFinalize
+}
+
+// Builds the witness stack, without script and control block, satisfying a
+// tapscript leaf of the form <key> OP_CHECKSIG or a multi_a
+// (<key> OP_CHECKSIG <key> OP_CHECKSIGADD ... <k> OP_NUMEQUAL)
+func (input *Input) solveTapscript(script blockchainparser.Script, leafHash []byte) ([][]byte, error) {
+	ops, ok := script.Ops()
+	if !ok || len(ops) < 2 {
+		return nil, ErrCannotFinalize
+	}
+
+	if len(ops) == 2 && len(ops[0].Data) == 32 && ops[1].Opcode == blockchainparser.OP_CHECKSIG {
+		if sig := input.tapScriptSig(ops[0].Data, leafHash); sig != nil {
+			return [][]byte{sig}, nil
+		}
+		return nil, ErrCannotFinalize
+	}
+
+	// multi_a
+	last := len(ops) - 1
+	if len(ops)%2 != 0 || ops[last].Opcode != blockchainparser.OP_NUMEQUAL || !blockchainparser.IsOpN(ops[last-1].Opcode) {
+		return nil, ErrCannotFinalize
+	}
+	required := blockchainparser.DecodeOpN(ops[last-1].Opcode)
+	var keys [][]byte
+	for i := 0; i < last-1; i += 2 {
+		expected := byte(blockchainparser.OP_CHECKSIGADD)
+		if i == 0 {
+			expected = blockchainparser.OP_CHECKSIG
+		}
+		if len(ops[i].Data) != 32 || ops[i+1].Opcode != expected {
+			return nil, ErrCannotFinalize
+		}
+		keys = append(keys, ops[i].Data)
+	}
+
+	// The signature for the first key is at the top of the stack
+	stack := make([][]byte, len(keys))
+	found := 0
+	for i, key := range keys {
+		sig := input.tapScriptSig(key, leafHash)
+		if sig != nil && found < required {
+			stack[len(keys)-1-i] = sig
+			found++
+		} else {
+			stack[len(keys)-1-i] = []byte{}
+		}
+	}
+	if found < required {
+		return nil, ErrCannotFinalize
+	}
+
+	return stack, nil
+}
+
+// Builds the final scriptSig and witness of input i from its signatures and
+// scripts, as the BIP174 Input Finalizer role. Supported are P2PK, P2PKH,
+// multisig, P2WPKH, P2WSH and P2SH wrapping of these, and taproot key path
+// or pk/multi_a script path spends.
+func (psbt *Psbt) FinalizeInput(i int) error {
+	input := &psbt.Inputs[i]
+	if input.IsFinalized() {
+		return nil
+	}
+
+	utxo, err := psbt.Utxo(i)
+	if err != nil {
+		return err
+	}
+
+	script := utxo.Script
+	isP2SH := script.IsPayToScriptHash()
+	if isP2SH {
+		if input.RedeemScript == nil || !bytes.Equal(blockchainparser.Hash160(input.RedeemScript), script[2:22]) {
+			return ErrCannotFinalize
+		}
+		script = input.RedeemScript
+	}
+
+	var scriptSigStack, witness [][]byte
+	if version, program, ok := script.WitnessProgram(); ok {
+		switch {
+		case version == 0 && len(program) == 20:
+			for _, partialSig := range input.PartialSigs {
+				if bytes.Equal(blockchainparser.Hash160(partialSig.PubKey), program) {
+					witness = [][]byte{partialSig.Signature, partialSig.PubKey}
+				}
+			}
+		case version == 0 && len(program) == 32:
+			if input.WitnessScript == nil || !bytes.Equal(blockchainparser.Sha256(input.WitnessScript), program) {
+				return ErrCannotFinalize
+			}
+			stack, err := input.solve(input.WitnessScript)
+			if err != nil {
+				return err
+			}
+			witness = append(stack, input.WitnessScript)
+		case version == 1 && len(program) == 32 && !isP2SH:
+			if input.TapKeySig != nil {
+				witness = [][]byte{input.TapKeySig}
+				break
+			}
+			for _, leaf := range input.TapLeafScripts {
+				if leaf.LeafVersion != blockchainparser.TAPROOT_LEAF_TAPSCRIPT {
+					continue
+				}
+				leafHash := blockchainparser.TapLeafHash(leaf.LeafVersion, leaf.Script)
+				if !blockchainparser.VerifyTaprootCommitment(leaf.ControlBlock, program, leafHash) {
+					continue
+				}
+				stack, err := input.solveTapscript(leaf.Script, leafHash)
+				if err == nil {
+					witness = append(stack, leaf.Script, leaf.ControlBlock)
+					break
+				}
+			}
+		}
+		if witness == nil {
+			return ErrCannotFinalize
+		}
+	} else {
+		scriptSigStack, err = input.solve(script)
+		if err != nil {
+			return err
+		}
+	}
+	if isP2SH {
+		scriptSigStack = append(scriptSigStack, input.RedeemScript)
+	}
+
+	var scriptSig blockchainparser.Script
+	for _, item := range scriptSigStack {
+		scriptSig = append(scriptSig, blockchainparser.PushData(item)...)
+	}
+
+	// Only the UTXOs, the v2 transaction fields and unknowns are kept
+	*input = Input{
+		NonWitnessUtxo:         input.NonWitnessUtxo,
+		WitnessUtxo:            input.WitnessUtxo,
+		FinalScriptSig:         scriptSig,
+		FinalScriptWitness:     witness,
+		PreviousTxid:           input.PreviousTxid,
+		OutputIndex:            input.OutputIndex,
+		Sequence:               input.Sequence,
+		RequiredTimeLocktime:   input.RequiredTimeLocktime,
+		RequiredHeightLocktime: input.RequiredHeightLocktime,
+		Unknowns:               input.Unknowns,
+	}
+
+	return nil
+}
+
+// Finalizes every input it can. Returns the error of the first input which
+// could not be finalized.
+func (psbt *Psbt) Finalize() error {
+	var firstErr error
+	for i := range psbt.Inputs {
+		if err := psbt.FinalizeInput(i); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("Input %d: %v", i, err)
+		}
+	}
+
+	return firstErr
+}
+
+// Returns the network transaction of a complete PSBT, as the BIP174
+// Transaction Extractor role
+func (psbt *Psbt) Extract() (*blockchainparser.Transaction, error) {
+	if !psbt.IsComplete() {
+		return nil, ErrNotFinalized
+	}
+
+	tx, err := psbt.UnsignedTransaction()
+	if err != nil {
+		return nil, err
+	}
+	for i, input := range psbt.Inputs {
+		tx.Vin[i].Script = input.FinalScriptSig
+		tx.Vin[i].ScriptWitness = input.FinalScriptWitness
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,1232 @@
+// Package psbt implements Partially Signed Bitcoin Transactions, version 0
+// (BIP174) and version 2 (BIP370), including the taproot fields of BIP371.
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ruqqq/blockchainparser"
+	"golang.org/x/crypto/ripemd160"
+)
+
+const (
+	//! Global key types
+	PSBT_GLOBAL_UNSIGNED_TX       = 0x00
+	PSBT_GLOBAL_XPUB              = 0x01
+	PSBT_GLOBAL_TX_VERSION        = 0x02
+	PSBT_GLOBAL_FALLBACK_LOCKTIME = 0x03
+	PSBT_GLOBAL_INPUT_COUNT       = 0x04
+	PSBT_GLOBAL_OUTPUT_COUNT      = 0x05
+	PSBT_GLOBAL_TX_MODIFIABLE     = 0x06
+	PSBT_GLOBAL_VERSION           = 0xFB
+	PSBT_GLOBAL_PROPRIETARY       = 0xFC
+
+	//! Input key types
+	PSBT_IN_NON_WITNESS_UTXO         = 0x00
+	PSBT_IN_WITNESS_UTXO             = 0x01
+	PSBT_IN_PARTIAL_SIG              = 0x02
+	PSBT_IN_SIGHASH_TYPE             = 0x03
+	PSBT_IN_REDEEM_SCRIPT            = 0x04
+	PSBT_IN_WITNESS_SCRIPT           = 0x05
+	PSBT_IN_BIP32_DERIVATION         = 0x06
+	PSBT_IN_FINAL_SCRIPTSIG          = 0x07
+	PSBT_IN_FINAL_SCRIPTWITNESS      = 0x08
+	PSBT_IN_RIPEMD160                = 0x0A
+	PSBT_IN_SHA256                   = 0x0B
+	PSBT_IN_HASH160                  = 0x0C
+	PSBT_IN_HASH256                  = 0x0D
+	PSBT_IN_PREVIOUS_TXID            = 0x0E
+	PSBT_IN_OUTPUT_INDEX             = 0x0F
+	PSBT_IN_SEQUENCE                 = 0x10
+	PSBT_IN_REQUIRED_TIME_LOCKTIME   = 0x11
+	PSBT_IN_REQUIRED_HEIGHT_LOCKTIME = 0x12
+	PSBT_IN_TAP_KEY_SIG              = 0x13
+	PSBT_IN_TAP_SCRIPT_SIG           = 0x14
+	PSBT_IN_TAP_LEAF_SCRIPT          = 0x15
+	PSBT_IN_TAP_BIP32_DERIVATION     = 0x16
+	PSBT_IN_TAP_INTERNAL_KEY         = 0x17
+	PSBT_IN_TAP_MERKLE_ROOT          = 0x18
+	PSBT_IN_PROPRIETARY              = 0xFC
+
+	//! Output key types
+	PSBT_OUT_REDEEM_SCRIPT        = 0x00
+	PSBT_OUT_WITNESS_SCRIPT       = 0x01
+	PSBT_OUT_BIP32_DERIVATION     = 0x02
+	PSBT_OUT_AMOUNT               = 0x03
+	PSBT_OUT_SCRIPT               = 0x04
+	PSBT_OUT_TAP_INTERNAL_KEY     = 0x05
+	PSBT_OUT_TAP_TREE             = 0x06
+	PSBT_OUT_TAP_BIP32_DERIVATION = 0x07
+	PSBT_OUT_PROPRIETARY          = 0xFC
+
+	//! Bits of PSBT_GLOBAL_TX_MODIFIABLE
+	PSBT_TX_MODIFIABLE_INPUTS         = 0x01
+	PSBT_TX_MODIFIABLE_OUTPUTS        = 0x02
+	PSBT_TX_MODIFIABLE_SIGHASH_SINGLE = 0x04
+
+	//! Highest supported PSBT version
+	PSBT_HIGHEST_VERSION = 2
+
+	BIP32_EXTKEY_WITH_VERSION_SIZE = 78
+)
+
+var psbtMagic = []byte{'p', 's', 'b', 't', 0xff}
+
+var (
+	ErrInvalidMagic   = errors.New("Invalid PSBT magic bytes")
+	ErrDuplicateKey   = errors.New("Duplicate key in PSBT")
+	ErrTxMismatch     = errors.New("PSBTs not for the same transaction")
+	ErrNotFinalized   = errors.New("PSBT input not finalized")
+	ErrMissingUtxo    = errors.New("PSBT input is missing its UTXO")
+	ErrUtxoMismatch   = errors.New("PSBT input UTXO does not match the spent outpoint")
+	ErrCannotFinalize = errors.New("PSBT input can't be finalized with the available data")
+	ErrV2Field        = errors.New("PSBTv2 field in a version 0 PSBT")
+)
+
+// Origin of a key: master key fingerprint and derivation path
+type KeyOrigin struct {
+	Fingerprint [4]byte
+	Path        []uint32
+}
+
+// Public key, or extended public key for global xpubs, with its origin
+type Bip32Derivation struct {
+	PubKey []byte
+	KeyOrigin
+}
+
+type TapBip32Derivation struct {
+	XOnlyPubKey []byte
+	LeafHashes  [][]byte
+	KeyOrigin
+}
+
+type PartialSig struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+type TapScriptSig struct {
+	XOnlyPubKey []byte
+	LeafHash    []byte
+	Signature   []byte
+}
+
+type TapLeafScript struct {
+	ControlBlock []byte
+	Script       blockchainparser.Script
+	LeafVersion  byte
+}
+
+// Leaf of PSBT_OUT_TAP_TREE, in depth first order
+type TapLeaf struct {
+	Depth       byte
+	LeafVersion byte
+	Script      blockchainparser.Script
+}
+
+type Preimage struct {
+	Hash     []byte
+	Preimage []byte
+}
+
+// Unknown or proprietary pair; Key includes the key type
+type Unknown struct {
+	Key   []byte
+	Value []byte
+}
+
+type Input struct {
+	NonWitnessUtxo     *blockchainparser.Transaction
+	WitnessUtxo        *blockchainparser.TxOutput
+	PartialSigs        []PartialSig
+	SighashType        *uint32
+	RedeemScript       blockchainparser.Script
+	WitnessScript      blockchainparser.Script
+	Bip32Derivations   []Bip32Derivation
+	FinalScriptSig     blockchainparser.Script
+	FinalScriptWitness [][]byte
+	Ripemd160Preimages []Preimage
+	Sha256Preimages    []Preimage
+	Hash160Preimages   []Preimage
+	Hash256Preimages   []Preimage
+
+	// Version 2 only
+	PreviousTxid           blockchainparser.Hash256
+	OutputIndex            *uint32
+	Sequence               *uint32
+	RequiredTimeLocktime   *uint32
+	RequiredHeightLocktime *uint32
+
+	TapKeySig           []byte
+	TapScriptSigs       []TapScriptSig
+	TapLeafScripts      []TapLeafScript
+	TapBip32Derivations []TapBip32Derivation
+	TapInternalKey      []byte
+	TapMerkleRoot       []byte
+
+	Unknowns []Unknown
+}
+
+type Output struct {
+	RedeemScript     blockchainparser.Script
+	WitnessScript    blockchainparser.Script
+	Bip32Derivations []Bip32Derivation
+
+	// Version 2 only
+	Amount *blockchainparser.Amount
+	Script blockchainparser.Script
+
+	TapInternalKey      []byte
+	TapTree             []TapLeaf
+	TapBip32Derivations []TapBip32Derivation
+
+	Unknowns []Unknown
+}
+
+type Psbt struct {
+	Version uint32
+
+	// Version 0 only
+	UnsignedTx *blockchainparser.Transaction
+
+	// Version 2 only
+	TxVersion        int32
+	FallbackLocktime *uint32
+	TxModifiable     *uint8
+
+	Xpubs    []Bip32Derivation
+	Inputs   []Input
+	Outputs  []Output
+	Unknowns []Unknown
+}
+
+// Creates a version 0 PSBT for an unsigned transaction
+func New(tx *blockchainparser.Transaction) (*Psbt, error) {
+	for _, in := range tx.Vin {
+		if len(in.Script) > 0 || len(in.ScriptWitness) > 0 {
+			return nil, errors.New("Unsigned tx does not have empty scriptSigs and scriptWitnesses")
+		}
+	}
+
+	return &Psbt{
+		UnsignedTx: tx,
+		Inputs:     make([]Input, len(tx.Vin)),
+		Outputs:    make([]Output, len(tx.Vout)),
+	}, nil
+}
+
+type reader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *reader) readBytes(length uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if length > uint64(len(r.b)-r.pos) {
+		r.err = blockchainparser.ErrUnexpectedEnd
+		return nil
+	}
+	val := make([]byte, length)
+	copy(val, r.b[r.pos:r.pos+int(length)])
+	r.pos += int(length)
+	return val
+}
+
+func (r *reader) readVarint() uint64 {
+	val := r.readBytes(1)
+	if val == nil {
+		return 0
+	}
+	switch val[0] {
+	case 0xFF:
+		if b := r.readBytes(8); b != nil {
+			return binary.LittleEndian.Uint64(b)
+		}
+	case 0xFE:
+		if b := r.readBytes(4); b != nil {
+			return uint64(binary.LittleEndian.Uint32(b))
+		}
+	case 0xFD:
+		if b := r.readBytes(2); b != nil {
+			return uint64(binary.LittleEndian.Uint16(b))
+		}
+	default:
+		return uint64(val[0])
+	}
+
+	return 0
+}
+
+func (r *reader) done() bool {
+	return r.err == nil && r.pos == len(r.b)
+}
+
+// Reads a key-value pair; a nil key is the map separator
+func (r *reader) readPair() ([]byte, []byte) {
+	key := r.readBytes(r.readVarint())
+	if r.err != nil || len(key) == 0 {
+		return nil, nil
+	}
+	value := r.readBytes(r.readVarint())
+	return key, value
+}
+
+// Reads the pairs of a map up to its separator
+func (r *reader) readPairs() ([]Unknown, error) {
+	var pairs []Unknown
+	seen := map[string]bool{}
+	for {
+		key, value := r.readPair()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if key == nil {
+			return pairs, nil
+		}
+		if seen[string(key)] {
+			return nil, ErrDuplicateKey
+		}
+		seen[string(key)] = true
+		pairs = append(pairs, Unknown{key, value})
+	}
+}
+
+func parsePairs(pairs []Unknown, parse func(keyType byte, key []byte, value []byte) error) error {
+	for _, pair := range pairs {
+		if err := parse(pair.Key[0], pair.Key, pair.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parses a map, calling parse for each pair
+func (r *reader) readMap(parse func(keyType byte, key []byte, value []byte) error) error {
+	pairs, err := r.readPairs()
+	if err != nil {
+		return err
+	}
+	return parsePairs(pairs, parse)
+}
+
+// Version of a PSBT from its global pairs
+func pairsVersion(pairs []Unknown) (uint32, error) {
+	for _, pair := range pairs {
+		if pair.Key[0] != PSBT_GLOBAL_VERSION {
+			continue
+		}
+		if err := keyWithoutData(pair.Key); err != nil {
+			return 0, err
+		}
+		version, err := uint32Value(pair.Value)
+		if err != nil {
+			return 0, err
+		}
+		if *version > PSBT_HIGHEST_VERSION || *version == 1 {
+			return 0, fmt.Errorf("Unsupported PSBT version %d", *version)
+		}
+		return *version, nil
+	}
+	return 0, nil
+}
+
+func keyWithoutData(key []byte) error {
+	if len(key) != 1 {
+		return fmt.Errorf("Key type 0x%02x must not have key data", key[0])
+	}
+	return nil
+}
+
+func uint32Value(value []byte) (*uint32, error) {
+	if len(value) != 4 {
+		return nil, errors.New("Invalid size for a 32 bits value")
+	}
+	val := binary.LittleEndian.Uint32(value)
+	return &val, nil
+}
+
+func parseKeyOrigin(value []byte) (KeyOrigin, error) {
+	origin := KeyOrigin{}
+	if len(value) < 4 || len(value)%4 != 0 {
+		return origin, errors.New("Invalid length for HD key path")
+	}
+	copy(origin.Fingerprint[:], value[:4])
+	for i := 4; i < len(value); i += 4 {
+		origin.Path = append(origin.Path, binary.LittleEndian.Uint32(value[i:]))
+	}
+	return origin, nil
+}
+
+func (origin KeyOrigin) binary() []byte {
+	bin := append([]byte{}, origin.Fingerprint[:]...)
+	for _, index := range origin.Path {
+		bin = binary.LittleEndian.AppendUint32(bin, index)
+	}
+	return bin
+}
+
+func parseBip32Derivation(key []byte) (Bip32Derivation, error) {
+	pubKey := key[1:]
+	if len(pubKey) != blockchainparser.COMPRESSED_PUBKEY_SIZE && len(pubKey) != blockchainparser.PUBKEY_SIZE {
+		return Bip32Derivation{}, errors.New("Invalid pubkey size for HD key path")
+	}
+	return Bip32Derivation{PubKey: pubKey}, nil
+}
+
+func parseTapBip32Derivation(key []byte, value []byte) (TapBip32Derivation, error) {
+	derivation := TapBip32Derivation{XOnlyPubKey: key[1:]}
+	if len(derivation.XOnlyPubKey) != 32 {
+		return derivation, errors.New("Invalid x-only pubkey size for taproot HD key path")
+	}
+
+	r := &reader{b: value}
+	count := r.readVarint()
+	if r.err == nil && count > uint64(len(value))/32 {
+		return derivation, blockchainparser.ErrUnexpectedEnd
+	}
+	for i := uint64(0); i < count; i++ {
+		derivation.LeafHashes = append(derivation.LeafHashes, r.readBytes(32))
+	}
+	if r.err != nil {
+		return derivation, r.err
+	}
+
+	origin, err := parseKeyOrigin(value[r.pos:])
+	derivation.KeyOrigin = origin
+	return derivation, err
+}
+
+func parseWitnessStack(value []byte) ([][]byte, error) {
+	r := &reader{b: value}
+	count := r.readVarint()
+	if r.err == nil && count > uint64(len(value)) {
+		return nil, blockchainparser.ErrUnexpectedEnd
+	}
+	stack := make([][]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		stack = append(stack, r.readBytes(r.readVarint()))
+	}
+	if !r.done() {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, errors.New("Unexpected data after witness stack")
+	}
+	return stack, nil
+}
+
+func parseTxOutput(value []byte) (*blockchainparser.TxOutput, error) {
+	r := &reader{b: value}
+	amount := r.readBytes(8)
+	script := r.readBytes(r.readVarint())
+	if !r.done() {
+		return nil, errors.New("Invalid serialized output")
+	}
+	return &blockchainparser.TxOutput{
+		Value:  blockchainparser.Amount(binary.LittleEndian.Uint64(amount)),
+		Script: script,
+	}, nil
+}
+
+func parsePreimage(key []byte, value []byte, hashFunc func([]byte) []byte) (Preimage, error) {
+	hash := key[1:]
+	if !bytes.Equal(hashFunc(value), hash) {
+		return Preimage{}, errors.New("Provided hash preimage does not match hash")
+	}
+	return Preimage{Hash: hash, Preimage: value}, nil
+}
+
+func ripemd160Hash(data []byte) []byte {
+	hash := ripemd160.New()
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+func sha256Hash(data []byte) []byte {
+	return blockchainparser.Sha256(data)
+}
+
+func hash256(data []byte) []byte {
+	return blockchainparser.DoubleSha256(data)
+}
+
+// Parses a serialized PSBT of version 0 or 2
+func Parse(b []byte) (*Psbt, error) {
+	if !bytes.HasPrefix(b, psbtMagic) {
+		return nil, ErrInvalidMagic
+	}
+	r := &reader{b: b, pos: len(psbtMagic)}
+
+	pairs, err := r.readPairs()
+	if err != nil {
+		return nil, err
+	}
+	version, err := pairsVersion(pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	psbt := &Psbt{Version: version}
+	var inputCount, outputCount *uint64
+	hasTxVersion := false
+	err = parsePairs(pairs, func(keyType byte, key []byte, value []byte) error {
+		// BIP370 excludes the PSBTv2 fields from version 0; keys of the same
+		// types with key data are unknown fields, as in the BIP174 vectors
+		if version == 0 && keyType >= PSBT_GLOBAL_TX_VERSION && keyType <= PSBT_GLOBAL_TX_MODIFIABLE {
+			if len(key) == 1 {
+				return ErrV2Field
+			}
+			keyType = PSBT_GLOBAL_PROPRIETARY
+		}
+
+		var err error
+		switch keyType {
+		case PSBT_GLOBAL_UNSIGNED_TX:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			psbt.UnsignedTx, err = blockchainparser.ParseTransactionFromBytesNoWitness(value)
+			if err != nil {
+				return err
+			}
+			for _, in := range psbt.UnsignedTx.Vin {
+				if len(in.Script) > 0 {
+					return errors.New("Unsigned tx does not have empty scriptSigs and scriptWitnesses")
+				}
+			}
+		case PSBT_GLOBAL_XPUB:
+			if len(key) != 1+BIP32_EXTKEY_WITH_VERSION_SIZE {
+				return errors.New("Invalid size for global xpub key")
+			}
+			origin, err := parseKeyOrigin(value)
+			if err != nil {
+				return err
+			}
+			psbt.Xpubs = append(psbt.Xpubs, Bip32Derivation{PubKey: key[1:], KeyOrigin: origin})
+		case PSBT_GLOBAL_TX_VERSION:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			txVersion, err := uint32Value(value)
+			if err != nil {
+				return err
+			}
+			psbt.TxVersion = int32(*txVersion)
+			hasTxVersion = true
+		case PSBT_GLOBAL_FALLBACK_LOCKTIME:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			psbt.FallbackLocktime, err = uint32Value(value)
+		case PSBT_GLOBAL_INPUT_COUNT, PSBT_GLOBAL_OUTPUT_COUNT:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			vr := &reader{b: value}
+			count := vr.readVarint()
+			if !vr.done() {
+				return errors.New("Invalid input or output count")
+			}
+			if keyType == PSBT_GLOBAL_INPUT_COUNT {
+				inputCount = &count
+			} else {
+				outputCount = &count
+			}
+		case PSBT_GLOBAL_TX_MODIFIABLE:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			if len(value) != 1 {
+				return errors.New("Invalid size for tx modifiable flags")
+			}
+			psbt.TxModifiable = &value[0]
+		case PSBT_GLOBAL_VERSION:
+			// Already parsed by pairsVersion
+		default:
+			psbt.Unknowns = append(psbt.Unknowns, Unknown{key, value})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Fields required or forbidden by the version
+	var nInputs, nOutputs uint64
+	if psbt.Version == 0 {
+		if psbt.UnsignedTx == nil {
+			return nil, errors.New("No unsigned transaction was provided")
+		}
+		nInputs, nOutputs = uint64(len(psbt.UnsignedTx.Vin)), uint64(len(psbt.UnsignedTx.Vout))
+	} else {
+		if psbt.UnsignedTx != nil {
+			return nil, errors.New("PSBTv2 must not have an unsigned transaction")
+		}
+		if !hasTxVersion || inputCount == nil || outputCount == nil {
+			return nil, errors.New("PSBTv2 is missing required global fields")
+		}
+		nInputs, nOutputs = *inputCount, *outputCount
+	}
+	// Each map takes at least its separator byte
+	if nInputs+nOutputs > uint64(len(b)-r.pos) {
+		return nil, blockchainparser.ErrUnexpectedEnd
+	}
+
+	for i := uint64(0); i < nInputs; i++ {
+		input, err := r.readInput(psbt.Version)
+		if err != nil {
+			return nil, fmt.Errorf("Input %d: %v", i, err)
+		}
+		psbt.Inputs = append(psbt.Inputs, *input)
+	}
+	for i := uint64(0); i < nOutputs; i++ {
+		output, err := r.readOutput(psbt.Version)
+		if err != nil {
+			return nil, fmt.Errorf("Output %d: %v", i, err)
+		}
+		psbt.Outputs = append(psbt.Outputs, *output)
+	}
+	if !r.done() {
+		return nil, errors.New("Unexpected data after PSBT")
+	}
+
+	for i := range psbt.Inputs {
+		if err := psbt.checkUtxo(i); err != nil {
+			return nil, fmt.Errorf("Input %d: %v", i, err)
+		}
+	}
+
+	return psbt, nil
+}
+
+// Parses a base64 encoded PSBT, as used by bitcoind's RPC
+func ParseBase64(s string) (*Psbt, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+func (r *reader) readInput(version uint32) (*Input, error) {
+	input := &Input{}
+	err := r.readMap(func(keyType byte, key []byte, value []byte) error {
+		if version == 0 && keyType >= PSBT_IN_PREVIOUS_TXID && keyType <= PSBT_IN_REQUIRED_HEIGHT_LOCKTIME {
+			if len(key) == 1 {
+				return ErrV2Field
+			}
+			keyType = PSBT_IN_PROPRIETARY
+		}
+
+		var err error
+		switch keyType {
+		case PSBT_IN_NON_WITNESS_UTXO:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			input.NonWitnessUtxo, err = blockchainparser.ParseTransactionFromBytes(value)
+		case PSBT_IN_WITNESS_UTXO:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			input.WitnessUtxo, err = parseTxOutput(value)
+		case PSBT_IN_PARTIAL_SIG:
+			pubKey := key[1:]
+			if len(pubKey) != blockchainparser.COMPRESSED_PUBKEY_SIZE && len(pubKey) != blockchainparser.PUBKEY_SIZE {
+				return errors.New("Invalid pubkey size for partial signature")
+			}
+			input.PartialSigs = append(input.PartialSigs, PartialSig{pubKey, value})
+		case PSBT_IN_SIGHASH_TYPE:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			input.SighashType, err = uint32Value(value)
+		case PSBT_IN_REDEEM_SCRIPT:
+			err = keyWithoutData(key)
+			input.RedeemScript = value
+		case PSBT_IN_WITNESS_SCRIPT:
+			err = keyWithoutData(key)
+			input.WitnessScript = value
+		case PSBT_IN_BIP32_DERIVATION:
+			derivation, err := parseBip32Derivation(key)
+			if err != nil {
+				return err
+			}
+			derivation.KeyOrigin, err = parseKeyOrigin(value)
+			input.Bip32Derivations = append(input.Bip32Derivations, derivation)
+			return err
+		case PSBT_IN_FINAL_SCRIPTSIG:
+			err = keyWithoutData(key)
+			input.FinalScriptSig = value
+		case PSBT_IN_FINAL_SCRIPTWITNESS:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			input.FinalScriptWitness, err = parseWitnessStack(value)
+		case PSBT_IN_RIPEMD160, PSBT_IN_SHA256, PSBT_IN_HASH160, PSBT_IN_HASH256:
+			hashSize := 32
+			if keyType == PSBT_IN_RIPEMD160 || keyType == PSBT_IN_HASH160 {
+				hashSize = 20
+			}
+			if len(key) != 1+hashSize {
+				return errors.New("Invalid size for hash preimage key")
+			}
+			var preimage Preimage
+			switch keyType {
+			case PSBT_IN_RIPEMD160:
+				preimage, err = parsePreimage(key, value, ripemd160Hash)
+				input.Ripemd160Preimages = append(input.Ripemd160Preimages, preimage)
+			case PSBT_IN_SHA256:
+				preimage, err = parsePreimage(key, value, sha256Hash)
+				input.Sha256Preimages = append(input.Sha256Preimages, preimage)
+			case PSBT_IN_HASH160:
+				preimage, err = parsePreimage(key, value, blockchainparser.Hash160)
+				input.Hash160Preimages = append(input.Hash160Preimages, preimage)
+			case PSBT_IN_HASH256:
+				preimage, err = parsePreimage(key, value, hash256)
+				input.Hash256Preimages = append(input.Hash256Preimages, preimage)
+			}
+		case PSBT_IN_PREVIOUS_TXID:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			if len(value) != 32 {
+				return errors.New("Invalid size for previous txid")
+			}
+			input.PreviousTxid = value
+		case PSBT_IN_OUTPUT_INDEX:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			input.OutputIndex, err = uint32Value(value)
+		case PSBT_IN_SEQUENCE:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			input.Sequence, err = uint32Value(value)
+		case PSBT_IN_REQUIRED_TIME_LOCKTIME:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			input.RequiredTimeLocktime, err = uint32Value(value)
+			if err == nil && *input.RequiredTimeLocktime < blockchainparser.LOCKTIME_THRESHOLD {
+				return errors.New("Required time locktime is below the locktime threshold")
+			}
+		case PSBT_IN_REQUIRED_HEIGHT_LOCKTIME:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			input.RequiredHeightLocktime, err = uint32Value(value)
+			if err == nil && (*input.RequiredHeightLocktime == 0 || *input.RequiredHeightLocktime >= blockchainparser.LOCKTIME_THRESHOLD) {
+				return errors.New("Required height locktime is not a valid height")
+			}
+		case PSBT_IN_TAP_KEY_SIG:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			if len(value) != 64 && len(value) != 65 {
+				return errors.New("Invalid size for taproot key signature")
+			}
+			input.TapKeySig = value
+		case PSBT_IN_TAP_SCRIPT_SIG:
+			if len(key) != 65 {
+				return errors.New("Invalid size for taproot script signature key")
+			}
+			if len(value) != 64 && len(value) != 65 {
+				return errors.New("Invalid size for taproot script signature")
+			}
+			input.TapScriptSigs = append(input.TapScriptSigs, TapScriptSig{key[1:33], key[33:], value})
+		case PSBT_IN_TAP_LEAF_SCRIPT:
+			control := key[1:]
+			if len(control) < blockchainparser.TAPROOT_CONTROL_BASE_SIZE || len(control) > blockchainparser.TAPROOT_CONTROL_MAX_SIZE ||
+				(len(control)-blockchainparser.TAPROOT_CONTROL_BASE_SIZE)%blockchainparser.TAPROOT_CONTROL_NODE_SIZE != 0 {
+				return errors.New("Invalid size for taproot control block")
+			}
+			if len(value) < 1 {
+				return errors.New("Taproot leaf script is missing its leaf version")
+			}
+			input.TapLeafScripts = append(input.TapLeafScripts, TapLeafScript{control, value[:len(value)-1], value[len(value)-1]})
+		case PSBT_IN_TAP_BIP32_DERIVATION:
+			derivation, err := parseTapBip32Derivation(key, value)
+			if err != nil {
+				return err
+			}
+			input.TapBip32Derivations = append(input.TapBip32Derivations, derivation)
+		case PSBT_IN_TAP_INTERNAL_KEY:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			if len(value) != 32 {
+				return errors.New("Invalid size for taproot internal key")
+			}
+			input.TapInternalKey = value
+		case PSBT_IN_TAP_MERKLE_ROOT:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			if len(value) != 32 {
+				return errors.New("Invalid size for taproot merkle root")
+			}
+			input.TapMerkleRoot = value
+		default:
+			input.Unknowns = append(input.Unknowns, Unknown{key, value})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if version >= 2 && (input.PreviousTxid == nil || input.OutputIndex == nil) {
+		return nil, errors.New("PSBTv2 input is missing the previous txid or output index")
+	}
+
+	return input, nil
+}
+
+func (r *reader) readOutput(version uint32) (*Output, error) {
+	output := &Output{}
+	err := r.readMap(func(keyType byte, key []byte, value []byte) error {
+		if version == 0 && (keyType == PSBT_OUT_AMOUNT || keyType == PSBT_OUT_SCRIPT) {
+			if len(key) == 1 {
+				return ErrV2Field
+			}
+			keyType = PSBT_OUT_PROPRIETARY
+		}
+
+		var err error
+		switch keyType {
+		case PSBT_OUT_REDEEM_SCRIPT:
+			err = keyWithoutData(key)
+			output.RedeemScript = value
+		case PSBT_OUT_WITNESS_SCRIPT:
+			err = keyWithoutData(key)
+			output.WitnessScript = value
+		case PSBT_OUT_BIP32_DERIVATION:
+			derivation, err := parseBip32Derivation(key)
+			if err != nil {
+				return err
+			}
+			derivation.KeyOrigin, err = parseKeyOrigin(value)
+			output.Bip32Derivations = append(output.Bip32Derivations, derivation)
+			return err
+		case PSBT_OUT_AMOUNT:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			if len(value) != 8 {
+				return errors.New("Invalid size for output amount")
+			}
+			amount := blockchainparser.Amount(binary.LittleEndian.Uint64(value))
+			output.Amount = &amount
+		case PSBT_OUT_SCRIPT:
+			err = keyWithoutData(key)
+			output.Script = value
+		case PSBT_OUT_TAP_INTERNAL_KEY:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			if len(value) != 32 {
+				return errors.New("Invalid size for taproot internal key")
+			}
+			output.TapInternalKey = value
+		case PSBT_OUT_TAP_TREE:
+			if err = keyWithoutData(key); err != nil {
+				return err
+			}
+			vr := &reader{b: value}
+			for vr.err == nil && vr.pos < len(value) {
+				header := vr.readBytes(2)
+				script := vr.readBytes(vr.readVarint())
+				if vr.err == nil {
+					output.TapTree = append(output.TapTree, TapLeaf{header[0], header[1], script})
+				}
+			}
+			if vr.err != nil || len(output.TapTree) == 0 {
+				return errors.New("Invalid taproot tree")
+			}
+		case PSBT_OUT_TAP_BIP32_DERIVATION:
+			derivation, err := parseTapBip32Derivation(key, value)
+			if err != nil {
+				return err
+			}
+			output.TapBip32Derivations = append(output.TapBip32Derivations, derivation)
+		default:
+			output.Unknowns = append(output.Unknowns, Unknown{key, value})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if version >= 2 && (output.Amount == nil || output.Script == nil) {
+		return nil, errors.New("PSBTv2 output is missing its amount or script")
+	}
+
+	return output, nil
+}
+
+// Outpoint spent by input i
+func (psbt *Psbt) Outpoint(i int) (blockchainparser.Hash256, uint32) {
+	if psbt.Version == 0 {
+		in := psbt.UnsignedTx.Vin[i]
+		return in.Hash, in.Index
+	}
+
+	return psbt.Inputs[i].PreviousTxid, *psbt.Inputs[i].OutputIndex
+}
+
+func (psbt *Psbt) checkUtxo(i int) error {
+	input := psbt.Inputs[i]
+	if input.NonWitnessUtxo == nil {
+		return nil
+	}
+
+	hash, index := psbt.Outpoint(i)
+	if !bytes.Equal(input.NonWitnessUtxo.Txid(), hash) || int(index) >= len(input.NonWitnessUtxo.Vout) {
+		return ErrUtxoMismatch
+	}
+
+	return nil
+}
+
+// Returns the output spent by input i, from its witness or non-witness UTXO
+func (psbt *Psbt) Utxo(i int) (*blockchainparser.TxOutput, error) {
+	input := psbt.Inputs[i]
+	if input.WitnessUtxo != nil {
+		return input.WitnessUtxo, nil
+	}
+	if input.NonWitnessUtxo != nil {
+		_, index := psbt.Outpoint(i)
+		if int(index) >= len(input.NonWitnessUtxo.Vout) {
+			return nil, ErrUtxoMismatch
+		}
+		return &input.NonWitnessUtxo.Vout[index], nil
+	}
+
+	return nil, ErrMissingUtxo
+}
+
+// Fee paid by the transaction; every input needs its UTXO
+func (psbt *Psbt) Fee() (blockchainparser.Amount, error) {
+	tx, err := psbt.UnsignedTransaction()
+	if err != nil {
+		return 0, err
+	}
+
+	var in blockchainparser.Amount
+	for i := range psbt.Inputs {
+		utxo, err := psbt.Utxo(i)
+		if err != nil {
+			return 0, err
+		}
+		in += utxo.Value
+	}
+
+	return in - tx.ValueOut(), nil
+}
+
+// Locktime of a PSBTv2 transaction, as defined by BIP370: the maximum
+// required locktime of the inputs, preferring heights, else the fallback
+func (psbt *Psbt) locktime() (uint32, error) {
+	hasTime, hasHeight, onlyHeight, onlyTime := false, false, true, true
+	var maxTime, maxHeight uint32
+	for _, input := range psbt.Inputs {
+		if input.RequiredTimeLocktime != nil {
+			hasTime = true
+			if *input.RequiredTimeLocktime > maxTime {
+				maxTime = *input.RequiredTimeLocktime
+			}
+		}
+		if input.RequiredHeightLocktime != nil {
+			hasHeight = true
+			if *input.RequiredHeightLocktime > maxHeight {
+				maxHeight = *input.RequiredHeightLocktime
+			}
+		}
+		if input.RequiredTimeLocktime != nil && input.RequiredHeightLocktime == nil {
+			onlyHeight = false
+		}
+		if input.RequiredHeightLocktime != nil && input.RequiredTimeLocktime == nil {
+			onlyTime = false
+		}
+	}
+
+	switch {
+	case hasHeight && onlyHeight:
+		return maxHeight, nil
+	case hasTime && onlyTime:
+		return maxTime, nil
+	case hasTime || hasHeight:
+		return 0, errors.New("PSBT inputs have incompatible locktime requirements")
+	case psbt.FallbackLocktime != nil:
+		return *psbt.FallbackLocktime, nil
+	}
+
+	return 0, nil
+}
+
+// Returns the transaction without any signature data. For version 0 this is
+// a copy of UnsignedTx; for version 2 it is built from the inputs and outputs.
+func (psbt *Psbt) UnsignedTransaction() (*blockchainparser.Transaction, error) {
+	tx := &blockchainparser.Transaction{}
+	if psbt.Version == 0 {
+		tx.Version = psbt.UnsignedTx.Version
+		tx.Locktime = psbt.UnsignedTx.Locktime
+		tx.Vin = append(tx.Vin, psbt.UnsignedTx.Vin...)
+		tx.Vout = append(tx.Vout, psbt.UnsignedTx.Vout...)
+		return tx, nil
+	}
+
+	locktime, err := psbt.locktime()
+	if err != nil {
+		return nil, err
+	}
+	tx.Version = psbt.TxVersion
+	tx.Locktime = locktime
+	for i, input := range psbt.Inputs {
+		hash, index := psbt.Outpoint(i)
+		in := blockchainparser.TxInput{Hash: hash, Index: index, Sequence: blockchainparser.SEQUENCE_FINAL}
+		if input.Sequence != nil {
+			in.Sequence = *input.Sequence
+		}
+		tx.Vin = append(tx.Vin, in)
+	}
+	for _, output := range psbt.Outputs {
+		tx.Vout = append(tx.Vout, blockchainparser.TxOutput{Value: *output.Amount, Script: output.Script})
+	}
+
+	return tx, nil
+}
+
+type writer struct {
+	bytes.Buffer
+}
+
+func (w *writer) writePair(key []byte, value []byte) {
+	w.Write(blockchainparser.Varint(uint64(len(key))))
+	w.Write(key)
+	w.Write(blockchainparser.Varint(uint64(len(value))))
+	w.Write(value)
+}
+
+func (w *writer) writeKey(keyType byte, value []byte) {
+	w.writePair([]byte{keyType}, value)
+}
+
+func (w *writer) writeUint32(keyType byte, value uint32) {
+	w.writeKey(keyType, binary.LittleEndian.AppendUint32(nil, value))
+}
+
+func (w *writer) writeSeparator() {
+	w.WriteByte(0)
+}
+
+func (w *writer) writeBip32Derivations(keyType byte, derivations []Bip32Derivation) {
+	sorted := append([]Bip32Derivation{}, derivations...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].PubKey, sorted[j].PubKey) < 0 })
+	for _, derivation := range sorted {
+		w.writePair(append([]byte{keyType}, derivation.PubKey...), derivation.binary())
+	}
+}
+
+func (w *writer) writeTapBip32Derivations(keyType byte, derivations []TapBip32Derivation) {
+	sorted := append([]TapBip32Derivation{}, derivations...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].XOnlyPubKey, sorted[j].XOnlyPubKey) < 0 })
+	for _, derivation := range sorted {
+		value := blockchainparser.Varint(uint64(len(derivation.LeafHashes)))
+		for _, leafHash := range derivation.LeafHashes {
+			value = append(value, leafHash...)
+		}
+		w.writePair(append([]byte{keyType}, derivation.XOnlyPubKey...), append(value, derivation.binary()...))
+	}
+}
+
+func (w *writer) writePreimages(keyType byte, preimages []Preimage) {
+	sorted := append([]Preimage{}, preimages...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].Hash, sorted[j].Hash) < 0 })
+	for _, preimage := range sorted {
+		w.writePair(append([]byte{keyType}, preimage.Hash...), preimage.Preimage)
+	}
+}
+
+func (w *writer) writeUnknowns(unknowns []Unknown) {
+	sorted := append([]Unknown{}, unknowns...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].Key, sorted[j].Key) < 0 })
+	for _, unknown := range sorted {
+		w.writePair(unknown.Key, unknown.Value)
+	}
+}
+
+// Serializes the PSBT. Fields are written in key order, as bitcoind does,
+// except that final scripts come after the other input fields.
+func (psbt *Psbt) Serialize() []byte {
+	w := &writer{}
+	w.Write(psbtMagic)
+
+	if psbt.Version == 0 {
+		w.writeKey(PSBT_GLOBAL_UNSIGNED_TX, psbt.UnsignedTx.BinaryNoWitness())
+	}
+	w.writeBip32Derivations(PSBT_GLOBAL_XPUB, psbt.Xpubs)
+	if psbt.Version >= 2 {
+		w.writeUint32(PSBT_GLOBAL_TX_VERSION, uint32(psbt.TxVersion))
+		if psbt.FallbackLocktime != nil {
+			w.writeUint32(PSBT_GLOBAL_FALLBACK_LOCKTIME, *psbt.FallbackLocktime)
+		}
+		w.writeKey(PSBT_GLOBAL_INPUT_COUNT, blockchainparser.Varint(uint64(len(psbt.Inputs))))
+		w.writeKey(PSBT_GLOBAL_OUTPUT_COUNT, blockchainparser.Varint(uint64(len(psbt.Outputs))))
+		if psbt.TxModifiable != nil {
+			w.writeKey(PSBT_GLOBAL_TX_MODIFIABLE, []byte{*psbt.TxModifiable})
+		}
+	}
+	if psbt.Version > 0 {
+		w.writeUint32(PSBT_GLOBAL_VERSION, psbt.Version)
+	}
+	w.writeUnknowns(psbt.Unknowns)
+	w.writeSeparator()
+
+	for _, input := range psbt.Inputs {
+		input.serialize(w)
+	}
+	for _, output := range psbt.Outputs {
+		output.serialize(w)
+	}
+
+	return w.Bytes()
+}
+
+// Base64 encoding of the serialized PSBT, as used by bitcoind's RPC
+func (psbt *Psbt) Base64() string {
+	return base64.StdEncoding.EncodeToString(psbt.Serialize())
+}
+
+func (input *Input) serialize(w *writer) {
+	if input.NonWitnessUtxo != nil {
+		w.writeKey(PSBT_IN_NON_WITNESS_UTXO, input.NonWitnessUtxo.Binary())
+	}
+	if input.WitnessUtxo != nil {
+		w.writeKey(PSBT_IN_WITNESS_UTXO, input.WitnessUtxo.Binary())
+	}
+
+	// bitcoind orders partial signatures by the hash160 of their pubkey
+	partialSigs := append([]PartialSig{}, input.PartialSigs...)
+	sort.Slice(partialSigs, func(i, j int) bool {
+		return bytes.Compare(blockchainparser.Hash160(partialSigs[i].PubKey), blockchainparser.Hash160(partialSigs[j].PubKey)) < 0
+	})
+	for _, partialSig := range partialSigs {
+		w.writePair(append([]byte{PSBT_IN_PARTIAL_SIG}, partialSig.PubKey...), partialSig.Signature)
+	}
+	if input.SighashType != nil {
+		w.writeUint32(PSBT_IN_SIGHASH_TYPE, *input.SighashType)
+	}
+	if input.RedeemScript != nil {
+		w.writeKey(PSBT_IN_REDEEM_SCRIPT, input.RedeemScript)
+	}
+	if input.WitnessScript != nil {
+		w.writeKey(PSBT_IN_WITNESS_SCRIPT, input.WitnessScript)
+	}
+	w.writeBip32Derivations(PSBT_IN_BIP32_DERIVATION, input.Bip32Derivations)
+	w.writePreimages(PSBT_IN_RIPEMD160, input.Ripemd160Preimages)
+	w.writePreimages(PSBT_IN_SHA256, input.Sha256Preimages)
+	w.writePreimages(PSBT_IN_HASH160, input.Hash160Preimages)
+	w.writePreimages(PSBT_IN_HASH256, input.Hash256Preimages)
+
+	if input.PreviousTxid != nil {
+		w.writeKey(PSBT_IN_PREVIOUS_TXID, input.PreviousTxid)
+	}
+	if input.OutputIndex != nil {
+		w.writeUint32(PSBT_IN_OUTPUT_INDEX, *input.OutputIndex)
+	}
+	if input.Sequence != nil {
+		w.writeUint32(PSBT_IN_SEQUENCE, *input.Sequence)
+	}
+	if input.RequiredTimeLocktime != nil {
+		w.writeUint32(PSBT_IN_REQUIRED_TIME_LOCKTIME, *input.RequiredTimeLocktime)
+	}
+	if input.RequiredHeightLocktime != nil {
+		w.writeUint32(PSBT_IN_REQUIRED_HEIGHT_LOCKTIME, *input.RequiredHeightLocktime)
+	}
+
+	if input.TapKeySig != nil {
+		w.writeKey(PSBT_IN_TAP_KEY_SIG, input.TapKeySig)
+	}
+	tapScriptSigs := append([]TapScriptSig{}, input.TapScriptSigs...)
+	sort.Slice(tapScriptSigs, func(i, j int) bool {
+		ki := append(append([]byte{}, tapScriptSigs[i].XOnlyPubKey...), tapScriptSigs[i].LeafHash...)
+		kj := append(append([]byte{}, tapScriptSigs[j].XOnlyPubKey...), tapScriptSigs[j].LeafHash...)
+		return bytes.Compare(ki, kj) < 0
+	})
+	for _, sig := range tapScriptSigs {
+		key := append(append([]byte{PSBT_IN_TAP_SCRIPT_SIG}, sig.XOnlyPubKey...), sig.LeafHash...)
+		w.writePair(key, sig.Signature)
+	}
+	leafScripts := append([]TapLeafScript{}, input.TapLeafScripts...)
+	sort.Slice(leafScripts, func(i, j int) bool {
+		return bytes.Compare(leafScripts[i].ControlBlock, leafScripts[j].ControlBlock) < 0
+	})
+	for _, leafScript := range leafScripts {
+		value := append(append([]byte{}, leafScript.Script...), leafScript.LeafVersion)
+		w.writePair(append([]byte{PSBT_IN_TAP_LEAF_SCRIPT}, leafScript.ControlBlock...), value)
+	}
+	w.writeTapBip32Derivations(PSBT_IN_TAP_BIP32_DERIVATION, input.TapBip32Derivations)
+	if input.TapInternalKey != nil {
+		w.writeKey(PSBT_IN_TAP_INTERNAL_KEY, input.TapInternalKey)
+	}
+	if input.TapMerkleRoot != nil {
+		w.writeKey(PSBT_IN_TAP_MERKLE_ROOT, input.TapMerkleRoot)
+	}
+
+	if input.FinalScriptSig != nil {
+		w.writeKey(PSBT_IN_FINAL_SCRIPTSIG, input.FinalScriptSig)
+	}
+	if input.FinalScriptWitness != nil {
+		in := blockchainparser.TxInput{ScriptWitness: input.FinalScriptWitness}
+		w.writeKey(PSBT_IN_FINAL_SCRIPTWITNESS, in.ScriptWitnessBinary())
+	}
+
+	w.writeUnknowns(input.Unknowns)
+	w.writeSeparator()
+}
+
+func (output *Output) serialize(w *writer) {
+	if output.RedeemScript != nil {
+		w.writeKey(PSBT_OUT_REDEEM_SCRIPT, output.RedeemScript)
+	}
+	if output.WitnessScript != nil {
+		w.writeKey(PSBT_OUT_WITNESS_SCRIPT, output.WitnessScript)
+	}
+	w.writeBip32Derivations(PSBT_OUT_BIP32_DERIVATION, output.Bip32Derivations)
+	if output.Amount != nil {
+		w.writeKey(PSBT_OUT_AMOUNT, binary.LittleEndian.AppendUint64(nil, uint64(*output.Amount)))
+	}
+	if output.Script != nil {
+		w.writeKey(PSBT_OUT_SCRIPT, output.Script)
+	}
+	if output.TapInternalKey != nil {
+		w.writeKey(PSBT_OUT_TAP_INTERNAL_KEY, output.TapInternalKey)
+	}
+	if len(output.TapTree) > 0 {
+		value := make([]byte, 0)
+		for _, leaf := range output.TapTree {
+			value = append(value, leaf.Depth, leaf.LeafVersion)
+			value = append(value, blockchainparser.Varint(uint64(len(leaf.Script)))...)
+			value = append(value, leaf.Script...)
+		}
+		w.writeKey(PSBT_OUT_TAP_TREE, value)
+	}
+	w.writeTapBip32Derivations(PSBT_OUT_TAP_BIP32_DERIVATION, output.TapBip32Derivations)
+
+	w.writeUnknowns(output.Unknowns)
+	w.writeSeparator()
+}
@@ -0,0 +1,361 @@
+package psbt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+// Test vectors of BIP174 and BIP371, base64 encoded as in bitcoind's
+// test/functional/data/rpc_psbt.json
+type vectorsForTest struct {
+	Invalid  [][2]string
+	Valid    []string
+	Combiner []struct {
+		Combine []string
+		Result  string
+	}
+	Finalizer []struct{ Finalize, Result string }
+	Extractor []struct{ Extract, Result string }
+	// Finalizable PSBTs without a finalized vector
+	Incomplete []string
+}
+
+func loadVectorsForTest(t *testing.T) *vectorsForTest {
+	data, err := os.ReadFile("testdata/bip174.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vectors := &vectorsForTest{}
+	if err := json.Unmarshal(data, vectors); err != nil {
+		t.Fatal(err)
+	}
+	return vectors
+}
+
+func parseForTest(t *testing.T, s string) *Psbt {
+	psbt, err := ParseBase64(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return psbt
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, test := range loadVectorsForTest(t).Invalid {
+		if _, err := ParseBase64(test[1]); err == nil {
+			t.Errorf("%s: accepted", test[0])
+		}
+	}
+}
+
+func TestParseValid(t *testing.T) {
+	for i, test := range loadVectorsForTest(t).Valid {
+		psbt, err := ParseBase64(test)
+		if err != nil {
+			t.Errorf("Vector %d: %s", i, err)
+			continue
+		}
+		if psbt.Base64() != test {
+			t.Errorf("Vector %d: serialized as %s", i, psbt.Base64())
+		}
+	}
+}
+
+func TestCombine(t *testing.T) {
+	for i, test := range loadVectorsForTest(t).Combiner {
+		var psbts []*Psbt
+		for _, s := range test.Combine {
+			psbts = append(psbts, parseForTest(t, s))
+		}
+		combined, err := Combine(psbts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if combined.Base64() != test.Result {
+			t.Errorf("Vector %d: combined as %s", i, combined.Base64())
+		}
+
+		// Combining in the other order gives the same PSBT
+		for l, r := 0, len(psbts)-1; l < r; l, r = l+1, r-1 {
+			psbts[l], psbts[r] = psbts[r], psbts[l]
+		}
+		combined, err = Combine(psbts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if combined.Base64() != test.Result {
+			t.Errorf("Vector %d reversed: combined as %s", i, combined.Base64())
+		}
+	}
+
+	vectors := loadVectorsForTest(t)
+	if _, err := Combine(parseForTest(t, vectors.Valid[0]), parseForTest(t, vectors.Valid[1])); err != ErrTxMismatch {
+		t.Errorf("Combining different transactions: %v", err)
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	vectors := loadVectorsForTest(t)
+	for i, test := range vectors.Finalizer {
+		psbt := parseForTest(t, test.Finalize)
+		if psbt.IsComplete() {
+			t.Errorf("Vector %d: complete before finalizing", i)
+		}
+		if _, err := psbt.Extract(); err != ErrNotFinalized {
+			t.Errorf("Vector %d: extracted before finalizing: %v", i, err)
+		}
+		if err := psbt.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+		if psbt.Base64() != test.Result {
+			t.Errorf("Vector %d: finalized as %s", i, psbt.Base64())
+		}
+	}
+
+	for i, test := range vectors.Incomplete {
+		psbt := parseForTest(t, test)
+		if psbt.IsComplete() {
+			t.Errorf("Incomplete %d: complete before finalizing", i)
+		}
+		if err := psbt.Finalize(); err != nil || !psbt.IsComplete() {
+			t.Errorf("Incomplete %d: not finalized: %v", i, err)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	for i, test := range loadVectorsForTest(t).Extractor {
+		psbt := parseForTest(t, test.Extract)
+		tx, err := psbt.Extract()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(tx.Binary()) != test.Result {
+			t.Errorf("Vector %d: extracted %x", i, tx.Binary())
+		}
+
+		var prevouts []blockchainparser.TxOutput
+		for i := range psbt.Inputs {
+			utxo, err := psbt.Utxo(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prevouts = append(prevouts, *utxo)
+		}
+		results, err := blockchainparser.VerifyTransactionScripts(tx, prevouts, blockchainparser.SCRIPT_VERIFY_CONSENSUS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, result := range results {
+			if !result.Valid {
+				t.Errorf("Vector %d input %d: %v", i, j, result.Err)
+			}
+		}
+	}
+}
+
+// The BIP371 vectors with a key path signature or script path signatures
+// finalize into transactions whose witnesses verify
+func TestFinalizeTaproot(t *testing.T) {
+	finalized := 0
+	for i, test := range loadVectorsForTest(t).Valid {
+		psbt := parseForTest(t, test)
+		if len(psbt.Inputs) == 0 || psbt.Inputs[0].TapKeySig == nil && psbt.Inputs[0].TapScriptSigs == nil {
+			continue
+		}
+		if err := psbt.FinalizeInput(0); err != nil {
+			t.Errorf("Vector %d: %s", i, err)
+			continue
+		}
+		tx, err := psbt.Extract()
+		if err != nil {
+			t.Fatal(err)
+		}
+		utxo, err := psbt.Utxo(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := blockchainparser.VerifyTransactionScripts(tx, []blockchainparser.TxOutput{*utxo}, blockchainparser.SCRIPT_VERIFY_CONSENSUS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !results[0].Valid {
+			t.Errorf("Vector %d: %v", i, results[0].Err)
+		}
+		finalized++
+	}
+	if finalized != 2 {
+		t.Errorf("Finalized %d taproot vectors", finalized)
+	}
+}
+
+// Serializes maps of raw pairs: the global map, then the input and output
+// maps, to build PSBTs Serialize can't
+func serializeForTest(maps ...[]Unknown) []byte {
+	w := &writer{}
+	w.Write(psbtMagic)
+	for _, pairs := range maps {
+		for _, pair := range pairs {
+			w.writePair(pair.Key, pair.Value)
+		}
+		w.writeSeparator()
+	}
+	return w.Bytes()
+}
+
+func uint32ForTest(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+func withoutKeyForTest(pairs []Unknown, keyType byte) []Unknown {
+	var result []Unknown
+	for _, pair := range pairs {
+		if pair.Key[0] != keyType {
+			result = append(result, pair)
+		}
+	}
+	return result
+}
+
+func withPairForTest(pairs []Unknown, keyType byte, value []byte) []Unknown {
+	return append(append([]Unknown{}, pairs...), Unknown{[]byte{keyType}, value})
+}
+
+// PSBTv2 cases of BIP370: the required and excluded fields of each version
+func TestParseV2(t *testing.T) {
+	globals := []Unknown{
+		{[]byte{PSBT_GLOBAL_TX_VERSION}, uint32ForTest(2)},
+		{[]byte{PSBT_GLOBAL_INPUT_COUNT}, []byte{1}},
+		{[]byte{PSBT_GLOBAL_OUTPUT_COUNT}, []byte{1}},
+		{[]byte{PSBT_GLOBAL_VERSION}, uint32ForTest(2)},
+	}
+	input := []Unknown{
+		{[]byte{PSBT_IN_PREVIOUS_TXID}, blockchainparser.DoubleSha256([]byte("prev"))},
+		{[]byte{PSBT_IN_OUTPUT_INDEX}, uint32ForTest(1)},
+	}
+	output := []Unknown{
+		{[]byte{PSBT_OUT_AMOUNT}, binary.LittleEndian.AppendUint64(nil, 1000)},
+		{[]byte{PSBT_OUT_SCRIPT}, append([]byte{blockchainparser.OP_0, 20}, blockchainparser.Hash160([]byte("out"))...)},
+	}
+
+	valid := [][]byte{
+		serializeForTest(globals, input, output),
+		serializeForTest(globals, withPairForTest(input, PSBT_IN_SEQUENCE, uint32ForTest(0xfffffffe)), output),
+		serializeForTest(globals, withPairForTest(input, PSBT_IN_REQUIRED_TIME_LOCKTIME, uint32ForTest(657182000)), output),
+		serializeForTest(globals, withPairForTest(input, PSBT_IN_REQUIRED_HEIGHT_LOCKTIME, uint32ForTest(10000)), output),
+		serializeForTest(append([]Unknown{globals[0], {[]byte{PSBT_GLOBAL_FALLBACK_LOCKTIME}, uint32ForTest(0)}}, globals[1:]...), input, output),
+		serializeForTest(append(append([]Unknown{}, globals[:3]...), Unknown{[]byte{PSBT_GLOBAL_TX_MODIFIABLE}, []byte{0x03}}, globals[3]), input, output),
+	}
+	for i, b := range valid {
+		psbt, err := Parse(b)
+		if err != nil {
+			t.Errorf("Valid %d: %s", i, err)
+			continue
+		}
+		if !bytes.Equal(psbt.Serialize(), b) {
+			t.Errorf("Valid %d: serialized as %x", i, psbt.Serialize())
+		}
+	}
+
+	unsignedTx := (&blockchainparser.Transaction{
+		Version: 2,
+		Vin:     []blockchainparser.TxInput{{Hash: blockchainparser.DoubleSha256([]byte("prev")), Index: 1, Sequence: blockchainparser.SEQUENCE_FINAL}},
+		Vout:    []blockchainparser.TxOutput{{Value: 1000, Script: output[1].Value}},
+	}).BinaryNoWitness()
+	v0 := []Unknown{{[]byte{PSBT_GLOBAL_UNSIGNED_TX}, unsignedTx}}
+	if _, err := Parse(serializeForTest(v0, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []struct {
+		name string
+		psbt []byte
+	}{
+		{"PSBTv0 with PSBT_GLOBAL_VERSION 2", serializeForTest(withPairForTest(v0, PSBT_GLOBAL_VERSION, uint32ForTest(2)), nil, nil)},
+		{"PSBTv0 with PSBT_GLOBAL_TX_VERSION", serializeForTest(withPairForTest(v0, PSBT_GLOBAL_TX_VERSION, uint32ForTest(2)), nil, nil)},
+		{"PSBTv0 with PSBT_GLOBAL_FALLBACK_LOCKTIME", serializeForTest(withPairForTest(v0, PSBT_GLOBAL_FALLBACK_LOCKTIME, uint32ForTest(0)), nil, nil)},
+		{"PSBTv0 with PSBT_GLOBAL_INPUT_COUNT", serializeForTest(withPairForTest(v0, PSBT_GLOBAL_INPUT_COUNT, []byte{1}), nil, nil)},
+		{"PSBTv0 with PSBT_GLOBAL_OUTPUT_COUNT", serializeForTest(withPairForTest(v0, PSBT_GLOBAL_OUTPUT_COUNT, []byte{1}), nil, nil)},
+		{"PSBTv0 with PSBT_GLOBAL_TX_MODIFIABLE", serializeForTest(withPairForTest(v0, PSBT_GLOBAL_TX_MODIFIABLE, []byte{0}), nil, nil)},
+		{"PSBTv0 with PSBT_IN_PREVIOUS_TXID", serializeForTest(v0, input[:1], nil)},
+		{"PSBTv0 with PSBT_IN_OUTPUT_INDEX", serializeForTest(v0, input[1:], nil)},
+		{"PSBTv0 with PSBT_IN_SEQUENCE", serializeForTest(v0, withPairForTest(nil, PSBT_IN_SEQUENCE, uint32ForTest(0)), nil)},
+		{"PSBTv0 with PSBT_IN_REQUIRED_TIME_LOCKTIME", serializeForTest(v0, withPairForTest(nil, PSBT_IN_REQUIRED_TIME_LOCKTIME, uint32ForTest(657182000)), nil)},
+		{"PSBTv0 with PSBT_IN_REQUIRED_HEIGHT_LOCKTIME", serializeForTest(v0, withPairForTest(nil, PSBT_IN_REQUIRED_HEIGHT_LOCKTIME, uint32ForTest(10000)), nil)},
+		{"PSBTv0 with PSBT_OUT_AMOUNT", serializeForTest(v0, nil, output[:1])},
+		{"PSBTv0 with PSBT_OUT_SCRIPT", serializeForTest(v0, nil, output[1:])},
+		{"PSBTv2 with PSBT_GLOBAL_UNSIGNED_TX", serializeForTest(append(append([]Unknown{}, v0...), globals...), input, output)},
+		{"PSBTv2 missing PSBT_GLOBAL_TX_VERSION", serializeForTest(withoutKeyForTest(globals, PSBT_GLOBAL_TX_VERSION), input, output)},
+		{"PSBTv2 missing PSBT_GLOBAL_INPUT_COUNT", serializeForTest(withoutKeyForTest(globals, PSBT_GLOBAL_INPUT_COUNT), input, output)},
+		{"PSBTv2 missing PSBT_GLOBAL_OUTPUT_COUNT", serializeForTest(withoutKeyForTest(globals, PSBT_GLOBAL_OUTPUT_COUNT), input, output)},
+		{"PSBTv2 missing PSBT_IN_PREVIOUS_TXID", serializeForTest(globals, withoutKeyForTest(input, PSBT_IN_PREVIOUS_TXID), output)},
+		{"PSBTv2 missing PSBT_IN_OUTPUT_INDEX", serializeForTest(globals, withoutKeyForTest(input, PSBT_IN_OUTPUT_INDEX), output)},
+		{"PSBTv2 missing PSBT_OUT_AMOUNT", serializeForTest(globals, input, withoutKeyForTest(output, PSBT_OUT_AMOUNT))},
+		{"PSBTv2 missing PSBT_OUT_SCRIPT", serializeForTest(globals, input, withoutKeyForTest(output, PSBT_OUT_SCRIPT))},
+		{"PSBTv2 with a time locktime below the threshold", serializeForTest(globals, withPairForTest(input, PSBT_IN_REQUIRED_TIME_LOCKTIME, uint32ForTest(499999999)), output)},
+		{"PSBTv2 with a height locktime above the threshold", serializeForTest(globals, withPairForTest(input, PSBT_IN_REQUIRED_HEIGHT_LOCKTIME, uint32ForTest(500000000)), output)},
+		{"PSBTv2 with fewer inputs than PSBT_GLOBAL_INPUT_COUNT", serializeForTest(withPairForTest(withoutKeyForTest(globals, PSBT_GLOBAL_INPUT_COUNT), PSBT_GLOBAL_INPUT_COUNT, []byte{2}), input, output)},
+	}
+	for _, test := range invalid {
+		if _, err := Parse(test.psbt); err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
+
+// Locktime determination of BIP370: heights win when every input allows
+// them, the fallback applies when no input requires a locktime
+func TestUnsignedTransactionV2(t *testing.T) {
+	value := func(v uint32) *uint32 { return &v }
+	amount := blockchainparser.Amount(1000)
+	psbtForTest := func(fallback *uint32, locktimes ...[2]*uint32) *Psbt {
+		psbt := &Psbt{Version: 2, TxVersion: 2, FallbackLocktime: fallback}
+		for i, locktime := range locktimes {
+			psbt.Inputs = append(psbt.Inputs, Input{
+				PreviousTxid:           blockchainparser.DoubleSha256([]byte{byte(i)}),
+				OutputIndex:            value(uint32(i)),
+				RequiredTimeLocktime:   locktime[0],
+				RequiredHeightLocktime: locktime[1],
+			})
+		}
+		psbt.Outputs = []Output{{Amount: &amount, Script: []byte{blockchainparser.OP_TRUE}}}
+		return psbt
+	}
+
+	tests := []struct {
+		psbt     *Psbt
+		locktime uint32
+	}{
+		{psbtForTest(nil, [2]*uint32{}), 0},
+		{psbtForTest(value(100), [2]*uint32{}), 100},
+		{psbtForTest(value(100), [2]*uint32{nil, value(10000)}, [2]*uint32{nil, value(9000)}), 10000},
+		{psbtForTest(nil, [2]*uint32{value(1657000000), nil}, [2]*uint32{value(1658000000), nil}), 1658000000},
+		{psbtForTest(nil, [2]*uint32{value(1657000000), value(10000)}, [2]*uint32{nil, value(9000)}), 10000},
+		{psbtForTest(nil, [2]*uint32{value(1657000000), value(10000)}, [2]*uint32{value(1658000000), nil}), 1658000000},
+		{psbtForTest(nil, [2]*uint32{value(1657000000), value(10000)}, [2]*uint32{value(1658000000), value(9000)}), 10000},
+	}
+	for i, test := range tests {
+		tx, err := test.psbt.UnsignedTransaction()
+		if err != nil {
+			t.Errorf("Test %d: %s", i, err)
+			continue
+		}
+		if tx.Locktime != test.locktime {
+			t.Errorf("Test %d: locktime %d, want %d", i, tx.Locktime, test.locktime)
+		}
+		if len(tx.Vin) != len(test.psbt.Inputs) || tx.Vin[0].Sequence != blockchainparser.SEQUENCE_FINAL || tx.Vin[0].Index != 0 {
+			t.Errorf("Test %d: inputs %v", i, tx.Vin)
+		}
+	}
+
+	incompatible := psbtForTest(nil, [2]*uint32{value(1657000000), nil}, [2]*uint32{nil, value(9000)})
+	if _, err := incompatible.UnsignedTransaction(); err == nil {
+		t.Error("Incompatible locktimes accepted")
+	}
+}
@@ -0,0 +1,295 @@
+package rpc
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+const (
+	COOKIE_FILE_NAME = ".cookie"
+	CONF_FILE_NAME   = "bitcoin.conf"
+	COOKIE_USER      = "__cookie__"
+
+	CHAIN_MAIN     = blockchainparser.CHAIN_MAIN
+	CHAIN_TESTNET  = blockchainparser.CHAIN_TESTNET
+	CHAIN_TESTNET4 = blockchainparser.CHAIN_TESTNET4
+	CHAIN_SIGNET   = blockchainparser.CHAIN_SIGNET
+	CHAIN_REGTEST  = blockchainparser.CHAIN_REGTEST
+)
+
+// Returns the network specific data directory of bitcoind for chain, which
+// is where bitcoind writes its .cookie file
+func ChainDataDir(datadir string, chain string) (string, error) {
+	params, err := blockchainparser.GetChainParams(chain)
+	if err != nil {
+		return "", err
+	}
+
+	return params.DataDir(datadir), nil
+}
+
+// Reads the user and password from a bitcoind cookie file
+func ReadCookieFile(path string) (string, string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(string(b)), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", errors.New("Malformed cookie file: " + path)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// RPC settings found in bitcoin.conf
+type BitcoinConf struct {
+	Chain       string // network selected by the testnet/signet/regtest/chain options
+	RpcUser     string
+	RpcPassword string
+	RpcPort     string
+	RpcConnect  string
+	RpcCookie   string   // rpccookiefile
+	RpcAuth     []string // rpcauth entries, user:salt$hash
+	DataDir     string
+}
+
+// Parses the RPC options of bitcoin.conf. Settings of the [main], [test],
+// [testnet4], [signet] and [regtest] sections only apply when chain is the
+// matching network; an empty chain uses the network selected in the file.
+func ReadBitcoinConf(path string, chain string) (*BitcoinConf, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	type setting struct {
+		section, key, value string
+	}
+	settings := make([]setting, 0)
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		value := ""
+		if len(parts) == 2 {
+			value = strings.TrimSpace(parts[1])
+		}
+		// Options can be given with a chain prefix, e.g. regtest.rpcport=1234
+		if i := strings.Index(key, "."); i >= 0 && section == "" {
+			settings = append(settings, setting{key[:i], key[i+1:], value})
+			continue
+		}
+		settings = append(settings, setting{section, key, value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	conf := &BitcoinConf{Chain: chain}
+	if conf.Chain == "" {
+		conf.Chain = CHAIN_MAIN
+		for _, s := range settings {
+			if s.section != "" || (s.value != "1" && s.key != "chain") {
+				continue
+			}
+			switch s.key {
+			case "testnet":
+				conf.Chain = CHAIN_TESTNET
+			case "testnet4", "signet", "regtest":
+				conf.Chain = s.key
+			case "chain":
+				conf.Chain = s.value
+			}
+		}
+	}
+
+	// Settings of the network's section take precedence over the top level
+	// ones, and a top level rpcport only applies to mainnet as in bitcoind.
+	// Within a section the first value of a single valued option wins.
+	sort.SliceStable(settings, func(i, j int) bool { return settings[i].section != "" && settings[j].section == "" })
+	seen := make(map[string]bool)
+	for _, s := range settings {
+		if s.section != "" && s.section != conf.Chain {
+			continue
+		}
+		if s.section == "" && s.key == "rpcport" && conf.Chain != CHAIN_MAIN {
+			continue
+		}
+		if s.key == "rpcauth" {
+			conf.RpcAuth = append(conf.RpcAuth, s.value)
+			continue
+		}
+		if seen[s.key] {
+			continue
+		}
+		seen[s.key] = true
+		switch s.key {
+		case "rpcuser":
+			conf.RpcUser = s.value
+		case "rpcpassword":
+			conf.RpcPassword = s.value
+		case "rpcport":
+			conf.RpcPort = s.value
+		case "rpcconnect":
+			conf.RpcConnect = s.value
+		case "rpccookiefile":
+			conf.RpcCookie = s.value
+		case "datadir":
+			conf.DataDir = s.value
+		}
+	}
+
+	return conf, nil
+}
+
+// Builds the options to reach the bitcoind using datadir, from its
+// bitcoin.conf if present. Without rpcuser/rpcpassword the .cookie file of
+// the chain is used. datadir defaults to BitcoinDir() and chain to the one
+// selected in bitcoin.conf.
+func NewRpcOptionsFromDataDir(datadir string, chain string) (*RpcOptions, error) {
+	if datadir == "" {
+		datadir = blockchainparser.BitcoinDir()
+	}
+
+	conf, err := ReadBitcoinConf(filepath.Join(datadir, CONF_FILE_NAME), chain)
+	if os.IsNotExist(err) {
+		if chain == "" {
+			chain = CHAIN_MAIN
+		}
+		conf = &BitcoinConf{Chain: chain}
+	} else if err != nil {
+		return nil, err
+	}
+	if conf.DataDir != "" {
+		datadir = conf.DataDir
+	}
+
+	params, err := blockchainparser.GetChainParams(conf.Chain)
+	if err != nil {
+		return nil, err
+	}
+	chainDir := params.DataDir(datadir)
+
+	options := &RpcOptions{
+		Host:  "127.0.0.1",
+		Port:  params.RpcPort,
+		Chain: conf.Chain,
+	}
+	if conf.RpcConnect != "" {
+		options.Host = conf.RpcConnect
+	}
+	if conf.RpcPort != "" {
+		options.Port = conf.RpcPort
+	}
+
+	if conf.RpcPassword != "" {
+		options.User = conf.RpcUser
+		options.Pass = conf.RpcPassword
+	} else {
+		options.CookieFile = filepath.Join(chainDir, COOKIE_FILE_NAME)
+		if conf.RpcCookie != "" {
+			options.CookieFile = conf.RpcCookie
+			if !filepath.IsAbs(options.CookieFile) {
+				options.CookieFile = filepath.Join(chainDir, options.CookieFile)
+			}
+		}
+	}
+
+	return options, nil
+}
+
+// Generates a bitcoin.conf rpcauth entry for user and password with a random
+// salt, as bitcoind's share/rpcauth/rpcauth.py does
+func RpcAuthLine(user string, password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	saltHex := hex.EncodeToString(salt)
+
+	mac := hmac.New(sha256.New, []byte(saltHex))
+	mac.Write([]byte(password))
+
+	return user + ":" + saltHex + "$" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Checks a user and password against a bitcoin.conf rpcauth entry
+func CheckRpcAuth(rpcAuth string, user string, password string) bool {
+	parts := strings.SplitN(rpcAuth, ":", 2)
+	if len(parts) != 2 || parts[0] != user {
+		return false
+	}
+	saltHash := strings.SplitN(parts[1], "$", 2)
+	if len(saltHash) != 2 {
+		return false
+	}
+	expected, err := hex.DecodeString(saltHash[1])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(saltHash[0]))
+	mac.Write([]byte(password))
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// Credentials read from a cookie file, re-read whenever bitcoind rewrites it
+// on restart
+type cookieAuth struct {
+	path    string
+	mutex   sync.Mutex
+	modTime time.Time
+	user    string
+	pass    string
+}
+
+func (cookie *cookieAuth) credentials(reload bool) (string, string, error) {
+	cookie.mutex.Lock()
+	defer cookie.mutex.Unlock()
+
+	info, err := os.Stat(cookie.path)
+	if err != nil {
+		return "", "", err
+	}
+	if reload || cookie.user == "" || !info.ModTime().Equal(cookie.modTime) {
+		user, pass, err := ReadCookieFile(cookie.path)
+		if err != nil {
+			return "", "", err
+		}
+		cookie.user, cookie.pass, cookie.modTime = user, pass, info.ModTime()
+	}
+
+	return cookie.user, cookie.pass, nil
+}
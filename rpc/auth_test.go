@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadBitcoinConfRpcPort(t *testing.T) {
+	tests := []struct {
+		conf  string
+		chain string
+		want  string
+	}{
+		{"rpcport=1234\n", "", "1234"},
+		{"rpcport=1234\n", CHAIN_REGTEST, ""},
+		{"testnet=1\nrpcport=1234\n", "", ""},
+		{"rpcport=1234\n[regtest]\nrpcport=5555\n", CHAIN_REGTEST, "5555"},
+		{"rpcport=1234\n[regtest]\nrpcport=5555\n", CHAIN_MAIN, "1234"},
+		{"regtest.rpcport=7777\nrpcport=1234\n", CHAIN_REGTEST, "7777"},
+		{"main.rpcport=1111\nrpcport=1234\n", CHAIN_MAIN, "1111"},
+	}
+
+	path := filepath.Join(t.TempDir(), "bitcoin.conf")
+	for _, test := range tests {
+		if err := os.WriteFile(path, []byte(test.conf), 0644); err != nil {
+			t.Fatal(err)
+		}
+		conf, err := ReadBitcoinConf(path, test.chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conf.RpcPort != test.want {
+			t.Errorf("%q on %q: got rpcport %q, want %q", test.conf, conf.Chain, conf.RpcPort, test.want)
+		}
+	}
+}
+
+// Options other than rpcport apply to every network
+func TestReadBitcoinConfTopLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bitcoin.conf")
+	data := "rpcuser=alice\nrpcpassword=secret\nrpcconnect=10.0.0.1\n[signet]\nrpcpassword=other\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := ReadBitcoinConf(path, CHAIN_SIGNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.RpcUser != "alice" || conf.RpcPassword != "other" || conf.RpcConnect != "10.0.0.1" {
+		t.Errorf("Got %+v", conf)
+	}
+}
+
+// bitcoind uses the first value of an option given twice in bitcoin.conf,
+// unlike the command line where the last one wins
+func TestReadBitcoinConfFirstValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bitcoin.conf")
+	data := "rpcuser=alice\nrpcpassword=secret\nrpcuser=bob\nrpcpassword=other\nrpccookiefile=first\nrpccookiefile=second\n" +
+		"rpcauth=alice:1$2\nrpcauth=bob:3$4\n" +
+		"[regtest]\nrpcport=5555\nrpcconnect=10.0.0.1\nrpcport=6666\nrpcconnect=10.0.0.2\nrpcpassword=regtest\nrpcpassword=last\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := ReadBitcoinConf(path, CHAIN_MAIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.RpcUser != "alice" || conf.RpcPassword != "secret" || conf.RpcCookie != "first" || len(conf.RpcAuth) != 2 {
+		t.Errorf("Mainnet: got %+v", conf)
+	}
+
+	conf, err = ReadBitcoinConf(path, CHAIN_REGTEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.RpcUser != "alice" || conf.RpcPassword != "regtest" || conf.RpcPort != "5555" || conf.RpcConnect != "10.0.0.1" {
+		t.Errorf("Regtest: got %+v", conf)
+	}
+}
@@ -0,0 +1,115 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+const DEFAULT_BATCH_SIZE = 500 // requests per POST
+
+var ErrMissingBatchResponse = errors.New("No response for the request in the batch")
+
+type BatchRequest struct {
+	Method string
+	Params []interface{}
+}
+
+func NewBatchRequest(method string, params ...interface{}) BatchRequest {
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	return BatchRequest{method, params}
+}
+
+// Outcome of one request of a batch
+type BatchResult struct {
+	Result json.RawMessage
+	Err    error // *RPCError for errors returned by bitcoind
+}
+
+// Decodes the result into v
+func (result BatchResult) Decode(v interface{}) error {
+	if result.Err != nil {
+		return result.Err
+	}
+
+	return json.Unmarshal(result.Result, v)
+}
+
+// Sends requests as JSON-RPC batches of at most RpcOptions.BatchSize requests
+// and returns their results in the same order. The error is only set when a
+// whole batch failed; errors of single requests are in their BatchResult.
+func (client *Client) Batch(ctx context.Context, requests []BatchRequest) ([]BatchResult, error) {
+	batchSize := client.options.BatchSize
+	if batchSize <= 0 {
+		batchSize = DEFAULT_BATCH_SIZE
+	}
+
+	results := make([]BatchResult, 0, len(requests))
+	for start := 0; start < len(requests); start += batchSize {
+		end := start + batchSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		chunkResults, err := client.batch(ctx, requests[start:end])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, chunkResults...)
+	}
+
+	return results, nil
+}
+
+func (client *Client) batch(ctx context.Context, requests []BatchRequest) ([]BatchResult, error) {
+	body := make([]rpcRequestBody, len(requests))
+	indexes := make(map[uint64]int, len(requests))
+	for i, request := range requests {
+		params := request.Params
+		if params == nil {
+			params = []interface{}{}
+		}
+		body[i] = rpcRequestBody{"1.0", client.nextId(), request.Method, params}
+		indexes[body[i].Id] = i
+	}
+
+	status, resBody, err := client.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []rpcResponseBody
+	if err := json.Unmarshal(resBody, &responses); err != nil {
+		// bitcoind answers with a single error object when the batch itself is invalid
+		var response rpcResponseBody
+		if json.Unmarshal(resBody, &response) == nil && response.Error != nil {
+			return nil, response.Error
+		}
+		if status != http.StatusOK {
+			return nil, &HTTPError{status, string(resBody)}
+		}
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(requests))
+	for i := range results {
+		results[i].Err = ErrMissingBatchResponse
+	}
+	for _, response := range responses {
+		i, ok := indexes[response.Id]
+		if !ok {
+			continue
+		}
+		if response.Error != nil {
+			results[i] = BatchResult{Err: response.Error}
+		} else {
+			results[i] = BatchResult{Result: response.Result}
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,115 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Answers each batch in reverse order with the first param of each request,
+// an error for "fail" and no response at all for "drop"
+func reversedBatchServer(t *testing.T, sizes *[]int, lock *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []rpcRequestBody
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			t.Errorf("Batch is not an array: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		*sizes = append(*sizes, len(requests))
+		lock.Unlock()
+
+		responses := make([]interface{}, 0, len(requests))
+		for i := len(requests) - 1; i >= 0; i-- {
+			request := requests[i]
+			switch request.Method {
+			case "drop":
+			case "fail":
+				responses = append(responses, map[string]interface{}{"result": nil, "error": map[string]interface{}{"code": -8, "message": "failed"}, "id": request.Id})
+			default:
+				responses = append(responses, map[string]interface{}{"result": request.Params[0], "error": nil, "id": request.Id})
+			}
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+}
+
+func TestBatchChunksAndOrder(t *testing.T) {
+	var sizes []int
+	var lock sync.Mutex
+	server := reversedBatchServer(t, &sizes, &lock)
+	defer server.Close()
+
+	options := testServerOptions(server)
+	options.BatchSize = 3
+	client := NewClient(options)
+
+	var requests []BatchRequest
+	for i := 0; i < 8; i++ {
+		requests = append(requests, NewBatchRequest("getblockhash", i))
+	}
+	requests[4] = NewBatchRequest("fail", 4)
+	requests[6] = NewBatchRequest("drop", 6)
+
+	results, err := client.Batch(context.Background(), requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 2 {
+		t.Errorf("Sent batches of %v requests", sizes)
+	}
+	if len(results) != len(requests) {
+		t.Fatalf("Got %d results", len(results))
+	}
+	for i, result := range results {
+		var n int
+		err := result.Decode(&n)
+		switch i {
+		case 4:
+			var rpcErr *RPCError
+			if !errors.As(err, &rpcErr) || rpcErr.Code != -8 {
+				t.Errorf("Result %d: got %v", i, err)
+			}
+		case 6:
+			if err != ErrMissingBatchResponse {
+				t.Errorf("Result %d: got %v", i, err)
+			}
+		default:
+			if err != nil || n != i {
+				t.Errorf("Result %d: got %d, %v", i, n, err)
+			}
+		}
+	}
+
+	// The default batch size sends everything at once
+	sizes = nil
+	if _, err := NewClient(testServerOptions(server)).Batch(context.Background(), requests); err != nil || len(sizes) != 1 {
+		t.Errorf("Sent batches of %v requests: %v", sizes, err)
+	}
+	if results, err := client.Batch(context.Background(), nil); err != nil || len(results) != 0 {
+		t.Errorf("Empty batch: got %v, %v", results, err)
+	}
+}
+
+func TestBatchFailure(t *testing.T) {
+	server := httptest.NewServer(respondWith(http.StatusInternalServerError, `{"result":null,"error":{"code":-32700,"message":"Parse error"},"id":null}`))
+	_, err := NewClient(testServerOptions(server)).Batch(context.Background(), []BatchRequest{NewBatchRequest("getblockcount")})
+	server.Close()
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != -32700 {
+		t.Errorf("Got %v, want an RPCError", err)
+	}
+
+	server = httptest.NewServer(respondWith(http.StatusServiceUnavailable, "Work queue depth exceeded"))
+	_, err = NewClient(testServerOptions(server)).Batch(context.Background(), []BatchRequest{NewBatchRequest("getblockcount")})
+	server.Close()
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Got %v, want an HTTPError", err)
+	}
+}
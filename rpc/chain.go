@@ -0,0 +1,190 @@
+package rpc
+
+import (
+	"context"
+	"encoding/hex"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+// Verbosity levels of getblock
+const (
+	BLOCK_VERBOSITY_HEX  = 0
+	BLOCK_VERBOSITY_TXID = 1
+	BLOCK_VERBOSITY_TX   = 2
+)
+
+func (client *Client) GetBlockCount(ctx context.Context) (int32, error) {
+	var count int32
+	err := client.Call(ctx, "getblockcount", &count)
+	return count, err
+}
+
+func (client *Client) GetBestBlockHash(ctx context.Context) (blockchainparser.Hash256, error) {
+	return client.callHash(ctx, "getbestblockhash")
+}
+
+func (client *Client) GetBlockHash(ctx context.Context, height int32) (blockchainparser.Hash256, error) {
+	return client.callHash(ctx, "getblockhash", height)
+}
+
+// getblockhash for every height in a single batch
+func (client *Client) GetBlockHashes(ctx context.Context, heights []int32) ([]blockchainparser.Hash256, error) {
+	requests := make([]BatchRequest, len(heights))
+	for i, height := range heights {
+		requests[i] = NewBatchRequest("getblockhash", height)
+	}
+
+	results, err := client.Batch(ctx, requests)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]blockchainparser.Hash256, len(results))
+	for i, result := range results {
+		var hash string
+		if err := result.Decode(&hash); err != nil {
+			return nil, err
+		}
+		hashes[i], err = blockchainparser.NewHash256FromString(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return hashes, nil
+}
+
+// Returns the parsed block, from getblock with verbosity 0
+func (client *Client) GetBlock(ctx context.Context, hash blockchainparser.Hash256) (*blockchainparser.Block, error) {
+	b, err := client.callHex(ctx, "getblock", hash.String(), BLOCK_VERBOSITY_HEX)
+	if err != nil {
+		return nil, err
+	}
+
+	return blockchainparser.ParseBlockFromBytes(b)
+}
+
+// getblock with verbosity 1
+func (client *Client) GetBlockVerbose(ctx context.Context, hash blockchainparser.Hash256) (*BlockResult, error) {
+	var result BlockResult
+	err := client.Call(ctx, "getblock", &result, hash.String(), BLOCK_VERBOSITY_TXID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// getblock with verbosity 2
+func (client *Client) GetBlockVerboseTx(ctx context.Context, hash blockchainparser.Hash256) (*BlockVerboseTxResult, error) {
+	var result BlockVerboseTxResult
+	err := client.Call(ctx, "getblock", &result, hash.String(), BLOCK_VERBOSITY_TX)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Returns the parsed header, from getblockheader with verbose unset
+func (client *Client) GetBlockHeader(ctx context.Context, hash blockchainparser.Hash256) (*blockchainparser.BlockHeader, error) {
+	b, err := client.callHex(ctx, "getblockheader", hash.String(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	return blockchainparser.ParseBlockHeaderFromBytes(b)
+}
+
+func (client *Client) GetBlockHeaderVerbose(ctx context.Context, hash blockchainparser.Hash256) (*BlockHeaderResult, error) {
+	var result BlockHeaderResult
+	err := client.Call(ctx, "getblockheader", &result, hash.String(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// getblockheader for every hash in a single batch
+func (client *Client) GetBlockHeaders(ctx context.Context, hashes []blockchainparser.Hash256) ([]*blockchainparser.BlockHeader, error) {
+	requests := make([]BatchRequest, len(hashes))
+	for i, hash := range hashes {
+		requests[i] = NewBatchRequest("getblockheader", hash.String(), false)
+	}
+
+	results, err := client.Batch(ctx, requests)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]*blockchainparser.BlockHeader, len(results))
+	for i, result := range results {
+		var headerHex string
+		if err := result.Decode(&headerHex); err != nil {
+			return nil, err
+		}
+		b, err := hex.DecodeString(headerHex)
+		if err != nil {
+			return nil, err
+		}
+		headers[i], err = blockchainparser.ParseBlockHeaderFromBytes(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return headers, nil
+}
+
+// Returns the parsed transaction. blockHash is optional and only needed to
+// find transactions outside the mempool when bitcoind's txindex is disabled.
+func (client *Client) GetRawTransaction(ctx context.Context, txid blockchainparser.Hash256, blockHash blockchainparser.Hash256) (*blockchainparser.Transaction, error) {
+	args := []interface{}{txid.String(), false}
+	if blockHash != nil {
+		args = append(args, blockHash.String())
+	}
+
+	b, err := client.callHex(ctx, "getrawtransaction", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return blockchainparser.ParseTransactionFromBytes(b)
+}
+
+func (client *Client) GetRawTransactionVerbose(ctx context.Context, txid blockchainparser.Hash256, blockHash blockchainparser.Hash256) (*TxResult, error) {
+	args := []interface{}{txid.String(), true}
+	if blockHash != nil {
+		args = append(args, blockHash.String())
+	}
+
+	var result TxResult
+	err := client.Call(ctx, "getrawtransaction", &result, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (client *Client) callHash(ctx context.Context, method string, args ...interface{}) (blockchainparser.Hash256, error) {
+	var hash string
+	err := client.Call(ctx, method, &hash, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return blockchainparser.NewHash256FromString(hash)
+}
+
+func (client *Client) callHex(ctx context.Context, method string, args ...interface{}) ([]byte, error) {
+	var result string
+	err := client.Call(ctx, method, &result, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(result)
+}
@@ -0,0 +1,248 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+const DEFAULT_TIMEOUT = 30 * time.Second
+
+const (
+	//! Error codes of bitcoind, see rpc/protocol.h
+	RPC_INVALID_REQUEST         = -32600
+	RPC_METHOD_NOT_FOUND        = -32601
+	RPC_INVALID_PARAMS          = -32602
+	RPC_INTERNAL_ERROR          = -32603
+	RPC_PARSE_ERROR             = -32700
+	RPC_MISC_ERROR              = -1
+	RPC_TYPE_ERROR              = -3
+	RPC_INVALID_ADDRESS_OR_KEY  = -5
+	RPC_INVALID_PARAMETER       = -8
+	RPC_DESERIALIZATION_ERROR   = -22
+	RPC_VERIFY_ERROR            = -25
+	RPC_VERIFY_REJECTED         = -26
+	RPC_VERIFY_ALREADY_IN_CHAIN = -27
+	RPC_IN_WARMUP               = -28
+	RPC_WALLET_ERROR            = -4
+)
+
+var (
+	ErrUnauthorized = errors.New("Incorrect rpcuser or rpcpassword")
+	ErrForbidden    = errors.New("Connection refused by bitcoind (check rpcallowip)")
+)
+
+// Error object returned by bitcoind in a JSON-RPC response
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *RPCError) Error() string {
+	return "Code " + strconv.Itoa(err.Code) + ": " + err.Message
+}
+
+// Non-200 HTTP response without a JSON-RPC error in the body
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (err *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP %d %s: %s", err.StatusCode, http.StatusText(err.StatusCode), err.Body)
+}
+
+type rpcRequestBody struct {
+	Jsonrpc string        `json:"jsonrpc"`
+	Id      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponseBody struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+	Id     uint64          `json:"id"`
+}
+
+// JSON-RPC client for bitcoind. A Client is safe for concurrent use and keeps
+// connections to bitcoind alive between calls.
+type Client struct {
+	options    *RpcOptions
+	httpClient *http.Client
+	cookie     *cookieAuth
+	lastId     uint64
+}
+
+func NewClient(options *RpcOptions) *Client {
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+
+	client := &Client{
+		options:    options,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+	if options.User == "" && options.CookieFile != "" {
+		client.cookie = &cookieAuth{path: options.CookieFile}
+	}
+
+	return client
+}
+
+func (client *Client) Options() *RpcOptions {
+	return client.options
+}
+
+// Parameters of the network selected by the options, mainnet by default
+func (client *Client) ChainParams() *blockchainparser.ChainParams {
+	if params, err := blockchainparser.GetChainParams(client.options.Chain); err == nil {
+		return params
+	}
+	if client.options.Testnet {
+		return blockchainparser.TestNetParams
+	}
+
+	return blockchainparser.MainNetParams
+}
+
+func (client *Client) Url() string {
+	port := client.options.Port
+	if port == "" {
+		port = client.ChainParams().RpcPort
+	} else if client.options.Testnet && client.options.Chain == "" {
+		port = "1" + port
+	}
+
+	url := "http://" + client.options.Host + ":" + port
+	if client.options.Wallet != "" {
+		url += "/wallet/" + neturl.PathEscape(client.options.Wallet)
+	}
+
+	return url
+}
+
+func (client *Client) nextId() uint64 {
+	return atomic.AddUint64(&client.lastId, 1)
+}
+
+// POSTs a JSON body to bitcoind and returns the response body. Errors are
+// only returned for transport failures and authentication/authorization
+// responses; other statuses are left to the caller since bitcoind reports
+// RPC errors with HTTP 500 and 404.
+func (client *Client) post(ctx context.Context, body interface{}) (int, []byte, error) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	status, resBody, err := client.postOnce(ctx, jsonBody, false)
+	if status == http.StatusUnauthorized && client.cookie != nil {
+		// bitcoind writes a new cookie each time it starts
+		status, resBody, err = client.postOnce(ctx, jsonBody, true)
+	}
+
+	return status, resBody, err
+}
+
+func (client *Client) postOnce(ctx context.Context, jsonBody []byte, reloadCookie bool) (int, []byte, error) {
+	user, pass := client.options.User, client.options.Pass
+	if client.cookie != nil {
+		var err error
+		user, pass, err = client.cookie.credentials(reloadCookie)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	req, err := http.NewRequest("POST", client.Url(), bytes.NewReader(jsonBody))
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(user, pass)
+
+	res, err := client.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		return res.StatusCode, resBody, ErrUnauthorized
+	case http.StatusForbidden:
+		return res.StatusCode, resBody, ErrForbidden
+	}
+
+	return res.StatusCode, resBody, nil
+}
+
+// Calls method and returns the raw JSON-RPC response body
+func (client *Client) RawCall(ctx context.Context, method string, args ...interface{}) ([]byte, error) {
+	if args == nil {
+		args = []interface{}{}
+	}
+
+	_, body, err := client.post(ctx, rpcRequestBody{"1.0", client.nextId(), method, args})
+	return body, err
+}
+
+// Calls method and returns the JSON of its result
+func (client *Client) CallResult(ctx context.Context, method string, args ...interface{}) (json.RawMessage, error) {
+	if args == nil {
+		args = []interface{}{}
+	}
+
+	status, body, err := client.post(ctx, rpcRequestBody{"1.0", client.nextId(), method, args})
+	if err != nil {
+		return nil, err
+	}
+
+	var response rpcResponseBody
+	if err := json.Unmarshal(body, &response); err != nil {
+		if status != http.StatusOK {
+			return nil, &HTTPError{status, string(body)}
+		}
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, response.Error
+	}
+	if status != http.StatusOK {
+		return nil, &HTTPError{status, string(body)}
+	}
+
+	return response.Result, nil
+}
+
+// Calls method and decodes its result into result, which may be nil to
+// discard it
+func (client *Client) Call(ctx context.Context, method string, result interface{}, args ...interface{}) error {
+	raw, err := client.CallResult(ctx, method, args...)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(raw, result)
+}
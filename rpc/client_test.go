@@ -0,0 +1,190 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Options reaching server with the given credentials
+func testServerOptions(server *httptest.Server) *RpcOptions {
+	u, _ := url.Parse(server.URL)
+	return &RpcOptions{Host: u.Hostname(), Port: u.Port(), User: "user", Pass: "pass"}
+}
+
+func respondWith(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+func TestClientRPCError(t *testing.T) {
+	// bitcoind reports RPC errors with HTTP 500, 404 for unknown methods
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError, http.StatusNotFound} {
+		server := httptest.NewServer(respondWith(status, `{"result":null,"error":{"code":-5,"message":"Block not found"},"id":1}`))
+		err := NewClient(testServerOptions(server)).Call(context.Background(), "getblock", nil, "00")
+		server.Close()
+
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			t.Errorf("HTTP %d: got %v, want an RPCError", status, err)
+			continue
+		}
+		if rpcErr.Code != RPC_INVALID_ADDRESS_OR_KEY || rpcErr.Message != "Block not found" {
+			t.Errorf("HTTP %d: got %+v", status, rpcErr)
+		}
+	}
+}
+
+func TestClientHTTPStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   error
+	}{
+		{http.StatusUnauthorized, "", ErrUnauthorized},
+		{http.StatusForbidden, "", ErrForbidden},
+		{http.StatusInternalServerError, "Work queue depth exceeded", &HTTPError{http.StatusInternalServerError, "Work queue depth exceeded"}},
+		{http.StatusServiceUnavailable, "", &HTTPError{http.StatusServiceUnavailable, ""}},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(respondWith(test.status, test.body))
+		_, err := NewClient(testServerOptions(server)).CallResult(context.Background(), "getblockcount")
+		server.Close()
+
+		if want, ok := test.want.(*HTTPError); ok {
+			var httpErr *HTTPError
+			if !errors.As(err, &httpErr) || *httpErr != *want {
+				t.Errorf("HTTP %d: got %v, want %v", test.status, err, want)
+			}
+		} else if err != test.want {
+			t.Errorf("HTTP %d: got %v, want %v", test.status, err, test.want)
+		}
+	}
+}
+
+func TestClientSendsCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `{"result":42,"error":null,"id":1}`)
+	}))
+	defer server.Close()
+
+	var count int
+	if err := NewClient(testServerOptions(server)).Call(context.Background(), "getblockcount", &count); err != nil || count != 42 {
+		t.Errorf("Got %d, %v", count, err)
+	}
+}
+
+// The cookie is read again after a 401, as bitcoind writes a new one when
+// it restarts
+func TestClientCookieReload(t *testing.T) {
+	cookieFile := filepath.Join(t.TempDir(), COOKIE_FILE_NAME)
+	if err := os.WriteFile(cookieFile, []byte(COOKIE_USER+":old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != COOKIE_USER || pass != "new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `{"result":1,"error":null,"id":1}`)
+	}))
+	defer server.Close()
+
+	options := testServerOptions(server)
+	options.User, options.Pass, options.CookieFile = "", "", cookieFile
+	client := NewClient(options)
+	if err := client.Call(context.Background(), "getblockcount", nil); err != ErrUnauthorized {
+		t.Errorf("Old cookie: got %v", err)
+	}
+	if err := os.WriteFile(cookieFile, []byte(COOKIE_USER+":new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call(context.Background(), "getblockcount", nil); err != nil {
+		t.Errorf("New cookie: %s", err)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	client := NewClient(testServerOptions(server))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if err := client.Call(ctx, "waitfornewblock", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Cancelled: got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := client.Call(ctx, "waitfornewblock", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Context deadline: got %v", err)
+	}
+
+	options := testServerOptions(server)
+	options.Timeout = 20 * time.Millisecond
+	err := NewClient(options).Call(context.Background(), "waitfornewblock", nil)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Timeout: got %v", err)
+	}
+}
+
+func TestClientReusesConnections(t *testing.T) {
+	var lock sync.Mutex
+	connections := 0
+	server := httptest.NewUnstartedServer(respondWith(http.StatusOK, `{"result":1,"error":null,"id":1}`))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			lock.Lock()
+			connections++
+			lock.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := NewClient(testServerOptions(server))
+	for i := 0; i < 10; i++ {
+		if err := client.Call(context.Background(), "getblockcount", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if connections != 1 {
+		t.Errorf("Opened %d connections for 10 calls", connections)
+	}
+}
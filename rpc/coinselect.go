@@ -0,0 +1,477 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+// Coin selection strategies
+const (
+	COIN_SELECTION_AUTO          = ""
+	COIN_SELECTION_BNB           = "bnb"
+	COIN_SELECTION_KNAPSACK      = "knapsack"
+	COIN_SELECTION_LARGEST_FIRST = "largest-first"
+)
+
+const (
+	//! Default fee rates (per kvB) as used by bitcoind
+	DUST_RELAY_TX_FEE            FeeRate = 3000
+	DEFAULT_DISCARD_FEE          FeeRate = 10000
+	DEFAULT_CONSOLIDATE_FEE_RATE FeeRate = 10000
+
+	//! Bounds of the random change target of the knapsack solver
+	CHANGE_LOWER = 50000
+	CHANGE_UPPER = 1000000
+
+	//! Maximum number of branch and bound iterations
+	BNB_TOTAL_TRIES = 100000
+	//! Number of random subsets tried by the knapsack solver
+	KNAPSACK_ITERATIONS = 1000
+
+	//! Virtual size of version, locktime, counts and segwit marker
+	TX_OVERHEAD_VSIZE = 11
+)
+
+var (
+	ErrInsufficientFunds = errors.New("Insufficient funds")
+	ErrNoChangeAddress   = errors.New("Change needed but no change address given")
+	ErrDustOutput        = errors.New("Output amount is dust")
+)
+
+// Fee rate in satoshis per 1000 virtual bytes, as returned by estimatesmartfee
+type FeeRate blockchainparser.Amount
+
+// Fee for vsize virtual bytes, rounded up
+func (feeRate FeeRate) Fee(vsize int) blockchainparser.Amount {
+	if feeRate <= 0 || vsize <= 0 {
+		return 0
+	}
+
+	return blockchainparser.Amount((int64(feeRate)*int64(vsize) + 999) / 1000)
+}
+
+type CoinSelectionParams struct {
+	Outputs         map[string]blockchainparser.Amount // address -> amount, as for CreateRawTransaction
+	ChangeAddress   string
+	AddressParams   *blockchainparser.AddressParams // defaults to mainnet
+	FeeRate         FeeRate
+	LongTermFeeRate FeeRate    // defaults to DEFAULT_CONSOLIDATE_FEE_RATE
+	DiscardFeeRate  FeeRate    // defaults to DEFAULT_DISCARD_FEE
+	DustRelayFee    FeeRate    // defaults to DUST_RELAY_TX_FEE
+	Strategy        string     // one of COIN_SELECTION_*
+	Rand            *rand.Rand // randomness of the knapsack solver, seeded from the clock when nil
+}
+
+// Inputs and outputs ready for CreateRawTransaction
+type CoinSelection struct {
+	Inputs    UnspentTxs
+	Outputs   map[string]blockchainparser.Amount
+	Fee       blockchainparser.Amount
+	Change    blockchainparser.Amount // 0 when the excess is given to fees
+	Waste     blockchainparser.Amount
+	Algorithm string
+}
+
+type coinCandidate struct {
+	utxo           UnspentTx
+	effectiveValue blockchainparser.Amount
+	fee            blockchainparser.Amount
+	longTermFee    blockchainparser.Amount
+}
+
+// Estimated virtual size of an input spending script, assuming P2SH outputs
+// are P2SH-P2WPKH. Returns false for scripts without a known spend size.
+func estimateInputVSize(script blockchainparser.Script) (int, bool) {
+	scriptType, _ := script.Type()
+	switch scriptType {
+	case blockchainparser.TX_PUBKEY:
+		return 114, true
+	case blockchainparser.TX_PUBKEYHASH:
+		return 148, true
+	case blockchainparser.TX_SCRIPTHASH:
+		return 91, true
+	case blockchainparser.TX_WITNESS_V0_KEYHASH:
+		return 68, true
+	case blockchainparser.TX_WITNESS_V1_TAPROOT:
+		return 58, true
+	}
+
+	return 0, false
+}
+
+func outputVSize(script blockchainparser.Script) int {
+	return 8 + len(blockchainparser.Varint(uint64(len(script)))) + len(script)
+}
+
+// Minimum amount for an output paying script to be relayed, as in bitcoind's
+// GetDustThreshold
+func DustThreshold(script blockchainparser.Script, dustRelayFee FeeRate) blockchainparser.Amount {
+	if len(script) > 0 && script[0] == blockchainparser.OP_RETURN {
+		return 0
+	}
+
+	spendSize := 32 + 4 + 1 + 107 + 4
+	if _, _, ok := script.WitnessProgram(); ok {
+		spendSize = 32 + 4 + 1 + 107/blockchainparser.WITNESS_SCALE_FACTOR + 4
+	}
+
+	return dustRelayFee.Fee(outputVSize(script) + spendSize)
+}
+
+func utxoScript(utxo UnspentTx, addressParams *blockchainparser.AddressParams) (blockchainparser.Script, error) {
+	if utxo.ScriptPubKey != "" {
+		return hex.DecodeString(utxo.ScriptPubKey)
+	}
+
+	return blockchainparser.DecodeAddress(utxo.Address, addressParams)
+}
+
+// Selects inputs among the unspent outputs to pay params.Outputs at
+// params.FeeRate. Unspent outputs of unknown script types, or not worth
+// spending at the fee rate, are ignored. With COIN_SELECTION_AUTO all
+// strategies are tried and the selection with the least waste is returned.
+func (slice UnspentTxs) SelectCoins(params *CoinSelectionParams) (*CoinSelection, error) {
+	addressParams := params.AddressParams
+	if addressParams == nil {
+		addressParams = blockchainparser.MainNetAddressParams
+	}
+	longTermFeeRate := params.LongTermFeeRate
+	if longTermFeeRate == 0 {
+		longTermFeeRate = DEFAULT_CONSOLIDATE_FEE_RATE
+	}
+	discardFeeRate := params.DiscardFeeRate
+	if discardFeeRate == 0 {
+		discardFeeRate = DEFAULT_DISCARD_FEE
+	}
+	dustRelayFee := params.DustRelayFee
+	if dustRelayFee == 0 {
+		dustRelayFee = DUST_RELAY_TX_FEE
+	}
+	rng := params.Rand
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	// Amount to reach with the effective values of the inputs
+	vsize := TX_OVERHEAD_VSIZE
+	payment := blockchainparser.Amount(0)
+	for address, amount := range params.Outputs {
+		script, err := blockchainparser.DecodeAddress(address, addressParams)
+		if err != nil {
+			return nil, err
+		}
+		if amount < DustThreshold(script, dustRelayFee) {
+			return nil, ErrDustOutput
+		}
+		vsize += outputVSize(script)
+		payment += amount
+	}
+	target := payment + params.FeeRate.Fee(vsize)
+
+	// Cost of creating and later spending a change output
+	changeOutputVSize, changeSpendVSize := 31, 68
+	minViableChange := blockchainparser.Amount(0)
+	if params.ChangeAddress != "" {
+		changeScript, err := blockchainparser.DecodeAddress(params.ChangeAddress, addressParams)
+		if err != nil {
+			return nil, err
+		}
+		changeOutputVSize = outputVSize(changeScript)
+		if spendVSize, ok := estimateInputVSize(changeScript); ok {
+			changeSpendVSize = spendVSize
+		}
+		minViableChange = DustThreshold(changeScript, dustRelayFee)
+	}
+	changeFee := params.FeeRate.Fee(changeOutputVSize)
+	changeSpendFee := discardFeeRate.Fee(changeSpendVSize)
+	costOfChange := changeSpendFee + changeFee
+	if minViableChange <= changeSpendFee {
+		minViableChange = changeSpendFee + 1
+	}
+
+	pool := make([]coinCandidate, 0, len(slice))
+	for _, utxo := range slice {
+		script, err := utxoScript(utxo, addressParams)
+		if err != nil {
+			continue
+		}
+		inputVSize, ok := estimateInputVSize(script)
+		if !ok {
+			continue
+		}
+		candidate := coinCandidate{
+			utxo:        utxo,
+			fee:         params.FeeRate.Fee(inputVSize),
+			longTermFee: longTermFeeRate.Fee(inputVSize),
+		}
+		candidate.effectiveValue = utxo.Amount - candidate.fee
+		if candidate.effectiveValue > 0 {
+			pool = append(pool, candidate)
+		}
+	}
+	sort.SliceStable(pool, func(i, j int) bool {
+		return pool[i].effectiveValue > pool[j].effectiveValue
+	})
+
+	// Selected pool indexes for each tried strategy
+	selections := map[string][]int{}
+	if params.Strategy == COIN_SELECTION_AUTO || params.Strategy == COIN_SELECTION_BNB {
+		if selected, ok := selectCoinsBnB(pool, target, costOfChange); ok {
+			selections[COIN_SELECTION_BNB] = selected
+		}
+	}
+	if params.Strategy == COIN_SELECTION_AUTO || params.Strategy == COIN_SELECTION_KNAPSACK {
+		changeTarget := generateChangeTarget(payment, changeFee, rng)
+		if selected, ok := knapsackSolver(pool, target, changeTarget, rng); ok {
+			selections[COIN_SELECTION_KNAPSACK] = selected
+		}
+	}
+	if params.Strategy == COIN_SELECTION_AUTO || params.Strategy == COIN_SELECTION_LARGEST_FIRST {
+		if selected, ok := selectLargestFirst(pool, target); ok {
+			selections[COIN_SELECTION_LARGEST_FIRST] = selected
+		}
+	}
+
+	var best *CoinSelection
+	err := ErrInsufficientFunds
+	for _, algorithm := range []string{COIN_SELECTION_BNB, COIN_SELECTION_KNAPSACK, COIN_SELECTION_LARGEST_FIRST} {
+		selected, ok := selections[algorithm]
+		if !ok {
+			continue
+		}
+
+		result := &CoinSelection{Algorithm: algorithm, Outputs: map[string]blockchainparser.Amount{}}
+		effectiveValue := blockchainparser.Amount(0)
+		inputValue := blockchainparser.Amount(0)
+		for _, i := range selected {
+			result.Inputs = append(result.Inputs, pool[i].utxo)
+			result.Waste += pool[i].fee - pool[i].longTermFee
+			effectiveValue += pool[i].effectiveValue
+			inputValue += pool[i].utxo.Amount
+		}
+
+		excess := effectiveValue - target
+		if change := excess - changeFee; change >= minViableChange {
+			if params.ChangeAddress == "" {
+				err = ErrNoChangeAddress
+				continue
+			}
+			result.Change = change
+			result.Waste += costOfChange
+		} else {
+			result.Waste += excess
+		}
+
+		for address, amount := range params.Outputs {
+			result.Outputs[address] = amount
+		}
+		if result.Change > 0 {
+			result.Outputs[params.ChangeAddress] += result.Change
+		}
+		result.Fee = inputValue - payment - result.Change
+
+		// Prefer less waste, then more inputs to consolidate
+		if best == nil || result.Waste < best.Waste || (result.Waste == best.Waste && len(result.Inputs) > len(best.Inputs)) {
+			best = result
+		}
+	}
+
+	if best == nil {
+		return nil, err
+	}
+
+	return best, nil
+}
+
+// Random change target, as in bitcoind's GenerateChangeTarget
+func generateChangeTarget(payment blockchainparser.Amount, changeFee blockchainparser.Amount, rng *rand.Rand) blockchainparser.Amount {
+	if payment <= CHANGE_LOWER/2 {
+		return changeFee + CHANGE_LOWER
+	}
+	upper := 2 * payment
+	if upper > CHANGE_UPPER {
+		upper = CHANGE_UPPER
+	}
+
+	return changeFee + CHANGE_LOWER + blockchainparser.Amount(rng.Int63n(int64(upper-CHANGE_LOWER)))
+}
+
+// Depth first search for a changeless selection whose effective value lies
+// within [target, target+costOfChange], minimizing waste. Port of
+// bitcoind's SelectCoinsBnB; pool must be sorted by descending effective value.
+func selectCoinsBnB(pool []coinCandidate, target blockchainparser.Amount, costOfChange blockchainparser.Amount) ([]int, bool) {
+	if len(pool) == 0 {
+		return nil, false
+	}
+
+	currAvailableValue := blockchainparser.Amount(0)
+	for _, candidate := range pool {
+		currAvailableValue += candidate.effectiveValue
+	}
+	if currAvailableValue < target {
+		return nil, false
+	}
+
+	var currSelection, bestSelection []int
+	currValue := blockchainparser.Amount(0)
+	currWaste := blockchainparser.Amount(0)
+	bestWaste := blockchainparser.Amount(blockchainparser.MAX_MONEY)
+	isFeerateHigh := pool[0].fee > pool[0].longTermFee
+
+	for try, i := 0, 0; try < BNB_TOTAL_TRIES; try, i = try+1, i+1 {
+		backtrack := false
+		if currValue+currAvailableValue < target ||
+			currValue > target+costOfChange ||
+			(currWaste > bestWaste && isFeerateHigh) {
+			backtrack = true
+		} else if currValue >= target {
+			currWaste += currValue - target
+			if currWaste <= bestWaste {
+				bestSelection = append(bestSelection[:0], currSelection...)
+				bestWaste = currWaste
+			}
+			currWaste -= currValue - target
+			backtrack = true
+		}
+
+		if backtrack {
+			if len(currSelection) == 0 {
+				break
+			}
+			// Add omitted candidates back before excluding the last included one
+			last := currSelection[len(currSelection)-1]
+			for i--; i > last; i-- {
+				currAvailableValue += pool[i].effectiveValue
+			}
+			currValue -= pool[i].effectiveValue
+			currWaste -= pool[i].fee - pool[i].longTermFee
+			currSelection = currSelection[:len(currSelection)-1]
+		} else {
+			currAvailableValue -= pool[i].effectiveValue
+			// Skip candidates equivalent to an excluded previous one
+			if len(currSelection) == 0 || i-1 == currSelection[len(currSelection)-1] ||
+				pool[i].effectiveValue != pool[i-1].effectiveValue || pool[i].fee != pool[i-1].fee {
+				currSelection = append(currSelection, i)
+				currValue += pool[i].effectiveValue
+				currWaste += pool[i].fee - pool[i].longTermFee
+			}
+		}
+	}
+
+	return bestSelection, len(bestSelection) > 0
+}
+
+// Stochastic subset sum approximation, as in bitcoind's KnapsackSolver
+func knapsackSolver(pool []coinCandidate, target blockchainparser.Amount, changeTarget blockchainparser.Amount, rng *rand.Rand) ([]int, bool) {
+	order := rng.Perm(len(pool))
+
+	var applicable []int
+	lowestLarger := -1
+	totalLower := blockchainparser.Amount(0)
+	for _, i := range order {
+		value := pool[i].effectiveValue
+		if value == target {
+			return []int{i}, true
+		} else if value < target+changeTarget {
+			applicable = append(applicable, i)
+			totalLower += value
+		} else if lowestLarger < 0 || value < pool[lowestLarger].effectiveValue {
+			lowestLarger = i
+		}
+	}
+
+	if totalLower == target {
+		return applicable, true
+	}
+	if totalLower < target {
+		if lowestLarger < 0 {
+			return nil, false
+		}
+		return []int{lowestLarger}, true
+	}
+
+	sort.SliceStable(applicable, func(a, b int) bool {
+		return pool[applicable[a]].effectiveValue > pool[applicable[b]].effectiveValue
+	})
+	values := make([]blockchainparser.Amount, len(applicable))
+	for n, i := range applicable {
+		values[n] = pool[i].effectiveValue
+	}
+
+	included, best := approximateBestSubset(values, totalLower, target, rng)
+	if best != target && totalLower >= target+changeTarget {
+		included, best = approximateBestSubset(values, totalLower, target+changeTarget, rng)
+	}
+
+	if lowestLarger >= 0 &&
+		((best != target && best < target+changeTarget) || pool[lowestLarger].effectiveValue <= best) {
+		return []int{lowestLarger}, true
+	}
+
+	var selected []int
+	for n, i := range applicable {
+		if included[n] {
+			selected = append(selected, i)
+		}
+	}
+
+	return selected, true
+}
+
+func approximateBestSubset(values []blockchainparser.Amount, totalLower blockchainparser.Amount, target blockchainparser.Amount, rng *rand.Rand) ([]bool, blockchainparser.Amount) {
+	best := make([]bool, len(values))
+	for i := range best {
+		best[i] = true
+	}
+	bestValue := totalLower
+
+	included := make([]bool, len(values))
+	for rep := 0; rep < KNAPSACK_ITERATIONS && bestValue != target; rep++ {
+		for i := range included {
+			included[i] = false
+		}
+		total := blockchainparser.Amount(0)
+		reachedTarget := false
+		for pass := 0; pass < 2 && !reachedTarget; pass++ {
+			for i, value := range values {
+				// The first pass includes random values, the second fills up
+				if (pass == 0 && rng.Intn(2) == 1) || (pass == 1 && !included[i]) {
+					total += value
+					included[i] = true
+					if total >= target {
+						reachedTarget = true
+						if total < bestValue {
+							bestValue = total
+							copy(best, included)
+						}
+						total -= value
+						included[i] = false
+					}
+				}
+			}
+		}
+	}
+
+	return best, bestValue
+}
+
+// Adds the largest candidates until target is reached; pool must be sorted by
+// descending effective value
+func selectLargestFirst(pool []coinCandidate, target blockchainparser.Amount) ([]int, bool) {
+	var selected []int
+	total := blockchainparser.Amount(0)
+	for i := range pool {
+		if total >= target {
+			break
+		}
+		selected = append(selected, i)
+		total += pool[i].effectiveValue
+	}
+
+	return selected, total >= target && len(selected) > 0
+}
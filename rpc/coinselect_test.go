@@ -0,0 +1,246 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+// Mainnet P2WPKH address and scriptPubKey hex of a 20 byte program filled with b
+func p2wpkhForTest(t *testing.T, b byte) (string, string) {
+	program := bytes.Repeat([]byte{b}, 20)
+	address, err := blockchainparser.EncodeSegwitAddress("bc", 0, program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return address, "0014" + hex.EncodeToString(program)
+}
+
+// P2WPKH unspent outputs of the given amounts
+func unspentForTest(t *testing.T, amounts ...blockchainparser.Amount) UnspentTxs {
+	_, script := p2wpkhForTest(t, 0xaa)
+	var unspent UnspentTxs
+	for i, amount := range amounts {
+		unspent = append(unspent, UnspentTx{Txid: "00", Vout: i, ScriptPubKey: script, Amount: amount})
+	}
+
+	return unspent
+}
+
+func selectionAmounts(selection *CoinSelection) []blockchainparser.Amount {
+	var amounts []blockchainparser.Amount
+	for _, utxo := range selection.Inputs {
+		amounts = append(amounts, utxo.Amount)
+	}
+
+	return amounts
+}
+
+// P2WPKH inputs are 68 vB, a P2WPKH output 31 vB and the rest of the
+// transaction 11 vB. At 1 sat/vB with the same long term fee rate inputs
+// add no waste, so the waste is the excess or the cost of change.
+func coinSelectionParamsForTest(t *testing.T, strategy string, payment blockchainparser.Amount) *CoinSelectionParams {
+	to, _ := p2wpkhForTest(t, 0x01)
+	change, _ := p2wpkhForTest(t, 0x02)
+
+	return &CoinSelectionParams{
+		Outputs:         map[string]blockchainparser.Amount{to: payment},
+		ChangeAddress:   change,
+		FeeRate:         1000,
+		LongTermFeeRate: 1000,
+		Strategy:        strategy,
+		Rand:            rand.New(rand.NewSource(1)),
+	}
+}
+
+func TestSelectCoinsBnBExactMatch(t *testing.T) {
+	// Target of 100000 + 42; 60000 and 40042 of effective value match it exactly
+	unspent := unspentForTest(t, 200000, 60068, 30068, 40110, 1000)
+	params := coinSelectionParamsForTest(t, COIN_SELECTION_AUTO, 100000)
+
+	selection, err := unspent.SelectCoins(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	amounts := selectionAmounts(selection)
+	if selection.Algorithm != COIN_SELECTION_BNB || len(amounts) != 2 || amounts[0] != 60068 || amounts[1] != 40110 {
+		t.Errorf("Got %s with %v", selection.Algorithm, amounts)
+	}
+	if selection.Change != 0 || selection.Waste != 0 || selection.Fee != 42+2*68 || len(selection.Outputs) != 1 {
+		t.Errorf("Got %+v", selection)
+	}
+}
+
+func TestSelectCoinsKnapsack(t *testing.T) {
+	unspent := unspentForTest(t, 50000, 80000, 150000, 20000)
+	payment := blockchainparser.Amount(100000)
+
+	var first *CoinSelection
+	for i := 0; i < 2; i++ {
+		params := coinSelectionParamsForTest(t, COIN_SELECTION_KNAPSACK, payment)
+		selection, err := unspent.SelectCoins(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if selection.Algorithm != COIN_SELECTION_KNAPSACK || selection.Change <= 0 || selection.Outputs[params.ChangeAddress] != selection.Change {
+			t.Fatalf("Got %+v", selection)
+		}
+
+		inputValue := blockchainparser.Amount(0)
+		for _, amount := range selectionAmounts(selection) {
+			inputValue += amount
+		}
+		// The fee pays for the inputs, the payment, the change output and the overhead
+		if selection.Fee != blockchainparser.Amount(11+31+31+68*len(selection.Inputs)) || inputValue != payment+selection.Change+selection.Fee {
+			t.Errorf("Inputs of %d paying %d with %d of change and %d of fee", inputValue, payment, selection.Change, selection.Fee)
+		}
+		if selection.Waste != params.FeeRate.Fee(31)+DEFAULT_DISCARD_FEE.Fee(68) {
+			t.Errorf("Waste %d, want the cost of change", selection.Waste)
+		}
+
+		// The same seed gives the same selection
+		if first == nil {
+			first = selection
+		} else if len(first.Inputs) != len(selection.Inputs) || first.Change != selection.Change {
+			t.Errorf("Got %v then %v", selectionAmounts(first), selectionAmounts(selection))
+		}
+	}
+}
+
+func TestSelectCoinsLargestFirst(t *testing.T) {
+	unspent := unspentForTest(t, 10000, 70000, 50000, 30000)
+	params := coinSelectionParamsForTest(t, COIN_SELECTION_LARGEST_FIRST, 100000)
+
+	selection, err := unspent.SelectCoins(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	amounts := selectionAmounts(selection)
+	if selection.Algorithm != COIN_SELECTION_LARGEST_FIRST || len(amounts) != 2 || amounts[0] != 70000 || amounts[1] != 50000 {
+		t.Errorf("Got %s with %v", selection.Algorithm, amounts)
+	}
+	// 119864 of effective value, minus the target and the change output
+	if selection.Change != 119864-100042-31 || selection.Fee != 42+2*68+31 {
+		t.Errorf("Got %+v", selection)
+	}
+}
+
+// Change worth less than spending it later is given to the miner
+func TestSelectCoinsDustChange(t *testing.T) {
+	// 500 over the target: 469 of change is below the 681 that spending it costs
+	for _, strategy := range []string{COIN_SELECTION_BNB, COIN_SELECTION_LARGEST_FIRST} {
+		unspent := unspentForTest(t, 100042+500+68)
+		selection, err := unspent.SelectCoins(coinSelectionParamsForTest(t, strategy, 100000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if selection.Change != 0 || selection.Fee != 610 || selection.Waste != 500 || len(selection.Outputs) != 1 {
+			t.Errorf("%s: got %+v", strategy, selection)
+		}
+	}
+
+	// Enough for change, but nowhere to send it
+	params := coinSelectionParamsForTest(t, COIN_SELECTION_LARGEST_FIRST, 100000)
+	params.ChangeAddress = ""
+	if _, err := unspentForTest(t, 200000).SelectCoins(params); err != ErrNoChangeAddress {
+		t.Errorf("Got %v, want ErrNoChangeAddress", err)
+	}
+}
+
+func TestSelectCoinsInsufficientFunds(t *testing.T) {
+	for _, strategy := range []string{COIN_SELECTION_AUTO, COIN_SELECTION_BNB, COIN_SELECTION_KNAPSACK, COIN_SELECTION_LARGEST_FIRST} {
+		// 100110 of inputs, 136 of which go to their fees
+		unspent := unspentForTest(t, 60000, 40110)
+		if _, err := unspent.SelectCoins(coinSelectionParamsForTest(t, strategy, 100000)); err != ErrInsufficientFunds {
+			t.Errorf("%s: got %v", strategy, err)
+		}
+	}
+
+	// Outputs costing more to spend than they are worth, or of unknown type, are ignored
+	unspent := unspentForTest(t, 68, 200000)
+	unspent[1].ScriptPubKey = "6a"
+	if _, err := unspent.SelectCoins(coinSelectionParamsForTest(t, COIN_SELECTION_AUTO, 1000)); err != ErrInsufficientFunds {
+		t.Errorf("Got %v", err)
+	}
+
+	if _, err := unspentForTest(t, 200000).SelectCoins(coinSelectionParamsForTest(t, COIN_SELECTION_AUTO, 293)); err != ErrDustOutput {
+		t.Errorf("Dust output: got %v", err)
+	}
+}
+
+// At 10 sat/vB against a long term 1 sat/vB, each input wastes 612 and change
+// costs 310 to create and 680 to spend
+func TestSelectCoinsLeastWaste(t *testing.T) {
+	tests := []struct {
+		name      string
+		amounts   []blockchainparser.Amount
+		algorithm string
+		waste     blockchainparser.Amount
+	}{
+		// Two inputs matching the target of 100420 exactly waste less than
+		// one input with change
+		{"exact match", []blockchainparser.Amount{500000, 60680, 41100}, COIN_SELECTION_BNB, 2 * 612},
+		// 700 over the target is more waste than creating change
+		{"large excess", []blockchainparser.Amount{500000, 60680, 41800}, COIN_SELECTION_KNAPSACK, 612 + 310 + 680},
+	}
+
+	for _, test := range tests {
+		params := coinSelectionParamsForTest(t, COIN_SELECTION_AUTO, 100000)
+		params.FeeRate = 10000
+		unspent := unspentForTest(t, test.amounts...)
+		selection, err := unspent.SelectCoins(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if selection.Algorithm != test.algorithm || selection.Waste != test.waste {
+			t.Errorf("%s: got %s with %v, waste %d", test.name, selection.Algorithm, selectionAmounts(selection), selection.Waste)
+		}
+
+		// No single strategy does better
+		for _, strategy := range []string{COIN_SELECTION_BNB, COIN_SELECTION_KNAPSACK, COIN_SELECTION_LARGEST_FIRST} {
+			params.Strategy = strategy
+			params.Rand = rand.New(rand.NewSource(1))
+			if other, err := unspent.SelectCoins(params); err == nil && other.Waste < selection.Waste {
+				t.Errorf("%s: %s wastes %d", test.name, strategy, other.Waste)
+			}
+		}
+	}
+}
+
+func TestGenerateChangeTarget(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	if got := generateChangeTarget(CHANGE_LOWER/2, 31, rng); got != 31+CHANGE_LOWER {
+		t.Errorf("Small payment: got %d", got)
+	}
+	for i := 0; i < 100; i++ {
+		if got := generateChangeTarget(80000, 31, rng); got < 31+CHANGE_LOWER || got >= 31+160000 {
+			t.Errorf("Payment of 80000: got %d", got)
+		}
+		if got := generateChangeTarget(10*blockchainparser.COIN, 31, rng); got < 31+CHANGE_LOWER || got >= 31+CHANGE_UPPER {
+			t.Errorf("Payment of 10 BTC: got %d", got)
+		}
+	}
+}
+
+// bitcoind's well known dust limits at the default 3 sat/vB
+func TestDustThreshold(t *testing.T) {
+	tests := []struct {
+		script string
+		want   blockchainparser.Amount
+	}{
+		{"76a914000000000000000000000000000000000000000088ac", 546},
+		{"0014" + "0000000000000000000000000000000000000000", 294},
+		{"0020" + "0000000000000000000000000000000000000000000000000000000000000000", 330},
+		{"6a0401020304", 0},
+	}
+	for _, test := range tests {
+		script, _ := hex.DecodeString(test.script)
+		if got := DustThreshold(script, DUST_RELAY_TX_FEE); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.script, got, test.want)
+		}
+	}
+}
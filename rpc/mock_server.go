@@ -0,0 +1,649 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+// Handler for a custom method of MockServer
+type MockHandler func(params []json.RawMessage) (interface{}, *RPCError)
+
+// In-process fake bitcoind for tests. It serves the chain and mempool RPCs
+// from blocks added with AddBlock or LoadBlockFiles, checks basic auth when
+// User or Pass are set, accepts batches and can be told to fail.
+type MockServer struct {
+	User string
+	Pass string
+
+	server *httptest.Server
+	mutex  sync.Mutex
+
+	chain   []*blockchainparser.Block // best chain, by height
+	heights map[string]int32          // block hash -> height
+	txs     map[string]mockTx         // confirmed transactions by txid
+	mempool map[string]*blockchainparser.Transaction
+	unspent UnspentTxs
+
+	handlers   map[string]MockHandler
+	errors     map[string]*RPCError
+	httpStatus int
+	calls      []string
+}
+
+type mockTx struct {
+	tx     *blockchainparser.Transaction
+	height int32
+}
+
+type mockRequest struct {
+	Id     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type mockResponse struct {
+	Result interface{}     `json:"result"`
+	Error  *RPCError       `json:"error"`
+	Id     json.RawMessage `json:"id"`
+}
+
+// Starts a mock server; close it with Close
+func NewMockServer(user string, pass string) *MockServer {
+	mock := &MockServer{
+		User:     user,
+		Pass:     pass,
+		heights:  make(map[string]int32),
+		txs:      make(map[string]mockTx),
+		mempool:  make(map[string]*blockchainparser.Transaction),
+		handlers: make(map[string]MockHandler),
+		errors:   make(map[string]*RPCError),
+	}
+	mock.server = httptest.NewServer(mock)
+
+	return mock
+}
+
+func (mock *MockServer) Close() {
+	mock.server.Close()
+}
+
+// Options to reach the server with Client or the package functions
+func (mock *MockServer) Options() *RpcOptions {
+	u, _ := url.Parse(mock.server.URL)
+	return &RpcOptions{
+		Host: u.Hostname(),
+		Port: u.Port(),
+		User: mock.User,
+		Pass: mock.Pass,
+	}
+}
+
+// Appends block to the best chain. The first block is taken as genesis and
+// later blocks must build on the tip. Transactions of the block are removed
+// from the mempool.
+func (mock *MockServer) AddBlock(block *blockchainparser.Block) error {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	if len(mock.chain) > 0 {
+		tip := mock.chain[len(mock.chain)-1]
+		if block.HashPrev.String() != tip.Hash().String() {
+			return errors.New("Block does not connect to the tip: " + block.Hash().String())
+		}
+	}
+
+	height := int32(len(mock.chain))
+	mock.chain = append(mock.chain, block)
+	mock.heights[block.Hash().String()] = height
+	for i := range block.Transactions {
+		txid := block.Transactions[i].Txid().String()
+		mock.txs[txid] = mockTx{&block.Transactions[i], height}
+		delete(mock.mempool, txid)
+	}
+
+	return nil
+}
+
+// Adds the blocks of the blk*.dat files of datadir in chain order, up to the
+// block with the most work (the first one read among equal work blocks, as
+// bitcoind). Blocks of other branches are left out. With blocks already
+// added, only the ones building on the tip are added.
+func (mock *MockServer) LoadBlockFiles(datadir string, magicId blockchainparser.MagicId) error {
+	var read []*blockchainparser.Block
+	for fileNum := uint32(0); ; fileNum++ {
+		blockFile, err := blockchainparser.NewBlockFile(datadir, fileNum)
+		if err != nil {
+			break
+		}
+		size, err := blockFile.Size()
+		if err != nil {
+			blockFile.Close()
+			return err
+		}
+		for {
+			pos, _ := blockFile.Seek(0, 1)
+			if pos >= size {
+				break
+			}
+			block, err := blockchainparser.ParseBlockFromFile(blockFile, magicId)
+			if err != nil {
+				// Zero padding at the end of the file
+				break
+			}
+			read = append(read, block)
+		}
+		blockFile.Close()
+	}
+	if len(read) == 0 {
+		return errors.New("No blocks found in " + datadir)
+	}
+
+	mock.mutex.Lock()
+	tipHash := ""
+	if len(mock.chain) > 0 {
+		tipHash = mock.chain[len(mock.chain)-1].Hash().String()
+	}
+	blocks := make(map[string]*blockchainparser.Block, len(read))
+	for _, block := range read {
+		if _, ok := mock.heights[block.Hash().String()]; !ok {
+			blocks[block.Hash().String()] = block
+		}
+	}
+	mock.mutex.Unlock()
+
+	// Blocks are not stored in chain order: sum the work of each branch from
+	// its first block, whose parent is the tip or, without blocks yet, unknown
+	work := make(map[string]*big.Int, len(blocks))
+	var chainWork func(block *blockchainparser.Block) *big.Int
+	chainWork = func(block *blockchainparser.Block) *big.Int {
+		var branch []*blockchainparser.Block
+		parentWork := new(big.Int)
+		for {
+			hash := block.Hash().String()
+			if known, ok := work[hash]; ok {
+				parentWork = known
+				break
+			}
+			branch = append(branch, block)
+			prev := block.HashPrev.String()
+			if parent, ok := blocks[prev]; ok {
+				block = parent
+				continue
+			}
+			if tipHash == "" || prev == tipHash {
+				break
+			}
+			// Doesn't build on the tip
+			parentWork = nil
+			break
+		}
+
+		for i := len(branch) - 1; i >= 0; i-- {
+			var blockWork *big.Int
+			if parentWork != nil {
+				blockWork = new(big.Int).Add(parentWork, blockchainparser.GetBlockWork(branch[i].TargetDifficulty))
+			}
+			work[branch[i].Hash().String()] = blockWork
+			parentWork = blockWork
+		}
+		return parentWork
+	}
+
+	var best *blockchainparser.Block
+	var bestWork *big.Int
+	for _, block := range read {
+		if _, ok := blocks[block.Hash().String()]; !ok {
+			continue
+		}
+		if blockWork := chainWork(block); blockWork != nil && (bestWork == nil || blockWork.Cmp(bestWork) > 0) {
+			best, bestWork = block, blockWork
+		}
+	}
+
+	var chain []*blockchainparser.Block
+	for block := best; block != nil; block = blocks[block.HashPrev.String()] {
+		chain = append(chain, block)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if err := mock.AddBlock(chain[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (mock *MockServer) AddMempoolTx(tx *blockchainparser.Transaction) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	mock.mempool[tx.Txid().String()] = tx
+}
+
+// Sets the result of listunspent
+func (mock *MockServer) SetUnspent(unspent UnspentTxs) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	mock.unspent = unspent
+}
+
+// Serves method with handler, overriding any builtin method
+func (mock *MockServer) Handle(method string, handler MockHandler) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	mock.handlers[method] = handler
+}
+
+// Makes every call of method fail with err until cleared with a nil err
+func (mock *MockServer) SetError(method string, err *RPCError) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	if err == nil {
+		delete(mock.errors, method)
+	} else {
+		mock.errors[method] = err
+	}
+}
+
+// Makes every request fail with the HTTP status, e.g. 403 or 503, until
+// cleared with 0
+func (mock *MockServer) SetHTTPStatus(status int) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	mock.httpStatus = status
+}
+
+// Methods called so far, in order
+func (mock *MockServer) Calls() []string {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	return append([]string(nil), mock.calls...)
+}
+
+func (mock *MockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mock.mutex.Lock()
+	status := mock.httpStatus
+	mock.mutex.Unlock()
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	if mock.User != "" || mock.Pass != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != mock.User || pass != mock.Pass {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		var requests []mockRequest
+		if err := json.Unmarshal(body, &requests); err != nil {
+			mock.writeResponse(w, mockResponse{Error: &RPCError{RPC_PARSE_ERROR, "Parse error"}})
+			return
+		}
+		responses := make([]mockResponse, len(requests))
+		for i, request := range requests {
+			responses[i] = mock.call(request)
+		}
+		json.NewEncoder(w).Encode(responses)
+		return
+	}
+
+	var request mockRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		mock.writeResponse(w, mockResponse{Error: &RPCError{RPC_PARSE_ERROR, "Parse error"}})
+		return
+	}
+	mock.writeResponse(w, mock.call(request))
+}
+
+// Writes a single response with the HTTP status bitcoind uses
+func (mock *MockServer) writeResponse(w http.ResponseWriter, response mockResponse) {
+	if response.Error != nil {
+		switch response.Error.Code {
+		case RPC_METHOD_NOT_FOUND:
+			w.WriteHeader(http.StatusNotFound)
+		case RPC_INVALID_REQUEST, RPC_PARSE_ERROR:
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (mock *MockServer) call(request mockRequest) mockResponse {
+	mock.mutex.Lock()
+	mock.calls = append(mock.calls, request.Method)
+	injected := mock.errors[request.Method]
+	handler := mock.handlers[request.Method]
+	mock.mutex.Unlock()
+
+	response := mockResponse{Id: request.Id}
+	if injected != nil {
+		response.Error = injected
+	} else if handler != nil {
+		response.Result, response.Error = handler(request.Params)
+	} else {
+		mock.mutex.Lock()
+		response.Result, response.Error = mock.builtin(request.Method, request.Params)
+		mock.mutex.Unlock()
+	}
+
+	return response
+}
+
+func (mock *MockServer) builtin(method string, params []json.RawMessage) (interface{}, *RPCError) {
+	switch method {
+	case "getinfo", "getnetworkinfo":
+		return map[string]interface{}{
+			"version":    270000,
+			"subversion": "/Satoshi:27.0.0/",
+			"blocks":     len(mock.chain) - 1,
+		}, nil
+	case "getblockchaininfo":
+		info := map[string]interface{}{
+			"chain":   "regtest",
+			"blocks":  len(mock.chain) - 1,
+			"headers": len(mock.chain) - 1,
+		}
+		if len(mock.chain) > 0 {
+			info["bestblockhash"] = mock.chain[len(mock.chain)-1].Hash().String()
+		}
+		return info, nil
+	case "getblockcount":
+		return len(mock.chain) - 1, nil
+	case "getbestblockhash":
+		if len(mock.chain) == 0 {
+			return nil, &RPCError{RPC_MISC_ERROR, "No blocks"}
+		}
+		return mock.chain[len(mock.chain)-1].Hash().String(), nil
+	case "getblockhash":
+		var height int
+		if err := mockParam(params, 0, &height); err != nil {
+			return nil, err
+		}
+		if height < 0 || height >= len(mock.chain) {
+			return nil, &RPCError{RPC_INVALID_PARAMETER, "Block height out of range"}
+		}
+		return mock.chain[height].Hash().String(), nil
+	case "getblock":
+		block, height, err := mock.blockParam(params)
+		if err != nil {
+			return nil, err
+		}
+		verbosity := BLOCK_VERBOSITY_TXID
+		if err := mockVerbosityParam(params, 1, &verbosity); err != nil {
+			return nil, err
+		}
+		switch verbosity {
+		case BLOCK_VERBOSITY_HEX:
+			return hex.EncodeToString(block.Binary()), nil
+		case BLOCK_VERBOSITY_TXID:
+			return mock.blockResult(block, height), nil
+		default:
+			return mock.blockVerboseTxResult(block, height), nil
+		}
+	case "getblockheader":
+		block, height, err := mock.blockParam(params)
+		if err != nil {
+			return nil, err
+		}
+		verbose := true
+		if len(params) > 1 {
+			if err := mockParam(params, 1, &verbose); err != nil {
+				return nil, err
+			}
+		}
+		if !verbose {
+			return hex.EncodeToString(block.BlockHeader.Binary()), nil
+		}
+		return mock.headerResult(block, height), nil
+	case "getrawtransaction":
+		var txid string
+		if err := mockParam(params, 0, &txid); err != nil {
+			return nil, err
+		}
+		verbose := 0
+		if err := mockVerbosityParam(params, 1, &verbose); err != nil {
+			return nil, err
+		}
+		var result *TxResult
+		if tx, ok := mock.mempool[txid]; ok {
+			result = mock.txResult(tx, -1)
+		} else if confirmed, ok := mock.txs[txid]; ok {
+			result = mock.txResult(confirmed.tx, confirmed.height)
+		} else {
+			return nil, &RPCError{RPC_INVALID_ADDRESS_OR_KEY, "No such mempool or blockchain transaction. Use gettransaction for wallet transactions."}
+		}
+		if verbose == 0 {
+			return result.Hex, nil
+		}
+		return result, nil
+	case "getrawmempool":
+		txids := make([]string, 0, len(mock.mempool))
+		for txid := range mock.mempool {
+			txids = append(txids, txid)
+		}
+		sort.Strings(txids)
+		return txids, nil
+	case "listunspent":
+		if mock.unspent == nil {
+			return UnspentTxs{}, nil
+		}
+		return mock.unspent, nil
+	case "sendrawtransaction":
+		var txHex string
+		if err := mockParam(params, 0, &txHex); err != nil {
+			return nil, err
+		}
+		b, err := hex.DecodeString(txHex)
+		if err != nil {
+			return nil, &RPCError{RPC_DESERIALIZATION_ERROR, "TX decode failed"}
+		}
+		tx, err := blockchainparser.ParseTransactionFromBytes(b)
+		if err != nil {
+			return nil, &RPCError{RPC_DESERIALIZATION_ERROR, "TX decode failed"}
+		}
+		txid := tx.Txid().String()
+		if _, ok := mock.txs[txid]; ok {
+			return nil, &RPCError{RPC_VERIFY_ALREADY_IN_CHAIN, "Transaction already in block chain"}
+		}
+		mock.mempool[txid] = tx
+		return txid, nil
+	}
+
+	return nil, &RPCError{RPC_METHOD_NOT_FOUND, "Method not found"}
+}
+
+func mockParam(params []json.RawMessage, i int, v interface{}) *RPCError {
+	if i >= len(params) {
+		return &RPCError{RPC_INVALID_PARAMS, fmt.Sprintf("Missing parameter %d", i+1)}
+	}
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return &RPCError{RPC_TYPE_ERROR, fmt.Sprintf("Invalid parameter %d: %s", i+1, err)}
+	}
+
+	return nil
+}
+
+// Optional verbosity given as a number or a boolean
+func mockVerbosityParam(params []json.RawMessage, i int, verbosity *int) *RPCError {
+	if i >= len(params) || string(params[i]) == "null" {
+		return nil
+	}
+	var verbose bool
+	if json.Unmarshal(params[i], &verbose) == nil {
+		*verbosity = 0
+		if verbose {
+			*verbosity = 1
+		}
+		return nil
+	}
+
+	return mockParam(params, i, verbosity)
+}
+
+func (mock *MockServer) blockParam(params []json.RawMessage) (*blockchainparser.Block, int32, *RPCError) {
+	var hash string
+	if err := mockParam(params, 0, &hash); err != nil {
+		return nil, 0, err
+	}
+	height, ok := mock.heights[hash]
+	if !ok {
+		return nil, 0, &RPCError{RPC_INVALID_ADDRESS_OR_KEY, "Block not found"}
+	}
+
+	return mock.chain[height], height, nil
+}
+
+func (mock *MockServer) headerResult(block *blockchainparser.Block, height int32) BlockHeaderResult {
+	tip := int32(len(mock.chain) - 1)
+
+	// Median of the timestamps of the last 11 blocks
+	times := make([]int64, 0, 11)
+	for h := height; h >= 0 && h > height-11; h-- {
+		times = append(times, mock.chain[h].Timestamp.Unix())
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	chainwork := new(big.Int)
+	for h := int32(0); h <= height; h++ {
+		chainwork.Add(chainwork, blockchainparser.GetBlockWork(mock.chain[h].TargetDifficulty))
+	}
+
+	result := BlockHeaderResult{
+		Hash:          block.Hash().String(),
+		Confirmations: int64(tip - height + 1),
+		Height:        height,
+		Version:       block.Version,
+		VersionHex:    fmt.Sprintf("%08x", uint32(block.Version)),
+		MerkleRoot:    block.HashMerkle.String(),
+		Time:          block.Timestamp.Unix(),
+		MedianTime:    times[len(times)/2],
+		Nonce:         block.Nonce,
+		Bits:          fmt.Sprintf("%08x", block.TargetDifficulty),
+		Difficulty:    blockchainparser.GetDifficulty(block.TargetDifficulty),
+		Chainwork:     fmt.Sprintf("%064x", chainwork),
+		NTx:           len(block.Transactions),
+	}
+	if height > 0 {
+		result.PreviousBlockHash = block.HashPrev.String()
+	}
+	if height < tip {
+		result.NextBlockHash = mock.chain[height+1].Hash().String()
+	}
+
+	return result
+}
+
+func (mock *MockServer) blockResult(block *blockchainparser.Block, height int32) BlockResult {
+	result := BlockResult{
+		BlockHeaderResult: mock.headerResult(block, height),
+		StrippedSize:      block.StrippedSize(),
+		Size:              block.TotalSize(),
+		Weight:            block.Weight(),
+		Tx:                make([]string, len(block.Transactions)),
+	}
+	for i, tx := range block.Transactions {
+		result.Tx[i] = tx.Txid().String()
+	}
+
+	return result
+}
+
+func (mock *MockServer) blockVerboseTxResult(block *blockchainparser.Block, height int32) BlockVerboseTxResult {
+	result := BlockVerboseTxResult{
+		BlockHeaderResult: mock.headerResult(block, height),
+		StrippedSize:      block.StrippedSize(),
+		Size:              block.TotalSize(),
+		Weight:            block.Weight(),
+		Tx:                make([]TxResult, len(block.Transactions)),
+	}
+	for i := range block.Transactions {
+		result.Tx[i] = *mock.txResult(&block.Transactions[i], -1)
+	}
+
+	return result
+}
+
+// Decoded transaction; height is -1 for mempool transactions
+func (mock *MockServer) txResult(tx *blockchainparser.Transaction, height int32) *TxResult {
+	result := &TxResult{
+		Txid:     tx.Txid().String(),
+		Hash:     tx.Wtxid().String(),
+		Version:  tx.Version,
+		Size:     tx.TotalSize(),
+		VSize:    tx.VSize(),
+		Weight:   tx.Weight(),
+		Locktime: tx.Locktime,
+		Vin:      make([]TxInResult, len(tx.Vin)),
+		Vout:     make([]TxOutResult, len(tx.Vout)),
+		Hex:      hex.EncodeToString(tx.Binary()),
+	}
+
+	isCoinbase := tx.IsCoinbase()
+	for i, in := range tx.Vin {
+		input := TxInResult{Sequence: in.Sequence}
+		if isCoinbase {
+			input.Coinbase = hex.EncodeToString(in.Script)
+		} else {
+			input.Txid = in.Hash.String()
+			input.Vout = in.Index
+			input.ScriptSig = &ScriptSigResult{in.Script.Disasm(), hex.EncodeToString(in.Script)}
+		}
+		for _, item := range in.ScriptWitness {
+			input.TxInWitness = append(input.TxInWitness, hex.EncodeToString(item))
+		}
+		result.Vin[i] = input
+	}
+
+	for i, out := range tx.Vout {
+		outType, _ := out.Script.Type()
+		result.Vout[i] = TxOutResult{
+			Value: out.Value,
+			N:     uint32(i),
+			ScriptPubKey: ScriptPubKeyResult{
+				Asm:  out.Script.Disasm(),
+				Hex:  hex.EncodeToString(out.Script),
+				Type: outType.String(),
+			},
+		}
+	}
+
+	if height >= 0 {
+		block := mock.chain[height]
+		result.BlockHash = block.Hash().String()
+		result.Confirmations = int64(len(mock.chain)) - int64(height)
+		result.Time = block.Timestamp.Unix()
+		result.BlockTime = block.Timestamp.Unix()
+	}
+
+	return result
+}
@@ -0,0 +1,217 @@
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+func mockBlockForTest(hashPrev blockchainparser.Hash256, tag byte) *blockchainparser.Block {
+	coinbase := blockchainparser.Transaction{
+		Version: 1,
+		Vin:     []blockchainparser.TxInput{{Hash: make(blockchainparser.Hash256, 32), Index: 0xffffffff, Script: blockchainparser.Script{1, tag}, Sequence: 0xffffffff}},
+		Vout:    []blockchainparser.TxOutput{{Value: 5000000000, Script: blockchainparser.Script{blockchainparser.OP_1}}},
+	}
+	block := &blockchainparser.Block{Transactions: []blockchainparser.Transaction{coinbase}}
+	block.Version = 4
+	block.HashPrev = hashPrev
+	block.Timestamp = time.Unix(1600000000+int64(tag), 0)
+	block.TargetDifficulty = 0x207fffff
+	block.HashMerkle = block.MerkleRoot()
+
+	return block
+}
+
+func writeBlockFileForTest(t *testing.T, datadir string, fileNum uint32, blocks ...*blockchainparser.Block) {
+	var data []byte
+	for _, block := range blocks {
+		body := block.Binary()
+		header := make([]byte, 8)
+		binary.LittleEndian.PutUint32(header, uint32(blockchainparser.BLOCK_MAGIC_ID_REGTEST))
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(body)))
+		data = append(append(data, header...), body...)
+	}
+	data = append(data, make([]byte, 64)...) // preallocated space
+
+	if err := os.MkdirAll(datadir+"/blocks", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fmt.Sprintf(datadir+"/blocks/blk%05d.dat", fileNum), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mockChainHashes(t *testing.T, mock *MockServer) []string {
+	client := NewClient(mock.Options())
+	count, err := client.GetBlockCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hashes []string
+	for height := int32(0); height <= count; height++ {
+		hash, err := client.GetBlockHash(context.Background(), height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash.String())
+	}
+
+	return hashes
+}
+
+func TestMockServerAuth(t *testing.T) {
+	mock := NewMockServer("user", "pass")
+	defer mock.Close()
+	ctx := context.Background()
+
+	if _, err := NewClient(mock.Options()).GetRawMempool(ctx); err != nil {
+		t.Errorf("Valid credentials: %s", err)
+	}
+	for _, credentials := range [][2]string{{"user", "wrong"}, {"other", "pass"}, {"", ""}} {
+		options := mock.Options()
+		options.User, options.Pass = credentials[0], credentials[1]
+		if _, err := NewClient(options).GetRawMempool(ctx); err != ErrUnauthorized {
+			t.Errorf("%v: got %v", credentials, err)
+		}
+	}
+
+	open := NewMockServer("", "")
+	defer open.Close()
+	options := open.Options()
+	options.User, options.Pass = "any", "thing"
+	if _, err := NewClient(options).GetRawMempool(ctx); err != nil {
+		t.Errorf("Server without auth: %s", err)
+	}
+}
+
+func TestMockServerBatch(t *testing.T) {
+	mock := NewMockServer("", "")
+	defer mock.Close()
+	genesis := mockBlockForTest(make(blockchainparser.Hash256, 32), 0)
+	if err := mock.AddBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := NewClient(mock.Options()).Batch(context.Background(), []BatchRequest{
+		NewBatchRequest("getblockhash", 0),
+		NewBatchRequest("getblockhash", 1),
+		NewBatchRequest("nosuchmethod"),
+		NewBatchRequest("getblockcount"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Got %d results", len(results))
+	}
+
+	var hash string
+	if err := results[0].Decode(&hash); err != nil || hash != genesis.Hash().String() {
+		t.Errorf("getblockhash 0: got %q, %v", hash, err)
+	}
+	var rpcErr *RPCError
+	if !errors.As(results[1].Err, &rpcErr) || rpcErr.Code != RPC_INVALID_PARAMETER {
+		t.Errorf("getblockhash 1: got %v", results[1].Err)
+	}
+	if !errors.As(results[2].Err, &rpcErr) || rpcErr.Code != RPC_METHOD_NOT_FOUND {
+		t.Errorf("Unknown method: got %v", results[2].Err)
+	}
+	var count int32
+	if err := results[3].Decode(&count); err != nil || count != 0 {
+		t.Errorf("getblockcount: got %d, %v", count, err)
+	}
+
+	calls := mock.Calls()
+	if len(calls) != 4 || calls[0] != "getblockhash" || calls[2] != "nosuchmethod" {
+		t.Errorf("Got calls %v", calls)
+	}
+}
+
+func TestMockServerFailures(t *testing.T) {
+	mock := NewMockServer("", "")
+	defer mock.Close()
+	client := NewClient(mock.Options())
+	ctx := context.Background()
+
+	mock.SetError("getrawmempool", &RPCError{RPC_IN_WARMUP, "Loading block index..."})
+	_, err := client.GetRawMempool(ctx)
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != RPC_IN_WARMUP || rpcErr.Message != "Loading block index..." {
+		t.Errorf("SetError: got %v", err)
+	}
+	if _, err := client.GetBlockCount(ctx); err != nil {
+		t.Errorf("Other methods: %s", err)
+	}
+	mock.SetError("getrawmempool", nil)
+	if _, err := client.GetRawMempool(ctx); err != nil {
+		t.Errorf("Cleared error: %s", err)
+	}
+
+	mock.SetHTTPStatus(http.StatusForbidden)
+	if _, err := client.GetRawMempool(ctx); err != ErrForbidden {
+		t.Errorf("HTTP 403: got %v", err)
+	}
+	mock.SetHTTPStatus(http.StatusServiceUnavailable)
+	var httpErr *HTTPError
+	if _, err := client.GetRawMempool(ctx); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("HTTP 503: got %v", err)
+	}
+	mock.SetHTTPStatus(0)
+	if _, err := client.GetRawMempool(ctx); err != nil {
+		t.Errorf("Cleared status: %s", err)
+	}
+}
+
+func TestMockServerLoadBlockFiles(t *testing.T) {
+	genesis := mockBlockForTest(make(blockchainparser.Hash256, 32), 0)
+	a1 := mockBlockForTest(genesis.Hash(), 1)
+	a2 := mockBlockForTest(a1.Hash(), 2)
+	b1 := mockBlockForTest(genesis.Hash(), 11)
+	b2 := mockBlockForTest(b1.Hash(), 12)
+	b3 := mockBlockForTest(b2.Hash(), 13)
+
+	// The longer branch wins whatever the order of the files
+	datadir := t.TempDir()
+	writeBlockFileForTest(t, datadir, 0, a2, b1, genesis, a1)
+	writeBlockFileForTest(t, datadir, 1, b3, b2)
+	mock := NewMockServer("", "")
+	defer mock.Close()
+	if err := mock.LoadBlockFiles(datadir, blockchainparser.BLOCK_MAGIC_ID_REGTEST); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{genesis.Hash().String(), b1.Hash().String(), b2.Hash().String(), b3.Hash().String()}
+	if hashes := mockChainHashes(t, mock); len(hashes) != len(want) || hashes[1] != want[1] || hashes[3] != want[3] {
+		t.Errorf("Got chain %v, want %v", hashes, want)
+	}
+
+	// Among branches of equal work, the first block read
+	datadir = t.TempDir()
+	writeBlockFileForTest(t, datadir, 0, genesis, b1, a1, a2, b2)
+	mock = NewMockServer("", "")
+	defer mock.Close()
+	if err := mock.LoadBlockFiles(datadir, blockchainparser.BLOCK_MAGIC_ID_REGTEST); err != nil {
+		t.Fatal(err)
+	}
+	if hashes := mockChainHashes(t, mock); len(hashes) != 3 || hashes[2] != a2.Hash().String() {
+		t.Errorf("Got chain %v, want the a branch", hashes)
+	}
+
+	// Loading again extends the tip only
+	datadir = t.TempDir()
+	a3 := mockBlockForTest(a2.Hash(), 3)
+	writeBlockFileForTest(t, datadir, 0, genesis, a1, a2, b1, b2, b3, a3)
+	if err := mock.LoadBlockFiles(datadir, blockchainparser.BLOCK_MAGIC_ID_REGTEST); err != nil {
+		t.Fatal(err)
+	}
+	if hashes := mockChainHashes(t, mock); len(hashes) != 4 || hashes[3] != a3.Hash().String() {
+		t.Errorf("Got chain %v after reload", hashes)
+	}
+}
@@ -1,86 +1,92 @@
 package rpc
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
-	"fmt"
-	"io/ioutil"
-	"math"
-	"math/rand"
-	"net/http"
-	"strconv"
-	"time"
+
+	"github.com/ruqqq/blockchainparser"
 )
 
 func Check(options *RpcOptions) (bool, error) {
-	_, err := Cmd("getinfo", options)
-	if err != nil {
-		return false, err
-	}
-
-	return true, nil
+	return NewClient(options).Check(context.Background())
 }
 
 func ListUnspent(options *RpcOptions) (UnspentTxs, error) {
-	body, err := Cmd("listunspent", options)
-	if err != nil {
-		return nil, err
-	}
+	return NewClient(options).ListUnspent(context.Background())
+}
+
+func GetRawMempool(options *RpcOptions) ([]string, error) {
+	return NewClient(options).GetRawMempool(context.Background())
+}
 
-	var result UnspentTxRPCResult
-	err = json.Unmarshal(body, &result)
+func CreateRawTransaction(inputs UnspentTxs, outputs map[string]blockchainparser.Amount, options *RpcOptions) (RawTxn, error) {
+	return NewClient(options).CreateRawTransaction(context.Background(), inputs, outputs)
+}
+
+// Deprecated: signrawtransaction was removed in bitcoind 0.18, use
+// Client.SignRawTransactionWithWallet or Client.SignRawTransactionWithKey.
+func SignRawTransaction(txn RawTxn, prevTxns UnspentTxs, options *RpcOptions) (SignedTx, error) {
+	return NewClient(options).SignRawTransaction(context.Background(), txn, prevTxns)
+}
+
+func SendRawTransaction(rawTxn string, options *RpcOptions) (string, error) {
+	return NewClient(options).SendRawTransaction(context.Background(), rawTxn)
+}
+
+func CmdAsSingleResult(command string, options *RpcOptions, args ...interface{}) (interface{}, error) {
+	var result interface{}
+	err := NewClient(options).Call(context.Background(), command, &result, args...)
 	if err != nil {
-		return nil, err
-	}
-	if result.Error.Message != "" {
-		return nil, errors.New(result.Error.Message)
+		return "", err
 	}
 
-	return result.Result, nil
+	return result, nil
 }
 
-func GetRawMempool(options *RpcOptions) ([]string, error) {
-	body, err := Cmd("getrawmempool", options)
+// Returns the raw JSON-RPC response body of command
+func Cmd(command string, options *RpcOptions, args ...interface{}) ([]byte, error) {
+	return NewClient(options).RawCall(context.Background(), command, args...)
+}
+
+func (client *Client) Check(ctx context.Context) (bool, error) {
+	err := client.Call(ctx, "getinfo", nil)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	var result MempoolRPCResult
-	err = json.Unmarshal(body, &result)
+	return true, nil
+}
+
+func (client *Client) ListUnspent(ctx context.Context) (UnspentTxs, error) {
+	var result UnspentTxs
+	err := client.Call(ctx, "listunspent", &result)
 	if err != nil {
 		return nil, err
 	}
-	if result.Error.Message != "" {
-		return nil, errors.New(result.Error.Message)
-	}
 
-	return result.Result, nil
+	return result, nil
 }
 
-func CreateRawTransaction(inputs UnspentTxs, outputs map[string]float32, options *RpcOptions) (RawTxn, error) {
-	body, err := Cmd("createrawtransaction", options, inputs, outputs)
+func (client *Client) GetRawMempool(ctx context.Context) ([]string, error) {
+	var result []string
+	err := client.Call(ctx, "getrawmempool", &result)
 	if err != nil {
-		return RawTxn{}, err
+		return nil, err
 	}
 
-	result := make(map[string]interface{})
-	err = json.Unmarshal(body, &result)
+	return result, nil
+}
+
+func (client *Client) CreateRawTransaction(ctx context.Context, inputs UnspentTxs, outputs map[string]blockchainparser.Amount) (RawTxn, error) {
+	var result string
+	err := client.Call(ctx, "createrawtransaction", &result, inputs, outputs)
 	if err != nil {
 		return RawTxn{}, err
 	}
-	if errMap, ok := result["error"]; ok && errMap != nil {
-		return RawTxn{}, errors.New(errMap.(map[string]interface{})["message"].(string))
-	}
-
-	if _, ok := result["result"]; !ok {
-		return RawTxn{}, errors.New("Can't find result field")
-	}
 
 	// hex to []byte
-	rawTxnHex, err := hex.DecodeString(result["result"].(string))
+	rawTxnHex, err := hex.DecodeString(result)
 	if err != nil {
 		return RawTxn{}, err
 	}
@@ -94,123 +100,43 @@ func CreateRawTransaction(inputs UnspentTxs, outputs map[string]float32, options
 	return rawTxn, nil
 }
 
-func SignRawTransaction(txn RawTxn, prevTxns UnspentTxs, options *RpcOptions) (SignedTx, error) {
+// Deprecated: see SignRawTransaction
+func (client *Client) SignRawTransaction(ctx context.Context, txn RawTxn, prevTxns UnspentTxs) (SignedTx, error) {
 	rawTxn, err := pack_txn(txn)
 	if err != nil {
 		return SignedTx{}, err
 	}
 
-	fmt.Printf("rawTxn: %s\n", hex.EncodeToString(rawTxn))
-	var body []byte
-
-	if prevTxns != nil && len(prevTxns) != 0 {
-		prevTxnsJson, err := json.Marshal(prevTxns)
-		if err != nil {
-			return SignedTx{}, err
-		}
-		fmt.Printf("prevTxnsJson: %s\n", prevTxnsJson)
-		body, err = Cmd("signrawtransaction", options, hex.EncodeToString(rawTxn), prevTxns)
-		if err != nil {
-			return SignedTx{}, err
-		}
-	} else {
-		body, err = Cmd("signrawtransaction", options, hex.EncodeToString(rawTxn))
-		if err != nil {
-			return SignedTx{}, err
-		}
+	args := []interface{}{hex.EncodeToString(rawTxn)}
+	if len(prevTxns) != 0 {
+		args = append(args, prevTxns)
 	}
 
-	var result SignedTxRPCResult
-	err = json.Unmarshal(body, &result)
+	var result SignedTx
+	err = client.Call(ctx, "signrawtransaction", &result, args...)
 	if err != nil {
 		return SignedTx{}, err
 	}
-	if len(result.Result.Errors) > 0 {
-		return SignedTx{}, errors.New(result.Result.Errors[0].Error)
-	} else if result.Error.Code > 0 {
-		return SignedTx{}, errors.New("Code " + strconv.Itoa(result.Error.Code) + ": " + result.Error.Message)
-	} else if !result.Result.Complete {
-		return SignedTx{}, errors.New("Unknown error occured: " + string(body))
+	if len(result.Errors) > 0 {
+		return SignedTx{}, errors.New(result.Errors[0].Error)
+	} else if !result.Complete {
+		return SignedTx{}, errors.New("Transaction signing is incomplete")
 	}
 
-	return result.Result, nil
+	return result, nil
 }
 
-func SendRawTransaction(rawTxn string, options *RpcOptions) (string, error) {
-	sendTxid, err := CmdAsSingleResult("sendrawtransaction", options, rawTxn)
+func (client *Client) SendRawTransaction(ctx context.Context, rawTxn string) (string, error) {
+	var sendTxid string
+	err := client.Call(ctx, "sendrawtransaction", &sendTxid, rawTxn)
 	if err != nil {
 		return "", err
 	}
-	if len(sendTxid.(string)) != 64 {
+	if len(sendTxid) != 64 {
 		return "", errors.New("Can't send transaction")
 	}
 
-	return sendTxid.(string), nil
-}
-
-func CmdAsSingleResult(command string, options *RpcOptions, args ...interface{}) (interface{}, error) {
-	body, err := Cmd(command, options, args...)
-	if err != nil {
-		return "", err
-	}
-
-	result := make(map[string]interface{})
-	err = json.Unmarshal(body, &result)
-	if err != nil {
-		return "", err
-	}
-	if errMap, ok := result["error"]; ok && errMap != nil {
-		return "", errors.New(errMap.(map[string]interface{})["message"].(string))
-	}
-
-	if res, ok := result["result"]; ok {
-		return res, nil
-	} else {
-		return "", errors.New("Can't find result field")
-	}
-}
-
-func Cmd(command string, options *RpcOptions, args ...interface{}) ([]byte, error) {
-	// For cmd line
-	//args_compiled := args
-	//if Testnet {
-	//	args_compiled = append([]string{"-Testnet"}, args_compiled...)
-	//}
-	//args_compiled = append(args_compiled, command)
-	//body, err = exec.Command(BITCOIN_PATH, args_compiled...).Output()
-	//if err != nil {
-	//	return nil, err
-	//}
-
-	now := strconv.FormatInt(time.Now().Unix(), 10)
-	random := strconv.FormatInt(rand.Int63n(999999)+100000, 10)
-
-	requestBody := struct {
-		Id     string        `json:"id"`
-		Method string        `json:"method"`
-		Params []interface{} `json:"params"`
-	}{now + "-" + random, command, args}
-
-	client := &http.Client{}
-	port := options.Port
-	if options.Testnet {
-		port = "1" + port
-	}
-	jsonBody, _ := json.Marshal(requestBody)
-	//fmt.Printf("jsonBody: %s\n", jsonBody)
-	req, err := http.NewRequest("POST", "http://"+options.Host+":"+port, bytes.NewBuffer(jsonBody))
-	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth(options.User, options.Pass)
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	return body, nil
+	return sendTxid, nil
 }
 
 //func bitcoin_cmd_as_map(command string) (map[string]interface{}, error) {
@@ -263,116 +189,99 @@ func Cmd(command string, options *RpcOptions, args ...interface{}) ([]byte, erro
 //	}
 //}
 
-func unpack_txn(b []byte) (RawTxn, error) {
-	var txn RawTxn
-	txnBuf := TxnBuf{b, 0}
-	txn.Version = txnBuf.shift_32bit()
-
-	for inputs := txnBuf.shift_varint(); inputs > 0; inputs -= 1 {
-		var input RawTxIn
-		txid := txnBuf.shift_bits(32)
-		// reverse to get txid
-		for i := len(txid)/2 - 1; i >= 0; i-- {
-			opp := len(txid) - 1 - i
-			txid[i], txid[opp] = txid[opp], txid[i]
-		}
-		input.Txid = hex.EncodeToString(txid)
-		input.Vout = txnBuf.shift_32bit()
-		scriptSig := txnBuf.shift_bits(txnBuf.shift_varint())
-		input.ScriptSig = hex.EncodeToString(scriptSig)
-		input.Sequence = txnBuf.shift_32bit()
+// Convert a parsed transaction to its RawTxn representation
+func NewRawTxn(tx *blockchainparser.Transaction) RawTxn {
+	txn := RawTxn{
+		Version:  uint32(tx.Version),
+		Locktime: tx.Locktime,
+		Vin:      make([]RawTxIn, 0, len(tx.Vin)),
+		Vout:     make([]RawTxOut, 0, len(tx.Vout)),
+	}
 
+	for _, in := range tx.Vin {
+		input := RawTxIn{
+			Txid:      in.Hash.String(),
+			Vout:      in.Index,
+			ScriptSig: hex.EncodeToString(in.Script),
+			Sequence:  in.Sequence,
+		}
+		for _, item := range in.ScriptWitness {
+			input.TxInWitness = append(input.TxInWitness, hex.EncodeToString(item))
+		}
 		txn.Vin = append(txn.Vin, input)
 	}
 
-	for outputs := txnBuf.shift_varint(); outputs > 0; outputs -= 1 {
-		var output RawTxOut
-		output.Value = float32(txnBuf.shift_64bit()) / 100000000.0
-		output.ScriptPubKey = hex.EncodeToString(txnBuf.shift_bits(txnBuf.shift_varint()))
-
-		txn.Vout = append(txn.Vout, output)
+	for _, out := range tx.Vout {
+		txn.Vout = append(txn.Vout, RawTxOut{
+			Value:        out.Value,
+			ScriptPubKey: hex.EncodeToString(out.Script),
+		})
 	}
 
-	txn.Locktime = txnBuf.shift_32bit()
-
-	return txn, nil
+	return txn
 }
 
-func pack_txn(txn RawTxn) ([]byte, error) {
-	b := make([]byte, 0)
-	version := make([]byte, 4)
-	binary.LittleEndian.PutUint32(version, txn.Version)
-	b = append(b, version...)
+// Convert to the root package's Transaction
+func (txn RawTxn) Transaction() (*blockchainparser.Transaction, error) {
+	tx := &blockchainparser.Transaction{
+		Version:  int32(txn.Version),
+		Locktime: txn.Locktime,
+	}
 
-	b = append(b, pack_varint(uint64(len(txn.Vin)))...)
 	for _, input := range txn.Vin {
-		txid, err := hex.DecodeString(input.Txid)
+		hash, err := blockchainparser.NewHash256FromString(input.Txid)
 		if err != nil {
 			return nil, err
 		}
-		// reverse to store txid
-		for i := len(txid)/2 - 1; i >= 0; i-- {
-			opp := len(txid) - 1 - i
-			txid[i], txid[opp] = txid[opp], txid[i]
-		}
-		b = append(b, txid...)
-
-		vout := make([]byte, 4)
-		binary.LittleEndian.PutUint32(vout, input.Vout)
-		b = append(b, vout...)
-
 		scriptSig, err := hex.DecodeString(input.ScriptSig)
 		if err != nil {
 			return nil, err
 		}
-		b = append(b, pack_varint(uint64(len(scriptSig)))...)
-		b = append(b, scriptSig...)
-
-		sequence := make([]byte, 4)
-		binary.LittleEndian.PutUint32(sequence, input.Sequence)
-		b = append(b, sequence...)
+		in := blockchainparser.TxInput{
+			Hash:     hash,
+			Index:    input.Vout,
+			Script:   scriptSig,
+			Sequence: input.Sequence,
+		}
+		for _, itemHex := range input.TxInWitness {
+			item, err := hex.DecodeString(itemHex)
+			if err != nil {
+				return nil, err
+			}
+			in.ScriptWitness = append(in.ScriptWitness, item)
+		}
+		tx.Vin = append(tx.Vin, in)
 	}
 
-	b = append(b, pack_varint(uint64(len(txn.Vout)))...)
 	for _, output := range txn.Vout {
-		value := make([]byte, 8)
-		binary.LittleEndian.PutUint64(value, uint64(math.Ceil(float64(output.Value)*100000000)))
-		b = append(b, value...)
-
 		scriptPubKey, err := hex.DecodeString(output.ScriptPubKey)
 		if err != nil {
 			return nil, err
 		}
-		b = append(b, pack_varint(uint64(len(scriptPubKey)))...)
-		b = append(b, scriptPubKey...)
+		tx.Vout = append(tx.Vout, blockchainparser.TxOutput{
+			Value:  output.Value,
+			Script: scriptPubKey,
+		})
 	}
 
-	locktime := make([]byte, 4)
-	binary.LittleEndian.PutUint32(locktime, txn.Locktime)
-	b = append(b, locktime...)
+	return tx, nil
+}
+
+func unpack_txn(b []byte) (RawTxn, error) {
+	tx, err := blockchainparser.ParseTransactionFromBytes(b)
+	if err != nil {
+		return RawTxn{}, err
+	}
 
-	return b, nil
+	return NewRawTxn(tx), nil
 }
 
-func pack_varint(val uint64) []byte {
-	b := make([]byte, 0)
-	if val > 0xFFFFFFFF {
-		l := make([]byte, 8)
-		binary.LittleEndian.PutUint64(l, val)
-		b = append(b, 0xFF)
-		b = append(b, l...)
-	} else if val > 0xFFFF {
-		l := make([]byte, 4)
-		binary.LittleEndian.PutUint32(l, uint32(val))
-		b = append(b, 0xFE)
-		b = append(b, l...)
-	} else if val > 0xFC {
-		l := make([]byte, 2)
-		binary.LittleEndian.PutUint16(l, uint16(val))
-		b = append(b, 0xFD)
-		b = append(b, l...)
-	} else {
-		b = append(b, uint8(val))
+// Serialize txn, with witness data when any input has some
+func pack_txn(txn RawTxn) ([]byte, error) {
+	tx, err := txn.Transaction()
+	if err != nil {
+		return nil, err
 	}
-	return b
+
+	return tx.Binary(), nil
 }
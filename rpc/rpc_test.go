@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+const (
+	// Mainnet transaction 23b397edccd3740a74adb603c9756370fafcde9bcc4483eb271ecad09a94dd63
+	legacyTxHexForTest = "0100000001b14bdcbc3e01bdaad36cc08e81e69c82e1060bc14e518db2b49aa43ad90ba26000000000490047304402203f16c6f40162ab686621ef3000b04e75418a0c0cb2d8aebeac894ae360ac1e780220ddc15ecdfc3507ac48e1681a33eb60996631bf6bf5bc0a0682c4db743ce7ca2b01ffffffff0140420f00000000001976a914660d4ef3a743e3e696ad990364e555c271ad504b88ac00000000"
+
+	// Network transaction of BIP174's extractor test vector
+	segwitTxHexForTest = "0200000000010258e87a21b56daf0c23be8e7070456c336f7cbaa5c8757924f545887bb2abdd7500000000da00473044022074018ad4180097b873323c0015720b3684cc8123891048e7dbcd9b55ad679c99022073d369b740e3eb53dcefa33823c8070514ca55a7dd9544f157c167913261118c01483045022100f61038b308dc1da865a34852746f015772934208c6d24454393cd99bdf2217770220056e675a675a6d0a02b85b14e5e29074d8a25a9b5760bea2816f661910a006ea01475221029583bf39ae0a609747ad199addd634fa6108559d6c5cd39b4c2183f1ab96e07f2102dab61ff49a14db6a7d02b0cd1fbb78fc4b18312b5b4e54dae4dba2fbfef536d752aeffffffff838d0427d0ec650a68aa46bb0b098aea4422c071b2ca78352a077959d07cea1d01000000232200208c2353173743b595dfb4a07b72ba8e42e3797da74e87fe7d9d7497e3b2028903ffffffff0270aaf00800000000160014d85c2b71d0060b09c9886aeb815e50991dda124d00e1f5050000000016001400aea9a2e5f0f876a588df5546e8742d1d87008f000400473044022062eb7a556107a7c73f45ac4ab5a1dddf6f7075fb1275969a7f383efff784bcb202200c05dbb7470dbf2f08557dd356c7325c1ed30913e996cd3840945db12228da5f01473044022065f45ba5998b59a27ffe1a7bed016af1f1f90d54b3aa8f7450aa5f56a25103bd02207f724703ad1edb96680b284b56d4ffcb88f7fb759eabbe08aa30f29b851383d20147522103089dc10c7ac6db54f91329af617333db388cead0c231f723379d1b99030b02dc21023add904f3d6dcf59ddb906b0dee23529b7ffb9ed50e5e86151926860221f0e7352ae00000000"
+)
+
+func TestPackUnpackTxn(t *testing.T) {
+	for _, s := range []string{legacyTxHexForTest, segwitTxHexForTest} {
+		b, _ := hex.DecodeString(s)
+		txn, err := unpack_txn(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packed, err := pack_txn(txn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(packed) != s {
+			t.Errorf("Round trip of %s...: got %x", s[:16], packed)
+		}
+	}
+}
+
+func TestUnpackTxnWitness(t *testing.T) {
+	b, _ := hex.DecodeString(segwitTxHexForTest)
+	txn, err := unpack_txn(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if txn.Version != 2 || len(txn.Vin) != 2 || len(txn.Vout) != 2 {
+		t.Fatalf("Got %+v", txn)
+	}
+	if txn.Vin[0].Txid != "75ddabb27b8845f5247975c8a5ba7c6f336c4570708ebe230caf6db5217ae858" || txn.Vin[1].Vout != 1 {
+		t.Errorf("Got inputs %+v", txn.Vin)
+	}
+	// The first input spends a P2SH multisig, the second a P2SH-P2WSH one
+	if len(txn.Vin[0].TxInWitness) != 0 || len(txn.Vin[1].TxInWitness) != 4 || txn.Vin[1].TxInWitness[0] != "" {
+		t.Errorf("Got witnesses %v and %v", txn.Vin[0].TxInWitness, txn.Vin[1].TxInWitness)
+	}
+	if txn.Vout[0].Value != 149990000 || txn.Vout[1].Value != 100000000 {
+		t.Errorf("Got outputs %+v", txn.Vout)
+	}
+
+	// Without the witnesses the transaction is packed in the legacy format
+	for i := range txn.Vin {
+		txn.Vin[i].TxInWitness = nil
+	}
+	packed, err := pack_txn(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped, err := unpack_txn(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packed[4] == 0 || len(stripped.Vin) != 2 || stripped.Vin[1].TxInWitness != nil {
+		t.Errorf("Got %x", packed)
+	}
+}
+
+func TestPackTxnInvalidHex(t *testing.T) {
+	txns := []RawTxn{
+		{Vin: []RawTxIn{{Txid: "zz"}}},
+		{Vin: []RawTxIn{{Txid: "75ddabb27b8845f5247975c8a5ba7c6f336c4570708ebe230caf6db5217ae858", ScriptSig: "0"}}},
+		{Vin: []RawTxIn{{Txid: "75ddabb27b8845f5247975c8a5ba7c6f336c4570708ebe230caf6db5217ae858", TxInWitness: []string{"x"}}}},
+		{Vout: []RawTxOut{{ScriptPubKey: "abc"}}},
+	}
+	for i, txn := range txns {
+		if _, err := pack_txn(txn); err == nil {
+			t.Errorf("Transaction %d packed", i)
+		}
+	}
+}
@@ -1,13 +1,22 @@
 package rpc
 
-import "encoding/binary"
+import (
+	"time"
+
+	"github.com/ruqqq/blockchainparser"
+)
 
 type RpcOptions struct {
-	Host    string
-	Port    string
-	User    string
-	Pass    string
-	Testnet bool
+	Host       string
+	Port       string
+	User       string
+	Pass       string
+	CookieFile string        // used when User is empty, see NewRpcOptionsFromDataDir
+	Wallet     string        // wallet name for multiwallet nodes
+	Chain      string        // CHAIN_*, selects the default port when Port is empty
+	Testnet    bool          // Deprecated: use Chain; prefixes "1" to Port
+	Timeout    time.Duration // per request, DEFAULT_TIMEOUT when zero
+	BatchSize  int           // requests per batch POST, DEFAULT_BATCH_SIZE when zero
 }
 
 type RpcRequest struct {
@@ -24,15 +33,15 @@ type MempoolRPCResult struct {
 }
 
 type UnspentTx struct {
-	Txid          string  `json:"txid"`
-	Vout          int     `json:"vout"`
-	Address       string  `json:"address,omitempty"`
-	ScriptPubKey  string  `json:"scriptPubKey,omitempty"`
-	Amount        float32 `json:"amount,omitempty"`
-	Confirmations int64   `json:"confirmations,omitempty"`
-	Spendable     bool    `json:"spendable,omitempty"`
-	Solvable      bool    `json:"solvable,omitempty"`
-	Priority      float32 `json:"priority,omitempty"`
+	Txid          string                  `json:"txid"`
+	Vout          int                     `json:"vout"`
+	Address       string                  `json:"address,omitempty"`
+	ScriptPubKey  string                  `json:"scriptPubKey,omitempty"`
+	Amount        blockchainparser.Amount `json:"amount,omitempty"`
+	Confirmations int64                   `json:"confirmations,omitempty"`
+	Spendable     bool                    `json:"spendable,omitempty"`
+	Solvable      bool                    `json:"solvable,omitempty"`
+	Priority      float32                 `json:"priority,omitempty"`
 }
 
 type UnspentTxs []UnspentTx
@@ -55,15 +64,16 @@ func (slice UnspentTxs) Swap(i, j int) {
 }
 
 type RawTxIn struct {
-	Txid      string `json:"txid"`
-	Vout      uint32 `json:"vout"`
-	ScriptSig string `json:"scriptSig"`
-	Sequence  uint32 `json:"sequence"`
+	Txid        string   `json:"txid"`
+	Vout        uint32   `json:"vout"`
+	ScriptSig   string   `json:"scriptSig"`
+	Sequence    uint32   `json:"sequence"`
+	TxInWitness []string `json:"txinwitness,omitempty"`
 }
 
 type RawTxOut struct {
-	Value        float32 `json:"value"`
-	ScriptPubKey string  `json:"scriptPubKey"`
+	Value        blockchainparser.Amount `json:"value"`
+	ScriptPubKey string                  `json:"scriptPubKey"`
 }
 
 type RawTxn struct {
@@ -73,67 +83,185 @@ type RawTxn struct {
 	Vout     []RawTxOut `json:"vout"`
 }
 
-type TxnBuf struct {
-	b   []byte
-	pos uint64
+type SignedTx struct {
+	Hex      string `json:"hex"`
+	Complete bool   `json:"complete"`
+	Errors   []struct {
+		Txid      string `json:"txid"`
+		Vout      uint32 `json:"vout"`
+		ScriptSig string `json:"scriptSig"`
+		Sequence  uint32 `json:"sequence"`
+		Error     string `json:"error"`
+	} `json:"errors"`
 }
 
-func (txnBuf *TxnBuf) shift_byte() byte {
-	val := txnBuf.b[txnBuf.pos : txnBuf.pos+1]
-	txnBuf.pos += 1
-	return val[0]
+type SignedTxRPCResult struct {
+	RpcRequest
+	Result SignedTx `json:"result"`
 }
 
-func (txnBuf *TxnBuf) shift_16bit() uint16 {
-	val := binary.LittleEndian.Uint16(txnBuf.b[txnBuf.pos : txnBuf.pos+2])
-	txnBuf.pos += 2
-	return val
+type ScriptSigResult struct {
+	Asm string `json:"asm"`
+	Hex string `json:"hex"`
 }
 
-func (txnBuf *TxnBuf) shift_32bit() uint32 {
-	val := binary.LittleEndian.Uint32(txnBuf.b[txnBuf.pos : txnBuf.pos+4])
-	txnBuf.pos += 4
-	return val
+type ScriptPubKeyResult struct {
+	Asm     string `json:"asm"`
+	Desc    string `json:"desc,omitempty"`
+	Hex     string `json:"hex"`
+	Type    string `json:"type"`
+	Address string `json:"address,omitempty"`
 }
 
-func (txnBuf *TxnBuf) shift_64bit() uint64 {
-	val := binary.LittleEndian.Uint64(txnBuf.b[txnBuf.pos : txnBuf.pos+8])
-	txnBuf.pos += 8
-	return val
+type TxInResult struct {
+	Txid        string           `json:"txid,omitempty"`
+	Vout        uint32           `json:"vout"`
+	Coinbase    string           `json:"coinbase,omitempty"`
+	ScriptSig   *ScriptSigResult `json:"scriptSig,omitempty"`
+	TxInWitness []string         `json:"txinwitness,omitempty"`
+	Sequence    uint32           `json:"sequence"`
 }
 
-func (txnBuf *TxnBuf) shift_bits(length uint64) []byte {
-	val := txnBuf.b[txnBuf.pos : txnBuf.pos+length]
-	txnBuf.pos += length
-	return val
+type TxOutResult struct {
+	Value        blockchainparser.Amount `json:"value"`
+	N            uint32                  `json:"n"`
+	ScriptPubKey ScriptPubKeyResult      `json:"scriptPubKey"`
 }
 
-func (txnBuf *TxnBuf) shift_varint() uint64 {
-	intType := txnBuf.shift_byte()
-	if intType == 0xFF {
-		return txnBuf.shift_64bit()
-	} else if intType == 0xFE {
-		return uint64(txnBuf.shift_32bit())
-	} else if intType == 0xFD {
-		return uint64(txnBuf.shift_16bit())
-	}
+// Decoded transaction of getrawtransaction with verbose set and getblock
+// with verbosity 2
+type TxResult struct {
+	Txid          string                  `json:"txid"`
+	Hash          string                  `json:"hash"`
+	Version       int32                   `json:"version"`
+	Size          int                     `json:"size"`
+	VSize         int                     `json:"vsize"`
+	Weight        int                     `json:"weight"`
+	Locktime      uint32                  `json:"locktime"`
+	Vin           []TxInResult            `json:"vin"`
+	Vout          []TxOutResult           `json:"vout"`
+	Fee           blockchainparser.Amount `json:"fee,omitempty"`
+	Hex           string                  `json:"hex,omitempty"`
+	BlockHash     string                  `json:"blockhash,omitempty"`
+	Confirmations int64                   `json:"confirmations,omitempty"`
+	Time          int64                   `json:"time,omitempty"`
+	BlockTime     int64                   `json:"blocktime,omitempty"`
+}
 
-	return uint64(intType)
+// getblockheader with verbose set
+type BlockHeaderResult struct {
+	Hash              string  `json:"hash"`
+	Confirmations     int64   `json:"confirmations"`
+	Height            int32   `json:"height"`
+	Version           int32   `json:"version"`
+	VersionHex        string  `json:"versionHex"`
+	MerkleRoot        string  `json:"merkleroot"`
+	Time              int64   `json:"time"`
+	MedianTime        int64   `json:"mediantime"`
+	Nonce             uint32  `json:"nonce"`
+	Bits              string  `json:"bits"`
+	Difficulty        float64 `json:"difficulty"`
+	Chainwork         string  `json:"chainwork"`
+	NTx               int     `json:"nTx"`
+	PreviousBlockHash string  `json:"previousblockhash,omitempty"`
+	NextBlockHash     string  `json:"nextblockhash,omitempty"`
 }
 
-type SignedTx struct {
-	Hex      string `json:"hex"`
+// getblock with verbosity 1
+type BlockResult struct {
+	BlockHeaderResult
+	StrippedSize int      `json:"strippedsize"`
+	Size         int      `json:"size"`
+	Weight       int      `json:"weight"`
+	Tx           []string `json:"tx"`
+}
+
+// getblock with verbosity 2
+type BlockVerboseTxResult struct {
+	BlockHeaderResult
+	StrippedSize int        `json:"strippedsize"`
+	Size         int        `json:"size"`
+	Weight       int        `json:"weight"`
+	Tx           []TxResult `json:"tx"`
+}
+
+// Previous output for signrawtransactionwith*
+type PrevTx struct {
+	Txid          string                  `json:"txid"`
+	Vout          uint32                  `json:"vout"`
+	ScriptPubKey  string                  `json:"scriptPubKey"`
+	RedeemScript  string                  `json:"redeemScript,omitempty"`
+	WitnessScript string                  `json:"witnessScript,omitempty"`
+	Amount        blockchainparser.Amount `json:"amount,omitempty"`
+}
+
+// Input for walletcreatefundedpsbt
+type OutPoint struct {
+	Txid     string  `json:"txid"`
+	Vout     uint32  `json:"vout"`
+	Sequence *uint32 `json:"sequence,omitempty"`
+}
+
+// Options of walletcreatefundedpsbt
+type FundOptions struct {
+	AddInputs              *bool   `json:"add_inputs,omitempty"`
+	ChangeAddress          string  `json:"changeAddress,omitempty"`
+	ChangePosition         *int    `json:"changePosition,omitempty"`
+	ChangeType             string  `json:"change_type,omitempty"`
+	IncludeWatching        bool    `json:"includeWatching,omitempty"`
+	LockUnspents           bool    `json:"lockUnspents,omitempty"`
+	FeeRate                float64 `json:"fee_rate,omitempty"` // sat/vB
+	SubtractFeeFromOutputs []int   `json:"subtractFeeFromOutputs,omitempty"`
+	Replaceable            *bool   `json:"replaceable,omitempty"`
+	ConfTarget             int     `json:"conf_target,omitempty"`
+	EstimateMode           string  `json:"estimate_mode,omitempty"`
+}
+
+type FundedPsbtResult struct {
+	Psbt      string                  `json:"psbt"`
+	Fee       blockchainparser.Amount `json:"fee"`
+	ChangePos int                     `json:"changepos"`
+}
+
+type ProcessPsbtResult struct {
+	Psbt     string `json:"psbt"`
 	Complete bool   `json:"complete"`
-	Errors   []struct {
-		Txid      string `json:"txid"`
-		Vout      uint32 `json:"vout"`
-		ScriptSig string `json:"scriptSig"`
-		Sequence  uint32 `json:"sequence"`
-		Error     string `json:"error"`
-	} `json:"errors"`
+	Hex      string `json:"hex,omitempty"`
 }
 
-type SignedTxRPCResult struct {
-	RpcRequest
-	Result SignedTx `json:"result`
+type FinalizePsbtResult struct {
+	Psbt     string `json:"psbt,omitempty"`
+	Hex      string `json:"hex,omitempty"`
+	Complete bool   `json:"complete"`
+}
+
+type EstimateSmartFeeResult struct {
+	FeeRate blockchainparser.Amount `json:"feerate,omitempty"` // per kvB
+	Errors  []string                `json:"errors,omitempty"`
+	Blocks  int                     `json:"blocks"`
+}
+
+type DescriptorInfo struct {
+	Desc      string   `json:"desc"`
+	Timestamp int64    `json:"timestamp"`
+	Active    bool     `json:"active"`
+	Internal  *bool    `json:"internal,omitempty"`
+	Range     []uint32 `json:"range,omitempty"` // [begin, end] for ranged descriptors
+	Next      uint32   `json:"next,omitempty"`
+}
+
+type ListDescriptorsResult struct {
+	WalletName  string           `json:"wallet_name"`
+	Descriptors []DescriptorInfo `json:"descriptors"`
+}
+
+type MempoolAcceptResult struct {
+	Txid    string `json:"txid"`
+	Wtxid   string `json:"wtxid"`
+	Allowed bool   `json:"allowed"`
+	VSize   int    `json:"vsize,omitempty"`
+	Fees    *struct {
+		Base blockchainparser.Amount `json:"base"`
+	} `json:"fees,omitempty"`
+	RejectReason string `json:"reject-reason,omitempty"`
 }
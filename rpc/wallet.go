@@ -0,0 +1,208 @@
+package rpc
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+// Signature hash types accepted by the signing RPCs
+const (
+	SIGHASH_DEFAULT             = "DEFAULT"
+	SIGHASH_ALL                 = "ALL"
+	SIGHASH_NONE                = "NONE"
+	SIGHASH_SINGLE              = "SINGLE"
+	SIGHASH_ALL_ANYONECANPAY    = "ALL|ANYONECANPAY"
+	SIGHASH_NONE_ANYONECANPAY   = "NONE|ANYONECANPAY"
+	SIGHASH_SINGLE_ANYONECANPAY = "SINGLE|ANYONECANPAY"
+)
+
+// Address types of getnewaddress
+const (
+	ADDRESS_TYPE_LEGACY      = "legacy"
+	ADDRESS_TYPE_P2SH_SEGWIT = "p2sh-segwit"
+	ADDRESS_TYPE_BECH32      = "bech32"
+	ADDRESS_TYPE_BECH32M     = "bech32m"
+)
+
+func signedTxResult(result SignedTx) (SignedTx, error) {
+	if len(result.Errors) > 0 {
+		return result, errors.New(result.Errors[0].Error)
+	}
+
+	return result, nil
+}
+
+func signArgs(txn RawTxn, prevTxs []PrevTx) ([]interface{}, error) {
+	rawTxn, err := pack_txn(txn)
+	if err != nil {
+		return nil, err
+	}
+	if prevTxs == nil {
+		prevTxs = []PrevTx{}
+	}
+
+	return []interface{}{hex.EncodeToString(rawTxn), prevTxs}, nil
+}
+
+// Signs txn with the keys of the wallet. prevTxs are only needed for outputs
+// unknown to the node. The signed transaction is returned even when some
+// inputs failed, with the first failure as error.
+func (client *Client) SignRawTransactionWithWallet(ctx context.Context, txn RawTxn, prevTxs []PrevTx, sigHashType string) (SignedTx, error) {
+	args, err := signArgs(txn, prevTxs)
+	if err != nil {
+		return SignedTx{}, err
+	}
+	if sigHashType != "" {
+		args = append(args, sigHashType)
+	}
+
+	var result SignedTx
+	err = client.Call(ctx, "signrawtransactionwithwallet", &result, args...)
+	if err != nil {
+		return SignedTx{}, err
+	}
+
+	return signedTxResult(result)
+}
+
+// Signs txn with the given WIF private keys
+func (client *Client) SignRawTransactionWithKey(ctx context.Context, txn RawTxn, privKeys []string, prevTxs []PrevTx, sigHashType string) (SignedTx, error) {
+	args, err := signArgs(txn, prevTxs)
+	if err != nil {
+		return SignedTx{}, err
+	}
+	args = []interface{}{args[0], privKeys, args[1]}
+	if sigHashType != "" {
+		args = append(args, sigHashType)
+	}
+
+	var result SignedTx
+	err = client.Call(ctx, "signrawtransactionwithkey", &result, args...)
+	if err != nil {
+		return SignedTx{}, err
+	}
+
+	return signedTxResult(result)
+}
+
+// Creates a PSBT paying outputs (address -> amount) funded by the wallet.
+// inputs may be empty to let the wallet select them; options may be nil.
+func (client *Client) WalletCreateFundedPsbt(ctx context.Context, inputs []OutPoint, outputs map[string]blockchainparser.Amount, locktime uint32, options *FundOptions) (*FundedPsbtResult, error) {
+	if inputs == nil {
+		inputs = []OutPoint{}
+	}
+	if options == nil {
+		options = &FundOptions{}
+	}
+
+	var result FundedPsbtResult
+	err := client.Call(ctx, "walletcreatefundedpsbt", &result, inputs, outputs, locktime, options, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Updates psbt with the wallet's UTXO and key data, and signs it when sign is set
+func (client *Client) WalletProcessPsbt(ctx context.Context, psbt string, sign bool, sigHashType string) (*ProcessPsbtResult, error) {
+	if sigHashType == "" {
+		sigHashType = SIGHASH_DEFAULT
+	}
+
+	var result ProcessPsbtResult
+	err := client.Call(ctx, "walletprocesspsbt", &result, psbt, sign, sigHashType, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Finalizes the inputs of psbt. With extract set and a complete psbt, the
+// network serialized transaction is returned in Hex.
+func (client *Client) FinalizePsbt(ctx context.Context, psbt string, extract bool) (*FinalizePsbtResult, error) {
+	var result FinalizePsbtResult
+	err := client.Call(ctx, "finalizepsbt", &result, psbt, extract)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Returns the transaction extracted from a finalized psbt
+func (client *Client) FinalizePsbtTransaction(ctx context.Context, psbt string) (*blockchainparser.Transaction, error) {
+	result, err := client.FinalizePsbt(ctx, psbt, true)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Complete || result.Hex == "" {
+		return nil, errors.New("PSBT is not complete")
+	}
+
+	b, err := hex.DecodeString(result.Hex)
+	if err != nil {
+		return nil, err
+	}
+
+	return blockchainparser.ParseTransactionFromBytes(b)
+}
+
+// Estimates the fee rate, per kvB, for confirmation within confTarget
+// blocks. estimateMode is "economical", "conservative" or empty for the
+// default.
+func (client *Client) EstimateSmartFee(ctx context.Context, confTarget int, estimateMode string) (*EstimateSmartFeeResult, error) {
+	args := []interface{}{confTarget}
+	if estimateMode != "" {
+		args = append(args, estimateMode)
+	}
+
+	var result EstimateSmartFeeResult
+	err := client.Call(ctx, "estimatesmartfee", &result, args...)
+	if err != nil {
+		return nil, err
+	}
+	if result.FeeRate == 0 && len(result.Errors) > 0 {
+		return &result, errors.New(result.Errors[0])
+	}
+
+	return &result, nil
+}
+
+// addressType is one of the ADDRESS_TYPE_* values, or empty for the wallet default
+func (client *Client) GetNewAddress(ctx context.Context, label string, addressType string) (string, error) {
+	args := []interface{}{label}
+	if addressType != "" {
+		args = append(args, addressType)
+	}
+
+	var address string
+	err := client.Call(ctx, "getnewaddress", &address, args...)
+	return address, err
+}
+
+func (client *Client) ListDescriptors(ctx context.Context, private bool) (*ListDescriptorsResult, error) {
+	var result ListDescriptorsResult
+	err := client.Call(ctx, "listdescriptors", &result, private)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Checks whether the mempool would accept the raw transactions, without
+// broadcasting them
+func (client *Client) TestMempoolAccept(ctx context.Context, rawTxs []string) ([]MempoolAcceptResult, error) {
+	var result []MempoolAcceptResult
+	err := client.Call(ctx, "testmempoolaccept", &result, rawTxs)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,161 @@
+package rpc
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+// Records the params of method as JSON, answering with result
+func recordParamsForTest(mock *MockServer, method string, result interface{}) *string {
+	var params string
+	mock.Handle(method, func(raw []json.RawMessage) (interface{}, *RPCError) {
+		items := make([]string, len(raw))
+		for i, item := range raw {
+			items[i] = string(item)
+		}
+		params = "[" + strings.Join(items, ",") + "]"
+		return result, nil
+	})
+
+	return &params
+}
+
+func TestWalletRPCParams(t *testing.T) {
+	mock := NewMockServer("", "")
+	defer mock.Close()
+	client := NewClient(mock.Options())
+	ctx := context.Background()
+
+	txn := RawTxn{
+		Version: 2,
+		Vin:     []RawTxIn{{Txid: "75ddabb27b8845f5247975c8a5ba7c6f336c4570708ebe230caf6db5217ae858", Vout: 1, Sequence: 0xfffffffd}},
+		Vout:    []RawTxOut{{Value: 100000, ScriptPubKey: "51"}},
+	}
+	packed, err := pack_txn(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txnHex := `"` + hex.EncodeToString(packed) + `"`
+	prevTxs := []PrevTx{{Txid: txn.Vin[0].Txid, Vout: 1, ScriptPubKey: "51", Amount: 200000}}
+	prevTxsJSON := `[{"txid":"75ddabb27b8845f5247975c8a5ba7c6f336c4570708ebe230caf6db5217ae858","vout":1,"scriptPubKey":"51","amount":0.00200000}]`
+	sequence := uint32(0xfffffffd)
+	feeRate := blockchainparser.Amount(1234)
+
+	tests := []struct {
+		method string
+		result interface{}
+		call   func() error
+		params string
+	}{
+		{"signrawtransactionwithwallet", SignedTx{Hex: "00", Complete: true}, func() error {
+			_, err := client.SignRawTransactionWithWallet(ctx, txn, nil, "")
+			return err
+		}, "[" + txnHex + ",[]]"},
+		{"signrawtransactionwithwallet", SignedTx{Hex: "00", Complete: true}, func() error {
+			_, err := client.SignRawTransactionWithWallet(ctx, txn, prevTxs, SIGHASH_ALL_ANYONECANPAY)
+			return err
+		}, "[" + txnHex + "," + prevTxsJSON + `,"ALL|ANYONECANPAY"]`},
+		{"signrawtransactionwithkey", SignedTx{Hex: "00", Complete: true}, func() error {
+			_, err := client.SignRawTransactionWithKey(ctx, txn, []string{"cKey"}, prevTxs, SIGHASH_SINGLE)
+			return err
+		}, "[" + txnHex + `,["cKey"],` + prevTxsJSON + `,"SINGLE"]`},
+		{"walletcreatefundedpsbt", FundedPsbtResult{Psbt: "cHNidP8B", Fee: 141, ChangePos: -1}, func() error {
+			result, err := client.WalletCreateFundedPsbt(ctx, []OutPoint{{Txid: txn.Vin[0].Txid, Vout: 1, Sequence: &sequence}}, map[string]blockchainparser.Amount{"bcrt1q": 150000}, 0, &FundOptions{FeeRate: 2.5})
+			if err == nil && (result.Psbt != "cHNidP8B" || result.Fee != 141 || result.ChangePos != -1) {
+				t.Errorf("walletcreatefundedpsbt: got %+v", result)
+			}
+			return err
+		}, `[[{"txid":"75ddabb27b8845f5247975c8a5ba7c6f336c4570708ebe230caf6db5217ae858","vout":1,"sequence":4294967293}],{"bcrt1q":0.00150000},0,{"fee_rate":2.5},true]`},
+		{"walletcreatefundedpsbt", FundedPsbtResult{Psbt: "cHNidP8B"}, func() error {
+			_, err := client.WalletCreateFundedPsbt(ctx, nil, map[string]blockchainparser.Amount{"bcrt1q": blockchainparser.COIN}, 100, nil)
+			return err
+		}, `[[],{"bcrt1q":1.00000000},100,{},true]`},
+		{"walletprocesspsbt", ProcessPsbtResult{Psbt: "cHNidP8B", Complete: true}, func() error {
+			_, err := client.WalletProcessPsbt(ctx, "cHNidP8B", true, "")
+			return err
+		}, `["cHNidP8B",true,"DEFAULT",true]`},
+		{"finalizepsbt", FinalizePsbtResult{Psbt: "cHNidP8B"}, func() error {
+			_, err := client.FinalizePsbt(ctx, "cHNidP8B", false)
+			return err
+		}, `["cHNidP8B",false]`},
+		{"finalizepsbt", FinalizePsbtResult{Hex: hex.EncodeToString(packed), Complete: true}, func() error {
+			tx, err := client.FinalizePsbtTransaction(ctx, "cHNidP8B")
+			if err == nil && tx.Vout[0].Value != 100000 {
+				t.Errorf("finalizepsbt: got %+v", tx)
+			}
+			return err
+		}, `["cHNidP8B",true]`},
+		{"estimatesmartfee", EstimateSmartFeeResult{FeeRate: feeRate, Blocks: 6}, func() error {
+			result, err := client.EstimateSmartFee(ctx, 6, "")
+			if err == nil && result.FeeRate != feeRate {
+				t.Errorf("estimatesmartfee: got %+v", result)
+			}
+			return err
+		}, `[6]`},
+		{"estimatesmartfee", EstimateSmartFeeResult{FeeRate: feeRate, Blocks: 2}, func() error {
+			_, err := client.EstimateSmartFee(ctx, 2, "conservative")
+			return err
+		}, `[2,"conservative"]`},
+		{"getnewaddress", "bcrt1p", func() error {
+			address, err := client.GetNewAddress(ctx, "label", ADDRESS_TYPE_BECH32M)
+			if err == nil && address != "bcrt1p" {
+				t.Errorf("getnewaddress: got %s", address)
+			}
+			return err
+		}, `["label","bech32m"]`},
+		{"getnewaddress", "bcrt1q", func() error {
+			_, err := client.GetNewAddress(ctx, "", "")
+			return err
+		}, `[""]`},
+		{"listdescriptors", ListDescriptorsResult{WalletName: "w"}, func() error {
+			_, err := client.ListDescriptors(ctx, true)
+			return err
+		}, `[true]`},
+		{"testmempoolaccept", []MempoolAcceptResult{{Allowed: true}}, func() error {
+			results, err := client.TestMempoolAccept(ctx, []string{"00", "01"})
+			if err == nil && (len(results) != 1 || !results[0].Allowed) {
+				t.Errorf("testmempoolaccept: got %+v", results)
+			}
+			return err
+		}, `[["00","01"]]`},
+	}
+
+	for _, test := range tests {
+		params := recordParamsForTest(mock, test.method, test.result)
+		if err := test.call(); err != nil {
+			t.Errorf("%s: %s", test.method, err)
+			continue
+		}
+		if *params != test.params {
+			t.Errorf("%s: sent %s, want %s", test.method, *params, test.params)
+		}
+	}
+}
+
+func TestWalletRPCErrors(t *testing.T) {
+	mock := NewMockServer("", "")
+	defer mock.Close()
+	client := NewClient(mock.Options())
+	ctx := context.Background()
+
+	txn := RawTxn{Version: 2, Vout: []RawTxOut{{Value: 1, ScriptPubKey: "51"}}}
+	recordParamsForTest(mock, "signrawtransactionwithwallet", json.RawMessage(`{"hex":"00","complete":false,"errors":[{"txid":"00","vout":0,"error":"Input not found or already spent"}]}`))
+	if result, err := client.SignRawTransactionWithWallet(ctx, txn, nil, ""); err == nil || result.Hex != "00" {
+		t.Errorf("Got %+v, %v", result, err)
+	}
+
+	recordParamsForTest(mock, "estimatesmartfee", json.RawMessage(`{"errors":["Insufficient data or no feerate found"],"blocks":0}`))
+	if _, err := client.EstimateSmartFee(ctx, 6, ""); err == nil || err.Error() != "Insufficient data or no feerate found" {
+		t.Errorf("Got %v", err)
+	}
+
+	recordParamsForTest(mock, "finalizepsbt", FinalizePsbtResult{Psbt: "cHNidP8B"})
+	if _, err := client.FinalizePsbtTransaction(ctx, "cHNidP8B"); err == nil {
+		t.Error("Incomplete PSBT extracted")
+	}
+}
@@ -0,0 +1,165 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+var ErrUnorderedResume = errors.New("Unordered scans can only be resumed with a single worker")
+
+// Scan state persisted between runs: the last processed block and where it
+// is stored
+type Checkpoint struct {
+	FileNum uint32 `json:"file"`
+	Offset  int64  `json:"offset"` // of the block record (magic id) in the file
+	Length  uint32 `json:"length"` // of the block, to resume after it
+	Height  int32  `json:"height"` // -1 when unknown
+	Hash    string `json:"hash"`   // big endian hex, as bitcoind's RPC
+}
+
+// Reads the checkpoint saved at path. Returns nil without error when there
+// is none yet.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+// Writes the checkpoint to path. The file is replaced atomically so that an
+// interrupted save keeps the previous checkpoint.
+func (checkpoint *Checkpoint) Save(path string) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Records block as the last processed one
+func (checkpoint *Checkpoint) Update(block *ScannedBlock) {
+	checkpoint.FileNum = block.FileNum
+	checkpoint.Offset = block.Offset
+	checkpoint.Length = block.Length
+	checkpoint.Height = block.Height
+	checkpoint.Hash = block.Hash().String()
+}
+
+// Options resuming a scan after the checkpoint. Ordered scans restart at the
+// next height. Unordered scans restart after the checkpoint's record, which
+// requires a single worker: with files read in parallel, the blocks of the
+// files before the checkpoint's one may not have been processed.
+func (checkpoint *Checkpoint) ResumeOptions(opts Options) (Options, error) {
+	if opts.Ordered {
+		opts.StartHeight = checkpoint.Height + 1
+		return opts, nil
+	}
+	if opts.Workers != 1 {
+		return opts, ErrUnorderedResume
+	}
+
+	opts.StartFile = checkpoint.FileNum
+	opts.StartOffset = checkpoint.Offset + BLOCK_RECORD_HEADER_SIZE + int64(checkpoint.Length)
+
+	return opts, nil
+}
+
+// Moves a checkpoint left on a block that is no longer in the active chain
+// (a reorg happened between runs) back to the fork point. Blocks missing
+// from the index are followed through the HashPrev of their header read
+// from the block files. Returns the hashes of the disconnected blocks, the
+// most recent first, so that their effects can be undone.
+func (checkpoint *Checkpoint) Rewind(index *ChainIndex, params *blockchainparser.ChainParams, dataDir string) ([]blockchainparser.Hash256, error) {
+	hash, err := blockchainparser.NewHash256FromString(checkpoint.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var disconnected []blockchainparser.Hash256
+	inFile := true // only the checkpoint block can be looked up in the files
+	for !index.Contains(hash) {
+		disconnected = append(disconnected, hash)
+
+		if entry := index.Get(hash); entry != nil {
+			hash = entry.HashPrev
+			continue
+		}
+		if !inFile {
+			return nil, errors.New("Can't find the fork point of block " + hash.String())
+		}
+
+		header, err := readBlockHeader(params, dataDir, checkpoint.FileNum, checkpoint.Offset)
+		if err != nil {
+			return nil, err
+		}
+		if header.Hash().String() != hash.String() {
+			return nil, errors.New("Checkpoint block " + hash.String() + " not found in its block file")
+		}
+		hash = header.HashPrev
+		inFile = false
+	}
+
+	if len(disconnected) == 0 {
+		return nil, nil
+	}
+
+	fork := index.Get(hash)
+	checkpoint.FileNum = uint32(fork.NFile)
+	checkpoint.Offset = int64(fork.NDataPos) - BLOCK_RECORD_HEADER_SIZE
+	checkpoint.Height = fork.Height
+	checkpoint.Hash = fork.Hash.String()
+	checkpoint.Length, err = readBlockLength(dataDir, checkpoint.FileNum, checkpoint.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return disconnected, nil
+}
+
+func readBlockHeader(params *blockchainparser.ChainParams, dataDir string, fileNum uint32, offset int64) (*blockchainparser.BlockHeader, error) {
+	block, err := readBlock(params, dataDir, fileNum, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &block.BlockHeader, nil
+}
+
+func readBlockLength(dataDir string, fileNum uint32, offset int64) (uint32, error) {
+	blockFile, err := blockchainparser.NewBlockFile(dataDir, fileNum)
+	if err != nil {
+		return 0, err
+	}
+	defer blockFile.Close()
+
+	if _, err := blockFile.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	header, err := blockFile.Peek(BLOCK_RECORD_HEADER_SIZE)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint32(header[4:]), nil
+}
@@ -0,0 +1,131 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/ruqqq/blockchainparser"
+	"github.com/ruqqq/blockchainparser/db"
+)
+
+func TestResumeOptions(t *testing.T) {
+	dataDir := t.TempDir()
+	index := writeChainForTest(t, dataDir, [][]int32{{0, 2, 1}, {3, 4}})
+
+	checkpoint := &Checkpoint{}
+	for block := range New(blockchainparser.RegTestParams, dataDir, Options{Workers: 1, Index: index}).Scan(context.Background()) {
+		if block.Height == 2 {
+			checkpoint.Update(block)
+		}
+	}
+
+	opts, err := checkpoint.ResumeOptions(Options{Ordered: true, Index: index})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if heights := scanHeightsForTest(t, New(blockchainparser.RegTestParams, dataDir, opts)); len(heights) != 2 || heights[0] != 3 {
+		t.Errorf("Ordered resume: got heights %v", heights)
+	}
+
+	// Resumes after the checkpoint's record, which is before height 1
+	opts, err = checkpoint.ResumeOptions(Options{Workers: 1, Index: index})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if heights := scanHeightsForTest(t, New(blockchainparser.RegTestParams, dataDir, opts)); len(heights) != 3 || heights[0] != 1 {
+		t.Errorf("Unordered resume: got heights %v", heights)
+	}
+
+	for _, workers := range []int{0, 4} {
+		if _, err := checkpoint.ResumeOptions(Options{Workers: workers}); err != ErrUnorderedResume {
+			t.Errorf("Unordered resume with %d workers: got %v", workers, err)
+		}
+	}
+}
+
+// Main chain of heights 0 to 4, and a stale branch of two blocks from height
+// 2 in blk00002.dat whose first block only is in the index
+func staleBranchForTest(t *testing.T, dataDir string) (index *ChainIndex, stale []*blockchainparser.Block) {
+	index = writeChainForTest(t, dataDir, [][]int32{{0, 1, 2}, {3, 4}})
+	s3 := blockForTest(index.AtHeight(2).Hash, 100)
+	s4 := blockForTest(s3.Hash(), 101)
+	data := append(blockRecordForTest(s3), blockRecordForTest(s4)...)
+	if err := os.WriteFile(blockchainparser.BlockFilePath(dataDir, 2), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []*IndexEntry{{Hash: s3.Hash(), BlockIndexRecord: &db.BlockIndexRecord{
+		Height:   3,
+		Status:   db.BLOCK_VALID_SCRIPTS | db.BLOCK_HAVE_DATA,
+		NFile:    2,
+		NDataPos: BLOCK_RECORD_HEADER_SIZE,
+		HashPrev: s3.HashPrev,
+		NBits:    s3.TargetDifficulty,
+	}}}
+	for _, entry := range index.entries {
+		entries = append(entries, entry)
+	}
+
+	return NewChainIndex(entries), []*blockchainparser.Block{s3, s4}
+}
+
+func TestCheckpointRewind(t *testing.T) {
+	dataDir := t.TempDir()
+	index, stale := staleBranchForTest(t, dataDir)
+	s3, s4 := stale[0], stale[1]
+	if index.Height() != 4 || index.Contains(s3.Hash()) {
+		t.Fatal("Stale branch in the active chain")
+	}
+
+	// Checkpoint of the fork point, as saved by a scan
+	var fork Checkpoint
+	for block := range New(blockchainparser.RegTestParams, dataDir, Options{Workers: 1, Ordered: true, Index: index}).Scan(context.Background()) {
+		if block.Height == 2 {
+			fork.Update(block)
+		}
+	}
+
+	s3Length := uint32(len(s3.Binary()))
+	tests := []struct {
+		name       string
+		checkpoint Checkpoint
+		want       []blockchainparser.Hash256
+	}{
+		{"in the index", Checkpoint{FileNum: 2, Offset: 0, Length: s3Length, Height: 3, Hash: s3.Hash().String()}, []blockchainparser.Hash256{s3.Hash()}},
+		{"only in the blk file", Checkpoint{FileNum: 2, Offset: BLOCK_RECORD_HEADER_SIZE + int64(s3Length), Length: uint32(len(s4.Binary())), Height: -1, Hash: s4.Hash().String()}, []blockchainparser.Hash256{s4.Hash(), s3.Hash()}},
+	}
+	for _, test := range tests {
+		checkpoint := test.checkpoint
+		disconnected, err := checkpoint.Rewind(index, blockchainparser.RegTestParams, dataDir)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if len(disconnected) != len(test.want) {
+			t.Errorf("%s: got %d disconnected blocks", test.name, len(disconnected))
+			continue
+		}
+		for i, hash := range disconnected {
+			if hash.String() != test.want[i].String() {
+				t.Errorf("%s: disconnected block %d is %s, want %s", test.name, i, hash, test.want[i])
+			}
+		}
+		if checkpoint != fork {
+			t.Errorf("%s: got checkpoint %+v, want %+v", test.name, checkpoint, fork)
+		}
+	}
+
+	// Nothing to do on the active chain
+	checkpoint := fork
+	if disconnected, err := checkpoint.Rewind(index, blockchainparser.RegTestParams, dataDir); err != nil || disconnected != nil || checkpoint != fork {
+		t.Errorf("Active chain checkpoint: got %v, %v, %+v", disconnected, err, checkpoint)
+	}
+
+	// The block in the file must be the checkpoint's
+	checkpoint = tests[1].checkpoint
+	checkpoint.Offset = 0
+	if _, err := checkpoint.Rewind(index, blockchainparser.RegTestParams, dataDir); err == nil {
+		t.Error("Checkpoint pointing to another block passed")
+	}
+}
@@ -0,0 +1,130 @@
+package scanner
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ruqqq/blockchainparser"
+	"github.com/ruqqq/blockchainparser/db"
+)
+
+type EventType int
+
+const (
+	//! Active chain changes, named after bitcoind's validation interface
+	EVENT_BLOCK_CONNECTED EventType = iota
+	EVENT_BLOCK_DISCONNECTED
+)
+
+func (eventType EventType) String() string {
+	switch eventType {
+	case EVENT_BLOCK_CONNECTED:
+		return "BlockConnected"
+	case EVENT_BLOCK_DISCONNECTED:
+		return "BlockDisconnected"
+	}
+
+	return "Unknown"
+}
+
+// Block connected to or disconnected from the active chain. Disconnections
+// come tip first, before the connections of the new branch. Block.Height is
+// the height of the block in its branch.
+type Event struct {
+	Type  EventType
+	Block *ScannedBlock
+	// Outputs spent by the block, from rev*.dat. Always set for
+	// disconnections, and for connections when bitcoind wrote it.
+	Undo *blockchainparser.BlockUndo
+}
+
+// Calls fn with the events moving the chain from the block from (nil for an
+// empty chain) to the tip of index, stopping at the first error
+func ChainEvents(index *ChainIndex, params *blockchainparser.ChainParams, dataDir string, from blockchainparser.Hash256, fn func(Event) error) error {
+	reader := &eventReader{params: params, dataDir: dataDir}
+	return reader.emit(index, from, fn)
+}
+
+// Loads the blocks and undo data of events
+type eventReader struct {
+	params  *blockchainparser.ChainParams
+	dataDir string
+	cache   map[string]*ScannedBlock // blocks already parsed, optional
+}
+
+func (reader *eventReader) emit(index *ChainIndex, from blockchainparser.Hash256, fn func(Event) error) error {
+	disconnect, connect, err := index.ReorgPath(from)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range disconnect {
+		event, err := reader.event(EVENT_BLOCK_DISCONNECTED, entry)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	for _, entry := range connect {
+		event, err := reader.event(EVENT_BLOCK_CONNECTED, entry)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (reader *eventReader) event(eventType EventType, entry *IndexEntry) (Event, error) {
+	event := Event{Type: eventType}
+
+	if block, ok := reader.cache[string(entry.Hash)]; ok {
+		delete(reader.cache, string(entry.Hash))
+		event.Block = block
+	} else {
+		if entry.Status&db.BLOCK_HAVE_DATA == 0 {
+			return event, fmt.Errorf("Data of block %s is not available", entry.Hash)
+		}
+		block, err := readBlock(reader.params, reader.dataDir, uint32(entry.NFile), int64(entry.NDataPos)-BLOCK_RECORD_HEADER_SIZE)
+		if err != nil {
+			return event, err
+		}
+		event.Block = block
+	}
+	event.Block.Height = entry.Height
+
+	if entry.Status&db.BLOCK_HAVE_UNDO != 0 {
+		undo, err := blockchainparser.NewBlockUndoFromFile(reader.dataDir, reader.params.MagicId, uint32(entry.NFile), entry.NUndoPos, entry.HashPrev)
+		if err != nil {
+			return event, err
+		}
+		event.Undo = undo
+	} else if eventType == EVENT_BLOCK_DISCONNECTED {
+		return event, fmt.Errorf("Undo data of block %s is not available", entry.Hash)
+	}
+
+	return event, nil
+}
+
+func readBlock(params *blockchainparser.ChainParams, dataDir string, fileNum uint32, offset int64) (*ScannedBlock, error) {
+	blockFile, err := blockchainparser.NewBlockFile(dataDir, fileNum)
+	if err != nil {
+		return nil, err
+	}
+	defer blockFile.Close()
+
+	if _, err := blockFile.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	block, err := params.ParseBlockFromFile(blockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScannedBlock{Block: block, FileNum: fileNum, Offset: offset, Height: -1}, nil
+}
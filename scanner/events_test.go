@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/ruqqq/blockchainparser"
+)
+
+// Undo record as stored in rev*.dat files, with its checksum
+func undoRecordForTest(hashPrev blockchainparser.Hash256, undoData []byte) []byte {
+	header := make([]byte, BLOCK_RECORD_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(header, uint32(blockchainparser.BLOCK_MAGIC_ID_REGTEST))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(undoData)))
+	checksum := blockchainparser.DoubleSha256(append(append([]byte{}, hashPrev...), undoData...))
+
+	return append(append(header, undoData...), checksum...)
+}
+
+// Active chain a of heights 0 to 4, and a stale branch b from a1 in
+// blk00001.dat
+func forkedChainForTest(t *testing.T, dataDir string) (index *ChainIndex, a, b []*blockchainparser.Block) {
+	if err := os.MkdirAll(dataDir+"/blocks", 0755); err != nil {
+		t.Fatal(err)
+	}
+	chain := &followedChainForTest{t: t, dataDir: dataDir}
+	hashPrev := make(blockchainparser.Hash256, 32)
+	for height := int32(0); height <= 4; height++ {
+		block := blockForTest(hashPrev, byte(height))
+		chain.index(chain.write(0, block, height))
+		a = append(a, block)
+		hashPrev = block.Hash()
+	}
+	hashPrev = a[1].Hash()
+	for height := int32(2); height <= 3; height++ {
+		block := blockForTest(hashPrev, byte(100+height))
+		chain.index(chain.write(1, block, height))
+		b = append(b, block)
+		hashPrev = block.Hash()
+	}
+
+	return NewChainIndex(chain.entries), a, b
+}
+
+func TestChainEvents(t *testing.T) {
+	dataDir := t.TempDir()
+	index, a, b := forkedChainForTest(t, dataDir)
+	if index.Tip().Hash.String() != a[4].Hash().String() {
+		t.Fatalf("Got tip %s", index.Tip().Hash)
+	}
+
+	// Disconnections tip first, then the connections of the new branch
+	want := []struct {
+		eventType EventType
+		block     *blockchainparser.Block
+		height    int32
+	}{
+		{EVENT_BLOCK_DISCONNECTED, b[1], 3},
+		{EVENT_BLOCK_DISCONNECTED, b[0], 2},
+		{EVENT_BLOCK_CONNECTED, a[2], 2},
+		{EVENT_BLOCK_CONNECTED, a[3], 3},
+		{EVENT_BLOCK_CONNECTED, a[4], 4},
+	}
+	var events []Event
+	err := ChainEvents(index, blockchainparser.RegTestParams, dataDir, b[1].Hash(), func(event Event) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Got %d events", len(events))
+	}
+	for i, event := range events {
+		if event.Type != want[i].eventType || event.Block.Hash().String() != want[i].block.Hash().String() || event.Block.Height != want[i].height {
+			t.Errorf("Event %d: got %s of %s at height %d", i, event.Type, event.Block.Hash(), event.Block.Height)
+		}
+		if event.Undo == nil || len(event.Undo.TxUndo) != 0 {
+			t.Errorf("Event %d: got undo %+v", i, event.Undo)
+		}
+	}
+
+	// Errors of fn stop the events
+	stop := errors.New("stop")
+	calls := 0
+	err = ChainEvents(index, blockchainparser.RegTestParams, dataDir, b[1].Hash(), func(event Event) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Got %v after %d calls", err, calls)
+	}
+
+	// The undo data must match the block
+	path := blockchainparser.UndoFilePath(dataDir, 1)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[BLOCK_RECORD_HEADER_SIZE]++
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	calls = 0
+	err = ChainEvents(index, blockchainparser.RegTestParams, dataDir, b[1].Hash(), func(event Event) error {
+		calls++
+		return nil
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Tampered undo data: got %v after %d calls", err, calls)
+	}
+}
@@ -0,0 +1,244 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ruqqq/blockchainparser"
+	"github.com/ruqqq/blockchainparser/db"
+)
+
+// Returns a fresh copy of the block index and the last blk*.dat number used
+type IndexLoader func() (*ChainIndex, uint32, error)
+
+// Loads the block index of a bitcoind data directory, which bitcoind may be
+// running on, with the tip of its chainstate
+func DataDirIndexLoader(dataDir string) IndexLoader {
+	return func() (*ChainIndex, uint32, error) {
+		indexDb, err := db.OpenIndexDb(dataDir)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer indexDb.Close()
+
+		lastFile, err := db.GetLastBlockFileNumberUsed(indexDb)
+		if err != nil {
+			return nil, 0, err
+		}
+		index, err := LoadDataDirChainIndex(indexDb, dataDir)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return index, lastFile, nil
+	}
+}
+
+type FollowOptions struct {
+	PollInterval time.Duration // defaults to one second
+	LoadIndex    IndexLoader   // defaults to DataDirIndexLoader
+
+	// Report the changes since this checkpoint first. Without it following
+	// starts at the current tip.
+	From *Checkpoint
+}
+
+// Tails the blk*.dat files while bitcoind writes them. New complete records
+// of the last file (and the files created after it) are parsed as they
+// appear, and the block index is reloaded to find the tip changes. Events
+// therefore follow the index as bitcoind flushes it to disk.
+type Follower struct {
+	params  *blockchainparser.ChainParams
+	dataDir string
+	opts    FollowOptions
+
+	index    *ChainIndex
+	tipHash  blockchainparser.Hash256
+	lastFile uint32
+	offset   int64                    // end of the complete records of lastFile
+	pending  map[string]*ScannedBlock // read from the files but not in the index yet
+
+	errLock sync.Mutex
+	err     error
+}
+
+func NewFollower(params *blockchainparser.ChainParams, dataDir string, opts FollowOptions) *Follower {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.LoadIndex == nil {
+		opts.LoadIndex = DataDirIndexLoader(dataDir)
+	}
+
+	return &Follower{params: params, dataDir: dataDir, opts: opts, pending: make(map[string]*ScannedBlock)}
+}
+
+// Starts following. The channel is closed on the first error (see Err) or
+// when ctx is cancelled.
+func (follower *Follower) Follow(ctx context.Context) <-chan Event {
+	out := make(chan Event, SCAN_BUFFER_PER_WORKER)
+
+	go func() {
+		defer close(out)
+		if err := follower.start(ctx, out); err != nil {
+			follower.fail(err)
+			return
+		}
+
+		ticker := time.NewTicker(follower.opts.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := follower.poll(ctx, out); err != nil {
+					follower.fail(err)
+					return
+				}
+			case <-ctx.Done():
+				follower.fail(ctx.Err())
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// Error that stopped following, or the context error if it was cancelled
+func (follower *Follower) Err() error {
+	follower.errLock.Lock()
+	defer follower.errLock.Unlock()
+
+	return follower.err
+}
+
+func (follower *Follower) fail(err error) {
+	follower.errLock.Lock()
+	defer follower.errLock.Unlock()
+
+	if follower.err == nil {
+		follower.err = err
+	}
+}
+
+func (follower *Follower) start(ctx context.Context, out chan<- Event) error {
+	index, lastFile, err := follower.opts.LoadIndex()
+	if err != nil {
+		return err
+	}
+
+	// Tail the last file from the tip, as the blocks written after it may
+	// not be in the index yet
+	follower.lastFile = lastFile
+	if tip := index.Tip(); tip != nil && uint32(tip.NFile) == lastFile {
+		follower.offset = int64(tip.NDataPos) - BLOCK_RECORD_HEADER_SIZE
+	}
+
+	if follower.opts.From == nil {
+		follower.index = index
+		if tip := index.Tip(); tip != nil {
+			follower.tipHash = tip.Hash
+		}
+		return nil
+	}
+
+	follower.tipHash, err = blockchainparser.NewHash256FromString(follower.opts.From.Hash)
+	if err != nil {
+		return err
+	}
+
+	return follower.updateTip(ctx, index, out)
+}
+
+func (follower *Follower) poll(ctx context.Context, out chan<- Event) error {
+	grew, err := follower.readNewRecords()
+	if err != nil {
+		return err
+	}
+	if !grew && len(follower.pending) == 0 {
+		return nil
+	}
+
+	index, lastFile, err := follower.opts.LoadIndex()
+	if err != nil {
+		return err
+	}
+	index.keepTipOnTie(follower.tipHash)
+	if lastFile > follower.lastFile {
+		follower.lastFile = lastFile
+		follower.offset = 0
+	}
+	if err := follower.updateTip(ctx, index, out); err != nil {
+		return err
+	}
+
+	// Indexed blocks that weren't connected are stale
+	for key := range follower.pending {
+		if index.Get(blockchainparser.Hash256(key)) != nil {
+			delete(follower.pending, key)
+		}
+	}
+
+	return nil
+}
+
+// Parses the records appended to the last file and to the files after it.
+// An incomplete record is read again on the next poll, from the same offset.
+func (follower *Follower) readNewRecords() (bool, error) {
+	grew := false
+	for {
+		// bitcoind moves to a new file once the current one is full, so the
+		// records written before the next file appeared are complete
+		_, nextErr := os.Stat(blockchainparser.BlockFilePath(follower.dataDir, follower.lastFile+1))
+		offset, err := ReadBlockFile(follower.params, follower.dataDir, follower.lastFile, follower.offset, func(block *ScannedBlock) error {
+			follower.pending[string(block.Hash())] = block
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return grew, err
+		}
+		grew = grew || offset != follower.offset
+		follower.offset = offset
+
+		if nextErr != nil {
+			return grew, nil
+		}
+		follower.lastFile++
+		follower.offset = 0
+		grew = true
+	}
+}
+
+// Emits the events moving the chain from the previous tip to the tip of index
+func (follower *Follower) updateTip(ctx context.Context, index *ChainIndex, out chan<- Event) error {
+	follower.index = index
+	tip := index.Tip()
+	if tip == nil || bytes.Equal(follower.tipHash, tip.Hash) {
+		return nil
+	}
+
+	reader := &eventReader{params: follower.params, dataDir: follower.dataDir, cache: follower.pending}
+	return reader.emit(index, follower.tipHash, func(event Event) error {
+		if err := emit(ctx, out, event); err != nil {
+			return err
+		}
+		if event.Type == EVENT_BLOCK_DISCONNECTED {
+			follower.tipHash = event.Block.HashPrev
+		} else {
+			follower.tipHash = event.Block.Hash()
+		}
+		return nil
+	})
+}
+
+func emit(ctx context.Context, out chan<- Event, event Event) error {
+	select {
+	case out <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
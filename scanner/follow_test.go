@@ -0,0 +1,165 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ruqqq/blockchainparser"
+	"github.com/ruqqq/blockchainparser/db"
+)
+
+// blk files and block index growing as bitcoind writes them, read by the
+// follower through loadIndex
+type followedChainForTest struct {
+	t       *testing.T
+	dataDir string
+
+	lock     sync.Mutex
+	entries  []*IndexEntry
+	lastFile uint32
+	loaded   chan struct{} // closed on the first load
+}
+
+func (chain *followedChainForTest) loadIndex() (*ChainIndex, uint32, error) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	select {
+	case <-chain.loaded:
+	default:
+		close(chain.loaded)
+	}
+
+	return NewChainIndex(append([]*IndexEntry{}, chain.entries...)), chain.lastFile, nil
+}
+
+// Appends the record of block to a blk file and its undo data to the rev
+// file, and returns its index entry, which isn't indexed yet
+func (chain *followedChainForTest) write(fileNum uint32, block *blockchainparser.Block, height int32) *IndexEntry {
+	offset := appendFileForTest(chain.t, blockchainparser.BlockFilePath(chain.dataDir, fileNum), blockRecordForTest(block))
+	undoOffset := appendFileForTest(chain.t, blockchainparser.UndoFilePath(chain.dataDir, fileNum), undoRecordForTest(block.HashPrev, []byte{0}))
+
+	return &IndexEntry{Hash: block.Hash(), BlockIndexRecord: &db.BlockIndexRecord{
+		Height:   height,
+		Status:   db.BLOCK_VALID_SCRIPTS | db.BLOCK_HAVE_DATA | db.BLOCK_HAVE_UNDO,
+		NFile:    int32(fileNum),
+		NDataPos: uint32(offset + BLOCK_RECORD_HEADER_SIZE),
+		NUndoPos: uint32(undoOffset + BLOCK_RECORD_HEADER_SIZE),
+		HashPrev: block.HashPrev,
+		NBits:    block.TargetDifficulty,
+	}}
+}
+
+// Appends data to the file at path and returns the offset it was written at
+func appendFileForTest(t *testing.T, path string, data []byte) int64 {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	return info.Size()
+}
+
+// Flushes entries to the block index
+func (chain *followedChainForTest) index(entries ...*IndexEntry) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	for _, entry := range entries {
+		chain.entries = append(chain.entries, entry)
+		if uint32(entry.NFile) > chain.lastFile {
+			chain.lastFile = uint32(entry.NFile)
+		}
+	}
+}
+
+func nextEventForTest(t *testing.T, events <-chan Event) Event {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("Follower stopped")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event")
+	}
+
+	return Event{}
+}
+
+func expectEventForTest(t *testing.T, events <-chan Event, eventType EventType, block *blockchainparser.Block, height int32, fileNum uint32) {
+	event := nextEventForTest(t, events)
+	if event.Type != eventType || event.Block.Hash().String() != block.Hash().String() {
+		t.Fatalf("Got %s of %s, want %s of %s", event.Type, event.Block.Hash(), eventType, block.Hash())
+	}
+	if event.Block.Height != height || event.Block.FileNum != fileNum {
+		t.Errorf("%s of %s: got height %d in file %d", eventType, block.Hash(), event.Block.Height, event.Block.FileNum)
+	}
+	if event.Undo == nil {
+		t.Errorf("%s of %s: no undo data", eventType, block.Hash())
+	}
+}
+
+func TestFollower(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.MkdirAll(dataDir+"/blocks", 0755); err != nil {
+		t.Fatal(err)
+	}
+	chain := &followedChainForTest{t: t, dataDir: dataDir, loaded: make(chan struct{})}
+	var blocks []*blockchainparser.Block
+	hashPrev := make(blockchainparser.Hash256, 32)
+	for height := int32(0); height < 3; height++ {
+		block := blockForTest(hashPrev, byte(height))
+		chain.index(chain.write(0, block, height))
+		blocks = append(blocks, block)
+		hashPrev = block.Hash()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	follower := NewFollower(blockchainparser.RegTestParams, dataDir, FollowOptions{PollInterval: 5 * time.Millisecond, LoadIndex: chain.loadIndex})
+	events := follower.Follow(ctx)
+	<-chain.loaded
+
+	// Appended to the last file
+	b3 := blockForTest(blocks[2].Hash(), 3)
+	chain.index(chain.write(0, b3, 3))
+	expectEventForTest(t, events, EVENT_BLOCK_CONNECTED, b3, 3, 0)
+
+	// Roll-over to a new file
+	b4 := blockForTest(b3.Hash(), 4)
+	chain.index(chain.write(1, b4, 4))
+	expectEventForTest(t, events, EVENT_BLOCK_CONNECTED, b4, 4, 1)
+
+	// A longer branch from b3, reported once indexed only
+	s4 := blockForTest(b3.Hash(), 100)
+	s5 := blockForTest(s4.Hash(), 101)
+	branch := []*IndexEntry{chain.write(1, s4, 4), chain.write(1, s5, 5)}
+	select {
+	case event := <-events:
+		t.Fatalf("Got %s of %s before the index", event.Type, event.Block.Hash())
+	case <-time.After(50 * time.Millisecond):
+	}
+	chain.index(branch...)
+	expectEventForTest(t, events, EVENT_BLOCK_DISCONNECTED, b4, 4, 1)
+	expectEventForTest(t, events, EVENT_BLOCK_CONNECTED, s4, 4, 1)
+	expectEventForTest(t, events, EVENT_BLOCK_CONNECTED, s5, 5, 1)
+
+	cancel()
+	for event := range events {
+		t.Errorf("Unexpected %s of %s", event.Type, event.Block.Hash())
+	}
+	if err := follower.Err(); err != context.Canceled {
+		t.Errorf("Got %v after cancel", err)
+	}
+}
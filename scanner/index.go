@@ -0,0 +1,209 @@
+package scanner
+
+import (
+	"errors"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/ruqqq/blockchainparser"
+	"github.com/ruqqq/blockchainparser/db"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Block of the block index with its hash and cumulative work
+type IndexEntry struct {
+	*db.BlockIndexRecord
+	Hash      blockchainparser.Hash256
+	ChainWork *big.Int
+}
+
+// In memory copy of bitcoind's block index and its active chain
+type ChainIndex struct {
+	entries map[string]*IndexEntry
+	chain   []*IndexEntry // active chain, by height
+	pinned  bool          // tip taken from bitcoind's chainstate
+}
+
+// Reads every record of the block index and selects the active chain, see
+// NewChainIndex
+func LoadChainIndex(indexDb *db.IndexDb) (*ChainIndex, error) {
+	return LoadChainIndexWithTip(indexDb, nil)
+}
+
+// Reads every record of the block index, with the active chain ending at
+// tipHash, see NewChainIndexWithTip
+func LoadChainIndexWithTip(indexDb *db.IndexDb, tipHash blockchainparser.Hash256) (*ChainIndex, error) {
+	var entries []*IndexEntry
+	err := db.ForEachBlockIndexRecord(indexDb, func(blockHash blockchainparser.Hash256, record *db.BlockIndexRecord) error {
+		entries = append(entries, &IndexEntry{BlockIndexRecord: record, Hash: blockHash})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewChainIndexWithTip(entries, tipHash), nil
+}
+
+// Reads the block index of a bitcoind data directory, with the active chain
+// ending at the best block of its chainstate when there is one
+func LoadDataDirChainIndex(indexDb *db.IndexDb, dataDir string) (*ChainIndex, error) {
+	if _, err := os.Stat(dataDir + "/chainstate/"); os.IsNotExist(err) {
+		return LoadChainIndex(indexDb)
+	}
+	chainstateDb, err := db.OpenChainstateDb(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer chainstateDb.Close()
+
+	tipHash, err := db.GetBestBlock(chainstateDb)
+	if err != nil && err != leveldb.ErrNotFound {
+		return nil, err
+	}
+
+	return LoadChainIndexWithTip(indexDb, tipHash)
+}
+
+// Builds the index from its entries. The active chain ends at the fully
+// validated, non failed block with the most work. bitcoind keeps the first
+// block it received among the ones with the same work, which the index
+// doesn't record: use NewChainIndexWithTip with bitcoind's tip when it
+// matters.
+func NewChainIndex(entries []*IndexEntry) *ChainIndex {
+	return NewChainIndexWithTip(entries, nil)
+}
+
+// Builds the index from its entries, with the active chain ending at
+// tipHash, as found in bitcoind's chainstate. Falls back on the most work
+// block when tipHash is nil or unknown.
+func NewChainIndexWithTip(entries []*IndexEntry, tipHash blockchainparser.Hash256) *ChainIndex {
+	index := &ChainIndex{entries: make(map[string]*IndexEntry, len(entries))}
+
+	// Parents always have a lower height, so their work is known first
+	sorted := append([]*IndexEntry{}, entries...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Height < sorted[j].Height })
+
+	var tip *IndexEntry
+	for _, entry := range sorted {
+		entry.ChainWork = blockchainparser.GetBlockWork(entry.NBits)
+		if parent := index.Get(entry.HashPrev); parent != nil {
+			entry.ChainWork.Add(entry.ChainWork, parent.ChainWork)
+		}
+		index.entries[string(entry.Hash)] = entry
+
+		if entry.Status&db.BLOCK_VALID_MASK < db.BLOCK_VALID_SCRIPTS || entry.Status&db.BLOCK_FAILED_MASK != 0 {
+			continue
+		}
+		if tip == nil || entry.ChainWork.Cmp(tip.ChainWork) > 0 {
+			tip = entry
+		}
+	}
+	if entry := index.Get(tipHash); entry != nil {
+		tip = entry
+		index.pinned = true
+	}
+	index.setTip(tip)
+
+	return index
+}
+
+func (index *ChainIndex) setTip(tip *IndexEntry) {
+	index.chain = nil
+	if tip == nil {
+		return
+	}
+
+	index.chain = make([]*IndexEntry, tip.Height+1)
+	for entry := tip; entry != nil; entry = index.Get(entry.HashPrev) {
+		index.chain[entry.Height] = entry
+	}
+}
+
+// Moves the active chain to tipHash when it is a valid block with as much
+// work as the tip, as bitcoind doesn't switch between chains of equal work.
+// A tip taken from the chainstate is kept.
+func (index *ChainIndex) keepTipOnTie(tipHash blockchainparser.Hash256) {
+	entry, tip := index.Get(tipHash), index.Tip()
+	if index.pinned || entry == nil || tip == nil || entry == tip || entry.ChainWork.Cmp(tip.ChainWork) != 0 {
+		return
+	}
+	if entry.Status&db.BLOCK_VALID_MASK < db.BLOCK_VALID_SCRIPTS || entry.Status&db.BLOCK_FAILED_MASK != 0 {
+		return
+	}
+
+	index.setTip(entry)
+}
+
+// Returns the entry of blockHash, nil if it isn't in the index
+func (index *ChainIndex) Get(blockHash blockchainparser.Hash256) *IndexEntry {
+	return index.entries[string(blockHash)]
+}
+
+// Height of the active chain tip, -1 when the chain is empty
+func (index *ChainIndex) Height() int32 {
+	return int32(len(index.chain)) - 1
+}
+
+func (index *ChainIndex) Tip() *IndexEntry {
+	if len(index.chain) == 0 {
+		return nil
+	}
+
+	return index.chain[len(index.chain)-1]
+}
+
+// Returns the active chain block at height, nil if out of range
+func (index *ChainIndex) AtHeight(height int32) *IndexEntry {
+	if height < 0 || height >= int32(len(index.chain)) {
+		return nil
+	}
+
+	return index.chain[height]
+}
+
+// Whether blockHash is part of the active chain
+func (index *ChainIndex) Contains(blockHash blockchainparser.Hash256) bool {
+	entry := index.Get(blockHash)
+	return entry != nil && index.AtHeight(entry.Height) == entry
+}
+
+// Last common block of the active chain and the chain ending at blockHash,
+// nil if blockHash is unknown
+func (index *ChainIndex) FindFork(blockHash blockchainparser.Hash256) *IndexEntry {
+	for entry := index.Get(blockHash); entry != nil; entry = index.Get(entry.HashPrev) {
+		if index.AtHeight(entry.Height) == entry {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// Blocks to disconnect, tip first, and to connect, by height, to move the
+// chain from the block from (nil for an empty chain) to the active tip.
+// Stale blocks are followed back to the fork point through their HashPrev.
+func (index *ChainIndex) ReorgPath(from blockchainparser.Hash256) ([]*IndexEntry, []*IndexEntry, error) {
+	var disconnect []*IndexEntry
+	forkHeight := int32(-1)
+	for hash := from; hash != nil; {
+		entry := index.Get(hash)
+		if entry == nil {
+			return nil, nil, errors.New("Block " + hash.String() + " is not in the block index")
+		}
+		if index.Contains(entry.Hash) {
+			forkHeight = entry.Height
+			break
+		}
+		disconnect = append(disconnect, entry)
+		hash = entry.HashPrev
+	}
+
+	var connect []*IndexEntry
+	for height := forkHeight + 1; height <= index.Height(); height++ {
+		connect = append(connect, index.AtHeight(height))
+	}
+
+	return disconnect, connect, nil
+}
@@ -0,0 +1,134 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/ruqqq/blockchainparser"
+	"github.com/ruqqq/blockchainparser/db"
+)
+
+func indexEntryForTest(name string, parent *IndexEntry) *IndexEntry {
+	record := &db.BlockIndexRecord{Status: db.BLOCK_VALID_SCRIPTS | db.BLOCK_HAVE_DATA, NBits: 0x207fffff}
+	if parent != nil {
+		record.Height = parent.Height + 1
+		record.HashPrev = parent.Hash
+	}
+
+	return &IndexEntry{BlockIndexRecord: record, Hash: blockchainparser.DoubleSha256([]byte(name))}
+}
+
+// Two branches of equal work after the genesis block, and a shorter third one
+func forkEntriesForTest() (genesis, a, b, c *IndexEntry, entries []*IndexEntry) {
+	genesis = indexEntryForTest("genesis", nil)
+	a1 := indexEntryForTest("a1", genesis)
+	a = indexEntryForTest("a2", a1)
+	b1 := indexEntryForTest("b1", genesis)
+	b = indexEntryForTest("b2", b1)
+	c = indexEntryForTest("c1", genesis)
+
+	return genesis, a, b, c, []*IndexEntry{genesis, a1, a, b1, b, c}
+}
+
+func TestNewChainIndexWithTip(t *testing.T) {
+	_, a, b, c, entries := forkEntriesForTest()
+
+	tests := []struct {
+		tipHash blockchainparser.Hash256
+		want    *IndexEntry
+	}{
+		{a.Hash, a},
+		{b.Hash, b},
+		{c.Hash, c}, // chainstate behind the block index
+		{nil, a},    // first of the most work blocks
+		{blockchainparser.DoubleSha256([]byte("unknown")), a},
+	}
+	for _, test := range tests {
+		index := NewChainIndexWithTip(entries, test.tipHash)
+		if index.Tip() != test.want {
+			t.Errorf("Tip %s: got %s, want %s", test.tipHash, index.Tip().Hash, test.want.Hash)
+			continue
+		}
+		if index.Height() != test.want.Height || !index.Contains(test.want.HashPrev) {
+			t.Errorf("Tip %s: active chain doesn't lead to the tip", test.tipHash)
+		}
+	}
+
+	// Entries are read in hash order: the order must not pick the tip
+	reversed := []*IndexEntry{entries[0], entries[4], entries[3], entries[2], entries[1], entries[5]}
+	if tip := NewChainIndexWithTip(reversed, a.Hash).Tip(); tip != a {
+		t.Errorf("Got tip %s, want %s", tip.Hash, a.Hash)
+	}
+}
+
+func TestKeepTipOnTie(t *testing.T) {
+	_, a, b, c, entries := forkEntriesForTest()
+
+	index := NewChainIndex(entries)
+	index.keepTipOnTie(b.Hash)
+	if index.Tip() != b {
+		t.Errorf("Previous tip of equal work not kept, got %s", index.Tip().Hash)
+	}
+	index.keepTipOnTie(c.Hash)
+	if index.Tip() != b {
+		t.Errorf("Moved to a tip with less work, got %s", index.Tip().Hash)
+	}
+
+	// More work on the other branch
+	a3 := indexEntryForTest("a3", a)
+	index = NewChainIndex(append(entries, a3))
+	index.keepTipOnTie(b.Hash)
+	if index.Tip() != a3 {
+		t.Errorf("Previous tip kept over more work, got %s", index.Tip().Hash)
+	}
+
+	index = NewChainIndexWithTip(entries, a.Hash)
+	index.keepTipOnTie(b.Hash)
+	if index.Tip() != a {
+		t.Errorf("Chainstate tip replaced, got %s", index.Tip().Hash)
+	}
+}
+
+func TestReorgPath(t *testing.T) {
+	genesis, a, b, c, entries := forkEntriesForTest()
+	index := NewChainIndexWithTip(entries, a.Hash)
+	a1, b1 := index.Get(a.HashPrev), index.Get(b.HashPrev)
+
+	tests := []struct {
+		from       blockchainparser.Hash256
+		disconnect []*IndexEntry
+		connect    []*IndexEntry
+	}{
+		{b.Hash, []*IndexEntry{b, b1}, []*IndexEntry{a1, a}},
+		{c.Hash, []*IndexEntry{c}, []*IndexEntry{a1, a}},
+		{genesis.Hash, nil, []*IndexEntry{a1, a}},
+		{a.Hash, nil, nil},
+		{nil, nil, []*IndexEntry{genesis, a1, a}},
+	}
+	for _, test := range tests {
+		disconnect, connect, err := index.ReorgPath(test.from)
+		if err != nil {
+			t.Errorf("From %s: %s", test.from, err)
+			continue
+		}
+		if !sameEntriesForTest(disconnect, test.disconnect) || !sameEntriesForTest(connect, test.connect) {
+			t.Errorf("From %s: got %d disconnected and %d connected blocks", test.from, len(disconnect), len(connect))
+		}
+	}
+
+	if _, _, err := index.ReorgPath(blockchainparser.DoubleSha256([]byte("unknown"))); err == nil {
+		t.Error("Unknown block passed")
+	}
+}
+
+func sameEntriesForTest(got, want []*IndexEntry) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,483 @@
+// Package scanner reads whole chains from the blk*.dat files, parsing files
+// in parallel and optionally delivering blocks in active chain order.
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ruqqq/blockchainparser"
+	"github.com/ruqqq/blockchainparser/db"
+)
+
+const (
+	//! Size of the magic id and length preceding every block in blk*.dat
+	BLOCK_RECORD_HEADER_SIZE = 8
+
+	//! Number of blocks buffered between the workers and the consumer, per worker
+	SCAN_BUFFER_PER_WORKER = 16
+)
+
+type Options struct {
+	Workers int // files parsed in parallel, defaults to the number of CPUs
+
+	// Deliver blocks of the active chain by increasing height and skip the
+	// others. Requires Index.
+	Ordered bool
+	// Block index used to find the height of blocks, optional unless Ordered
+	Index *ChainIndex
+
+	StartFile   uint32 // first blk*.dat to read
+	StartOffset int64  // offset of the first record in StartFile
+	FileCount   int    // number of files to read from StartFile, 0 for all
+
+	// Ordered scans: first height to deliver. When set, StartFile and
+	// StartOffset are derived from the block index.
+	StartHeight int32
+
+	ProgressInterval time.Duration // defaults to 10 seconds
+	OnProgress       func(Progress)
+}
+
+// Parsed block with its location
+type ScannedBlock struct {
+	*blockchainparser.Block
+	FileNum uint32
+	Offset  int64 // of the magic id in the file
+	Height  int32 // -1 when unknown or not in the active chain
+}
+
+type Progress struct {
+	FilesTotal int
+	FilesDone  int
+	Blocks     uint64
+	Bytes      uint64 // size of the parsed block records
+	Elapsed    time.Duration
+}
+
+func (progress Progress) BlocksPerSecond() float64 {
+	if progress.Elapsed <= 0 {
+		return 0
+	}
+	return float64(progress.Blocks) / progress.Elapsed.Seconds()
+}
+
+func (progress Progress) BytesPerSecond() float64 {
+	if progress.Elapsed <= 0 {
+		return 0
+	}
+	return float64(progress.Bytes) / progress.Elapsed.Seconds()
+}
+
+type Scanner struct {
+	params  *blockchainparser.ChainParams
+	dataDir string
+	opts    Options
+
+	files     []uint32
+	expected  []*IndexEntry // ordered scans: active chain blocks to deliver
+	window    *fileWindow   // ordered scans: limits the files read ahead
+	start     time.Time
+	blocks    uint64
+	bytes     uint64
+	filesDone int64
+
+	errLock sync.Mutex
+	err     error
+}
+
+// Scanner of the blk*.dat files of dataDir (the network specific directory,
+// see ChainParams.DataDir)
+func New(params *blockchainparser.ChainParams, dataDir string, opts Options) *Scanner {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = 10 * time.Second
+	}
+
+	return &Scanner{params: params, dataDir: dataDir, opts: opts}
+}
+
+// Starts the scan. The returned channel is closed when every file was read,
+// on the first error (see Err) or when ctx is cancelled.
+func (scanner *Scanner) Scan(ctx context.Context) <-chan *ScannedBlock {
+	out := make(chan *ScannedBlock, scanner.opts.Workers*SCAN_BUFFER_PER_WORKER)
+	if scanner.opts.Ordered && scanner.opts.Index == nil {
+		scanner.fail(fmt.Errorf("Ordered scan requires a block index"))
+		close(out)
+		return out
+	}
+
+	scanner.start = time.Now()
+	if scanner.opts.Ordered && scanner.opts.StartHeight > 0 {
+		scanner.opts.StartFile, scanner.opts.StartOffset = scanner.startFromHeight()
+	}
+	scanner.files = scanner.listFiles()
+	if scanner.opts.Ordered {
+		scanner.expected = scanner.expectedBlocks()
+		scanner.window = newFileWindow(scanner.expected)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	results := make(chan *ScannedBlock, cap(out))
+	jobs := make(chan uint32)
+	var wg sync.WaitGroup
+	for i := 0; i < scanner.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fileNum := range jobs {
+				if err := scanner.scanFile(ctx, fileNum, results); err != nil {
+					scanner.fail(err)
+					cancel()
+				}
+				atomic.AddInt64(&scanner.filesDone, 1)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, fileNum := range scanner.files {
+			if scanner.window != nil && scanner.window.wait(ctx, fileNum, scanner.opts.Workers) != nil {
+				return
+			}
+			select {
+			case jobs <- fileNum:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	if scanner.opts.OnProgress != nil {
+		go scanner.reportProgress(done, stopped)
+	} else {
+		close(stopped)
+	}
+
+	go func() {
+		defer cancel()
+		defer close(out)
+		if scanner.opts.Ordered {
+			scanner.resequence(ctx, results, out)
+		} else {
+			forward(ctx, results, out)
+		}
+		if scanner.Err() == nil && ctx.Err() != nil {
+			scanner.fail(ctx.Err())
+		}
+
+		// The last report comes before the channel is closed
+		close(done)
+		<-stopped
+		if scanner.opts.OnProgress != nil {
+			scanner.opts.OnProgress(scanner.Progress())
+		}
+	}()
+
+	return out
+}
+
+// Error that stopped the scan, or the context error if it was cancelled
+func (scanner *Scanner) Err() error {
+	scanner.errLock.Lock()
+	defer scanner.errLock.Unlock()
+
+	return scanner.err
+}
+
+func (scanner *Scanner) Progress() Progress {
+	return Progress{
+		FilesTotal: len(scanner.files),
+		FilesDone:  int(atomic.LoadInt64(&scanner.filesDone)),
+		Blocks:     atomic.LoadUint64(&scanner.blocks),
+		Bytes:      atomic.LoadUint64(&scanner.bytes),
+		Elapsed:    time.Since(scanner.start),
+	}
+}
+
+// Keeps the first error
+func (scanner *Scanner) fail(err error) {
+	scanner.errLock.Lock()
+	defer scanner.errLock.Unlock()
+
+	if scanner.err == nil {
+		scanner.err = err
+	}
+}
+
+func (scanner *Scanner) reportProgress(done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(scanner.opts.ProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			scanner.opts.OnProgress(scanner.Progress())
+		case <-done:
+			return
+		}
+	}
+}
+
+// Existing blk*.dat files from StartFile
+func (scanner *Scanner) listFiles() []uint32 {
+	var files []uint32
+	for fileNum := scanner.opts.StartFile; scanner.opts.FileCount <= 0 || len(files) < scanner.opts.FileCount; fileNum++ {
+		if _, err := os.Stat(blockchainparser.BlockFilePath(scanner.dataDir, fileNum)); err != nil {
+			break
+		}
+		files = append(files, fileNum)
+	}
+
+	return files
+}
+
+func (scanner *Scanner) scanFile(ctx context.Context, fileNum uint32, results chan<- *ScannedBlock) error {
+	offset := int64(0)
+	if fileNum == scanner.opts.StartFile {
+		offset = scanner.opts.StartOffset
+	}
+
+	_, err := ReadBlockFile(scanner.params, scanner.dataDir, fileNum, offset, func(block *ScannedBlock) error {
+		block.Height = -1
+		if scanner.opts.Index != nil {
+			if entry := scanner.opts.Index.Get(block.Hash()); entry != nil && scanner.opts.Index.Contains(entry.Hash) {
+				block.Height = entry.Height
+			}
+		}
+		atomic.AddUint64(&scanner.blocks, 1)
+		atomic.AddUint64(&scanner.bytes, uint64(block.Length)+BLOCK_RECORD_HEADER_SIZE)
+
+		select {
+		case results <- block:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	return err
+}
+
+// Calls fn for every complete block record of a blk*.dat file from offset,
+// and returns the offset following the last one. Reading stops at the zero
+// padding bitcoind preallocates and before a record still being written.
+// As the padding makes the file large enough for any record, a record which
+// doesn't parse or whose merkle root doesn't match is taken as incomplete.
+func ReadBlockFile(params *blockchainparser.ChainParams, dataDir string, fileNum uint32, offset int64, fn func(*ScannedBlock) error) (int64, error) {
+	blockFile, err := blockchainparser.NewBlockFile(dataDir, fileNum)
+	if err != nil {
+		return offset, err
+	}
+	defer blockFile.Close()
+
+	size, err := blockFile.Size()
+	if err != nil {
+		return offset, err
+	}
+
+	for offset+BLOCK_RECORD_HEADER_SIZE <= size {
+		if _, err := blockFile.Seek(offset, io.SeekStart); err != nil {
+			return offset, err
+		}
+		header, err := blockFile.Peek(BLOCK_RECORD_HEADER_SIZE)
+		if err != nil {
+			return offset, err
+		}
+		magicId := blockchainparser.MagicId(binary.LittleEndian.Uint32(header))
+		if magicId == 0 {
+			break
+		}
+		if magicId != params.MagicId {
+			return offset, fmt.Errorf("Unexpected magic id %s in file %d at offset %d", magicId, fileNum, offset)
+		}
+		length := int64(binary.LittleEndian.Uint32(header[4:]))
+		if offset+BLOCK_RECORD_HEADER_SIZE+length > size {
+			break
+		}
+
+		block, err := params.ParseBlockFromFile(blockFile)
+		if err != nil || len(block.Transactions) == 0 || !bytes.Equal(block.MerkleRoot(), block.HashMerkle) {
+			break
+		}
+		if err := fn(&ScannedBlock{Block: block, FileNum: fileNum, Offset: offset, Height: -1}); err != nil {
+			return offset, err
+		}
+		offset += BLOCK_RECORD_HEADER_SIZE + length
+	}
+
+	return offset, nil
+}
+
+func forward(ctx context.Context, results <-chan *ScannedBlock, out chan<- *ScannedBlock) {
+	for block := range results {
+		select {
+		case out <- block:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Delivers the active chain blocks stored in the scanned range by height,
+// buffering the ones read ahead of their turn. Blocks which aren't expected,
+// like the ones below StartHeight, are dropped.
+func (scanner *Scanner) resequence(ctx context.Context, results <-chan *ScannedBlock, out chan<- *ScannedBlock) {
+	expected := scanner.expected
+	wanted := make(map[int32]bool, len(expected))
+	for _, entry := range expected {
+		wanted[entry.Height] = true
+	}
+	pending := make(map[int32]*ScannedBlock)
+	next := 0
+
+	flush := func() bool {
+		for next < len(expected) {
+			block, ok := pending[expected[next].Height]
+			if !ok {
+				return true
+			}
+			delete(pending, expected[next].Height)
+			select {
+			case out <- block:
+			case <-ctx.Done():
+				return false
+			}
+			next++
+			scanner.window.advance(expected, next)
+		}
+		return true
+	}
+
+	for block := range results {
+		if !wanted[block.Height] || (next < len(expected) && block.Height < expected[next].Height) {
+			continue
+		}
+		pending[block.Height] = block
+		if !flush() {
+			return
+		}
+	}
+
+	if next < len(expected) && scanner.Err() == nil && ctx.Err() == nil {
+		entry := expected[next]
+		scanner.fail(fmt.Errorf("Block %s at height %d not found in file %d", entry.Hash, entry.Height, entry.NFile))
+	}
+}
+
+// File of the next block to deliver in an ordered scan. Files are dispatched
+// a few files past it at most, so that the blocks buffered while waiting for
+// it are bounded.
+type fileWindow struct {
+	lock    sync.Mutex
+	file    int64
+	changed chan struct{} // closed when file changes
+}
+
+func newFileWindow(expected []*IndexEntry) *fileWindow {
+	window := &fileWindow{changed: make(chan struct{})}
+	window.advance(expected, 0)
+
+	return window
+}
+
+// Moves the window to the file of expected[next]. Once every block was
+// delivered the remaining files are read without limit.
+func (window *fileWindow) advance(expected []*IndexEntry, next int) {
+	file := int64(math.MaxInt64 / 2)
+	if next < len(expected) {
+		file = int64(expected[next].NFile)
+	}
+
+	window.lock.Lock()
+	defer window.lock.Unlock()
+	if file != window.file {
+		window.file = file
+		close(window.changed)
+		window.changed = make(chan struct{})
+	}
+}
+
+// Waits until fileNum is at most ahead files past the window. The file of
+// the window is always dispatched before, as files are dispatched in order.
+func (window *fileWindow) wait(ctx context.Context, fileNum uint32, ahead int) error {
+	for {
+		window.lock.Lock()
+		file, changed := window.file, window.changed
+		window.lock.Unlock()
+		if int64(fileNum) <= file+int64(ahead) {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// First file holding an active chain block from StartHeight
+func (scanner *Scanner) startFromHeight() (uint32, int64) {
+	index := scanner.opts.Index
+	first := int32(-1)
+	for height := scanner.opts.StartHeight; height <= index.Height(); height++ {
+		entry := index.AtHeight(height)
+		if entry != nil && entry.Status&db.BLOCK_HAVE_DATA != 0 && (first < 0 || entry.NFile < first) {
+			first = entry.NFile
+		}
+	}
+	if first < 0 {
+		// Nothing left in the index, read the files written since its tip
+		if tip := index.Tip(); tip != nil {
+			return uint32(tip.NFile), 0
+		}
+		return 0, 0
+	}
+
+	return uint32(first), 0
+}
+
+// Active chain blocks stored in the scanned files, by height
+func (scanner *Scanner) expectedBlocks() []*IndexEntry {
+	if len(scanner.files) == 0 {
+		return nil
+	}
+	first := scanner.files[0]
+	last := scanner.files[len(scanner.files)-1]
+
+	var expected []*IndexEntry
+	index := scanner.opts.Index
+	for height := int32(0); height <= index.Height(); height++ {
+		entry := index.AtHeight(height)
+		if entry == nil || entry.Status&db.BLOCK_HAVE_DATA == 0 || height < scanner.opts.StartHeight {
+			continue
+		}
+		fileNum := uint32(entry.NFile)
+		offset := int64(entry.NDataPos) - BLOCK_RECORD_HEADER_SIZE
+		if fileNum < first || fileNum > last || (fileNum == scanner.opts.StartFile && offset < scanner.opts.StartOffset) {
+			continue
+		}
+		expected = append(expected, entry)
+	}
+
+	return expected
+}
@@ -0,0 +1,205 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ruqqq/blockchainparser"
+	"github.com/ruqqq/blockchainparser/db"
+)
+
+// Regtest block with a coinbase tagged by tag, so that siblings differ
+func blockForTest(hashPrev blockchainparser.Hash256, tag byte) *blockchainparser.Block {
+	coinbase := blockchainparser.Transaction{
+		Version: 1,
+		Vin:     []blockchainparser.TxInput{{Hash: make(blockchainparser.Hash256, 32), Index: 0xffffffff, Script: blockchainparser.Script{1, tag}}},
+		Vout:    []blockchainparser.TxOutput{{Value: 50, Script: blockchainparser.Script{blockchainparser.OP_1}}},
+	}
+	block := &blockchainparser.Block{Transactions: []blockchainparser.Transaction{coinbase}}
+	block.Version = 4
+	block.HashPrev = hashPrev
+	block.Timestamp = time.Unix(1600000000+int64(tag), 0)
+	block.TargetDifficulty = 0x207fffff
+	block.HashMerkle = block.MerkleRoot()
+
+	return block
+}
+
+// Block record as stored in blk*.dat files
+func blockRecordForTest(block *blockchainparser.Block) []byte {
+	body := block.Binary()
+	header := make([]byte, BLOCK_RECORD_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(header, uint32(blockchainparser.BLOCK_MAGIC_ID_REGTEST))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(body)))
+
+	return append(header, body...)
+}
+
+// Writes a regtest chain whose block heights are stored in blk*.dat files as
+// listed by layout, and returns its block index
+func writeChainForTest(t *testing.T, dataDir string, layout [][]int32) *ChainIndex {
+	var blocks []*blockchainparser.Block
+	hashPrev := make(blockchainparser.Hash256, 32)
+	for _, heights := range layout {
+		for range heights {
+			block := blockForTest(hashPrev, byte(len(blocks)))
+			blocks = append(blocks, block)
+			hashPrev = block.Hash()
+		}
+	}
+
+	if err := os.MkdirAll(dataDir+"/blocks", 0755); err != nil {
+		t.Fatal(err)
+	}
+	var entries []*IndexEntry
+	for fileNum, heights := range layout {
+		var data []byte
+		for _, height := range heights {
+			block := blocks[height]
+			entries = append(entries, &IndexEntry{Hash: block.Hash(), BlockIndexRecord: &db.BlockIndexRecord{
+				Height:   height,
+				Status:   db.BLOCK_VALID_SCRIPTS | db.BLOCK_HAVE_DATA,
+				NFile:    int32(fileNum),
+				NDataPos: uint32(len(data) + BLOCK_RECORD_HEADER_SIZE),
+				HashPrev: block.HashPrev,
+				NBits:    block.TargetDifficulty,
+			}})
+			data = append(data, blockRecordForTest(block)...)
+		}
+		if err := os.WriteFile(blockchainparser.BlockFilePath(dataDir, uint32(fileNum)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return NewChainIndex(entries)
+}
+
+func scanHeightsForTest(t *testing.T, scanner *Scanner) []int32 {
+	var heights []int32
+	for block := range scanner.Scan(context.Background()) {
+		heights = append(heights, block.Height)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return heights
+}
+
+func TestOrderedScan(t *testing.T) {
+	dataDir := t.TempDir()
+	// The genesis block comes last, the window must not wait for it
+	index := writeChainForTest(t, dataDir, [][]int32{{2, 1}, {4, 3}, {5, 6}, {8, 7}, {0}})
+
+	for _, workers := range []int{1, 2, 8} {
+		heights := scanHeightsForTest(t, New(blockchainparser.RegTestParams, dataDir, Options{Workers: workers, Ordered: true, Index: index}))
+		if len(heights) != 9 {
+			t.Fatalf("%d workers: got heights %v", workers, heights)
+		}
+		for i, height := range heights {
+			if height != int32(i) {
+				t.Fatalf("%d workers: got heights %v", workers, heights)
+			}
+		}
+	}
+}
+
+// The blocks below StartHeight sharing the first file are dropped
+func TestOrderedScanStartHeight(t *testing.T) {
+	dataDir := t.TempDir()
+	index := writeChainForTest(t, dataDir, [][]int32{{0, 1}, {2, 5, 3}, {4, 6}})
+
+	heights := scanHeightsForTest(t, New(blockchainparser.RegTestParams, dataDir, Options{Workers: 1, Ordered: true, Index: index, StartHeight: 3}))
+	if len(heights) != 4 || heights[0] != 3 || heights[3] != 6 {
+		t.Errorf("Got heights %v", heights)
+	}
+}
+
+func TestFileWindow(t *testing.T) {
+	expected := []*IndexEntry{
+		{BlockIndexRecord: &db.BlockIndexRecord{NFile: 2}},
+		{BlockIndexRecord: &db.BlockIndexRecord{NFile: 5}},
+	}
+	window := newFileWindow(expected)
+	ctx := context.Background()
+
+	if err := window.wait(ctx, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	waited := make(chan error)
+	go func() { waited <- window.wait(ctx, 7, 2) }()
+	select {
+	case <-waited:
+		t.Fatal("File dispatched past the window")
+	case <-time.After(50 * time.Millisecond):
+	}
+	window.advance(expected, 1)
+	if err := <-waited; err != nil {
+		t.Fatal(err)
+	}
+
+	// No limit once every block was delivered
+	window.advance(expected, 2)
+	if err := window.wait(ctx, 1000, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	window.advance(expected, 0)
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := window.wait(ctx, 7, 2); err != context.Canceled {
+		t.Errorf("Got %v after cancel", err)
+	}
+}
+
+// bitcoind preallocates blk files with zeros, so a record being written is
+// followed by enough padding to pass for complete
+func TestReadBlockFileIncompleteRecord(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.MkdirAll(dataDir+"/blocks", 0755); err != nil {
+		t.Fatal(err)
+	}
+	first := blockRecordForTest(blockForTest(make(blockchainparser.Hash256, 32), 0))
+	second := blockRecordForTest(blockForTest(make(blockchainparser.Hash256, 32), 1))
+	padding := make([]byte, 1024)
+
+	for _, written := range []int{BLOCK_RECORD_HEADER_SIZE, BLOCK_RECORD_HEADER_SIZE + 80, len(second) / 2, len(second) - 10} {
+		data := append(append(append([]byte{}, first...), second[:written]...), padding...)
+		if err := os.WriteFile(blockchainparser.BlockFilePath(dataDir, 0), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var blocks []*ScannedBlock
+		offset, err := ReadBlockFile(blockchainparser.RegTestParams, dataDir, 0, 0, func(block *ScannedBlock) error {
+			blocks = append(blocks, block)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%d bytes written: %s", written, err)
+		}
+		if len(blocks) != 1 || offset != int64(len(first)) {
+			t.Errorf("%d bytes written: got %d blocks up to offset %d", written, len(blocks), offset)
+		}
+	}
+
+	// Read from the same offset once complete
+	data := append(append(append([]byte{}, first...), second...), padding...)
+	if err := os.WriteFile(blockchainparser.BlockFilePath(dataDir, 0), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var blocks []*ScannedBlock
+	offset, err := ReadBlockFile(blockchainparser.RegTestParams, dataDir, 0, int64(len(first)), func(block *ScannedBlock) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || blocks[0].Offset != int64(len(first)) || offset != int64(len(first)+len(second)) {
+		t.Errorf("Complete record: got %d blocks up to offset %d", len(blocks), offset)
+	}
+}
@@ -0,0 +1,520 @@
+package blockchainparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Script opcodes, see bitcoind's script/script.h
+const (
+	// push value
+	OP_0         = 0x00
+	OP_FALSE     = OP_0
+	OP_PUSHDATA1 = 0x4c
+	OP_PUSHDATA2 = 0x4d
+	OP_PUSHDATA4 = 0x4e
+	OP_1NEGATE   = 0x4f
+	OP_RESERVED  = 0x50
+	OP_1         = 0x51
+	OP_TRUE      = OP_1
+	OP_2         = 0x52
+	OP_3         = 0x53
+	OP_4         = 0x54
+	OP_5         = 0x55
+	OP_6         = 0x56
+	OP_7         = 0x57
+	OP_8         = 0x58
+	OP_9         = 0x59
+	OP_10        = 0x5a
+	OP_11        = 0x5b
+	OP_12        = 0x5c
+	OP_13        = 0x5d
+	OP_14        = 0x5e
+	OP_15        = 0x5f
+	OP_16        = 0x60
+
+	// control
+	OP_NOP      = 0x61
+	OP_VER      = 0x62
+	OP_IF       = 0x63
+	OP_NOTIF    = 0x64
+	OP_VERIF    = 0x65
+	OP_VERNOTIF = 0x66
+	OP_ELSE     = 0x67
+	OP_ENDIF    = 0x68
+	OP_VERIFY   = 0x69
+	OP_RETURN   = 0x6a
+
+	// stack ops
+	OP_TOALTSTACK   = 0x6b
+	OP_FROMALTSTACK = 0x6c
+	OP_2DROP        = 0x6d
+	OP_2DUP         = 0x6e
+	OP_3DUP         = 0x6f
+	OP_2OVER        = 0x70
+	OP_2ROT         = 0x71
+	OP_2SWAP        = 0x72
+	OP_IFDUP        = 0x73
+	OP_DEPTH        = 0x74
+	OP_DROP         = 0x75
+	OP_DUP          = 0x76
+	OP_NIP          = 0x77
+	OP_OVER         = 0x78
+	OP_PICK         = 0x79
+	OP_ROLL         = 0x7a
+	OP_ROT          = 0x7b
+	OP_SWAP         = 0x7c
+	OP_TUCK         = 0x7d
+
+	// splice ops
+	OP_CAT    = 0x7e
+	OP_SUBSTR = 0x7f
+	OP_LEFT   = 0x80
+	OP_RIGHT  = 0x81
+	OP_SIZE   = 0x82
+
+	// bit logic
+	OP_INVERT      = 0x83
+	OP_AND         = 0x84
+	OP_OR          = 0x85
+	OP_XOR         = 0x86
+	OP_EQUAL       = 0x87
+	OP_EQUALVERIFY = 0x88
+	OP_RESERVED1   = 0x89
+	OP_RESERVED2   = 0x8a
+
+	// numeric
+	OP_1ADD      = 0x8b
+	OP_1SUB      = 0x8c
+	OP_2MUL      = 0x8d
+	OP_2DIV      = 0x8e
+	OP_NEGATE    = 0x8f
+	OP_ABS       = 0x90
+	OP_NOT       = 0x91
+	OP_0NOTEQUAL = 0x92
+
+	OP_ADD    = 0x93
+	OP_SUB    = 0x94
+	OP_MUL    = 0x95
+	OP_DIV    = 0x96
+	OP_MOD    = 0x97
+	OP_LSHIFT = 0x98
+	OP_RSHIFT = 0x99
+
+	OP_BOOLAND            = 0x9a
+	OP_BOOLOR             = 0x9b
+	OP_NUMEQUAL           = 0x9c
+	OP_NUMEQUALVERIFY     = 0x9d
+	OP_NUMNOTEQUAL        = 0x9e
+	OP_LESSTHAN           = 0x9f
+	OP_GREATERTHAN        = 0xa0
+	OP_LESSTHANOREQUAL    = 0xa1
+	OP_GREATERTHANOREQUAL = 0xa2
+	OP_MIN                = 0xa3
+	OP_MAX                = 0xa4
+
+	OP_WITHIN = 0xa5
+
+	// crypto
+	OP_RIPEMD160           = 0xa6
+	OP_SHA1                = 0xa7
+	OP_SHA256              = 0xa8
+	OP_HASH160             = 0xa9
+	OP_HASH256             = 0xaa
+	OP_CODESEPARATOR       = 0xab
+	OP_CHECKSIG            = 0xac
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIG       = 0xae
+	OP_CHECKMULTISIGVERIFY = 0xaf
+
+	// expansion
+	OP_NOP1                = 0xb0
+	OP_CHECKLOCKTIMEVERIFY = 0xb1
+	OP_NOP2                = OP_CHECKLOCKTIMEVERIFY
+	OP_CHECKSEQUENCEVERIFY = 0xb2
+	OP_NOP3                = OP_CHECKSEQUENCEVERIFY
+	OP_NOP4                = 0xb3
+	OP_NOP5                = 0xb4
+	OP_NOP6                = 0xb5
+	OP_NOP7                = 0xb6
+	OP_NOP8                = 0xb7
+	OP_NOP9                = 0xb8
+	OP_NOP10               = 0xb9
+
+	// Opcode added by BIP 342 (Tapscript)
+	OP_CHECKSIGADD = 0xba
+
+	OP_INVALIDOPCODE = 0xff
+)
+
+// Port of GetScriptOp from bitcoind. Reads the opcode at pc and its push data
+// (if any). Returns the position of the next opcode and false if the script
+// ended or the push data is truncated. Like bitcoind, next may be partially
+// advanced on failure.
+func GetScriptOp(script []byte, pc int) (opcode byte, data []byte, next int, ok bool) {
+	opcode = OP_INVALIDOPCODE
+	if pc >= len(script) {
+		return opcode, nil, pc, false
+	}
+
+	op := script[pc]
+	pc++
+
+	if op <= OP_PUSHDATA4 {
+		var size uint64
+		if op < OP_PUSHDATA1 {
+			size = uint64(op)
+		} else if op == OP_PUSHDATA1 {
+			if len(script)-pc < 1 {
+				return opcode, nil, pc, false
+			}
+			size = uint64(script[pc])
+			pc++
+		} else if op == OP_PUSHDATA2 {
+			if len(script)-pc < 2 {
+				return opcode, nil, pc, false
+			}
+			size = uint64(binary.LittleEndian.Uint16(script[pc : pc+2]))
+			pc += 2
+		} else {
+			if len(script)-pc < 4 {
+				return opcode, nil, pc, false
+			}
+			size = uint64(binary.LittleEndian.Uint32(script[pc : pc+4]))
+			pc += 4
+		}
+		if uint64(len(script)-pc) < size {
+			return opcode, nil, pc, false
+		}
+		data = script[pc : pc+int(size)]
+		pc += int(size)
+	}
+
+	return op, data, pc, true
+}
+
+type ScriptOp struct {
+	Opcode byte
+	Data   []byte // push data, nil for non-push opcodes
+	Pos    int    // offset of the opcode in the script
+}
+
+// Split the script into opcodes. Returns false as the second value if the
+// script contains truncated push data; the ops parsed so far are returned.
+func (script Script) Ops() ([]ScriptOp, bool) {
+	ops := make([]ScriptOp, 0)
+	pc := 0
+	for pc < len(script) {
+		opcode, data, next, ok := GetScriptOp(script, pc)
+		if !ok {
+			return ops, false
+		}
+		ops = append(ops, ScriptOp{Opcode: opcode, Data: data, Pos: pc})
+		pc = next
+	}
+
+	return ops, true
+}
+
+// Returns true if the script only contains push opcodes
+func (script Script) IsPushOnly() bool {
+	pc := 0
+	for pc < len(script) {
+		opcode, _, next, ok := GetScriptOp(script, pc)
+		if !ok {
+			return false
+		}
+		// OP_RESERVED is considered a push for this purpose, as in bitcoind
+		if opcode > OP_16 {
+			return false
+		}
+		pc = next
+	}
+
+	return true
+}
+
+// Serialize data as a single push using the smallest push opcode that fits
+// its length (equivalent of bitcoind's CScript() << data).
+func PushData(data []byte) []byte {
+	bin := make([]byte, 0, len(data)+5)
+	length := len(data)
+	if length < OP_PUSHDATA1 {
+		bin = append(bin, byte(length))
+	} else if length <= 0xff {
+		bin = append(bin, OP_PUSHDATA1, byte(length))
+	} else if length <= 0xffff {
+		l := make([]byte, 2)
+		binary.LittleEndian.PutUint16(l, uint16(length))
+		bin = append(bin, OP_PUSHDATA2)
+		bin = append(bin, l...)
+	} else {
+		l := make([]byte, 4)
+		binary.LittleEndian.PutUint32(l, uint32(length))
+		bin = append(bin, OP_PUSHDATA4)
+		bin = append(bin, l...)
+	}
+
+	return append(bin, data...)
+}
+
+// Port of FindAndDelete from bitcoind: removes every occurrence of b that
+// starts at an opcode boundary of script. Returns the new script and the
+// number of occurrences removed. Used by legacy signature checking to strip
+// the signature from the scriptCode before hashing.
+func FindAndDelete(script Script, b Script) (Script, int) {
+	found := 0
+	if len(b) == 0 {
+		return script, found
+	}
+
+	result := make(Script, 0, len(script))
+	pc, pc2 := 0, 0
+	for {
+		result = append(result, script[pc2:pc]...)
+		for len(script)-pc >= len(b) && bytes.Equal(script[pc:pc+len(b)], b) {
+			pc += len(b)
+			found++
+		}
+		pc2 = pc
+
+		var ok bool
+		_, _, pc, ok = GetScriptOp(script, pc)
+		if !ok {
+			break
+		}
+	}
+
+	if found == 0 {
+		return script, found
+	}
+
+	return append(result, script[pc2:]...), found
+}
+
+// Standard output script templates, see bitcoind's script/standard.h
+type TxOutType int
+
+const (
+	TX_NONSTANDARD TxOutType = iota
+	TX_PUBKEY
+	TX_PUBKEYHASH
+	TX_SCRIPTHASH
+	TX_MULTISIG
+	TX_NULL_DATA
+	TX_WITNESS_V0_KEYHASH
+	TX_WITNESS_V0_SCRIPTHASH
+	TX_WITNESS_V1_TAPROOT
+	TX_WITNESS_UNKNOWN
+)
+
+func (txOutType TxOutType) String() string {
+	switch txOutType {
+	case TX_PUBKEY:
+		return "pubkey"
+	case TX_PUBKEYHASH:
+		return "pubkeyhash"
+	case TX_SCRIPTHASH:
+		return "scripthash"
+	case TX_MULTISIG:
+		return "multisig"
+	case TX_NULL_DATA:
+		return "nulldata"
+	case TX_WITNESS_V0_KEYHASH:
+		return "witness_v0_keyhash"
+	case TX_WITNESS_V0_SCRIPTHASH:
+		return "witness_v0_scripthash"
+	case TX_WITNESS_V1_TAPROOT:
+		return "witness_v1_taproot"
+	case TX_WITNESS_UNKNOWN:
+		return "witness_unknown"
+	}
+
+	return "nonstandard"
+}
+
+// Returns the value of a small integer opcode (OP_0, OP_1 to OP_16)
+func DecodeOpN(opcode byte) int {
+	if opcode == OP_0 {
+		return 0
+	}
+	return int(opcode) - (OP_1 - 1)
+}
+
+func IsOpN(opcode byte) bool {
+	return opcode == OP_0 || (opcode >= OP_1 && opcode <= OP_16)
+}
+
+func (script Script) IsPayToScriptHash() bool {
+	return len(script) == 23 && script[0] == OP_HASH160 && script[1] == 0x14 && script[22] == OP_EQUAL
+}
+
+// Returns the witness version and program if the script is a BIP141 witness
+// program: a version opcode followed by a single 2 to 40 bytes push
+func (script Script) WitnessProgram() (version int, program []byte, ok bool) {
+	if len(script) < 4 || len(script) > 42 {
+		return 0, nil, false
+	}
+	if script[0] != OP_0 && (script[0] < OP_1 || script[0] > OP_16) {
+		return 0, nil, false
+	}
+	if int(script[1])+2 != len(script) {
+		return 0, nil, false
+	}
+
+	return DecodeOpN(script[0]), script[2:], true
+}
+
+// Port of CPubKey::ValidSize: the header byte must match the key's length
+func isValidPubKeySize(pubKey []byte) bool {
+	if len(pubKey) == 0 {
+		return false
+	}
+
+	switch pubKey[0] {
+	case PUBKEY_EVEN, PUBKEY_ODD:
+		return len(pubKey) == COMPRESSED_PUBKEY_SIZE
+	case PUBKEY_UNCOMPRESSED, PUBKEY_HYBRID_EVEN, PUBKEY_HYBRID_ODD:
+		return len(pubKey) == PUBKEY_SIZE
+	}
+
+	return false
+}
+
+// Returns the public keys and number of required signatures of a bare
+// multisig script: OP_m <pubkey>... OP_n OP_CHECKMULTISIG
+func (script Script) MultisigKeys() (required int, pubKeys [][]byte, ok bool) {
+	ops, valid := script.Ops()
+	if !valid || len(ops) < 4 || ops[len(ops)-1].Opcode != OP_CHECKMULTISIG {
+		return 0, nil, false
+	}
+
+	first, last := ops[0].Opcode, ops[len(ops)-2].Opcode
+	if first < OP_1 || first > OP_16 || last < OP_1 || last > OP_16 {
+		return 0, nil, false
+	}
+	required = DecodeOpN(first)
+
+	for _, op := range ops[1 : len(ops)-2] {
+		if !isValidPubKeySize(op.Data) {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, op.Data)
+	}
+	if len(pubKeys) != DecodeOpN(last) || required > len(pubKeys) {
+		return 0, nil, false
+	}
+
+	return required, pubKeys, true
+}
+
+// Classify an output script. Returns the template and its solutions: the
+// pubkey, key hash, script hash or witness program, depending on the type.
+// For multisig the solutions are the pubkeys.
+func (script Script) Type() (TxOutType, [][]byte) {
+	if script.IsPayToScriptHash() {
+		return TX_SCRIPTHASH, [][]byte{script[2:22]}
+	}
+
+	if version, program, ok := script.WitnessProgram(); ok {
+		if version == 0 && len(program) == 20 {
+			return TX_WITNESS_V0_KEYHASH, [][]byte{program}
+		}
+		if version == 0 && len(program) == 32 {
+			return TX_WITNESS_V0_SCRIPTHASH, [][]byte{program}
+		}
+		if version == 1 && len(program) == 32 {
+			return TX_WITNESS_V1_TAPROOT, [][]byte{program}
+		}
+		if version != 0 {
+			return TX_WITNESS_UNKNOWN, [][]byte{{byte(version)}, program}
+		}
+		return TX_NONSTANDARD, nil
+	}
+
+	// Provably prunable, data-carrying output
+	if len(script) >= 1 && script[0] == OP_RETURN && Script(script[1:]).IsPushOnly() {
+		return TX_NULL_DATA, nil
+	}
+
+	if (len(script) == COMPRESSED_PUBKEY_SIZE+2 || len(script) == PUBKEY_SIZE+2) && int(script[0]) == len(script)-2 &&
+		script[len(script)-1] == OP_CHECKSIG && isValidPubKeySize(script[1:len(script)-1]) {
+		return TX_PUBKEY, [][]byte{script[1 : len(script)-1]}
+	}
+
+	if len(script) == 25 && script[0] == OP_DUP && script[1] == OP_HASH160 && script[2] == 0x14 &&
+		script[23] == OP_EQUALVERIFY && script[24] == OP_CHECKSIG {
+		return TX_PUBKEYHASH, [][]byte{script[3:23]}
+	}
+
+	if _, pubKeys, ok := script.MultisigKeys(); ok {
+		return TX_MULTISIG, pubKeys
+	}
+
+	return TX_NONSTANDARD, nil
+}
+
+var opcodeNames = map[byte]string{
+	OP_0: "0", OP_PUSHDATA1: "OP_PUSHDATA1", OP_PUSHDATA2: "OP_PUSHDATA2", OP_PUSHDATA4: "OP_PUSHDATA4",
+	OP_1NEGATE: "-1", OP_RESERVED: "OP_RESERVED",
+	OP_NOP: "OP_NOP", OP_VER: "OP_VER", OP_IF: "OP_IF", OP_NOTIF: "OP_NOTIF", OP_VERIF: "OP_VERIF",
+	OP_VERNOTIF: "OP_VERNOTIF", OP_ELSE: "OP_ELSE", OP_ENDIF: "OP_ENDIF", OP_VERIFY: "OP_VERIFY", OP_RETURN: "OP_RETURN",
+	OP_TOALTSTACK: "OP_TOALTSTACK", OP_FROMALTSTACK: "OP_FROMALTSTACK", OP_2DROP: "OP_2DROP", OP_2DUP: "OP_2DUP",
+	OP_3DUP: "OP_3DUP", OP_2OVER: "OP_2OVER", OP_2ROT: "OP_2ROT", OP_2SWAP: "OP_2SWAP", OP_IFDUP: "OP_IFDUP",
+	OP_DEPTH: "OP_DEPTH", OP_DROP: "OP_DROP", OP_DUP: "OP_DUP", OP_NIP: "OP_NIP", OP_OVER: "OP_OVER",
+	OP_PICK: "OP_PICK", OP_ROLL: "OP_ROLL", OP_ROT: "OP_ROT", OP_SWAP: "OP_SWAP", OP_TUCK: "OP_TUCK",
+	OP_CAT: "OP_CAT", OP_SUBSTR: "OP_SUBSTR", OP_LEFT: "OP_LEFT", OP_RIGHT: "OP_RIGHT", OP_SIZE: "OP_SIZE",
+	OP_INVERT: "OP_INVERT", OP_AND: "OP_AND", OP_OR: "OP_OR", OP_XOR: "OP_XOR", OP_EQUAL: "OP_EQUAL",
+	OP_EQUALVERIFY: "OP_EQUALVERIFY", OP_RESERVED1: "OP_RESERVED1", OP_RESERVED2: "OP_RESERVED2",
+	OP_1ADD: "OP_1ADD", OP_1SUB: "OP_1SUB", OP_2MUL: "OP_2MUL", OP_2DIV: "OP_2DIV", OP_NEGATE: "OP_NEGATE",
+	OP_ABS: "OP_ABS", OP_NOT: "OP_NOT", OP_0NOTEQUAL: "OP_0NOTEQUAL", OP_ADD: "OP_ADD", OP_SUB: "OP_SUB",
+	OP_MUL: "OP_MUL", OP_DIV: "OP_DIV", OP_MOD: "OP_MOD", OP_LSHIFT: "OP_LSHIFT", OP_RSHIFT: "OP_RSHIFT",
+	OP_BOOLAND: "OP_BOOLAND", OP_BOOLOR: "OP_BOOLOR", OP_NUMEQUAL: "OP_NUMEQUAL",
+	OP_NUMEQUALVERIFY: "OP_NUMEQUALVERIFY", OP_NUMNOTEQUAL: "OP_NUMNOTEQUAL", OP_LESSTHAN: "OP_LESSTHAN",
+	OP_GREATERTHAN: "OP_GREATERTHAN", OP_LESSTHANOREQUAL: "OP_LESSTHANOREQUAL",
+	OP_GREATERTHANOREQUAL: "OP_GREATERTHANOREQUAL", OP_MIN: "OP_MIN", OP_MAX: "OP_MAX", OP_WITHIN: "OP_WITHIN",
+	OP_RIPEMD160: "OP_RIPEMD160", OP_SHA1: "OP_SHA1", OP_SHA256: "OP_SHA256", OP_HASH160: "OP_HASH160",
+	OP_HASH256: "OP_HASH256", OP_CODESEPARATOR: "OP_CODESEPARATOR", OP_CHECKSIG: "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY: "OP_CHECKSIGVERIFY", OP_CHECKMULTISIG: "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY", OP_NOP1: "OP_NOP1",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY", OP_CHECKSEQUENCEVERIFY: "OP_CHECKSEQUENCEVERIFY",
+	OP_NOP4: "OP_NOP4", OP_NOP5: "OP_NOP5", OP_NOP6: "OP_NOP6", OP_NOP7: "OP_NOP7", OP_NOP8: "OP_NOP8",
+	OP_NOP9: "OP_NOP9", OP_NOP10: "OP_NOP10", OP_CHECKSIGADD: "OP_CHECKSIGADD",
+	OP_INVALIDOPCODE: "OP_INVALIDOPCODE",
+}
+
+// Port of GetOpName from bitcoind
+func OpcodeName(opcode byte) string {
+	if opcode >= OP_1 && opcode <= OP_16 {
+		return strconv.Itoa(DecodeOpN(opcode))
+	}
+	if name, ok := opcodeNames[opcode]; ok {
+		return name
+	}
+
+	return "OP_UNKNOWN"
+}
+
+// Human readable form of the script, with push data as hex
+func (script Script) Disasm() string {
+	parts := make([]string, 0)
+	pc := 0
+	for pc < len(script) {
+		opcode, data, next, ok := GetScriptOp(script, pc)
+		if !ok {
+			parts = append(parts, "[error]")
+			break
+		}
+		if opcode > OP_0 && opcode <= OP_PUSHDATA4 {
+			parts = append(parts, hex.EncodeToString(data))
+		} else {
+			parts = append(parts, OpcodeName(opcode))
+		}
+		pc = next
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,50 @@
+package blockchainparser
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Keys must have a header byte matching their length, as in Core's Solver
+func TestScriptTypePubKeys(t *testing.T) {
+	compressed := append([]byte{PUBKEY_EVEN}, bytes.Repeat([]byte{0x11}, 32)...)
+	uncompressed := append([]byte{PUBKEY_UNCOMPRESSED}, bytes.Repeat([]byte{0x11}, 64)...)
+	hybrid := append([]byte{PUBKEY_HYBRID_ODD}, bytes.Repeat([]byte{0x11}, 64)...)
+	withHeader := func(key []byte, header byte) []byte {
+		return append([]byte{header}, key[1:]...)
+	}
+	p2pk := func(key []byte) Script {
+		return append(PushData(key), OP_CHECKSIG)
+	}
+	multisig := func(keys ...[]byte) Script {
+		script := Script{OP_1}
+		for _, key := range keys {
+			script = append(script, PushData(key)...)
+		}
+		return append(script, OP_1+byte(len(keys))-1, OP_CHECKMULTISIG)
+	}
+
+	tests := []struct {
+		script Script
+		want   TxOutType
+	}{
+		{p2pk(compressed), TX_PUBKEY},
+		{p2pk(withHeader(compressed, PUBKEY_ODD)), TX_PUBKEY},
+		{p2pk(uncompressed), TX_PUBKEY},
+		{p2pk(hybrid), TX_PUBKEY},
+		{p2pk(withHeader(compressed, PUBKEY_UNCOMPRESSED)), TX_NONSTANDARD},
+		{p2pk(withHeader(uncompressed, PUBKEY_EVEN)), TX_NONSTANDARD},
+		{p2pk(withHeader(compressed, 0x05)), TX_NONSTANDARD},
+		{multisig(compressed, uncompressed), TX_MULTISIG},
+		{multisig(compressed, hybrid), TX_MULTISIG},
+		{multisig(compressed, withHeader(compressed, 0x00)), TX_NONSTANDARD},
+		{multisig(compressed, withHeader(uncompressed, PUBKEY_ODD)), TX_NONSTANDARD},
+		// Any size between 33 and 65 bytes used to be taken for a key
+		{multisig(compressed, bytes.Repeat([]byte{PUBKEY_EVEN}, 40)), TX_NONSTANDARD},
+	}
+	for i, test := range tests {
+		if got, _ := test.script.Type(); got != test.want {
+			t.Errorf("Test %d: %s is %s, want %s", i, test.script, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package blockchainparser
+
+import (
+	"errors"
+	"math/big"
+)
+
+// Minimal secp256k1 implementation for verifying signatures found in the
+// blockchain. Only public data is involved so no attempt is made to be
+// constant time; never use this for signing.
+
+var (
+	secp256k1P, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	secp256k1N, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	secp256k1Gx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	secp256k1Gy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+	secp256k1B     = big.NewInt(7)
+
+	secp256k1HalfN = new(big.Int).Rsh(secp256k1N, 1)
+	// (p + 1) / 4, used for square roots since p = 3 mod 4
+	secp256k1SqrtExp = new(big.Int).Rsh(new(big.Int).Add(secp256k1P, big.NewInt(1)), 2)
+)
+
+const (
+	//! Public key serialization headers
+	PUBKEY_EVEN         = 0x02
+	PUBKEY_ODD          = 0x03
+	PUBKEY_UNCOMPRESSED = 0x04
+	PUBKEY_HYBRID_EVEN  = 0x06
+	PUBKEY_HYBRID_ODD   = 0x07
+
+	COMPRESSED_PUBKEY_SIZE = 33
+	PUBKEY_SIZE            = 65
+	XONLY_PUBKEY_SIZE      = 32
+	SCHNORR_SIGNATURE_SIZE = 64
+)
+
+// A point on the curve in affine coordinates
+type PublicKey struct {
+	X *big.Int
+	Y *big.Int
+}
+
+// Point in jacobian coordinates (x = X/Z^2, y = Y/Z^3); Z == 0 is infinity
+type jacobianPoint struct {
+	x, y, z *big.Int
+}
+
+func fieldMod(a *big.Int) *big.Int {
+	return a.Mod(a, secp256k1P)
+}
+
+func isOnCurve(x, y *big.Int) bool {
+	if x.Sign() < 0 || x.Cmp(secp256k1P) >= 0 || y.Sign() < 0 || y.Cmp(secp256k1P) >= 0 {
+		return false
+	}
+	// y^2 = x^3 + 7
+	y2 := fieldMod(new(big.Int).Mul(y, y))
+	x3 := new(big.Int).Mul(x, x)
+	x3.Mul(x3, x)
+	x3.Add(x3, secp256k1B)
+	return y2.Cmp(fieldMod(x3)) == 0
+}
+
+// Returns the y coordinate with the requested parity for x, if x is on the curve
+func liftX(x *big.Int, odd bool) (*big.Int, bool) {
+	if x.Cmp(secp256k1P) >= 0 {
+		return nil, false
+	}
+	c := new(big.Int).Mul(x, x)
+	c.Mul(c, x)
+	c.Add(c, secp256k1B)
+	fieldMod(c)
+
+	y := new(big.Int).Exp(c, secp256k1SqrtExp, secp256k1P)
+	if fieldMod(new(big.Int).Mul(y, y)).Cmp(c) != 0 {
+		return nil, false
+	}
+	if (y.Bit(0) == 1) != odd {
+		y.Sub(secp256k1P, y)
+	}
+
+	return y, true
+}
+
+func toJacobian(x, y *big.Int) *jacobianPoint {
+	return &jacobianPoint{new(big.Int).Set(x), new(big.Int).Set(y), big.NewInt(1)}
+}
+
+func (p *jacobianPoint) isInfinity() bool {
+	return p.z.Sign() == 0
+}
+
+func (p *jacobianPoint) toAffine() (*big.Int, *big.Int) {
+	zInv := new(big.Int).ModInverse(p.z, secp256k1P)
+	zInv2 := fieldMod(new(big.Int).Mul(zInv, zInv))
+	x := fieldMod(new(big.Int).Mul(p.x, zInv2))
+	zInv3 := fieldMod(zInv2.Mul(zInv2, zInv))
+	y := fieldMod(new(big.Int).Mul(p.y, zInv3))
+	return x, y
+}
+
+// dbl-2009-l, valid for a = 0
+func (p *jacobianPoint) double() *jacobianPoint {
+	if p.isInfinity() || p.y.Sign() == 0 {
+		return &jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	}
+
+	a := fieldMod(new(big.Int).Mul(p.x, p.x))
+	b := fieldMod(new(big.Int).Mul(p.y, p.y))
+	c := fieldMod(new(big.Int).Mul(b, b))
+
+	d := new(big.Int).Add(p.x, b)
+	d.Mul(d, d)
+	d.Sub(d, a)
+	d.Sub(d, c)
+	d.Lsh(d, 1)
+	fieldMod(d)
+
+	e := fieldMod(new(big.Int).Mul(a, big.NewInt(3)))
+	f := fieldMod(new(big.Int).Mul(e, e))
+
+	x3 := new(big.Int).Sub(f, new(big.Int).Lsh(d, 1))
+	fieldMod(x3)
+
+	y3 := new(big.Int).Sub(d, x3)
+	y3.Mul(y3, e)
+	y3.Sub(y3, new(big.Int).Lsh(c, 3))
+	fieldMod(y3)
+
+	z3 := new(big.Int).Mul(p.y, p.z)
+	z3.Lsh(z3, 1)
+	fieldMod(z3)
+
+	return &jacobianPoint{x3, y3, z3}
+}
+
+// add-2007-bl
+func (p *jacobianPoint) add(q *jacobianPoint) *jacobianPoint {
+	if p.isInfinity() {
+		return q
+	}
+	if q.isInfinity() {
+		return p
+	}
+
+	z1z1 := fieldMod(new(big.Int).Mul(p.z, p.z))
+	z2z2 := fieldMod(new(big.Int).Mul(q.z, q.z))
+	u1 := fieldMod(new(big.Int).Mul(p.x, z2z2))
+	u2 := fieldMod(new(big.Int).Mul(q.x, z1z1))
+	s1 := fieldMod(new(big.Int).Mul(p.y, fieldMod(new(big.Int).Mul(q.z, z2z2))))
+	s2 := fieldMod(new(big.Int).Mul(q.y, fieldMod(new(big.Int).Mul(p.z, z1z1))))
+
+	if u1.Cmp(u2) == 0 {
+		if s1.Cmp(s2) == 0 {
+			return p.double()
+		}
+		return &jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	}
+
+	h := fieldMod(new(big.Int).Sub(u2, u1))
+	i := new(big.Int).Lsh(h, 1)
+	i = fieldMod(i.Mul(i, i))
+	j := fieldMod(new(big.Int).Mul(h, i))
+	r := new(big.Int).Sub(s2, s1)
+	r = fieldMod(r.Lsh(r, 1))
+	v := fieldMod(new(big.Int).Mul(u1, i))
+
+	x3 := new(big.Int).Mul(r, r)
+	x3.Sub(x3, j)
+	x3.Sub(x3, new(big.Int).Lsh(v, 1))
+	fieldMod(x3)
+
+	y3 := new(big.Int).Sub(v, x3)
+	y3.Mul(y3, r)
+	y3.Sub(y3, new(big.Int).Lsh(fieldMod(new(big.Int).Mul(s1, j)), 1))
+	fieldMod(y3)
+
+	z3 := new(big.Int).Add(p.z, q.z)
+	z3.Mul(z3, z3)
+	z3.Sub(z3, z1z1)
+	z3.Sub(z3, z2z2)
+	z3.Mul(z3, h)
+	fieldMod(z3)
+
+	return &jacobianPoint{x3, y3, z3}
+}
+
+// Computes k1*G + k2*Q using Shamir's trick
+func doubleScalarMult(k1 *big.Int, q *PublicKey, k2 *big.Int) *jacobianPoint {
+	g := toJacobian(secp256k1Gx, secp256k1Gy)
+	p := toJacobian(q.X, q.Y)
+	gp := g.add(p)
+
+	result := &jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	bits := k1.BitLen()
+	if k2.BitLen() > bits {
+		bits = k2.BitLen()
+	}
+	for i := bits - 1; i >= 0; i-- {
+		result = result.double()
+		b1, b2 := k1.Bit(i), k2.Bit(i)
+		if b1 == 1 && b2 == 1 {
+			result = result.add(gp)
+		} else if b1 == 1 {
+			result = result.add(g)
+		} else if b2 == 1 {
+			result = result.add(p)
+		}
+	}
+
+	return result
+}
+
+// Parse a compressed, uncompressed or hybrid public key
+func ParsePubKey(b []byte) (*PublicKey, error) {
+	if len(b) == 0 {
+		return nil, errors.New("Empty public key")
+	}
+
+	switch b[0] {
+	case PUBKEY_EVEN, PUBKEY_ODD:
+		if len(b) != COMPRESSED_PUBKEY_SIZE {
+			return nil, errors.New("Invalid compressed public key length")
+		}
+		x := new(big.Int).SetBytes(b[1:])
+		y, ok := liftX(x, b[0] == PUBKEY_ODD)
+		if !ok {
+			return nil, errors.New("Public key is not on the curve")
+		}
+		return &PublicKey{x, y}, nil
+	case PUBKEY_UNCOMPRESSED, PUBKEY_HYBRID_EVEN, PUBKEY_HYBRID_ODD:
+		if len(b) != PUBKEY_SIZE {
+			return nil, errors.New("Invalid uncompressed public key length")
+		}
+		x := new(big.Int).SetBytes(b[1:33])
+		y := new(big.Int).SetBytes(b[33:])
+		if b[0] != PUBKEY_UNCOMPRESSED && (y.Bit(0) == 1) != (b[0] == PUBKEY_HYBRID_ODD) {
+			return nil, errors.New("Hybrid public key parity mismatch")
+		}
+		if !isOnCurve(x, y) {
+			return nil, errors.New("Public key is not on the curve")
+		}
+		return &PublicKey{x, y}, nil
+	}
+
+	return nil, errors.New("Invalid public key header")
+}
+
+// Parse a BIP340 x-only public key (the point with an even Y)
+func ParseXOnlyPubKey(b []byte) (*PublicKey, error) {
+	if len(b) != XONLY_PUBKEY_SIZE {
+		return nil, errors.New("Invalid x-only public key length")
+	}
+	x := new(big.Int).SetBytes(b)
+	y, ok := liftX(x, false)
+	if !ok {
+		return nil, errors.New("Public key is not on the curve")
+	}
+
+	return &PublicKey{x, y}, nil
+}
+
+func (pubKey *PublicKey) SerializeCompressed() []byte {
+	b := make([]byte, COMPRESSED_PUBKEY_SIZE)
+	b[0] = PUBKEY_EVEN
+	if pubKey.Y.Bit(0) == 1 {
+		b[0] = PUBKEY_ODD
+	}
+	pubKey.X.FillBytes(b[1:])
+	return b
+}
+
+func (pubKey *PublicKey) SerializeUncompressed() []byte {
+	b := make([]byte, PUBKEY_SIZE)
+	b[0] = PUBKEY_UNCOMPRESSED
+	pubKey.X.FillBytes(b[1:33])
+	pubKey.Y.FillBytes(b[33:])
+	return b
+}
+
+func (pubKey *PublicKey) SerializeXOnly() []byte {
+	b := make([]byte, XONLY_PUBKEY_SIZE)
+	pubKey.X.FillBytes(b)
+	return b
+}
+
+type ECDSASignature struct {
+	R *big.Int
+	S *big.Int
+}
+
+// Port of bitcoind's ecdsa_signature_parse_der_lax, which accepts the various
+// non-DER encodings found in the blockchain before BIP66. sig must not include
+// the sighash type byte. R or S overflowing the group order are parsed as an
+// (invalid) zero signature, as bitcoind does.
+func ParseDERSignatureLax(sig []byte) (*ECDSASignature, error) {
+	invalid := errors.New("Invalid DER signature")
+	pos := 0
+
+	// Sequence tag byte
+	if pos == len(sig) || sig[pos] != 0x30 {
+		return nil, invalid
+	}
+	pos++
+
+	// Sequence length bytes
+	if pos == len(sig) {
+		return nil, invalid
+	}
+	lenByte := int(sig[pos])
+	pos++
+	if lenByte&0x80 != 0 {
+		lenByte -= 0x80
+		if lenByte > len(sig)-pos {
+			return nil, invalid
+		}
+		pos += lenByte
+	}
+
+	readInteger := func() ([]byte, bool) {
+		// Integer tag byte
+		if pos == len(sig) || sig[pos] != 0x02 {
+			return nil, false
+		}
+		pos++
+
+		// Integer length
+		if pos == len(sig) {
+			return nil, false
+		}
+		length := int(sig[pos])
+		pos++
+		if length&0x80 != 0 {
+			lenBytes := length - 0x80
+			if lenBytes > len(sig)-pos {
+				return nil, false
+			}
+			for lenBytes > 0 && sig[pos] == 0 {
+				pos++
+				lenBytes--
+			}
+			if lenBytes >= 4 {
+				return nil, false
+			}
+			length = 0
+			for lenBytes > 0 {
+				length = (length << 8) + int(sig[pos])
+				pos++
+				lenBytes--
+			}
+		}
+		if length > len(sig)-pos {
+			return nil, false
+		}
+		value := sig[pos : pos+length]
+		pos += length
+
+		// Ignore leading zeroes
+		for len(value) > 0 && value[0] == 0 {
+			value = value[1:]
+		}
+		return value, true
+	}
+
+	r, ok := readInteger()
+	if !ok {
+		return nil, invalid
+	}
+	s, ok := readInteger()
+	if !ok {
+		return nil, invalid
+	}
+
+	signature := &ECDSASignature{new(big.Int), new(big.Int)}
+	if len(r) > 32 || len(s) > 32 {
+		return signature, nil
+	}
+	signature.R.SetBytes(r)
+	signature.S.SetBytes(s)
+	if signature.R.Cmp(secp256k1N) >= 0 || signature.S.Cmp(secp256k1N) >= 0 {
+		return &ECDSASignature{new(big.Int), new(big.Int)}, nil
+	}
+
+	return signature, nil
+}
+
+// Strict DER check from BIP66. sig includes the trailing sighash type byte.
+func IsValidSignatureEncoding(sig []byte) bool {
+	// Format: 0x30 [total-length] 0x02 [R-length] [R] 0x02 [S-length] [S] [sighash]
+	if len(sig) < 9 || len(sig) > 73 {
+		return false
+	}
+	if sig[0] != 0x30 || int(sig[1]) != len(sig)-3 {
+		return false
+	}
+
+	lenR := int(sig[3])
+	if 5+lenR >= len(sig) {
+		return false
+	}
+	lenS := int(sig[5+lenR])
+	if lenR+lenS+7 != len(sig) {
+		return false
+	}
+
+	// R: integer, non-empty, not negative, no unnecessary null bytes
+	if sig[2] != 0x02 || lenR == 0 || sig[4]&0x80 != 0 {
+		return false
+	}
+	if lenR > 1 && sig[4] == 0x00 && sig[5]&0x80 == 0 {
+		return false
+	}
+
+	// S: same rules
+	if sig[lenR+4] != 0x02 || lenS == 0 || sig[lenR+6]&0x80 != 0 {
+		return false
+	}
+	if lenS > 1 && sig[lenR+6] == 0x00 && sig[lenR+7]&0x80 == 0 {
+		return false
+	}
+
+	return true
+}
+
+// Returns true if S is at most half the group order (BIP62 rule 5)
+func (sig *ECDSASignature) IsLowS() bool {
+	return sig.S.Cmp(secp256k1HalfN) <= 0
+}
+
+// Verify an ECDSA signature over hash (a 32 bytes sighash). High S values are
+// normalized first, which is what consensus does; check IsLowS separately for
+// the standardness rule.
+func VerifyECDSA(pubKey *PublicKey, hash []byte, sig *ECDSASignature) bool {
+	r, s := sig.R, sig.S
+	if r.Sign() <= 0 || r.Cmp(secp256k1N) >= 0 || s.Sign() <= 0 || s.Cmp(secp256k1N) >= 0 {
+		return false
+	}
+	if s.Cmp(secp256k1HalfN) > 0 {
+		s = new(big.Int).Sub(secp256k1N, s)
+	}
+
+	e := new(big.Int).SetBytes(hash)
+	w := new(big.Int).ModInverse(s, secp256k1N)
+	u1 := e.Mul(e, w)
+	u1.Mod(u1, secp256k1N)
+	u2 := new(big.Int).Mul(r, w)
+	u2.Mod(u2, secp256k1N)
+
+	point := doubleScalarMult(u1, pubKey, u2)
+	if point.isInfinity() {
+		return false
+	}
+	x, _ := point.toAffine()
+	x.Mod(x, secp256k1N)
+
+	return x.Cmp(r) == 0
+}
+
+// BIP340 Schnorr signature verification. pubKey is the 32 bytes x-only key,
+// sig the 64 bytes signature (without any sighash type byte).
+func VerifySchnorr(pubKey []byte, msg []byte, sig []byte) bool {
+	if len(sig) != SCHNORR_SIGNATURE_SIZE {
+		return false
+	}
+	p, err := ParseXOnlyPubKey(pubKey)
+	if err != nil {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if r.Cmp(secp256k1P) >= 0 || s.Cmp(secp256k1N) >= 0 {
+		return false
+	}
+
+	e := new(big.Int).SetBytes(TaggedHash("BIP0340/challenge", sig[:32], pubKey, msg))
+	e.Mod(e, secp256k1N)
+
+	// R = s*G - e*P
+	negE := new(big.Int).Sub(secp256k1N, e)
+	negE.Mod(negE, secp256k1N)
+	point := doubleScalarMult(s, p, negE)
+	if point.isInfinity() {
+		return false
+	}
+	x, y := point.toAffine()
+
+	return y.Bit(0) == 0 && x.Cmp(r) == 0
+}
+
+// BIP341 output key: internalKey + TaggedHash("TapTweak", internalKey || merkleRoot)*G.
+// merkleRoot is empty for outputs without a script tree.
+func TaprootTweakPubKey(internalKey *PublicKey, merkleRoot []byte) (*PublicKey, bool) {
+	t := new(big.Int).SetBytes(TaggedHash("TapTweak", internalKey.SerializeXOnly(), merkleRoot))
+	if t.Cmp(secp256k1N) >= 0 {
+		return nil, false
+	}
+
+	point := doubleScalarMult(t, internalKey, big.NewInt(1))
+	if point.isInfinity() {
+		return nil, false
+	}
+	x, y := point.toAffine()
+
+	return &PublicKey{x, y}, true
+}
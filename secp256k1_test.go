@@ -0,0 +1,109 @@
+package blockchainparser
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"math/big"
+	"os"
+	"testing"
+)
+
+// Deterministic ECDSA signature, low S
+func ecdsaSignForTest(key *big.Int, hash []byte) *ECDSASignature {
+	nonce := sha256.Sum256(append(key.Bytes(), hash...))
+	k := new(big.Int).SetBytes(nonce[:])
+	k.Mod(k, secp256k1N)
+
+	x, _ := doubleScalarMult(k, &PublicKey{secp256k1Gx, secp256k1Gy}, new(big.Int)).toAffine()
+	r := x.Mod(x, secp256k1N)
+	s := new(big.Int).Mul(r, key)
+	s.Add(s, new(big.Int).SetBytes(hash))
+	s.Mul(s, new(big.Int).ModInverse(k, secp256k1N))
+	s.Mod(s, secp256k1N)
+	if s.Cmp(secp256k1HalfN) > 0 {
+		s.Sub(secp256k1N, s)
+	}
+
+	return &ECDSASignature{R: r, S: s}
+}
+
+// Strict DER encoding of sig with the sighash type appended
+func derSignatureForTest(sig *ECDSASignature, hashType byte) []byte {
+	derInt := func(n *big.Int) []byte {
+		b := n.Bytes()
+		if b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return append([]byte{0x02, byte(len(b))}, b...)
+	}
+	body := append(derInt(sig.R), derInt(sig.S)...)
+
+	return append(append([]byte{0x30, byte(len(body))}, body...), hashType)
+}
+
+// Deterministic ECDSA signature with the sighash type appended, low S
+func signECDSAForTest(key *big.Int, hash []byte, hashType byte) []byte {
+	return derSignatureForTest(ecdsaSignForTest(key, hash), hashType)
+}
+
+func pubKeyForTest(key *big.Int) *PublicKey {
+	x, y := doubleScalarMult(key, &PublicKey{secp256k1Gx, secp256k1Gy}, new(big.Int)).toAffine()
+	return &PublicKey{x, y}
+}
+
+// Deterministic BIP340 signature of msg by the x-only key of key
+func signSchnorrForTest(key *big.Int, msg []byte) []byte {
+	d := new(big.Int).Set(key)
+	pubKey := pubKeyForTest(d)
+	if pubKey.Y.Bit(0) == 1 {
+		d.Sub(secp256k1N, d)
+	}
+
+	nonce := sha256.Sum256(append(d.Bytes(), msg...))
+	k := new(big.Int).SetBytes(nonce[:])
+	k.Mod(k, secp256k1N)
+	r := pubKeyForTest(k)
+	if r.Y.Bit(0) == 1 {
+		k.Sub(secp256k1N, k)
+	}
+
+	e := new(big.Int).SetBytes(TaggedHash("BIP0340/challenge", r.SerializeXOnly(), pubKey.SerializeXOnly(), msg))
+	s := e.Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, secp256k1N)
+
+	return append(r.SerializeXOnly(), s.FillBytes(make([]byte, 32))...)
+}
+
+// BIP340's test-vectors.csv: secret key, public key, aux_rand, message,
+// signature, verification result and comment
+func TestVerifySchnorr(t *testing.T) {
+	file, err := os.Open("testdata/bip340_test_vectors.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, record := range records[1:] {
+		index, pubKey, msg, sig := record[0], hexForTest(t, record[2]), hexForTest(t, record[4]), hexForTest(t, record[5])
+		valid := record[6] == "TRUE"
+
+		if record[1] != "" {
+			key := new(big.Int).SetBytes(hexForTest(t, record[1]))
+			if !bytes.Equal(pubKeyForTest(key).SerializeXOnly(), pubKey) {
+				t.Errorf("Vector %s: public key doesn't match the secret key", index)
+			}
+		}
+		if VerifySchnorr(pubKey, msg, sig) != valid {
+			t.Errorf("Vector %s (%s): verification result %v", index, record[7], !valid)
+		}
+	}
+	if len(records) < 16 {
+		t.Errorf("Only %d vectors run", len(records)-1)
+	}
+}
@@ -0,0 +1,396 @@
+package blockchainparser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	//! Signature hash types
+	SIGHASH_DEFAULT      = 0x00 // Taproot only; implied when sighash byte is missing, and equivalent to SIGHASH_ALL
+	SIGHASH_ALL          = 0x01
+	SIGHASH_NONE         = 0x02
+	SIGHASH_SINGLE       = 0x03
+	SIGHASH_ANYONECANPAY = 0x80
+
+	SIGHASH_OUTPUT_MASK = 0x03
+	SIGHASH_INPUT_MASK  = 0x80
+
+	//! Tag of the optional last witness element of a taproot spend
+	ANNEX_TAG = 0x50
+
+	//! Leaf version of BIP342 tapscripts
+	TAPROOT_LEAF_TAPSCRIPT = 0xc0
+)
+
+// Hashes shared by all the inputs of a transaction. Computing them once per
+// transaction avoids quadratic hashing when every input is checked.
+type SigHashCache struct {
+	// BIP341: single SHA256
+	shaPrevouts      []byte
+	shaSequences     []byte
+	shaOutputs       []byte
+	shaAmounts       []byte // only if prevouts were supplied
+	shaScriptPubKeys []byte // only if prevouts were supplied
+
+	// BIP143: double SHA256
+	hashPrevouts []byte
+	hashSequence []byte
+	hashOutputs  []byte
+}
+
+// Precompute the hashes for tx. prevouts are the outputs spent by each input,
+// in input order; they are only needed for taproot and may be nil otherwise.
+func NewSigHashCache(tx *Transaction, prevouts []TxOutput) *SigHashCache {
+	cache := &SigHashCache{}
+
+	prevoutsBin := make([]byte, 0, len(tx.Vin)*36)
+	sequencesBin := make([]byte, 0, len(tx.Vin)*4)
+	for _, in := range tx.Vin {
+		prevoutsBin = append(prevoutsBin, in.outpointBinary()...)
+		sequence := make([]byte, 4)
+		binary.LittleEndian.PutUint32(sequence, in.Sequence)
+		sequencesBin = append(sequencesBin, sequence...)
+	}
+
+	outputsBin := make([]byte, 0)
+	for _, out := range tx.Vout {
+		outputsBin = append(outputsBin, out.Binary()...)
+	}
+
+	cache.shaPrevouts = Sha256(prevoutsBin)
+	cache.shaSequences = Sha256(sequencesBin)
+	cache.shaOutputs = Sha256(outputsBin)
+	cache.hashPrevouts = Sha256(cache.shaPrevouts)
+	cache.hashSequence = Sha256(cache.shaSequences)
+	cache.hashOutputs = Sha256(cache.shaOutputs)
+
+	if len(prevouts) == len(tx.Vin) {
+		amountsBin := make([]byte, 0, len(prevouts)*8)
+		scriptPubKeysBin := make([]byte, 0)
+		for _, prevout := range prevouts {
+			amount := make([]byte, 8)
+			binary.LittleEndian.PutUint64(amount, uint64(prevout.Value))
+			amountsBin = append(amountsBin, amount...)
+			scriptPubKeysBin = append(scriptPubKeysBin, Varint(uint64(len(prevout.Script)))...)
+			scriptPubKeysBin = append(scriptPubKeysBin, prevout.Script...)
+		}
+		cache.shaAmounts = Sha256(amountsBin)
+		cache.shaScriptPubKeys = Sha256(scriptPubKeysBin)
+	}
+
+	return cache
+}
+
+func (in TxInput) outpointBinary() []byte {
+	bin := make([]byte, 0, 36)
+	bin = append(bin, in.Hash...)
+	index := make([]byte, 4)
+	binary.LittleEndian.PutUint32(index, in.Index)
+	return append(bin, index...)
+}
+
+// Returns the annex of a taproot input (the last witness element if there are
+// at least two and it starts with ANNEX_TAG), or nil
+func (in TxInput) TaprootAnnex() []byte {
+	if len(in.ScriptWitness) < 2 {
+		return nil
+	}
+	last := in.ScriptWitness[len(in.ScriptWitness)-1]
+	if len(last) > 0 && last[0] == ANNEX_TAG {
+		return last
+	}
+
+	return nil
+}
+
+// uint256 one, returned by the legacy algorithm for invalid input indexes
+// and for SIGHASH_SINGLE without a matching output
+func sigHashOne() Hash256 {
+	one := make(Hash256, 32)
+	one[0] = 1
+	return one
+}
+
+// Serialize scriptCode with all OP_CODESEPARATORs removed, as bitcoind's
+// CTransactionSignatureSerializer does
+func legacyScriptCodeBinary(scriptCode Script) []byte {
+	codeSeparators := 0
+	for pc := 0; ; {
+		opcode, _, next, ok := GetScriptOp(scriptCode, pc)
+		if !ok {
+			break
+		}
+		if opcode == OP_CODESEPARATOR {
+			codeSeparators++
+		}
+		pc = next
+	}
+
+	bin := Varint(uint64(len(scriptCode) - codeSeparators))
+	begin, pc := 0, 0
+	for {
+		opcode, _, next, ok := GetScriptOp(scriptCode, pc)
+		pc = next
+		if !ok {
+			break
+		}
+		if opcode == OP_CODESEPARATOR {
+			bin = append(bin, scriptCode[begin:pc-1]...)
+			begin = pc
+		}
+	}
+	if begin != len(scriptCode) {
+		bin = append(bin, scriptCode[begin:pc]...)
+	}
+
+	return bin
+}
+
+// Original (pre-segwit) signature hash algorithm, including its quirks: an
+// out of range nIn or SIGHASH_SINGLE without a matching output hash to one.
+// OP_CODESEPARATORs are removed from scriptCode here; removing the signature
+// itself (FindAndDelete) is up to the caller, as in bitcoind.
+func SignatureHashLegacy(scriptCode Script, tx *Transaction, nIn int, hashType int32) Hash256 {
+	if nIn < 0 || nIn >= len(tx.Vin) {
+		return sigHashOne()
+	}
+
+	anyoneCanPay := hashType&SIGHASH_ANYONECANPAY != 0
+	hashSingle := hashType&0x1f == SIGHASH_SINGLE
+	hashNone := hashType&0x1f == SIGHASH_NONE
+
+	if hashSingle && nIn >= len(tx.Vout) {
+		return sigHashOne()
+	}
+
+	bin := make([]byte, 0)
+
+	version := make([]byte, 4)
+	binary.LittleEndian.PutUint32(version, uint32(tx.Version))
+	bin = append(bin, version...)
+
+	// Inputs: with ANYONECANPAY only the input being signed is serialized
+	inputs := tx.Vin
+	if anyoneCanPay {
+		inputs = tx.Vin[nIn : nIn+1]
+		bin = append(bin, Varint(1)...)
+	} else {
+		bin = append(bin, Varint(uint64(len(inputs)))...)
+	}
+	for i, in := range inputs {
+		isSigned := anyoneCanPay || i == nIn
+
+		bin = append(bin, in.outpointBinary()...)
+		if isSigned {
+			bin = append(bin, legacyScriptCodeBinary(scriptCode)...)
+		} else {
+			bin = append(bin, Varint(0)...)
+		}
+
+		// Let the others update at will
+		sequence := make([]byte, 4)
+		if isSigned || !(hashSingle || hashNone) {
+			binary.LittleEndian.PutUint32(sequence, in.Sequence)
+		}
+		bin = append(bin, sequence...)
+	}
+
+	// Outputs: none for SIGHASH_NONE, up to nIn for SIGHASH_SINGLE with the
+	// preceding ones blanked out
+	if hashNone {
+		bin = append(bin, Varint(0)...)
+	} else if hashSingle {
+		bin = append(bin, Varint(uint64(nIn+1))...)
+		for i := 0; i < nIn; i++ {
+			bin = append(bin, TxOutput{Value: -1}.Binary()...)
+		}
+		bin = append(bin, tx.Vout[nIn].Binary()...)
+	} else {
+		bin = append(bin, Varint(uint64(len(tx.Vout)))...)
+		for _, out := range tx.Vout {
+			bin = append(bin, out.Binary()...)
+		}
+	}
+
+	locktime := make([]byte, 4)
+	binary.LittleEndian.PutUint32(locktime, tx.Locktime)
+	bin = append(bin, locktime...)
+
+	sigHashType := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sigHashType, uint32(hashType))
+	bin = append(bin, sigHashType...)
+
+	return DoubleSha256(bin)
+}
+
+// BIP143 signature hash for segwit v0 inputs. amount is the value of the
+// output being spent. cache may be nil.
+func SignatureHashWitnessV0(scriptCode Script, tx *Transaction, nIn int, hashType int32, amount Amount, cache *SigHashCache) (Hash256, error) {
+	if nIn < 0 || nIn >= len(tx.Vin) {
+		return nil, fmt.Errorf("Input index %d out of range", nIn)
+	}
+	if cache == nil {
+		cache = NewSigHashCache(tx, nil)
+	}
+
+	anyoneCanPay := hashType&SIGHASH_ANYONECANPAY != 0
+	baseType := hashType & 0x1f
+
+	zero := make([]byte, 32)
+	hashPrevouts, hashSequence, hashOutputs := zero, zero, zero
+
+	if !anyoneCanPay {
+		hashPrevouts = cache.hashPrevouts
+	}
+	if !anyoneCanPay && baseType != SIGHASH_SINGLE && baseType != SIGHASH_NONE {
+		hashSequence = cache.hashSequence
+	}
+	if baseType != SIGHASH_SINGLE && baseType != SIGHASH_NONE {
+		hashOutputs = cache.hashOutputs
+	} else if baseType == SIGHASH_SINGLE && nIn < len(tx.Vout) {
+		hashOutputs = DoubleSha256(tx.Vout[nIn].Binary())
+	}
+
+	in := tx.Vin[nIn]
+	bin := make([]byte, 0)
+
+	version := make([]byte, 4)
+	binary.LittleEndian.PutUint32(version, uint32(tx.Version))
+	bin = append(bin, version...)
+
+	bin = append(bin, hashPrevouts...)
+	bin = append(bin, hashSequence...)
+
+	bin = append(bin, in.outpointBinary()...)
+	bin = append(bin, Varint(uint64(len(scriptCode)))...)
+	bin = append(bin, scriptCode...)
+
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, uint64(amount))
+	bin = append(bin, value...)
+
+	sequence := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sequence, in.Sequence)
+	bin = append(bin, sequence...)
+
+	bin = append(bin, hashOutputs...)
+
+	locktime := make([]byte, 4)
+	binary.LittleEndian.PutUint32(locktime, tx.Locktime)
+	bin = append(bin, locktime...)
+
+	sigHashType := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sigHashType, uint32(hashType))
+	bin = append(bin, sigHashType...)
+
+	return DoubleSha256(bin), nil
+}
+
+// Script path data committed to by BIP342 signatures
+type TapscriptSigHashExt struct {
+	LeafHash         []byte // see TapLeafHash
+	KeyVersion       byte   // 0 for BIP342 tapscript
+	CodeSeparatorPos uint32 // opcode position of the last executed OP_CODESEPARATOR, or 0xFFFFFFFF
+}
+
+// BIP341 leaf hash of a script
+func TapLeafHash(leafVersion byte, script Script) []byte {
+	return TaggedHash("TapLeaf", []byte{leafVersion}, Varint(uint64(len(script))), script)
+}
+
+// BIP341 signature hash for taproot inputs. prevouts are the outputs spent by
+// every input of tx, in input order. annex is the full annex (including the
+// ANNEX_TAG byte) or nil. ext is nil for key path spends and the script path
+// data for script path spends. cache may be nil.
+func SignatureHashTaproot(tx *Transaction, nIn int, hashType byte, prevouts []TxOutput, annex []byte, ext *TapscriptSigHashExt, cache *SigHashCache) (Hash256, error) {
+	if nIn < 0 || nIn >= len(tx.Vin) {
+		return nil, fmt.Errorf("Input index %d out of range", nIn)
+	}
+	if len(prevouts) != len(tx.Vin) {
+		return nil, errors.New("Taproot signature hash needs the spent outputs of all inputs")
+	}
+	if cache == nil || cache.shaAmounts == nil {
+		cache = NewSigHashCache(tx, prevouts)
+	}
+
+	outputType := hashType & SIGHASH_OUTPUT_MASK
+	if hashType == SIGHASH_DEFAULT {
+		outputType = SIGHASH_ALL
+	}
+	inputType := hashType & SIGHASH_INPUT_MASK
+	if !(hashType <= 0x03 || (hashType >= 0x81 && hashType <= 0x83)) {
+		return nil, fmt.Errorf("Invalid taproot hash type: 0x%02x", hashType)
+	}
+
+	// Epoch
+	bin := []byte{0x00}
+
+	// Hash type
+	bin = append(bin, hashType)
+
+	// Transaction level data
+	version := make([]byte, 4)
+	binary.LittleEndian.PutUint32(version, uint32(tx.Version))
+	bin = append(bin, version...)
+
+	locktime := make([]byte, 4)
+	binary.LittleEndian.PutUint32(locktime, tx.Locktime)
+	bin = append(bin, locktime...)
+
+	if inputType != SIGHASH_ANYONECANPAY {
+		bin = append(bin, cache.shaPrevouts...)
+		bin = append(bin, cache.shaAmounts...)
+		bin = append(bin, cache.shaScriptPubKeys...)
+		bin = append(bin, cache.shaSequences...)
+	}
+	if outputType == SIGHASH_ALL {
+		bin = append(bin, cache.shaOutputs...)
+	}
+
+	// Data about the input/prevout being spent
+	var spendType byte
+	if ext != nil {
+		spendType = 2 // ext_flag = 1
+	}
+	if annex != nil {
+		spendType |= 1
+	}
+	bin = append(bin, spendType)
+
+	if inputType == SIGHASH_ANYONECANPAY {
+		in := tx.Vin[nIn]
+		bin = append(bin, in.outpointBinary()...)
+		bin = append(bin, prevouts[nIn].Binary()...)
+		sequence := make([]byte, 4)
+		binary.LittleEndian.PutUint32(sequence, in.Sequence)
+		bin = append(bin, sequence...)
+	} else {
+		inputIndex := make([]byte, 4)
+		binary.LittleEndian.PutUint32(inputIndex, uint32(nIn))
+		bin = append(bin, inputIndex...)
+	}
+	if annex != nil {
+		bin = append(bin, Sha256(append(Varint(uint64(len(annex))), annex...))...)
+	}
+
+	// Data about the output (if only one)
+	if outputType == SIGHASH_SINGLE {
+		if nIn >= len(tx.Vout) {
+			return nil, errors.New("SIGHASH_SINGLE without a corresponding output")
+		}
+		bin = append(bin, Sha256(tx.Vout[nIn].Binary())...)
+	}
+
+	// Additional data for BIP342
+	if ext != nil {
+		bin = append(bin, ext.LeafHash...)
+		bin = append(bin, ext.KeyVersion)
+		codeSeparatorPos := make([]byte, 4)
+		binary.LittleEndian.PutUint32(codeSeparatorPos, ext.CodeSeparatorPos)
+		bin = append(bin, codeSeparatorPos...)
+	}
+
+	return TaggedHash("TapSighash", bin), nil
+}
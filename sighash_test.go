@@ -0,0 +1,217 @@
+package blockchainparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// Bitcoin Core's src/test/data/sighash.json: raw transaction, script, input
+// index, hash type and signature hash
+func TestSignatureHashLegacy(t *testing.T) {
+	data, err := os.ReadFile("testdata/sighash.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests [][]interface{}
+	if err := json.Unmarshal(data, &tests); err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for i, test := range tests {
+		if len(test) == 1 {
+			continue // comment
+		}
+		if len(test) != 5 {
+			t.Fatalf("Test %d: malformed %v", i, test)
+		}
+		tx := parseTxForTest(t, test[0].(string))
+		script := Script(hexForTest(t, test[1].(string)))
+		nIn := int(test[2].(float64))
+		hashType := int32(test[3].(float64))
+
+		hash := SignatureHashLegacy(script, tx, nIn, hashType)
+		if hash.String() != test[4].(string) {
+			t.Errorf("Test %d: got %s, want %s", i, hash, test[4])
+		}
+		count++
+	}
+	if count < 500 {
+		t.Errorf("Only %d vectors run", count)
+	}
+}
+
+// Key path and script path signatures of the BIP371 test vectors, made by
+// Bitcoin Core's wallet with SIGHASH_DEFAULT
+func TestSignatureHashTaprootSignatures(t *testing.T) {
+	keyPathTx := parseTxForTest(t, "020000000127744ababf3027fe0d6cf23a96eee2efb188ef52301954585883e69b6624b2420000000000ffffffff0148e6052a01000000160014768e1eeb4cf420866033f80aceff0f972074496900000000")
+	scriptPathTx := parseTxForTest(t, "02000000019bd48765230bf9a72e662001f972556e54f0c6f97feb56bcb5600d817f6995260100000000ffffffff0148e6052a0100000022512083698e458c6664e1595d75da2597de1e22ee97d798e706c4c0a4b5a9823cd74300000000")
+
+	keyPathPrevouts := []TxOutput{{Value: 5000000000, Script: hexForTest(t, "51205a2c2cf5b52cf31f83ad2e8da63ff03183ecd8f609c7510ae8a48e03910a0757")}}
+	hash, err := SignatureHashTaproot(keyPathTx, 0, SIGHASH_DEFAULT, keyPathPrevouts, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := hexForTest(t, "bb53ec917bad9d906af1ba87181c48b86ace5aae2b53605a725ca74625631476fc6f5baedaf4f2ee0f477f36f58f3970d5b8273b7e497b97af2e3f125c97af34")
+	if !VerifySchnorr(keyPathPrevouts[0].Script[2:], hash, sig) {
+		t.Error("Key path signature doesn't match the signature hash")
+	}
+
+	scriptPathPrevouts := []TxOutput{{Value: 5000000000, Script: hexForTest(t, "5120c2247efbfd92ac47f6f40b8d42d169175a19fa9fa10e4a25d7f35eb4dd85b692")}}
+	cache := NewSigHashCache(scriptPathTx, scriptPathPrevouts)
+	for _, leaf := range []struct{ pubKey, sig string }{
+		{"2cb13ac68248de806aa6a3659cf3c03eb6821d09c8114a4e868febde865bb6d2", "bf818d9757d6ffeb538ba057fb4c1fc4e0f5ef186e765beb564791e02af5fd3d5e2551d4e34e33d86f276b82c99c79aed3f0395a081efcd2cc2c65dd7e693d79"},
+		{"4320b0bf16f011b53ea7be615924aa7f27e5d29ad20ea1155d848676c3bad1b2", "e1f1ab6fabfa26b236f21833719dc1d428ab768d80f91f9988d8abef47bfb863bb1f2a529f768c15f00ce34ec283cdc07e88f8428be28f6ef64043c32911811a"},
+		{"fa0f7a3cef3b1d0c0a6ce7d26e17ada0b2e5c92d19efad48b41859cb8a451ca9", "ec1f0379206461c83342285423326708ab031f0da4a253ee45aafa5b8c92034d8b605490f8cd13e00f989989b97e215faa36f12dee3693d2daccf3781c1757f6"},
+	} {
+		pubKey := hexForTest(t, leaf.pubKey)
+		script := append(append(Script{32}, pubKey...), OP_CHECKSIG)
+		ext := &TapscriptSigHashExt{LeafHash: TapLeafHash(TAPROOT_LEAF_TAPSCRIPT, script), CodeSeparatorPos: 0xffffffff}
+		hash, err := SignatureHashTaproot(scriptPathTx, 0, SIGHASH_DEFAULT, scriptPathPrevouts, nil, ext, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifySchnorr(pubKey, hash, hexForTest(t, leaf.sig)) {
+			t.Errorf("Script path signature of %s doesn't match the signature hash", leaf.pubKey)
+		}
+	}
+}
+
+// SigMsg of BIP341 written out field by field, without the shared hashes
+func taprootSigMsgForTest(tx *Transaction, nIn int, hashType byte, prevouts []TxOutput, annex []byte, ext *TapscriptSigHashExt) []byte {
+	le32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, v)
+		return b
+	}
+	le64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, v)
+		return b
+	}
+
+	msg := []byte{0x00, hashType}
+	msg = append(msg, le32(uint32(tx.Version))...)
+	msg = append(msg, le32(tx.Locktime)...)
+	if hashType&0x80 == 0 {
+		var outpoints, amounts, scriptPubKeys, sequences []byte
+		for i, in := range tx.Vin {
+			outpoints = append(append(outpoints, in.Hash...), le32(in.Index)...)
+			amounts = append(amounts, le64(uint64(prevouts[i].Value))...)
+			scriptPubKeys = append(append(scriptPubKeys, Varint(uint64(len(prevouts[i].Script)))...), prevouts[i].Script...)
+			sequences = append(sequences, le32(in.Sequence)...)
+		}
+		msg = append(msg, Sha256(outpoints)...)
+		msg = append(msg, Sha256(amounts)...)
+		msg = append(msg, Sha256(scriptPubKeys)...)
+		msg = append(msg, Sha256(sequences)...)
+	}
+	if hashType&3 == SIGHASH_ALL || hashType == SIGHASH_DEFAULT {
+		var outputs []byte
+		for _, out := range tx.Vout {
+			outputs = append(outputs, out.Binary()...)
+		}
+		msg = append(msg, Sha256(outputs)...)
+	}
+
+	spendType := byte(0)
+	if ext != nil {
+		spendType += 2
+	}
+	if annex != nil {
+		spendType++
+	}
+	msg = append(msg, spendType)
+	if hashType&0x80 != 0 {
+		in := tx.Vin[nIn]
+		msg = append(append(msg, in.Hash...), le32(in.Index)...)
+		msg = append(msg, le64(uint64(prevouts[nIn].Value))...)
+		msg = append(append(msg, Varint(uint64(len(prevouts[nIn].Script)))...), prevouts[nIn].Script...)
+		msg = append(msg, le32(in.Sequence)...)
+	} else {
+		msg = append(msg, le32(uint32(nIn))...)
+	}
+	if annex != nil {
+		msg = append(msg, Sha256(append(Varint(uint64(len(annex))), annex...))...)
+	}
+	if hashType&3 == SIGHASH_SINGLE {
+		msg = append(msg, Sha256(tx.Vout[nIn].Binary())...)
+	}
+
+	if ext != nil {
+		msg = append(msg, ext.LeafHash...)
+		msg = append(msg, ext.KeyVersion)
+		msg = append(msg, le32(ext.CodeSeparatorPos)...)
+	}
+
+	return msg
+}
+
+// Every hash type, with and without annex, for key and script path spends,
+// with and without a cache shared by the inputs
+func TestSignatureHashTaprootHashTypes(t *testing.T) {
+	tx := &Transaction{
+		Version:  2,
+		Locktime: 500000,
+		Vin: []TxInput{
+			{Hash: DoubleSha256([]byte("a")), Index: 1, Sequence: 0xfffffffd},
+			{Hash: DoubleSha256([]byte("b")), Index: 0, Sequence: 0xffffffff},
+			{Hash: DoubleSha256([]byte("c")), Index: 7, Sequence: 0},
+		},
+		Vout: []TxOutput{
+			{Value: 1000, Script: append(Script{OP_1, 32}, Sha256([]byte("out0"))...)},
+			{Value: 2000, Script: append(Script{OP_0, 20}, Sha256([]byte("out1"))[:20]...)},
+		},
+	}
+	prevouts := []TxOutput{
+		{Value: 5000, Script: append(Script{OP_1, 32}, Sha256([]byte("in0"))...)},
+		{Value: 6000, Script: append(Script{OP_0, 20}, Sha256([]byte("in1"))[:20]...)},
+		{Value: 7000, Script: append(Script{OP_1, 32}, Sha256([]byte("in2"))...)},
+	}
+	annex := []byte{ANNEX_TAG, 0x01, 0x02}
+	ext := &TapscriptSigHashExt{LeafHash: TapLeafHash(TAPROOT_LEAF_TAPSCRIPT, Script{OP_TRUE}), CodeSeparatorPos: 3}
+	cache := NewSigHashCache(tx, prevouts)
+
+	hashes := make(map[string]bool)
+	for _, hashType := range []byte{0x00, 0x01, 0x02, 0x03, 0x81, 0x82, 0x83} {
+		for nIn := range tx.Vin {
+			for _, annex := range [][]byte{nil, annex} {
+				for _, ext := range []*TapscriptSigHashExt{nil, ext} {
+					if hashType&3 == SIGHASH_SINGLE && nIn >= len(tx.Vout) {
+						if _, err := SignatureHashTaproot(tx, nIn, hashType, prevouts, annex, ext, cache); err == nil {
+							t.Errorf("0x%02x input %d: SIGHASH_SINGLE without output accepted", hashType, nIn)
+						}
+						continue
+					}
+
+					want := TaggedHash("TapSighash", taprootSigMsgForTest(tx, nIn, hashType, prevouts, annex, ext))
+					for _, cache := range []*SigHashCache{nil, cache, NewSigHashCache(tx, nil)} {
+						hash, err := SignatureHashTaproot(tx, nIn, hashType, prevouts, annex, ext, cache)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !bytes.Equal(hash, want) {
+							t.Errorf("0x%02x input %d annex %v script path %v: got %x, want %x", hashType, nIn, annex != nil, ext != nil, []byte(hash), want)
+						}
+					}
+					hashes[string(want)] = true
+				}
+			}
+		}
+	}
+	if len(hashes) != 7*3*4-4*2 {
+		t.Errorf("Got %d distinct hashes", len(hashes))
+	}
+
+	for _, hashType := range []byte{0x04, 0x80, 0x84, 0xff} {
+		if _, err := SignatureHashTaproot(tx, 0, hashType, prevouts, nil, nil, cache); err == nil {
+			t.Errorf("Hash type 0x%02x accepted", hashType)
+		}
+	}
+	if _, err := SignatureHashTaproot(tx, 0, SIGHASH_ALL, prevouts[:2], nil, nil, nil); err == nil {
+		t.Error("Missing prevouts accepted")
+	}
+}
@@ -0,0 +1,196 @@
+package blockchainparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	//! Challenge of the default signet, a 1-of-2 multisig
+	SIGNET_DEFAULT_CHALLENGE = "512103ad5e0edad18cb1f0fc0d28a3d4f1f3e445640337489abb10404f2d1e086be430210359ef5021964fe22d6f8e05b2463c9540ce96883fe3b278760f048f5189f2e6c452ae"
+
+	//! Minimum size of a BIP141 witness commitment output script
+	MINIMUM_WITNESS_COMMITMENT = 38
+
+	//! Script verification flags of signet block solutions, see bitcoind's signet.cpp
+	SIGNET_SCRIPT_VERIFY_FLAGS = SCRIPT_VERIFY_P2SH | SCRIPT_VERIFY_WITNESS | SCRIPT_VERIFY_DERSIG | SCRIPT_VERIFY_NULLDUMMY
+)
+
+var (
+	// Prefix of the witness commitment output script (OP_RETURN, push 36, 0xaa21a9ed)
+	witnessCommitmentHeader = []byte{OP_RETURN, 0x24, 0xaa, 0x21, 0xa9, 0xed}
+
+	// Prefix of the push holding the signet solution in the witness commitment
+	SignetHeader = []byte{0xec, 0xc7, 0xda, 0xa2}
+
+	ErrNoSignetSolution      = errors.New("Block has no witness commitment for a signet solution")
+	ErrInvalidSignetSolution = errors.New("Invalid signet solution")
+)
+
+// Network magic of the signet with challenge: the first 4 bytes of the
+// double SHA256 of the serialized challenge
+func SignetMagicId(challenge Script) MagicId {
+	hash := DoubleSha256(append(Varint(uint64(len(challenge))), challenge...))
+	return MagicId(binary.LittleEndian.Uint32(hash[:4]))
+}
+
+// Parameters of a custom signet. Every signet shares the genesis block and
+// consensus heights of the default one and only differs in its challenge
+// and magic.
+func NewSigNetParams(challenge Script) *ChainParams {
+	params := *SigNetParams
+	params.SignetChallenge = append(Script{}, challenge...)
+	params.MagicId = SignetMagicId(challenge)
+
+	return &params
+}
+
+// Index of the output holding the BIP141 witness commitment, the last one
+// matching, or -1 when the transaction has none
+func (tx Transaction) WitnessCommitmentIndex() int {
+	index := -1
+	for i, out := range tx.Vout {
+		if len(out.Script) >= MINIMUM_WITNESS_COMMITMENT && bytes.HasPrefix(out.Script, witnessCommitmentHeader) {
+			index = i
+		}
+	}
+
+	return index
+}
+
+// Solution of a signet block: the scriptSig and witness spending the challenge
+type SignetSolution struct {
+	Script        Script
+	ScriptWitness [][]byte
+}
+
+// Removes the signet solution data from the witness commitment script,
+// keeping the bare SignetHeader push as bitcoind's
+// FetchAndClearCommitmentSection does. Returns the solution data and the
+// cleared script, which like bitcoind's stops at an invalid opcode.
+func fetchAndClearSignetCommitment(script Script) ([]byte, Script, bool) {
+	var solution []byte
+	found := false
+	replacement := make(Script, 0, len(script))
+	for pc := 0; pc < len(script); {
+		opcode, data, next, ok := GetScriptOp(script, pc)
+		if !ok {
+			break
+		}
+		pc = next
+
+		if len(data) == 0 {
+			replacement = append(replacement, opcode)
+			continue
+		}
+		if !found && len(data) > len(SignetHeader) && bytes.HasPrefix(data, SignetHeader) {
+			solution = data[len(SignetHeader):]
+			data = SignetHeader
+			found = true
+		}
+		replacement = append(replacement, PushData(data)...)
+	}
+
+	return solution, replacement, found
+}
+
+// Extracts the signet solution from the coinbase witness commitment. Also
+// returns the merkle root of the block with the solution cleared from the
+// coinbase, which is what the solution signs. A commitment without solution
+// gives an empty one, as bitcoind allows for trivial challenges like OP_TRUE.
+func (block *Block) signetSolution() (*SignetSolution, Hash256, error) {
+	if len(block.Transactions) == 0 {
+		return nil, nil, ErrNoSignetSolution
+	}
+	coinbase := block.Transactions[0]
+	index := coinbase.WitnessCommitmentIndex()
+	if index < 0 {
+		return nil, nil, ErrNoSignetSolution
+	}
+
+	solution := &SignetSolution{Script: Script{}}
+	data, script, found := fetchAndClearSignetCommitment(coinbase.Vout[index].Script)
+	if found {
+		r := &byteReader{b: data}
+		solution.Script = r.readBytes(r.readCount(1))
+		count := r.readCount(1)
+		for i := uint64(0); i < count && r.err == nil; i++ {
+			solution.ScriptWitness = append(solution.ScriptWitness, r.readBytes(r.readCount(1)))
+		}
+		if r.err != nil || r.pos != uint64(len(data)) {
+			return nil, nil, ErrInvalidSignetSolution
+		}
+
+		vout := append([]TxOutput{}, coinbase.Vout...)
+		vout[index] = TxOutput{Value: vout[index].Value, Script: script}
+		coinbase.Vout = vout
+		coinbase.hash = nil
+	}
+
+	hashes := make([]Hash256, len(block.Transactions))
+	hashes[0] = coinbase.Txid()
+	for i := 1; i < len(block.Transactions); i++ {
+		hashes[i] = block.Transactions[i].Txid()
+	}
+
+	return solution, ComputeMerkleRoot(hashes), nil
+}
+
+// Returns the signet solution carried in the coinbase witness commitment,
+// empty when the commitment has none
+func (block *Block) SignetSolution() (*SignetSolution, error) {
+	solution, _, err := block.signetSolution()
+	return solution, err
+}
+
+// Checks the block signature against the signet challenge, as bitcoind's
+// CheckSignetBlockSolution. The solution spends a virtual transaction paying
+// to the challenge and committing to the block header (without nBits and
+// nonce) and its transactions. The genesis block is always valid.
+func (block *Block) CheckSignetSolution(params *ChainParams) error {
+	if bytes.Equal(block.Hash(), params.GenesisHash) {
+		return nil
+	}
+	if params.SignetChallenge == nil {
+		return errors.New("Chain " + params.Name + " has no signet challenge")
+	}
+
+	solution, merkleRoot, err := block.signetSolution()
+	if err != nil {
+		return err
+	}
+
+	blockData := block.BlockHeader.Binary()[:4+32]
+	blockData = append(blockData, merkleRoot...)
+	timestamp := make([]byte, 4)
+	binary.LittleEndian.PutUint32(timestamp, uint32(block.Timestamp.Unix()))
+	blockData = append(blockData, timestamp...)
+
+	toSpend := Transaction{
+		Vin: []TxInput{{
+			Hash:   make(Hash256, 32),
+			Index:  0xFFFFFFFF,
+			Script: append([]byte{OP_0}, PushData(blockData)...),
+		}},
+		Vout: []TxOutput{{Script: params.SignetChallenge}},
+	}
+	toSign := &Transaction{
+		Vin: []TxInput{{
+			Hash:          toSpend.Txid(),
+			Script:        solution.Script,
+			ScriptWitness: solution.ScriptWitness,
+		}},
+		Vout: []TxOutput{{Script: Script{OP_RETURN}}},
+	}
+
+	result := VerifyInputScript(toSign, 0, toSpend.Vout, SIGNET_SCRIPT_VERIFY_FLAGS, false)
+	if !result.Valid {
+		if result.Err != nil {
+			return errors.New("Invalid signet block signature: " + result.Err.Error())
+		}
+		return errors.New("Invalid signet block signature")
+	}
+
+	return nil
+}
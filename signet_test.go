@@ -0,0 +1,156 @@
+package blockchainparser
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Block of a custom signet whose coinbase witness commitment carries the
+// signet header followed by solution
+func signetBlockForTest(solution []byte) *Block {
+	commitment := append(append(Script{}, witnessCommitmentHeader...), make([]byte, 32)...)
+	commitment = append(commitment, PushData(append(append([]byte{}, SignetHeader...), solution...))...)
+	coinbase := Transaction{
+		Version: 2,
+		Vin:     []TxInput{{Hash: make(Hash256, 32), Index: 0xffffffff, Script: Script{2, 0x10, 0x27}, Sequence: 0xffffffff}},
+		Vout:    []TxOutput{{Value: 5000000000, Script: Script{OP_1}}, {Script: commitment}},
+	}
+	payment := Transaction{
+		Version: 2,
+		Vin:     []TxInput{{Hash: DoubleSha256([]byte("prev")), Index: 1, Script: Script{OP_1}, Sequence: 0xfffffffe}},
+		Vout:    []TxOutput{{Value: 1000, Script: Script{OP_1}}},
+	}
+
+	block := &Block{Transactions: []Transaction{coinbase, payment}}
+	block.Version = 0x20000000
+	block.HashPrev = DoubleSha256([]byte("parent"))
+	block.Timestamp = time.Unix(1700000000, 0)
+	block.TargetDifficulty = 0x1e0377ae
+	block.HashMerkle = block.MerkleRoot()
+
+	return block
+}
+
+// Signs a block for a 1-of-1 bare multisig challenge, the form of the
+// default signet challenge
+func signSignetBlockForTest(t *testing.T, key *big.Int, challenge Script) *Block {
+	// The solution signs the block with the commitment cleared down to the header
+	unsigned := signetBlockForTest(nil)
+	_, merkleRoot, err := unsigned.signetSolution()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merkleRoot.String() != unsigned.MerkleRoot().String() {
+		t.Fatal("Merkle root of a block without solution was modified")
+	}
+
+	blockData := unsigned.BlockHeader.Binary()[:4+32]
+	blockData = append(blockData, merkleRoot...)
+	blockData = append(blockData, 0x00, 0xf1, 0x53, 0x65) // 1700000000
+	toSpend := Transaction{
+		Vin:  []TxInput{{Hash: make(Hash256, 32), Index: 0xFFFFFFFF, Script: append([]byte{OP_0}, PushData(blockData)...)}},
+		Vout: []TxOutput{{Script: challenge}},
+	}
+	toSign := &Transaction{
+		Vin:  []TxInput{{Hash: toSpend.Txid()}},
+		Vout: []TxOutput{{Script: Script{OP_RETURN}}},
+	}
+	sig := signECDSAForTest(key, SignatureHashLegacy(challenge, toSign, 0, SIGHASH_ALL), SIGHASH_ALL)
+
+	scriptSig := append(Script{OP_0}, PushData(sig)...)
+	solution := append(Varint(uint64(len(scriptSig))), scriptSig...)
+	solution = append(solution, 0) // empty witness
+
+	return signetBlockForTest(solution)
+}
+
+func TestCheckSignetSolution(t *testing.T) {
+	key := new(big.Int).SetBytes(Sha256([]byte("signet key")))
+	challenge := append(Script{OP_1, COMPRESSED_PUBKEY_SIZE}, pubKeyForTest(key).SerializeCompressed()...)
+	challenge = append(challenge, OP_1, OP_CHECKMULTISIG)
+	params := NewSigNetParams(challenge)
+
+	block := signSignetBlockForTest(t, key, challenge)
+	solution, err := block.SignetSolution()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(solution.Script) == 0 || len(solution.ScriptWitness) != 0 {
+		t.Errorf("Unexpected solution %+v", solution)
+	}
+	if err := block.CheckSignetSolution(params); err != nil {
+		t.Errorf("Signed block: %s", err)
+	}
+
+	tampered := signSignetBlockForTest(t, key, challenge)
+	tampered.Timestamp = tampered.Timestamp.Add(time.Second)
+	if err := tampered.CheckSignetSolution(params); err == nil {
+		t.Error("Block with a changed timestamp passed")
+	}
+
+	otherKey := new(big.Int).SetBytes(Sha256([]byte("other key")))
+	if err := signSignetBlockForTest(t, otherKey, challenge).CheckSignetSolution(params); err == nil {
+		t.Error("Block signed by another key passed")
+	}
+	if err := block.CheckSignetSolution(SigNetParams); err == nil {
+		t.Error("Block passed the default signet challenge")
+	}
+}
+
+// bitcoind accepts blocks without solution, which trivial challenges like
+// OP_TRUE are satisfied by
+func TestCheckSignetSolutionOpTrue(t *testing.T) {
+	params := NewSigNetParams(Script{OP_TRUE})
+
+	// Commitment without the signet header at all
+	block := signetBlockForTest(nil)
+	commitment := block.Transactions[0].Vout[1].Script
+	block.Transactions[0].Vout[1].Script = commitment[:len(witnessCommitmentHeader)+32]
+	block.HashMerkle = block.MerkleRoot()
+	solution, err := block.SignetSolution()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(solution.Script) != 0 || len(solution.ScriptWitness) != 0 {
+		t.Errorf("Unexpected solution %+v", solution)
+	}
+	if err := block.CheckSignetSolution(params); err != nil {
+		t.Errorf("OP_TRUE challenge: %s", err)
+	}
+
+	// Bare signet header, with an empty solution
+	if err := signetBlockForTest(nil).CheckSignetSolution(params); err != nil {
+		t.Errorf("OP_TRUE challenge with signet header: %s", err)
+	}
+	if err := signetBlockForTest(nil).CheckSignetSolution(NewSigNetParams(Script{OP_FALSE})); err == nil {
+		t.Error("OP_FALSE challenge passed")
+	}
+
+	// A witness commitment is still required
+	block.Transactions[0].Vout = block.Transactions[0].Vout[:1]
+	if _, err := block.SignetSolution(); err != ErrNoSignetSolution {
+		t.Errorf("Block without witness commitment: %v", err)
+	}
+}
+
+// Like bitcoind, the pushes before an invalid opcode are kept
+func TestFetchAndClearSignetCommitmentTruncated(t *testing.T) {
+	solution := []byte{0x01, OP_TRUE, 0x00}
+	script := append(append(Script{}, witnessCommitmentHeader...), make([]byte, 32)...)
+	script = append(script, PushData(append(append([]byte{}, SignetHeader...), solution...))...)
+	cleared := append(append(Script{}, witnessCommitmentHeader...), make([]byte, 32)...)
+	cleared = append(cleared, PushData(SignetHeader)...)
+	script = append(script, OP_PUSHDATA1) // missing length
+
+	data, replacement, found := fetchAndClearSignetCommitment(script)
+	if !found {
+		t.Fatal("Solution not found before the invalid opcode")
+	}
+	if string(data) != string(solution) {
+		t.Errorf("Got solution %x", data)
+	}
+	if replacement.String() != cleared.String() {
+		t.Errorf("Got cleared script %s, want %s", replacement, cleared)
+	}
+}
@@ -0,0 +1,83 @@
+package blockchainparser
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	//! Number of satoshis in one BTC
+	COIN = 100000000
+
+	//! Initial block subsidy before any halving
+	INITIAL_BLOCK_SUBSIDY = 50 * COIN
+
+	//! Number of blocks between each subsidy halving
+	SUBSIDY_HALVING_INTERVAL_BITCOIN = 210000
+	SUBSIDY_HALVING_INTERVAL_REGTEST = 150
+)
+
+// Returns the number of blocks between subsidy halvings for the network
+// identified by magicId. Unknown networks use the mainnet schedule.
+func SubsidyHalvingInterval(magicId MagicId) int32 {
+	params, err := GetChainParamsByMagicId(magicId)
+	if err != nil {
+		return SUBSIDY_HALVING_INTERVAL_BITCOIN
+	}
+
+	return params.SubsidyHalvingInterval
+}
+
+// Port of GetBlockSubsidy from bitcoind's validation.cpp
+func GetBlockSubsidy(height int32, halvingInterval int32) Amount {
+	if height < 0 || halvingInterval <= 0 {
+		return 0
+	}
+
+	halvings := height / halvingInterval
+	// Force block reward to zero when right shift is undefined
+	if halvings >= 64 {
+		return 0
+	}
+
+	return INITIAL_BLOCK_SUBSIDY >> uint(halvings)
+}
+
+type CoinbaseReward struct {
+	Height    int32
+	Subsidy   Amount
+	Fees      Amount
+	Claimed   Amount // sum of the coinbase outputs
+	Unclaimed Amount // subsidy + fees not paid out by the miner
+}
+
+// Checks that the coinbase of block does not pay out more than the subsidy
+// for height plus fees. Fees are the sum of (inputs - outputs) of all the
+// non-coinbase transactions in the block; they can't be derived from the block
+// alone, so the caller has to supply them.
+func CheckCoinbaseReward(block *Block, height int32, fees Amount, halvingInterval int32) (*CoinbaseReward, error) {
+	if len(block.Transactions) == 0 {
+		return nil, errors.New("Block has no transactions")
+	}
+	coinbase := block.Transactions[0]
+	if !coinbase.IsCoinbase() {
+		return nil, errors.New("First transaction is not a coinbase")
+	}
+	if fees < 0 {
+		return nil, fmt.Errorf("Invalid fees: %s", fees)
+	}
+
+	reward := &CoinbaseReward{
+		Height:  height,
+		Subsidy: GetBlockSubsidy(height, halvingInterval),
+		Fees:    fees,
+		Claimed: coinbase.ValueOut(),
+	}
+	reward.Unclaimed = reward.Subsidy + reward.Fees - reward.Claimed
+
+	if reward.Unclaimed < 0 {
+		return reward, fmt.Errorf("Coinbase pays too much: actual=%s vs limit=%s", reward.Claimed, reward.Subsidy+reward.Fees)
+	}
+
+	return reward, nil
+}
@@ -0,0 +1,105 @@
+package blockchainparser
+
+import (
+	"testing"
+)
+
+func TestGetBlockSubsidy(t *testing.T) {
+	tests := []struct {
+		height   int32
+		halvings uint
+	}{
+		{0, 0},
+		{209999, 0},
+		{210000, 1},
+		{419999, 1},
+		{420000, 2},
+		{630000, 3},
+		{840000, 4},
+		{6719999, 31},
+		{6720000, 32}, // the last satoshi
+		{13229999, 62},
+		{13439999, 63},
+	}
+	for _, test := range tests {
+		if got := GetBlockSubsidy(test.height, SUBSIDY_HALVING_INTERVAL_BITCOIN); got != INITIAL_BLOCK_SUBSIDY>>test.halvings {
+			t.Errorf("Height %d: got %d, want %d", test.height, got, INITIAL_BLOCK_SUBSIDY>>test.halvings)
+		}
+	}
+	if got := GetBlockSubsidy(6930000, SUBSIDY_HALVING_INTERVAL_BITCOIN); got != 0 {
+		t.Errorf("Subsidy after 33 halvings: %d", got)
+	}
+
+	// A right shift of 64 or more halvings is undefined in C++, so bitcoind
+	// forces the subsidy to zero instead of wrapping around
+	for _, height := range []int32{64 * 150, 64*150 + 1, 1 << 30} {
+		if got := GetBlockSubsidy(height, SUBSIDY_HALVING_INTERVAL_REGTEST); got != 0 {
+			t.Errorf("Regtest height %d: got %d", height, got)
+		}
+	}
+	if got := GetBlockSubsidy(63*150, SUBSIDY_HALVING_INTERVAL_REGTEST); got != INITIAL_BLOCK_SUBSIDY>>63 {
+		t.Errorf("Regtest after 63 halvings: %d", got)
+	}
+
+	if got := GetBlockSubsidy(-1, SUBSIDY_HALVING_INTERVAL_BITCOIN); got != 0 {
+		t.Errorf("Negative height: %d", got)
+	}
+	if got := GetBlockSubsidy(100, 0); got != 0 {
+		t.Errorf("Zero halving interval: %d", got)
+	}
+}
+
+func TestSubsidyHalvingInterval(t *testing.T) {
+	if got := SubsidyHalvingInterval(BLOCK_MAGIC_ID_BITCOIN); got != SUBSIDY_HALVING_INTERVAL_BITCOIN {
+		t.Errorf("Mainnet: %d", got)
+	}
+	if got := SubsidyHalvingInterval(BLOCK_MAGIC_ID_TESTNET); got != SUBSIDY_HALVING_INTERVAL_BITCOIN {
+		t.Errorf("Testnet: %d", got)
+	}
+	if got := SubsidyHalvingInterval(BLOCK_MAGIC_ID_REGTEST); got != SUBSIDY_HALVING_INTERVAL_REGTEST {
+		t.Errorf("Regtest: %d", got)
+	}
+	for _, params := range []*ChainParams{TestNet4Params, SigNetParams} {
+		if got := SubsidyHalvingInterval(params.MagicId); got != SUBSIDY_HALVING_INTERVAL_BITCOIN {
+			t.Errorf("%s: %d", params.Name, got)
+		}
+	}
+	if got := SubsidyHalvingInterval(0x01020304); got != SUBSIDY_HALVING_INTERVAL_BITCOIN {
+		t.Errorf("Unknown network: %d", got)
+	}
+}
+
+func TestCheckCoinbaseReward(t *testing.T) {
+	coinbase := Transaction{
+		Version: 1,
+		Vin:     []TxInput{{Hash: make(Hash256, 32), Index: 0xffffffff, Script: Script{3, 0x40, 0x0d, 0x03}, Sequence: 0xffffffff}},
+		Vout:    []TxOutput{{Value: 6 * COIN, Script: Script{0x51}}, {Value: COIN / 4, Script: Script{0x51}}},
+	}
+	block := &Block{Transactions: []Transaction{coinbase}}
+
+	// 6.25 BTC at the third halving, with 0.5 BTC of fees
+	reward, err := CheckCoinbaseReward(block, 630000, COIN/2, SUBSIDY_HALVING_INTERVAL_BITCOIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reward.Subsidy != 625000000 || reward.Claimed != 625000000 || reward.Unclaimed != COIN/2 {
+		t.Errorf("Got %+v", reward)
+	}
+
+	// Paying out the fees of the next halving is too much
+	reward, err = CheckCoinbaseReward(block, 840000, COIN/2, SUBSIDY_HALVING_INTERVAL_BITCOIN)
+	if err == nil || reward == nil || reward.Unclaimed != -262500000 {
+		t.Errorf("Got %+v, %v", reward, err)
+	}
+
+	if _, err := CheckCoinbaseReward(block, 630000, -1, SUBSIDY_HALVING_INTERVAL_BITCOIN); err == nil {
+		t.Error("Negative fees accepted")
+	}
+	if _, err := CheckCoinbaseReward(&Block{}, 630000, 0, SUBSIDY_HALVING_INTERVAL_BITCOIN); err == nil {
+		t.Error("Block without transactions accepted")
+	}
+	block.Transactions[0].Vin[0].Index = 0
+	if _, err := CheckCoinbaseReward(block, 630000, 0, SUBSIDY_HALVING_INTERVAL_BITCOIN); err == nil {
+		t.Error("Block without coinbase accepted")
+	}
+}
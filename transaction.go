@@ -55,12 +55,12 @@ func (in TxInput) ScriptWitnessBinary() []byte {
 }
 
 type TxOutput struct {
-	Value  int64
+	Value  Amount
 	Script Script
 }
 
 func (out TxOutput) BTC() float64 {
-	return float64(out.Value) / 1000000000.0
+	return out.Value.BTC()
 }
 
 func (out TxOutput) Binary() []byte {
@@ -84,21 +84,22 @@ type Transaction struct {
 	Locktime uint32
 	Vin      []TxInput
 	Vout     []TxOutput
+	HogEx    bool   // Litecoin MWEB integrating transaction; its flag isn't reserialized by Binary
 	StartPos uint64 // not actually in blockchain data
 }
 
 func Varint(n uint64) []byte {
-	if n > 4294967295 {
+	if n > 0xFFFFFFFF {
 		val := make([]byte, 8)
-		binary.BigEndian.PutUint64(val, n)
+		binary.LittleEndian.PutUint64(val, n)
 		return append([]byte{0xFF}, val...)
-	} else if n > 65535 {
+	} else if n > 0xFFFF {
 		val := make([]byte, 4)
-		binary.BigEndian.PutUint32(val, uint32(n))
+		binary.LittleEndian.PutUint32(val, uint32(n))
 		return append([]byte{0xFE}, val...)
-	} else if n > 255 {
+	} else if n > 0xFC {
 		val := make([]byte, 2)
-		binary.BigEndian.PutUint16(val, uint16(n))
+		binary.LittleEndian.PutUint16(val, uint16(n))
 		return append([]byte{0xFD}, val...)
 	} else {
 		return []byte{byte(n)}
@@ -115,25 +116,71 @@ func (tx Transaction) HasWitness() bool {
 	return false
 }
 
-func (tx Transaction) Txid() Hash256 {
-	if tx.hash != nil {
-		return tx.hash
+func (tx Transaction) IsCoinbase() bool {
+	if len(tx.Vin) != 1 || tx.Vin[0].Index != 0xFFFFFFFF {
+		return false
+	}
+
+	for _, b := range tx.Vin[0].Hash {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (tx Transaction) ValueOut() Amount {
+	var total Amount
+	for _, out := range tx.Vout {
+		total += out.Value
 	}
 
+	return total
+}
+
+// Serialize the transaction without witness data, as used for the txid
+func (tx Transaction) BinaryNoWitness() []byte {
 	bin := make([]byte, 0)
 
-	//hasScriptWitness := tx.HasWitness()
+	version := make([]byte, 4)
+	binary.LittleEndian.PutUint32(version, uint32(tx.Version))
+	bin = append(bin, version...)
+
+	vinLength := Varint(uint64(len(tx.Vin)))
+	bin = append(bin, vinLength...)
+	for _, in := range tx.Vin {
+		bin = append(bin, in.Binary()...)
+	}
+
+	voutLength := Varint(uint64(len(tx.Vout)))
+	bin = append(bin, voutLength...)
+	for _, out := range tx.Vout {
+		bin = append(bin, out.Binary()...)
+	}
+
+	locktime := make([]byte, 4)
+	binary.LittleEndian.PutUint32(locktime, tx.Locktime)
+	bin = append(bin, locktime...)
+
+	return bin
+}
+
+// Serialize the transaction in the extended (BIP144) format if it has any
+// witness data, otherwise in the legacy format
+func (tx Transaction) Binary() []byte {
+	if !tx.HasWitness() {
+		return tx.BinaryNoWitness()
+	}
+
+	bin := make([]byte, 0)
 
 	version := make([]byte, 4)
 	binary.LittleEndian.PutUint32(version, uint32(tx.Version))
 	bin = append(bin, version...)
 
-	//var flags byte
-	//if hasScriptWitness {
-	//	bin = append(bin, 0)
-	//	flags |= 1
-	//	bin = append(bin, flags)
-	//}
+	// marker & flags
+	bin = append(bin, 0, 1)
 
 	vinLength := Varint(uint64(len(tx.Vin)))
 	bin = append(bin, vinLength...)
@@ -147,20 +194,52 @@ func (tx Transaction) Txid() Hash256 {
 		bin = append(bin, out.Binary()...)
 	}
 
-	//if hasScriptWitness {
-	//	for _, in := range tx.Vin {
-	//		bin = append(bin, in.ScriptWitnessBinary()...)
-	//	}
-	//}
+	for _, in := range tx.Vin {
+		bin = append(bin, in.ScriptWitnessBinary()...)
+	}
 
 	locktime := make([]byte, 4)
 	binary.LittleEndian.PutUint32(locktime, tx.Locktime)
 	bin = append(bin, locktime...)
 
-	tx.hash = DoubleSha256(bin)
+	return bin
+}
+
+func (tx Transaction) Txid() Hash256 {
+	if tx.hash != nil {
+		return tx.hash
+	}
+
+	tx.hash = DoubleSha256(tx.BinaryNoWitness())
 	return tx.hash
 }
 
+// Hash including the witness data (BIP141); same as Txid for transactions
+// without witness
+func (tx Transaction) Wtxid() Hash256 {
+	return DoubleSha256(tx.Binary())
+}
+
+// Size of the transaction serialized without witness data
+func (tx Transaction) StrippedSize() int {
+	return len(tx.BinaryNoWitness())
+}
+
+// Size of the transaction serialized with witness data (BIP144)
+func (tx Transaction) TotalSize() int {
+	return len(tx.Binary())
+}
+
+// Weight as defined in BIP141: stripped size * 3 + total size
+func (tx Transaction) Weight() int {
+	return tx.StrippedSize()*(WITNESS_SCALE_FACTOR-1) + tx.TotalSize()
+}
+
+// Virtual size: weight / 4, rounded up
+func (tx Transaction) VSize() int {
+	return (tx.Weight() + WITNESS_SCALE_FACTOR - 1) / WITNESS_SCALE_FACTOR
+}
+
 func NewTxFromFile(blockchainDataDir string, magicHeader MagicId, num uint32, pos uint32, txPos uint32) (*Transaction, error) {
 	block := &Block{}
 
@@ -190,6 +269,14 @@ func NewTxFromFile(blockchainDataDir string, magicHeader MagicId, num uint32, po
 		return nil, err
 	}
 
+	// The transaction offset is counted from the end of the AuxPoW
+	if params, err := GetChainParamsByMagicId(magicHeader); err == nil && params.AuxPowChainId != 0 && block.IsAuxPow() {
+		_, err = ParseAuxPowFromFile(blockFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Seek to the transaction pos
 	_, err = blockFile.Seek(int64(txPos), 1)
 	if err != nil {
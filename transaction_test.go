@@ -0,0 +1,118 @@
+package blockchainparser
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func hexForTest(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Parses a serialized transaction the way it is read from a blk file
+func parseTxForTest(t *testing.T, s string) *Transaction {
+	path := filepath.Join(t.TempDir(), "tx.dat")
+	if err := os.WriteFile(path, hexForTest(t, s), 0666); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockFile := &BlockFile{file: file}
+	defer blockFile.Close()
+
+	tx, err := ParseBlockTransactionFromFile(blockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(tx.Binary()) != s {
+		t.Fatalf("Transaction %s doesn't serialize back to its bytes", tx.Txid())
+	}
+	return tx
+}
+
+const (
+	// Mainnet transaction 23b397edccd3740a74adb603c9756370fafcde9bcc4483eb271ecad09a94dd63
+	legacyTxForTest = "0100000001b14bdcbc3e01bdaad36cc08e81e69c82e1060bc14e518db2b49aa43ad90ba26000000000490047304402203f16c6f40162ab686621ef3000b04e75418a0c0cb2d8aebeac894ae360ac1e780220ddc15ecdfc3507ac48e1681a33eb60996631bf6bf5bc0a0682c4db743ce7ca2b01ffffffff0140420f00000000001976a914660d4ef3a743e3e696ad990364e555c271ad504b88ac00000000"
+
+	// Network transaction of BIP174's extractor test vector, spending a P2SH
+	// multisig and a P2SH-P2WSH multisig
+	segwitTxForTest = "0200000000010258e87a21b56daf0c23be8e7070456c336f7cbaa5c8757924f545887bb2abdd7500000000da00473044022074018ad4180097b873323c0015720b3684cc8123891048e7dbcd9b55ad679c99022073d369b740e3eb53dcefa33823c8070514ca55a7dd9544f157c167913261118c01483045022100f61038b308dc1da865a34852746f015772934208c6d24454393cd99bdf2217770220056e675a675a6d0a02b85b14e5e29074d8a25a9b5760bea2816f661910a006ea01475221029583bf39ae0a609747ad199addd634fa6108559d6c5cd39b4c2183f1ab96e07f2102dab61ff49a14db6a7d02b0cd1fbb78fc4b18312b5b4e54dae4dba2fbfef536d752aeffffffff838d0427d0ec650a68aa46bb0b098aea4422c071b2ca78352a077959d07cea1d01000000232200208c2353173743b595dfb4a07b72ba8e42e3797da74e87fe7d9d7497e3b2028903ffffffff0270aaf00800000000160014d85c2b71d0060b09c9886aeb815e50991dda124d00e1f5050000000016001400aea9a2e5f0f876a588df5546e8742d1d87008f000400473044022062eb7a556107a7c73f45ac4ab5a1dddf6f7075fb1275969a7f383efff784bcb202200c05dbb7470dbf2f08557dd356c7325c1ed30913e996cd3840945db12228da5f01473044022065f45ba5998b59a27ffe1a7bed016af1f1f90d54b3aa8f7450aa5f56a25103bd02207f724703ad1edb96680b284b56d4ffcb88f7fb759eabbe08aa30f29b851383d20147522103089dc10c7ac6db54f91329af617333db388cead0c231f723379d1b99030b02dc21023add904f3d6dcf59ddb906b0dee23529b7ffb9ed50e5e86151926860221f0e7352ae00000000"
+)
+
+func TestTransactionWeight(t *testing.T) {
+	tests := []struct {
+		tx                                     string
+		txid                                   string
+		strippedSize, totalSize, weight, vsize int
+	}{
+		{legacyTxForTest, "23b397edccd3740a74adb603c9756370fafcde9bcc4483eb271ecad09a94dd63", 158, 158, 632, 158},
+		// Marker, flag and the 219 bytes of witness stacks are discounted
+		{segwitTxForTest, "c001dff12b319c432360072394690d2e9ef1a28a5d77e3f5346ecc46dff966cd", 407, 628, 1849, 463},
+	}
+
+	for _, test := range tests {
+		tx := parseTxForTest(t, test.tx)
+		if tx.Txid().String() != test.txid {
+			t.Errorf("Got txid %s, want %s", tx.Txid(), test.txid)
+		}
+		if tx.StrippedSize() != test.strippedSize || tx.TotalSize() != test.totalSize || tx.Weight() != test.weight || tx.VSize() != test.vsize {
+			t.Errorf("%s: stripped size %d, total size %d, weight %d, vsize %d", test.txid, tx.StrippedSize(), tx.TotalSize(), tx.Weight(), tx.VSize())
+		}
+		if tx.StrippedSize() != len(tx.BinaryNoWitness()) {
+			t.Errorf("%s: stripped size %d, serialized without witness %d bytes", test.txid, tx.StrippedSize(), len(tx.BinaryNoWitness()))
+		}
+	}
+}
+
+func TestBlockWeight(t *testing.T) {
+	block := &Block{Transactions: []Transaction{*parseTxForTest(t, legacyTxForTest), *parseTxForTest(t, segwitTxForTest)}}
+
+	// 80 bytes of header and 1 byte of transaction count
+	if block.StrippedSize() != 81+158+407 || block.TotalSize() != 81+158+628 {
+		t.Errorf("Stripped size %d, total size %d", block.StrippedSize(), block.TotalSize())
+	}
+	if block.Weight() != 81*4+632+1849 || block.VSize() != (81*4+632+1849+3)/4 {
+		t.Errorf("Weight %d, vsize %d", block.Weight(), block.VSize())
+	}
+	if err := block.CheckWeight(); err != nil {
+		t.Error(err)
+	}
+
+	// 80 + 1 bytes of block header and transaction count, 4 + 1 + 1 + 8 + 4
+	// bytes of transaction fields and 5 bytes of script length leave 999896
+	// bytes of script under the limit of 4M weight units
+	padded := &Block{Transactions: []Transaction{{Version: 1, Vout: []TxOutput{{Script: make(Script, 999896)}}}}}
+	if err := padded.CheckWeight(); err != nil || padded.Weight() != MAX_BLOCK_WEIGHT {
+		t.Errorf("Block of weight %d: %v", padded.Weight(), err)
+	}
+	padded.Transactions[0].Vout[0].Script = append(padded.Transactions[0].Vout[0].Script, 0)
+	if err := padded.CheckWeight(); err == nil {
+		t.Errorf("Block of weight %d accepted", padded.Weight())
+	}
+}
+
+func TestTransactionWtxid(t *testing.T) {
+	legacy := parseTxForTest(t, legacyTxForTest)
+	if legacy.Wtxid().String() != legacy.Txid().String() {
+		t.Errorf("Legacy transaction: wtxid %s, txid %s", legacy.Wtxid(), legacy.Txid())
+	}
+
+	segwit := parseTxForTest(t, segwitTxForTest)
+	if segwit.Wtxid().String() == segwit.Txid().String() || segwit.Wtxid().String() != DoubleSha256(hexForTest(t, segwitTxForTest)).String() {
+		t.Errorf("Segwit transaction: wtxid %s, txid %s", segwit.Wtxid(), segwit.Txid())
+	}
+	for i := range segwit.Vin {
+		segwit.Vin[i].ScriptWitness = nil
+	}
+	if segwit.Wtxid().String() != "c001dff12b319c432360072394690d2e9ef1a28a5d77e3f5346ecc46dff966cd" {
+		t.Errorf("Without witnesses: wtxid %s", segwit.Wtxid())
+	}
+}
@@ -0,0 +1,198 @@
+// Package txindex builds the txid to block file position index of bitcoind's
+// -txindex from the blk*.dat files, for nodes running without it. The index
+// is stored in a separate LevelDB and updated incrementally.
+package txindex
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ruqqq/blockchainparser"
+	"github.com/ruqqq/blockchainparser/db"
+	"github.com/ruqqq/blockchainparser/scanner"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+const (
+	//! Transaction records: 't' + txid, as in bitcoind's txindex
+	TX_INDEX_RECORD_PREFIX = 't'
+
+	//! Hash and height of the last connected block
+	TX_INDEX_BEST_BLOCK_KEY = "B"
+
+	//! Size of a record: file number, block position and transaction offset
+	TX_INDEX_RECORD_SIZE = 12
+)
+
+type TxIndex struct {
+	*leveldb.DB
+	params *blockchainparser.ChainParams
+}
+
+// Opens the index at path, creating it if needed
+func Open(path string, params *blockchainparser.ChainParams) (*TxIndex, error) {
+	return open(path, params, nil)
+}
+
+// Opens an existing index for lookups only
+func OpenReadOnly(path string, params *blockchainparser.ChainParams) (*TxIndex, error) {
+	return open(path, params, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+}
+
+func open(path string, params *blockchainparser.ChainParams, options *opt.Options) (*TxIndex, error) {
+	db, err := leveldb.OpenFile(path, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxIndex{DB: db, params: params}, nil
+}
+
+// Position of a transaction, in the format of bitcoind's txindex: the block
+// position is the one of the block index and the offset is counted from the
+// end of the block header. Returns leveldb.ErrNotFound for unknown
+// transactions.
+func (txIndex *TxIndex) GetTxIndexRecord(txid blockchainparser.Hash256) (*db.TxIndexRecord, error) {
+	value, err := txIndex.Get(recordKey(txid), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) != TX_INDEX_RECORD_SIZE {
+		return nil, errors.New("Invalid txindex record")
+	}
+
+	return &db.TxIndexRecord{
+		NFile:     int32(binary.LittleEndian.Uint32(value)),
+		NDataPos:  binary.LittleEndian.Uint32(value[4:]),
+		NTxOffset: binary.LittleEndian.Uint32(value[8:]),
+	}, nil
+}
+
+// Reads a transaction from the block files
+func (txIndex *TxIndex) GetTx(dataDir string, txid blockchainparser.Hash256) (*blockchainparser.Transaction, error) {
+	record, err := txIndex.GetTxIndexRecord(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	return blockchainparser.NewTxFromFile(dataDir, txIndex.params.MagicId, uint32(record.NFile), record.NDataPos, record.NTxOffset)
+}
+
+// Hash and height of the last connected block, nil and -1 for an empty index
+func (txIndex *TxIndex) BestBlock() (blockchainparser.Hash256, int32, error) {
+	value, err := txIndex.Get([]byte(TX_INDEX_BEST_BLOCK_KEY), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, -1, nil
+	}
+	if err != nil {
+		return nil, -1, err
+	}
+	if len(value) != 36 {
+		return nil, -1, errors.New("Invalid best block record")
+	}
+
+	return blockchainparser.Hash256(value[:32]), int32(binary.LittleEndian.Uint32(value[32:])), nil
+}
+
+// Indexes the transactions of a block extending the best block
+func (txIndex *TxIndex) ConnectBlock(block *scanner.ScannedBlock) error {
+	best, _, err := txIndex.BestBlock()
+	if err != nil {
+		return err
+	}
+	if best != nil && !bytes.Equal(block.HashPrev, best) {
+		return fmt.Errorf("Block %s does not extend the indexed block %s", block.Hash(), best)
+	}
+
+	batch := new(leveldb.Batch)
+	dataPos := uint32(block.Offset + scanner.BLOCK_RECORD_HEADER_SIZE)
+	txOffset := uint32(len(blockchainparser.Varint(uint64(len(block.Transactions)))))
+	for _, tx := range block.Transactions {
+		value := make([]byte, TX_INDEX_RECORD_SIZE)
+		binary.LittleEndian.PutUint32(value, block.FileNum)
+		binary.LittleEndian.PutUint32(value[4:], dataPos)
+		binary.LittleEndian.PutUint32(value[8:], txOffset)
+		batch.Put(recordKey(tx.Txid()), value)
+		txOffset += uint32(tx.TotalSize())
+	}
+
+	return txIndex.write(batch, block.Hash(), block.Height)
+}
+
+// Removes the transactions of the best block
+func (txIndex *TxIndex) DisconnectBlock(block *scanner.ScannedBlock) error {
+	best, _, err := txIndex.BestBlock()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(block.Hash(), best) {
+		return fmt.Errorf("Block %s is not the indexed block %s", block.Hash(), best)
+	}
+
+	batch := new(leveldb.Batch)
+	for _, tx := range block.Transactions {
+		batch.Delete(recordKey(tx.Txid()))
+	}
+
+	return txIndex.write(batch, block.HashPrev, block.Height-1)
+}
+
+// Applies an event of scanner.ChainEvents or scanner.Follower
+func (txIndex *TxIndex) Apply(event scanner.Event) error {
+	if event.Type == scanner.EVENT_BLOCK_DISCONNECTED {
+		return txIndex.DisconnectBlock(event.Block)
+	}
+
+	return txIndex.ConnectBlock(event.Block)
+}
+
+// Brings the index to the tip of the active chain of chainIndex. Blocks are
+// read with an ordered scan, whose Workers and OnProgress are taken from
+// opts. An index left on a stale block is first moved to the new branch.
+func (txIndex *TxIndex) Update(ctx context.Context, chainIndex *scanner.ChainIndex, dataDir string, opts scanner.Options) error {
+	best, height, err := txIndex.BestBlock()
+	if err != nil {
+		return err
+	}
+	if best != nil && !chainIndex.Contains(best) {
+		return scanner.ChainEvents(chainIndex, txIndex.params, dataDir, best, txIndex.Apply)
+	}
+	if height >= chainIndex.Height() {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	opts.Ordered = true
+	opts.Index = chainIndex
+	opts.StartHeight = height + 1
+	blockScanner := scanner.New(txIndex.params, dataDir, opts)
+	for block := range blockScanner.Scan(ctx) {
+		if err := txIndex.ConnectBlock(block); err != nil {
+			return err
+		}
+	}
+
+	return blockScanner.Err()
+}
+
+func (txIndex *TxIndex) write(batch *leveldb.Batch, best blockchainparser.Hash256, height int32) error {
+	if height < 0 {
+		batch.Delete([]byte(TX_INDEX_BEST_BLOCK_KEY))
+	} else {
+		value := make([]byte, 36)
+		copy(value, best)
+		binary.LittleEndian.PutUint32(value[32:], uint32(height))
+		batch.Put([]byte(TX_INDEX_BEST_BLOCK_KEY), value)
+	}
+
+	return txIndex.Write(batch, nil)
+}
+
+func recordKey(txid blockchainparser.Hash256) []byte {
+	return append([]byte{TX_INDEX_RECORD_PREFIX}, txid...)
+}
@@ -0,0 +1,264 @@
+package txindex
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ruqqq/blockchainparser"
+	"github.com/ruqqq/blockchainparser/db"
+	"github.com/ruqqq/blockchainparser/scanner"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func coinbaseForTest(tag byte) blockchainparser.Transaction {
+	return blockchainparser.Transaction{
+		Version: 1,
+		Vin:     []blockchainparser.TxInput{{Hash: make(blockchainparser.Hash256, 32), Index: 0xffffffff, Script: blockchainparser.Script{1, tag}, Sequence: 0xffffffff}},
+		Vout:    []blockchainparser.TxOutput{{Value: 5000, Script: blockchainparser.Script{blockchainparser.OP_1}}},
+	}
+}
+
+// Legacy and segwit spends, so that transaction sizes differ
+func spendsForTest(tag byte) []blockchainparser.Transaction {
+	legacy := blockchainparser.Transaction{
+		Version: 2,
+		Vin:     []blockchainparser.TxInput{{Hash: blockchainparser.DoubleSha256([]byte{tag}), Index: 1, Script: blockchainparser.Script{blockchainparser.OP_1}, Sequence: 0xffffffff}},
+		Vout:    []blockchainparser.TxOutput{{Value: 1000, Script: blockchainparser.Script{blockchainparser.OP_1}}},
+	}
+	segwit := blockchainparser.Transaction{
+		Version: 2,
+		Vin: []blockchainparser.TxInput{{
+			Hash:          blockchainparser.DoubleSha256([]byte{tag, 1}),
+			Sequence:      0xfffffffd,
+			ScriptWitness: [][]byte{make([]byte, 72), make([]byte, 33)},
+		}},
+		Vout: []blockchainparser.TxOutput{{Value: 900, Script: blockchainparser.Script{blockchainparser.OP_1}}, {Value: 50, Script: blockchainparser.Script{blockchainparser.OP_2}}},
+	}
+
+	return []blockchainparser.Transaction{legacy, segwit}
+}
+
+func blockForTest(params *blockchainparser.ChainParams, hashPrev blockchainparser.Hash256, tag byte, txs ...blockchainparser.Transaction) *blockchainparser.Block {
+	block := &blockchainparser.Block{Transactions: append([]blockchainparser.Transaction{coinbaseForTest(tag)}, txs...)}
+	block.Version = 4
+	block.HashPrev = hashPrev
+	block.Timestamp = time.Unix(1600000000+int64(tag), 0)
+	block.TargetDifficulty = 0x207fffff
+	block.HashMerkle = block.MerkleRoot()
+	if params.AuxPowChainId != 0 {
+		block.Version |= blockchainparser.BLOCK_VERSION_AUXPOW | params.AuxPowChainId<<16
+		block.AuxPow = &blockchainparser.AuxPow{
+			CoinbaseTx:      coinbaseForTest(tag + 100),
+			ParentBlockHash: make(blockchainparser.Hash256, 32),
+			CoinbaseBranch:  []blockchainparser.Hash256{blockchainparser.DoubleSha256([]byte{tag})},
+			ParentBlock:     blockchainparser.BlockHeader{HashPrev: make(blockchainparser.Hash256, 32), HashMerkle: make(blockchainparser.Hash256, 32)},
+		}
+	}
+
+	return block
+}
+
+func recordForTest(magicId blockchainparser.MagicId, data []byte) []byte {
+	header := make([]byte, scanner.BLOCK_RECORD_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(header, uint32(magicId))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+
+	return append(header, data...)
+}
+
+// Writes blocks to blk*.dat number fileNum and returns them as read back
+func writeBlocksForTest(t *testing.T, params *blockchainparser.ChainParams, dataDir string, fileNum uint32, blocks ...*blockchainparser.Block) []*scanner.ScannedBlock {
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, recordForTest(params.MagicId, block.Binary())...)
+	}
+	if err := os.MkdirAll(dataDir+"/blocks", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(blockchainparser.BlockFilePath(dataDir, fileNum), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var scanned []*scanner.ScannedBlock
+	_, err := scanner.ReadBlockFile(params, dataDir, fileNum, 0, func(block *scanner.ScannedBlock) error {
+		block.Height = int32(len(scanned))
+		scanned = append(scanned, block)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scanned) != len(blocks) {
+		t.Fatalf("Read %d of %d blocks", len(scanned), len(blocks))
+	}
+
+	return scanned
+}
+
+func openForTest(t *testing.T, params *blockchainparser.ChainParams) *TxIndex {
+	txIndex, err := Open(t.TempDir(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { txIndex.Close() })
+
+	return txIndex
+}
+
+// Every transaction of blocks is read back from its record
+func checkTxsForTest(t *testing.T, txIndex *TxIndex, dataDir string, blocks ...*blockchainparser.Block) {
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			found, err := txIndex.GetTx(dataDir, tx.Txid())
+			if err != nil {
+				t.Errorf("Tx %s: %s", tx.Txid(), err)
+				continue
+			}
+			if found.Wtxid().String() != tx.Wtxid().String() {
+				t.Errorf("Tx %s: read %s", tx.Txid(), found.Wtxid())
+			}
+		}
+	}
+}
+
+func TestConnectBlockOffsets(t *testing.T) {
+	for _, params := range []*blockchainparser.ChainParams{blockchainparser.RegTestParams, blockchainparser.DogecoinParams} {
+		dataDir := t.TempDir()
+		genesis := blockForTest(params, make(blockchainparser.Hash256, 32), 0)
+		block := blockForTest(params, genesis.Hash(), 1, spendsForTest(1)...)
+		scanned := writeBlocksForTest(t, params, dataDir, 0, genesis, block)
+		if params.AuxPowChainId != 0 && scanned[1].AuxPow == nil {
+			t.Fatal("AuxPoW not parsed")
+		}
+
+		txIndex := openForTest(t, params)
+		for _, block := range scanned {
+			if err := txIndex.ConnectBlock(block); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkTxsForTest(t, txIndex, dataDir, genesis, block)
+
+		record, err := txIndex.GetTxIndexRecord(block.Transactions[0].Txid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.NFile != 0 || int64(record.NDataPos) != scanned[1].Offset+scanner.BLOCK_RECORD_HEADER_SIZE || record.NTxOffset != 1 {
+			t.Errorf("%s: got coinbase record %+v", params.Name, record)
+		}
+	}
+}
+
+func TestDisconnectBlock(t *testing.T) {
+	params := blockchainparser.RegTestParams
+	dataDir := t.TempDir()
+	genesis := blockForTest(params, make(blockchainparser.Hash256, 32), 0)
+	block := blockForTest(params, genesis.Hash(), 1, spendsForTest(1)...)
+	scanned := writeBlocksForTest(t, params, dataDir, 0, genesis, block)
+
+	txIndex := openForTest(t, params)
+	for _, block := range scanned {
+		if err := txIndex.ConnectBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := txIndex.DisconnectBlock(scanned[0]); err == nil {
+		t.Error("Disconnected a block below the best block")
+	}
+	if err := txIndex.ConnectBlock(scanned[1]); err == nil {
+		t.Error("Connected a block not extending the best block")
+	}
+
+	if err := txIndex.DisconnectBlock(scanned[1]); err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range block.Transactions {
+		if _, err := txIndex.GetTxIndexRecord(tx.Txid()); err != leveldb.ErrNotFound {
+			t.Errorf("Tx %s of the disconnected block: got %v", tx.Txid(), err)
+		}
+	}
+	checkTxsForTest(t, txIndex, dataDir, genesis)
+	if best, height, err := txIndex.BestBlock(); err != nil || best.String() != genesis.Hash().String() || height != 0 {
+		t.Errorf("Got best block %s at %d, %v", best, height, err)
+	}
+
+	if err := txIndex.DisconnectBlock(scanned[0]); err != nil {
+		t.Fatal(err)
+	}
+	if best, height, err := txIndex.BestBlock(); err != nil || best != nil || height != -1 {
+		t.Errorf("Got best block %s at %d, %v", best, height, err)
+	}
+}
+
+func indexEntryForTest(block *scanner.ScannedBlock, height int32) *scanner.IndexEntry {
+	return &scanner.IndexEntry{Hash: block.Hash(), BlockIndexRecord: &db.BlockIndexRecord{
+		Height:   height,
+		Status:   db.BLOCK_VALID_SCRIPTS | db.BLOCK_HAVE_DATA,
+		NFile:    int32(block.FileNum),
+		NDataPos: uint32(block.Offset + scanner.BLOCK_RECORD_HEADER_SIZE),
+		HashPrev: block.HashPrev,
+		NBits:    block.TargetDifficulty,
+	}}
+}
+
+func TestUpdate(t *testing.T) {
+	params := blockchainparser.RegTestParams
+	dataDir := t.TempDir()
+	genesis := blockForTest(params, make(blockchainparser.Hash256, 32), 0)
+	a1 := blockForTest(params, genesis.Hash(), 1, spendsForTest(1)...)
+	a2 := blockForTest(params, a1.Hash(), 2)
+	a3 := blockForTest(params, a2.Hash(), 3, spendsForTest(3)...)
+	b1 := blockForTest(params, genesis.Hash(), 11)
+	b2 := blockForTest(params, b1.Hash(), 12)
+	a := writeBlocksForTest(t, params, dataDir, 0, genesis, a1, a2, a3)
+	b := writeBlocksForTest(t, params, dataDir, 1, b1, b2)
+
+	// Undo data of the b branch, needed to disconnect it: coinbases only
+	var undo []byte
+	b1Entry, b2Entry := indexEntryForTest(b[0], 1), indexEntryForTest(b[1], 2)
+	for _, entry := range []*scanner.IndexEntry{b1Entry, b2Entry} {
+		entry.Status |= db.BLOCK_HAVE_UNDO
+		entry.NUndoPos = uint32(len(undo) + scanner.BLOCK_RECORD_HEADER_SIZE)
+		undo = append(undo, recordForTest(params.MagicId, []byte{0})...)
+		undo = append(undo, blockchainparser.DoubleSha256(append(append([]byte{}, entry.HashPrev...), 0))...)
+	}
+	if err := os.WriteFile(blockchainparser.UndoFilePath(dataDir, 1), undo, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The b branch is the active chain first
+	entries := []*scanner.IndexEntry{indexEntryForTest(a[0], 0), b1Entry, b2Entry}
+	txIndex := openForTest(t, params)
+	if err := txIndex.Update(context.Background(), scanner.NewChainIndex(entries), dataDir, scanner.Options{Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	checkTxsForTest(t, txIndex, dataDir, genesis, b1, b2)
+	if best, height, err := txIndex.BestBlock(); err != nil || best.String() != b2.Hash().String() || height != 2 {
+		t.Errorf("Got best block %s at %d, %v", best, height, err)
+	}
+
+	// Then the longer a branch
+	for height, block := range a[1:] {
+		entries = append(entries, indexEntryForTest(block, int32(height+1)))
+	}
+	chainIndex := scanner.NewChainIndex(entries)
+	if err := txIndex.Update(context.Background(), chainIndex, dataDir, scanner.Options{Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	checkTxsForTest(t, txIndex, dataDir, genesis, a1, a2, a3)
+	for _, block := range []*blockchainparser.Block{b1, b2} {
+		if _, err := txIndex.GetTxIndexRecord(block.Transactions[0].Txid()); err != leveldb.ErrNotFound {
+			t.Errorf("Tx of the stale block %s: got %v", block.Hash(), err)
+		}
+	}
+	if best, height, err := txIndex.BestBlock(); err != nil || best.String() != a3.Hash().String() || height != 3 {
+		t.Errorf("Got best block %s at %d, %v", best, height, err)
+	}
+
+	if err := txIndex.Update(context.Background(), chainIndex, dataDir, scanner.Options{}); err != nil {
+		t.Errorf("Up to date index: %s", err)
+	}
+}
@@ -0,0 +1,178 @@
+package blockchainparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Output spent by a transaction input, as kept in rev*.dat to undo the block
+type Coin struct {
+	Out        TxOutput
+	Height     int32 // of the block that created the output
+	IsCoinbase bool
+}
+
+// Spent outputs of one transaction, in input order
+type TxUndo struct {
+	PrevOuts []Coin
+}
+
+// Undo data of a block: one TxUndo per transaction except the coinbase
+type BlockUndo struct {
+	TxUndo []TxUndo
+}
+
+// Path of the rev*.dat file number fileNum
+func UndoFilePath(blockchainDataDir string, fileNum uint32) string {
+	return fmt.Sprintf(blockchainDataDir+"/blocks/rev%05d.dat", fileNum)
+}
+
+// Reads the MSB base-128 VARINT of bitcoind's serialize.h (not CompactSize)
+func (r *byteReader) readVarInt() uint64 {
+	var n uint64
+	for r.err == nil {
+		b := r.readByte()
+		if n > (1<<64-1)>>7 {
+			r.err = errors.New("VARINT too large")
+			return 0
+		}
+		n = n<<7 | uint64(b&0x7F)
+		if b&0x80 == 0 {
+			return n
+		}
+		n++
+	}
+
+	return 0
+}
+
+// Port of DecompressAmount from bitcoind's compressor.cpp
+func DecompressAmount(x uint64) Amount {
+	// x = 0  OR  x = 1+10*(9*n + d - 1) + e  OR  x = 1+10*(n - 1) + 9
+	if x == 0 {
+		return 0
+	}
+	x--
+	// x = 10*(9*n + d - 1) + e
+	e := x % 10
+	x /= 10
+	var n uint64
+	if e < 9 {
+		// x = 9*n + d - 1
+		d := x%9 + 1
+		x /= 9
+		// x = n
+		n = x*10 + d
+	} else {
+		n = x + 1
+	}
+	for ; e > 0; e-- {
+		n *= 10
+	}
+
+	return Amount(n)
+}
+
+// Reads a script compressed by bitcoind's ScriptCompression: the common
+// templates are stored as their hash or key only
+func (r *byteReader) readCompressedScript() Script {
+	size := r.readVarInt()
+	switch size {
+	case 0:
+		hash := r.readBytes(20)
+		return append(append(Script{OP_DUP, OP_HASH160, 20}, hash...), OP_EQUALVERIFY, OP_CHECKSIG)
+	case 1:
+		hash := r.readBytes(20)
+		return append(append(Script{OP_HASH160, 20}, hash...), OP_EQUAL)
+	case 2, 3:
+		x := r.readBytes(32)
+		return append(append(Script{COMPRESSED_PUBKEY_SIZE, byte(size)}, x...), OP_CHECKSIG)
+	case 4, 5:
+		x := r.readBytes(32)
+		if r.err != nil {
+			return nil
+		}
+		pubKey, err := ParsePubKey(append([]byte{byte(size - 2)}, x...))
+		if err != nil {
+			r.err = err
+			return nil
+		}
+		return append(append(Script{PUBKEY_SIZE}, pubKey.SerializeUncompressed()...), OP_CHECKSIG)
+	}
+
+	return r.readBytes(size - 6)
+}
+
+// Reads a Coin serialized by bitcoind's TxInUndoFormatter
+func (r *byteReader) readCoin() Coin {
+	code := r.readVarInt()
+	coin := Coin{Height: int32(code >> 1), IsCoinbase: code&1 == 1}
+	if coin.Height > 0 {
+		// Transaction version of the old undo format, always 0
+		r.readVarInt()
+	}
+	coin.Out.Value = DecompressAmount(r.readVarInt())
+	coin.Out.Script = r.readCompressedScript()
+
+	return coin
+}
+
+// Parse the undo data of a block, without the rev*.dat record header and checksum
+func ParseBlockUndoFromBytes(b []byte) (*BlockUndo, error) {
+	r := &byteReader{b: b}
+	undo := &BlockUndo{}
+
+	count := r.readCount(1)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		txUndo := TxUndo{}
+		coins := r.readCount(3)
+		for j := uint64(0); j < coins && r.err == nil; j++ {
+			txUndo.PrevOuts = append(txUndo.PrevOuts, r.readCoin())
+		}
+		undo.TxUndo = append(undo.TxUndo, txUndo)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.pos != uint64(len(b)) {
+		return nil, errors.New("Unexpected data after block undo")
+	}
+
+	return undo, nil
+}
+
+// Reads the undo data at pos (the NUndoPos of the block index) of rev*.dat
+// number num, and checks its checksum against the block's previous hash
+func NewBlockUndoFromFile(blockchainDataDir string, magicHeader MagicId, num uint32, pos uint32, hashPrev Hash256) (*BlockUndo, error) {
+	file, err := os.Open(UndoFilePath(blockchainDataDir, num))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(int64(pos)-8, io.SeekStart); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, err
+	}
+	if MagicId(binary.LittleEndian.Uint32(header)) != magicHeader {
+		return nil, errors.New("Invalid undo header: Can't find Magic ID")
+	}
+
+	data := make([]byte, binary.LittleEndian.Uint32(header[4:])+32)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return nil, err
+	}
+	undoData, checksum := data[:len(data)-32], data[len(data)-32:]
+	if !bytes.Equal(DoubleSha256(append(append([]byte{}, hashPrev...), undoData...)), checksum) {
+		return nil, errors.New("Invalid undo checksum")
+	}
+
+	return ParseBlockUndoFromBytes(undoData)
+}
@@ -0,0 +1,170 @@
+package blockchainparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"testing"
+)
+
+// MSB base-128 VARINT of bitcoind's serialize.h
+func undoVarIntForTest(n uint64) []byte {
+	var b []byte
+	for {
+		digit := byte(n & 0x7F)
+		if len(b) > 0 {
+			digit |= 0x80
+		}
+		b = append([]byte{digit}, b...)
+		if n <= 0x7F {
+			return b
+		}
+		n = n>>7 - 1
+	}
+}
+
+func TestDecompressAmount(t *testing.T) {
+	// From bitcoind's compress_tests
+	tests := []struct {
+		compressed uint64
+		amount     Amount
+	}{
+		{0, 0},
+		{1, 1},
+		{7, 1000000},
+		{9, 100000000},
+		{50, 5000000000},
+		{21000000, 2100000000000000},
+	}
+	for _, test := range tests {
+		if amount := DecompressAmount(test.compressed); amount != test.amount {
+			t.Errorf("DecompressAmount(%d): got %d, want %d", test.compressed, amount, test.amount)
+		}
+	}
+}
+
+func TestReadVarInt(t *testing.T) {
+	for _, n := range []uint64{0, 1, 0x7F, 0x80, 0x3FFF, 0x4000, 1 << 32, 1<<64 - 1} {
+		r := &byteReader{b: undoVarIntForTest(n)}
+		if got := r.readVarInt(); r.err != nil || got != n || r.pos != uint64(len(r.b)) {
+			t.Errorf("VARINT %d: got %d, %v", n, got, r.err)
+		}
+	}
+
+	r := &byteReader{b: bytes.Repeat([]byte{0xFF}, 11)}
+	if r.readVarInt(); r.err == nil {
+		t.Error("Oversized VARINT passed")
+	}
+	r = &byteReader{b: []byte{0x80}}
+	if r.readVarInt(); r.err != ErrUnexpectedEnd {
+		t.Errorf("Truncated VARINT: got %v", r.err)
+	}
+}
+
+// Every case of bitcoind's ScriptCompression
+func TestParseBlockUndoScripts(t *testing.T) {
+	hash := bytes.Repeat([]byte{0xAB}, 20)
+	keys := map[byte]*PublicKey{}
+	for key := int64(1); len(keys) < 2; key++ {
+		pubKey := pubKeyForTest(big.NewInt(key))
+		keys[pubKey.SerializeCompressed()[0]] = pubKey
+	}
+
+	tests := []struct {
+		name       string
+		compressed []byte
+		script     Script
+	}{
+		{"P2PKH", append([]byte{0}, hash...), append(append(Script{OP_DUP, OP_HASH160, 20}, hash...), OP_EQUALVERIFY, OP_CHECKSIG)},
+		{"P2SH", append([]byte{1}, hash...), append(append(Script{OP_HASH160, 20}, hash...), OP_EQUAL)},
+		{"P2PK even", append([]byte{2}, keys[2].SerializeCompressed()[1:]...), append(append(Script{COMPRESSED_PUBKEY_SIZE}, keys[2].SerializeCompressed()...), OP_CHECKSIG)},
+		{"P2PK odd", append([]byte{3}, keys[3].SerializeCompressed()[1:]...), append(append(Script{COMPRESSED_PUBKEY_SIZE}, keys[3].SerializeCompressed()...), OP_CHECKSIG)},
+		{"uncompressed P2PK even", append([]byte{4}, keys[2].SerializeCompressed()[1:]...), append(append(Script{PUBKEY_SIZE}, keys[2].SerializeUncompressed()...), OP_CHECKSIG)},
+		{"uncompressed P2PK odd", append([]byte{5}, keys[3].SerializeCompressed()[1:]...), append(append(Script{PUBKEY_SIZE}, keys[3].SerializeUncompressed()...), OP_CHECKSIG)},
+		{"raw", []byte{6 + 3, OP_RETURN, 1, 0x42}, Script{OP_RETURN, 1, 0x42}},
+		{"empty", []byte{6}, Script{}},
+	}
+	for _, test := range tests {
+		// One transaction spending a coinbase output of height 10, then a
+		// regular output of height 0
+		undoData := []byte{1, 2}
+		undoData = append(undoData, undoVarIntForTest(10<<1|1)...)
+		undoData = append(undoData, 0) // version
+		undoData = append(undoData, undoVarIntForTest(50)...)
+		undoData = append(undoData, test.compressed...)
+		undoData = append(undoData, 0, 9)
+		undoData = append(undoData, test.compressed...)
+
+		undo, err := ParseBlockUndoFromBytes(undoData)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if len(undo.TxUndo) != 1 || len(undo.TxUndo[0].PrevOuts) != 2 {
+			t.Errorf("%s: got %+v", test.name, undo)
+			continue
+		}
+		coin := undo.TxUndo[0].PrevOuts[0]
+		if coin.Height != 10 || !coin.IsCoinbase || coin.Out.Value != 5000000000 || !bytes.Equal(coin.Out.Script, test.script) {
+			t.Errorf("%s: got coin %+v", test.name, coin)
+		}
+		coin = undo.TxUndo[0].PrevOuts[1]
+		if coin.Height != 0 || coin.IsCoinbase || coin.Out.Value != 100000000 || !bytes.Equal(coin.Out.Script, test.script) {
+			t.Errorf("%s: got coin %+v", test.name, coin)
+		}
+
+		if _, err := ParseBlockUndoFromBytes(undoData[:len(undoData)-1]); err == nil {
+			t.Errorf("%s: truncated undo passed", test.name)
+		}
+	}
+
+	// Not a point of the curve
+	undoData := append([]byte{1, 1, 0, 0, 4}, bytes.Repeat([]byte{0xFF}, 32)...)
+	if _, err := ParseBlockUndoFromBytes(undoData); err == nil {
+		t.Error("Invalid uncompressed key passed")
+	}
+	if _, err := ParseBlockUndoFromBytes([]byte{0, 0}); err == nil {
+		t.Error("Trailing data passed")
+	}
+}
+
+func TestNewBlockUndoFromFile(t *testing.T) {
+	dataDir := t.TempDir()
+	hashPrev := DoubleSha256([]byte("parent"))
+	undoData := append([]byte{1, 1, 0, 9, 1}, bytes.Repeat([]byte{0xAB}, 20)...)
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header, uint32(BLOCK_MAGIC_ID_REGTEST))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(undoData)))
+	data := append(append(header, undoData...), DoubleSha256(append(append([]byte{}, hashPrev...), undoData...))...)
+	if err := os.MkdirAll(dataDir+"/blocks", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(UndoFilePath(dataDir, 0), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pos := uint32(len(header))
+
+	undo, err := NewBlockUndoFromFile(dataDir, BLOCK_MAGIC_ID_REGTEST, 0, pos, hashPrev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undo.TxUndo) != 1 || len(undo.TxUndo[0].PrevOuts) != 1 || undo.TxUndo[0].PrevOuts[0].Out.Value != 100000000 {
+		t.Errorf("Got %+v", undo)
+	}
+
+	if _, err := NewBlockUndoFromFile(dataDir, BLOCK_MAGIC_ID_REGTEST, 0, pos, DoubleSha256([]byte("other"))); err == nil {
+		t.Error("Undo of another block passed")
+	}
+	if _, err := NewBlockUndoFromFile(dataDir, BLOCK_MAGIC_ID_BITCOIN, 0, pos, hashPrev); err == nil {
+		t.Error("Undo of another network passed")
+	}
+
+	data[8+3] = 8 // compressed amount of the output
+	if err := os.WriteFile(UndoFilePath(dataDir, 0), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBlockUndoFromFile(dataDir, BLOCK_MAGIC_ID_REGTEST, 0, pos, hashPrev); err == nil {
+		t.Error("Tampered undo passed")
+	}
+}
@@ -2,6 +2,7 @@ package blockchainparser
 
 import (
 	"crypto/sha256"
+	"golang.org/x/crypto/ripemd160"
 	"os"
 	"runtime"
 )
@@ -37,3 +38,28 @@ func DoubleSha256(data []byte) Hash256 {
 	hash.Write(firstSha256)
 	return hash.Sum(nil)
 }
+
+func Sha256(data []byte) []byte {
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// BIP340 tagged hash: SHA256(SHA256(tag) || SHA256(tag) || data)
+func TaggedHash(tag string, data ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	hash := sha256.New()
+	hash.Write(tagHash[:])
+	hash.Write(tagHash[:])
+	for _, d := range data {
+		hash.Write(d)
+	}
+	return hash.Sum(nil)
+}
+
+// RIPEMD160(SHA256(data)), as used by P2PKH/P2SH/P2WPKH
+func Hash160(data []byte) []byte {
+	sha := sha256.Sum256(data)
+	hash := ripemd160.New()
+	hash.Write(sha[:])
+	return hash.Sum(nil)
+}
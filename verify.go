@@ -0,0 +1,309 @@
+package blockchainparser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnsupportedScript = errors.New("Unsupported script type")
+	ErrMissingPrevouts   = errors.New("Spent outputs are required for every input")
+)
+
+// Result of the signature checks of one input
+type InputVerification struct {
+	Index      int
+	Type       TxOutType           // type of the spent output
+	InnerType  TxOutType           // type of the redeem/witness script for P2SH and P2WSH
+	Signatures int                 // number of signatures checked
+	Encodings  []SignatureEncoding // one per ECDSA signature, in the order they are pushed
+	Valid      bool
+	Err        error // why the input is invalid, or ErrUnsupportedScript if it couldn't be checked
+}
+
+// Standardness of an ECDSA signature's encoding. Consensus accepts both lax
+// DER and high S signatures, but bitcoind doesn't relay them.
+type SignatureEncoding struct {
+	StrictDER bool // BIP66 DER encoding
+	LowS      bool // S is at most half the curve order
+}
+
+func signatureEncoding(sig []byte) SignatureEncoding {
+	encoding := SignatureEncoding{StrictDER: IsValidSignatureEncoding(sig)}
+	if len(sig) > 0 {
+		if signature, err := ParseDERSignatureLax(sig[:len(sig)-1]); err == nil {
+			encoding.LowS = signature.IsLowS()
+		}
+	}
+
+	return encoding
+}
+
+// Checks the signatures of every input of tx against the outputs they spend.
+// prevouts are the spent outputs in input order. This matches the common
+// templates (P2PK, P2PKH, multisig, P2SH, P2WPKH, P2WSH and taproot key path)
+// and verifies their signatures; anything else is reported as
+// ErrUnsupportedScript. See VerifyInputScript for full script execution.
+func VerifyTransactionSignatures(tx *Transaction, prevouts []TxOutput) ([]InputVerification, error) {
+	if len(prevouts) != len(tx.Vin) {
+		return nil, ErrMissingPrevouts
+	}
+
+	cache := NewSigHashCache(tx, prevouts)
+	results := make([]InputVerification, len(tx.Vin))
+	for i := range tx.Vin {
+		results[i] = verifyInputSignatures(tx, i, prevouts, cache)
+	}
+
+	return results, nil
+}
+
+func verifyInputSignatures(tx *Transaction, nIn int, prevouts []TxOutput, cache *SigHashCache) InputVerification {
+	result := InputVerification{Index: nIn}
+	in := tx.Vin[nIn]
+	prevout := prevouts[nIn]
+
+	var solutions [][]byte
+	result.Type, solutions = prevout.Script.Type()
+	result.InnerType = TX_NONSTANDARD
+
+	fail := func(err error) InputVerification {
+		result.Valid = false
+		result.Err = err
+		return result
+	}
+
+	switch result.Type {
+	case TX_PUBKEY, TX_PUBKEYHASH, TX_MULTISIG:
+		stack, err := scriptSigPushes(in.Script)
+		if err != nil {
+			return fail(err)
+		}
+		if err := verifyTemplate(&result, tx, prevout.Script, stack, false, prevout.Value, cache); err != nil {
+			return fail(err)
+		}
+	case TX_SCRIPTHASH:
+		stack, err := scriptSigPushes(in.Script)
+		if err != nil {
+			return fail(err)
+		}
+		if len(stack) == 0 {
+			return fail(errors.New("Missing P2SH redeem script"))
+		}
+		redeemScript := Script(stack[len(stack)-1])
+		if !bytes.Equal(Hash160(redeemScript), solutions[0]) {
+			return fail(errors.New("Redeem script does not match the script hash"))
+		}
+		result.InnerType, _ = redeemScript.Type()
+
+		switch result.InnerType {
+		case TX_WITNESS_V0_KEYHASH, TX_WITNESS_V0_SCRIPTHASH:
+			if len(stack) != 1 {
+				return fail(errors.New("P2SH-wrapped witness program must have an empty scriptSig besides the redeem script"))
+			}
+			if err := verifyWitnessV0(&result, tx, redeemScript, prevout.Value, cache); err != nil {
+				return fail(err)
+			}
+		default:
+			if err := verifyTemplate(&result, tx, redeemScript, stack[:len(stack)-1], false, prevout.Value, cache); err != nil {
+				return fail(err)
+			}
+		}
+	case TX_WITNESS_V0_KEYHASH, TX_WITNESS_V0_SCRIPTHASH:
+		if len(in.Script) != 0 {
+			return fail(errors.New("Native witness program must have an empty scriptSig"))
+		}
+		if result.Type == TX_WITNESS_V0_SCRIPTHASH && len(in.ScriptWitness) > 0 {
+			result.InnerType, _ = Script(in.ScriptWitness[len(in.ScriptWitness)-1]).Type()
+		}
+		if err := verifyWitnessV0(&result, tx, prevout.Script, prevout.Value, cache); err != nil {
+			return fail(err)
+		}
+	case TX_WITNESS_V1_TAPROOT:
+		witness := in.ScriptWitness
+		annex := in.TaprootAnnex()
+		if annex != nil {
+			witness = witness[:len(witness)-1]
+		}
+		if len(witness) != 1 {
+			// Script path spends need script execution
+			return fail(ErrUnsupportedScript)
+		}
+		result.Signatures = 1
+		if err := verifyTaprootKeyPath(tx, nIn, solutions[0], witness[0], prevouts, annex, cache); err != nil {
+			return fail(err)
+		}
+	default:
+		return fail(ErrUnsupportedScript)
+	}
+
+	result.Valid = true
+	return result
+}
+
+// Returns the data pushed by a push-only scriptSig
+func scriptSigPushes(script Script) ([][]byte, error) {
+	ops, ok := script.Ops()
+	if !ok {
+		return nil, errors.New("Malformed scriptSig")
+	}
+
+	stack := make([][]byte, 0, len(ops))
+	for _, op := range ops {
+		switch {
+		case op.Opcode <= OP_PUSHDATA4:
+			stack = append(stack, op.Data)
+		case op.Opcode == OP_1NEGATE:
+			stack = append(stack, []byte{0x81})
+		case op.Opcode >= OP_1 && op.Opcode <= OP_16:
+			stack = append(stack, []byte{byte(DecodeOpN(op.Opcode))})
+		default:
+			return nil, errors.New("scriptSig is not push only")
+		}
+	}
+
+	return stack, nil
+}
+
+func verifyWitnessV0(result *InputVerification, tx *Transaction, program Script, amount Amount, cache *SigHashCache) error {
+	witness := tx.Vin[result.Index].ScriptWitness
+	_, solution, _ := program.WitnessProgram()
+
+	if len(solution) == 20 {
+		if len(witness) != 2 {
+			return errors.New("P2WPKH witness must have 2 elements")
+		}
+		if !bytes.Equal(Hash160(witness[1]), solution) {
+			return errors.New("Witness public key does not match the key hash")
+		}
+		// scriptCode is the equivalent P2PKH script
+		scriptCode := append([]byte{OP_DUP, OP_HASH160, 0x14}, solution...)
+		scriptCode = append(scriptCode, OP_EQUALVERIFY, OP_CHECKSIG)
+		return verifyTemplate(result, tx, scriptCode, witness, true, amount, cache)
+	}
+
+	if len(witness) == 0 {
+		return errors.New("Empty P2WSH witness")
+	}
+	witnessScript := Script(witness[len(witness)-1])
+	if !bytes.Equal(Sha256(witnessScript), solution) {
+		return errors.New("Witness script does not match the script hash")
+	}
+
+	return verifyTemplate(result, tx, witnessScript, witness[:len(witness)-1], true, amount, cache)
+}
+
+// Verifies the signatures in stack against a P2PK, P2PKH or multisig script,
+// counting the checks and auditing the signature encodings in result
+func verifyTemplate(result *InputVerification, tx *Transaction, script Script, stack [][]byte, witnessV0 bool, amount Amount, cache *SigHashCache) error {
+	nIn := result.Index
+	scriptType, solutions := script.Type()
+
+	switch scriptType {
+	case TX_PUBKEY:
+		if len(stack) != 1 {
+			return fmt.Errorf("Expected 1 signature, got %d items", len(stack))
+		}
+		result.Signatures = 1
+		result.Encodings = []SignatureEncoding{signatureEncoding(stack[0])}
+		return checkECDSASignature(tx, nIn, script, stack[0], solutions[0], witnessV0, amount, cache)
+	case TX_PUBKEYHASH:
+		if len(stack) != 2 {
+			return fmt.Errorf("Expected signature and public key, got %d items", len(stack))
+		}
+		if !bytes.Equal(Hash160(stack[1]), solutions[0]) {
+			return errors.New("Public key does not match the key hash")
+		}
+		result.Signatures = 1
+		result.Encodings = []SignatureEncoding{signatureEncoding(stack[0])}
+		return checkECDSASignature(tx, nIn, script, stack[0], stack[1], witnessV0, amount, cache)
+	case TX_MULTISIG:
+		required, pubKeys, _ := script.MultisigKeys()
+		// Extra item consumed by the OP_CHECKMULTISIG off-by-one bug
+		if len(stack) != required+1 {
+			return fmt.Errorf("Expected %d signatures plus dummy, got %d items", required, len(stack))
+		}
+		sigs := stack[1:]
+		for _, sig := range sigs {
+			result.Encodings = append(result.Encodings, signatureEncoding(sig))
+		}
+
+		// Signatures must be in the same order as their public keys
+		k := 0
+		for _, sig := range sigs {
+			matched := false
+			for k < len(pubKeys) && !matched {
+				result.Signatures++
+				matched = checkECDSASignature(tx, nIn, script, sig, pubKeys[k], witnessV0, amount, cache) == nil
+				k++
+			}
+			if !matched {
+				return errors.New("Multisig signature does not match any remaining public key")
+			}
+		}
+		return nil
+	}
+
+	return ErrUnsupportedScript
+}
+
+// Checks a DER signature with its sighash type byte against pubKey
+func checkECDSASignature(tx *Transaction, nIn int, scriptCode Script, sig []byte, pubKey []byte, witnessV0 bool, amount Amount, cache *SigHashCache) error {
+	if len(sig) == 0 {
+		return errors.New("Empty signature")
+	}
+	hashType := int32(sig[len(sig)-1])
+
+	key, err := ParsePubKey(pubKey)
+	if err != nil {
+		return err
+	}
+	signature, err := ParseDERSignatureLax(sig[:len(sig)-1])
+	if err != nil {
+		return err
+	}
+
+	var hash Hash256
+	if witnessV0 {
+		hash, err = SignatureHashWitnessV0(scriptCode, tx, nIn, hashType, amount, cache)
+		if err != nil {
+			return err
+		}
+	} else {
+		// The signature can't sign itself
+		scriptCode, _ = FindAndDelete(scriptCode, PushData(sig))
+		hash = SignatureHashLegacy(scriptCode, tx, nIn, hashType)
+	}
+
+	if !VerifyECDSA(key, hash, signature) {
+		return errors.New("ECDSA signature verification failed")
+	}
+
+	return nil
+}
+
+// Checks a BIP340 signature, optionally followed by its sighash type byte,
+// against a taproot output key
+func verifyTaprootKeyPath(tx *Transaction, nIn int, outputKey []byte, sig []byte, prevouts []TxOutput, annex []byte, cache *SigHashCache) error {
+	hashType := byte(SIGHASH_DEFAULT)
+	if len(sig) == SCHNORR_SIGNATURE_SIZE+1 {
+		hashType = sig[SCHNORR_SIGNATURE_SIZE]
+		if hashType == SIGHASH_DEFAULT {
+			return errors.New("Explicit SIGHASH_DEFAULT is not allowed")
+		}
+		sig = sig[:SCHNORR_SIGNATURE_SIZE]
+	} else if len(sig) != SCHNORR_SIGNATURE_SIZE {
+		return fmt.Errorf("Invalid Schnorr signature size: %d", len(sig))
+	}
+
+	hash, err := SignatureHashTaproot(tx, nIn, hashType, prevouts, annex, nil, cache)
+	if err != nil {
+		return err
+	}
+	if !VerifySchnorr(outputKey, hash, sig) {
+		return errors.New("Schnorr signature verification failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package blockchainparser
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Mainnet transactions of Bitcoin Core's tx_valid.json, whose signatures
+// predate BIP66 and low S: some are not strict DER and some have a high S
+func TestVerifyTransactionSignaturesMainnet(t *testing.T) {
+	p2pkh := func(hash string) string { return "76a914" + hash + "88ac" }
+	tests := []struct {
+		txid      string
+		tx        string
+		prevouts  []string
+		types     []TxOutType
+		encodings []SignatureEncoding
+	}{
+		// First standard OP_CHECKMULTISIG, with a signature OpenSSL accepts
+		{
+			"23b397edccd3740a74adb603c9756370fafcde9bcc4483eb271ecad09a94dd63",
+			legacyTxForTest,
+			[]string{"514104cc71eb30d653c0c3163990c47b976f3fb3f37cccdcbedb169a1dfef58bbfbfaff7d8a473e7e2e6d317b87bafe8bde97e3cf8f065dec022b51d11fcdd0d348ac4410461cbdcc5409fb4b4d42b51d33381354d80e550078cb532a34bfa2fcfdeb7d76519aecc62770f5b0e4ef8551946d8a540911abe3e7854a26f39f58b25c15342af52ae"},
+			[]TxOutType{TX_MULTISIG},
+			[]SignatureEncoding{{false, false}},
+		},
+		{
+			"c99c49da4c38af669dea436d3e73780dfdb6c1ecf9958baa52960e8baee30e73",
+			"01000000010276b76b07f4935c70acf54fbf1f438a4c397a9fb7e633873c4dd3bc062b6b40000000008c493046022100d23459d03ed7e9511a47d13292d3430a04627de6235b6e51a40f9cd386f2abe3022100e7d25b080f0bb8d8d5f878bba7d54ad2fda650ea8d158a33ee3cbd11768191fd004104b0e2c879e4daf7b9ab68350228c159766676a14f5815084ba166432aab46198d4cca98fa3e9981d0a90b2effc514b76279476550ba3663fdcaff94c38420e9d5000000000100093d00000000001976a9149a7b0f3b80c6baaeedce0a0842553800f832ba1f88ac00000000",
+			[]string{p2pkh("dc44b1164188067c3a32d4780f5996fa14a4f2d9")},
+			[]TxOutType{TX_PUBKEYHASH},
+			[]SignatureEncoding{{true, false}},
+		},
+		{
+			"f7fdd091fa6d8f5e7a8c2458f5c38faffff2d3f1406b6e4fe2c99dcc0d2d1cbb",
+			"01000000023d6cf972d4dff9c519eff407ea800361dd0a121de1da8b6f4138a2f25de864b4000000008a4730440220ffda47bfc776bcd269da4832626ac332adfca6dd835e8ecd83cd1ebe7d709b0e022049cffa1cdc102a0b56e0e04913606c70af702a1149dc3b305ab9439288fee090014104266abb36d66eb4218a6dd31f09bb92cf3cfa803c7ea72c1fc80a50f919273e613f895b855fb7465ccbc8919ad1bd4a306c783f22cd3227327694c4fa4c1c439affffffff21ebc9ba20594737864352e95b727f1a565756f9d365083eb1a8596ec98c97b7010000008a4730440220503ff10e9f1e0de731407a4a245531c9ff17676eda461f8ceeb8c06049fa2c810220c008ac34694510298fa60b3f000df01caa244f165b727d4896eb84f81e46bcc4014104266abb36d66eb4218a6dd31f09bb92cf3cfa803c7ea72c1fc80a50f919273e613f895b855fb7465ccbc8919ad1bd4a306c783f22cd3227327694c4fa4c1c439affffffff01f0da5200000000001976a914857ccd42dded6df32949d4646dfa10a92458cfaa88ac00000000",
+			[]string{p2pkh("bef80ecf3a44500fda1bc92176e442891662aed2"), p2pkh("bef80ecf3a44500fda1bc92176e442891662aed2")},
+			[]TxOutType{TX_PUBKEYHASH, TX_PUBKEYHASH},
+			[]SignatureEncoding{{false, true}, {false, false}},
+		},
+		// SIGHASH_SINGLE signatures
+		{
+			"afd9c17f8913577ec3509520bd6e5d63e9c0fd2a5f70c787993b097ba6ca9fae",
+			"010000000370ac0a1ae588aaf284c308d67ca92c69a39e2db81337e563bf40c59da0a5cf63000000006a4730440220360d20baff382059040ba9be98947fd678fb08aab2bb0c172efa996fd8ece9b702201b4fb0de67f015c90e7ac8a193aeab486a1f587e0f54d0fb9552ef7f5ce6caec032103579ca2e6d107522f012cd00b52b9a65fb46f0c57b9b8b6e377c48f526a44741affffffff7d815b6447e35fbea097e00e028fb7dfbad4f3f0987b4734676c84f3fcd0e804010000006b483045022100c714310be1e3a9ff1c5f7cacc65c2d8e781fc3a88ceb063c6153bf950650802102200b2d0979c76e12bb480da635f192cc8dc6f905380dd4ac1ff35a4f68f462fffd032103579ca2e6d107522f012cd00b52b9a65fb46f0c57b9b8b6e377c48f526a44741affffffff3f1f097333e4d46d51f5e77b53264db8f7f5d2e18217e1099957d0f5af7713ee010000006c493046022100b663499ef73273a3788dea342717c2640ac43c5a1cf862c9e09b206fcb3f6bb8022100b09972e75972d9148f2bdd462e5cb69b57c1214b88fc55ca638676c07cfc10d8032103579ca2e6d107522f012cd00b52b9a65fb46f0c57b9b8b6e377c48f526a44741affffffff0380841e00000000001976a914bfb282c70c4191f45b5a6665cad1682f2c9cfdfb88ac80841e00000000001976a9149857cc07bed33a5cf12b9c5e0500b675d500c81188ace0fd1c00000000001976a91443c52850606c872403c0601e69fa34b26f62db4a88ac00000000",
+			[]string{p2pkh("dcf72c4fd02f5a987cf9b02f2fabfcac3341a87d"), p2pkh("dcf72c4fd02f5a987cf9b02f2fabfcac3341a87d"), p2pkh("dcf72c4fd02f5a987cf9b02f2fabfcac3341a87d")},
+			[]TxOutType{TX_PUBKEYHASH, TX_PUBKEYHASH, TX_PUBKEYHASH},
+			[]SignatureEncoding{{true, true}, {true, true}, {true, false}},
+		},
+	}
+
+	for _, test := range tests {
+		tx := parseTxForTest(t, test.tx)
+		if tx.Txid().String() != test.txid {
+			t.Fatalf("Got txid %s, want %s", tx.Txid(), test.txid)
+		}
+		var prevouts []TxOutput
+		for _, script := range test.prevouts {
+			prevouts = append(prevouts, TxOutput{Script: hexForTest(t, script)})
+		}
+
+		results, err := VerifyTransactionSignatures(tx, prevouts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, result := range results {
+			if !result.Valid || result.Type != test.types[j] || result.Signatures == 0 {
+				t.Errorf("%s input %d: %+v", test.txid, j, result)
+			}
+			if len(result.Encodings) != 1 || result.Encodings[0] != test.encodings[j] {
+				t.Errorf("%s input %d: got encodings %+v, want %+v", test.txid, j, result.Encodings, test.encodings[j])
+			}
+		}
+
+		// The signatures commit to the locktime
+		tx.Locktime++
+		results, _ = VerifyTransactionSignatures(tx, prevouts)
+		for j, result := range results {
+			if result.Valid || result.Err == nil {
+				t.Errorf("%s input %d: valid with another locktime", test.txid, j)
+			}
+		}
+	}
+}
+
+// Consensus accepts high S and BER signatures, the report flags them
+func TestVerifyTransactionSignaturesEncoding(t *testing.T) {
+	key := new(big.Int).SetBytes(Sha256([]byte("encoding")))
+	pubKey := pubKeyForTest(key).SerializeCompressed()
+	p2pkh := append(append(Script{OP_DUP, OP_HASH160, 20}, Hash160(pubKey)...), OP_EQUALVERIFY, OP_CHECKSIG)
+	prevouts := []TxOutput{{Value: 1000, Script: p2pkh}}
+	tx := &Transaction{
+		Version: 1,
+		Vin:     []TxInput{{Hash: DoubleSha256([]byte("encoding")), Sequence: 0xffffffff}},
+		Vout:    []TxOutput{{Value: 900, Script: p2pkh}},
+	}
+	sig := ecdsaSignForTest(key, SignatureHashLegacy(p2pkh, tx, 0, SIGHASH_ALL))
+	highS := &ECDSASignature{R: sig.R, S: new(big.Int).Sub(secp256k1N, sig.S)}
+
+	// BER allows integers with leading zero bytes, which DER forbids
+	ber := func(sig *ECDSASignature) []byte {
+		der := derSignatureForTest(sig, SIGHASH_ALL)
+		lenR := int(der[3])
+		return append([]byte{0x30, der[1] + 1, 0x02, byte(lenR + 1), 0x00}, der[4:]...)
+	}
+
+	tests := []struct {
+		sig      []byte
+		encoding SignatureEncoding
+	}{
+		{derSignatureForTest(sig, SIGHASH_ALL), SignatureEncoding{StrictDER: true, LowS: true}},
+		{derSignatureForTest(highS, SIGHASH_ALL), SignatureEncoding{StrictDER: true, LowS: false}},
+		{ber(sig), SignatureEncoding{StrictDER: false, LowS: true}},
+		{ber(highS), SignatureEncoding{StrictDER: false, LowS: false}},
+	}
+	for i, test := range tests {
+		tx.Vin[0].Script = append(PushData(test.sig), PushData(pubKey)...)
+		results, err := VerifyTransactionSignatures(tx, prevouts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := results[0]
+		if !result.Valid || len(result.Encodings) != 1 || result.Encodings[0] != test.encoding {
+			t.Errorf("Test %d: got %+v, want %+v", i, result, test.encoding)
+		}
+	}
+
+	// A signature that doesn't parse is neither
+	tx.Vin[0].Script = append(PushData([]byte{0x30, 0x01, SIGHASH_ALL}), PushData(pubKey)...)
+	results, _ := VerifyTransactionSignatures(tx, prevouts)
+	if results[0].Valid || len(results[0].Encodings) != 1 || results[0].Encodings[0] != (SignatureEncoding{}) {
+		t.Errorf("Got %+v", results[0])
+	}
+}
+
+// Report of a transaction spending every supported output type, plus an
+// invalid signature and an unsupported script
+func TestVerifyTransactionSignatures(t *testing.T) {
+	keys := make([]*big.Int, 3)
+	pubKeys := make([][]byte, 3)
+	for i := range keys {
+		keys[i] = new(big.Int).SetBytes(Sha256([]byte{byte(i)}))
+		pubKeys[i] = pubKeyForTest(keys[i]).SerializeCompressed()
+	}
+
+	p2pkh := append(append(Script{OP_DUP, OP_HASH160, 20}, Hash160(pubKeys[0])...), OP_EQUALVERIFY, OP_CHECKSIG)
+	p2wpkh := append(Script{OP_0, 20}, Hash160(pubKeys[0])...)
+	p2shP2wpkh := append(append(Script{OP_HASH160, 20}, Hash160(p2wpkh)...), OP_EQUAL)
+	multisig := Script{OP_2}
+	for _, pubKey := range pubKeys {
+		multisig = append(append(multisig, COMPRESSED_PUBKEY_SIZE), pubKey...)
+	}
+	multisig = append(multisig, OP_3, OP_CHECKMULTISIG)
+	p2wsh := append(Script{OP_0, 32}, Sha256(multisig)...)
+	p2pk := append(append(Script{COMPRESSED_PUBKEY_SIZE}, pubKeys[1]...), OP_CHECKSIG)
+
+	prevouts := []TxOutput{
+		{Value: 1000, Script: p2pkh},
+		{Value: 2000, Script: p2wpkh},
+		{Value: 3000, Script: p2shP2wpkh},
+		{Value: 4000, Script: p2wsh},
+		{Value: 5000, Script: multisig},
+		{Value: 6000, Script: p2pk},
+		{Value: 7000, Script: Script{OP_TRUE}},
+	}
+	tx := &Transaction{Version: 2, Vout: []TxOutput{{Value: 20000, Script: p2wpkh}}}
+	for i := range prevouts {
+		tx.Vin = append(tx.Vin, TxInput{Hash: DoubleSha256([]byte{byte(i)}), Index: uint32(i), Sequence: 0xffffffff})
+	}
+
+	legacySig := func(nIn int, scriptCode Script, key *big.Int) []byte {
+		return signECDSAForTest(key, SignatureHashLegacy(scriptCode, tx, nIn, SIGHASH_ALL), SIGHASH_ALL)
+	}
+	witnessSig := func(nIn int, scriptCode Script, key *big.Int) []byte {
+		hash, err := SignatureHashWitnessV0(scriptCode, tx, nIn, SIGHASH_ALL, prevouts[nIn].Value, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signECDSAForTest(key, hash, SIGHASH_ALL)
+	}
+
+	tx.Vin[0].Script = append(PushData(legacySig(0, p2pkh, keys[0])), PushData(pubKeys[0])...)
+	tx.Vin[1].ScriptWitness = [][]byte{witnessSig(1, p2pkh, keys[0]), pubKeys[0]}
+	tx.Vin[2].Script = PushData(p2wpkh)
+	tx.Vin[2].ScriptWitness = [][]byte{witnessSig(2, p2pkh, keys[0]), pubKeys[0]}
+	tx.Vin[3].ScriptWitness = [][]byte{{}, witnessSig(3, multisig, keys[0]), witnessSig(3, multisig, keys[2]), multisig}
+	tx.Vin[4].Script = append(append(Script{OP_0}, PushData(legacySig(4, multisig, keys[1]))...), PushData(legacySig(4, multisig, keys[2]))...)
+	// Signed by the wrong key
+	tx.Vin[5].Script = PushData(legacySig(5, p2pk, keys[0]))
+
+	results, err := VerifyTransactionSignatures(tx, prevouts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []InputVerification{
+		{Index: 0, Type: TX_PUBKEYHASH, InnerType: TX_NONSTANDARD, Signatures: 1, Valid: true},
+		{Index: 1, Type: TX_WITNESS_V0_KEYHASH, InnerType: TX_NONSTANDARD, Signatures: 1, Valid: true},
+		{Index: 2, Type: TX_SCRIPTHASH, InnerType: TX_WITNESS_V0_KEYHASH, Signatures: 1, Valid: true},
+		// A signature is checked against each key up to the one it matches
+		{Index: 3, Type: TX_WITNESS_V0_SCRIPTHASH, InnerType: TX_MULTISIG, Signatures: 3, Valid: true},
+		{Index: 4, Type: TX_MULTISIG, InnerType: TX_NONSTANDARD, Signatures: 3, Valid: true},
+		{Index: 5, Type: TX_PUBKEY, InnerType: TX_NONSTANDARD, Signatures: 1, Valid: false},
+		{Index: 6, Type: TX_NONSTANDARD, InnerType: TX_NONSTANDARD, Signatures: 0, Valid: false, Err: ErrUnsupportedScript},
+	}
+	for i, result := range results {
+		want := expected[i]
+		if result.Index != want.Index || result.Type != want.Type || result.InnerType != want.InnerType ||
+			result.Signatures != want.Signatures || result.Valid != want.Valid || (result.Err == nil) != want.Valid {
+			t.Errorf("Input %d: got %+v, want %+v", i, result, want)
+		}
+		if want.Err != nil && result.Err != want.Err {
+			t.Errorf("Input %d: got %v, want %v", i, result.Err, want.Err)
+		}
+		// One encoding per ECDSA signature, all of them standard
+		sigs := map[int]int{3: 2, 4: 2, 6: 0}
+		n, ok := sigs[i]
+		if !ok {
+			n = 1
+		}
+		if len(result.Encodings) != n {
+			t.Errorf("Input %d: %d encodings, want %d", i, len(result.Encodings), n)
+		}
+		for _, encoding := range result.Encodings {
+			if !encoding.StrictDER || !encoding.LowS {
+				t.Errorf("Input %d: %+v", i, encoding)
+			}
+		}
+	}
+
+	// The witness signatures commit to the spent amounts
+	prevouts[1].Value++
+	results, _ = VerifyTransactionSignatures(tx, prevouts)
+	if !results[0].Valid || results[1].Valid {
+		t.Errorf("Changed amount: %+v %+v", results[0], results[1])
+	}
+
+	if _, err := VerifyTransactionSignatures(tx, prevouts[1:]); err != ErrMissingPrevouts {
+		t.Errorf("Missing prevouts: %v", err)
+	}
+}
+
+// Taproot key path spends are checked, script path spends are unsupported
+func TestVerifyTransactionSignaturesTaproot(t *testing.T) {
+	tx := parseTxForTest(t, "020000000127744ababf3027fe0d6cf23a96eee2efb188ef52301954585883e69b6624b2420000000000ffffffff0148e6052a01000000160014768e1eeb4cf420866033f80aceff0f972074496900000000")
+	prevouts := []TxOutput{{Value: 5000000000, Script: hexForTest(t, "51205a2c2cf5b52cf31f83ad2e8da63ff03183ecd8f609c7510ae8a48e03910a0757")}}
+	sig := hexForTest(t, "bb53ec917bad9d906af1ba87181c48b86ace5aae2b53605a725ca74625631476fc6f5baedaf4f2ee0f477f36f58f3970d5b8273b7e497b97af2e3f125c97af34")
+
+	tests := []struct {
+		witness [][]byte
+		valid   bool
+		err     error
+	}{
+		{[][]byte{sig}, true, nil},
+		{[][]byte{sig, {ANNEX_TAG}}, false, nil}, // the annex is signed
+		{[][]byte{append(sig, SIGHASH_ALL)}, false, nil},
+		{[][]byte{append(sig, SIGHASH_DEFAULT)}, false, nil},
+		{[][]byte{sig[:63]}, false, nil},
+		{[][]byte{sig, {OP_TRUE}, {0xc0}}, false, ErrUnsupportedScript},
+	}
+	for i, test := range tests {
+		tx.Vin[0].ScriptWitness = test.witness
+		results, err := VerifyTransactionSignatures(tx, prevouts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := results[0]
+		if result.Type != TX_WITNESS_V1_TAPROOT || result.Valid != test.valid || (result.Err == nil) != test.valid {
+			t.Errorf("Test %d: %+v", i, result)
+		}
+		if test.err != nil && result.Err != test.err {
+			t.Errorf("Test %d: got %v, want %v", i, result.Err, test.err)
+		}
+	}
+}